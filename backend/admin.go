@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+var cardsReloading atomic.Bool
+
+func seedAdminUsername() string {
+	return strings.TrimSpace(os.Getenv("ADMIN_USERNAME"))
+}
+
+// initialRole gives the ADMIN_USERNAME account the admin role when it is
+// registered after the server started.
+func initialRole(username string) string {
+	if seed := seedAdminUsername(); seed != "" && seed == username {
+		return roleAdmin
+	}
+	return roleUser
+}
+
+// ensureAdminUser promotes the account named in ADMIN_USERNAME so a fresh
+// install has someone who can reach the admin API.
+func ensureAdminUser(db *sql.DB) error {
+	username := seedAdminUsername()
+	if username == "" {
+		return nil
+	}
+	result, err := db.Exec(`UPDATE users SET role = ? WHERE username = ? AND role != ?`, roleAdmin, username, roleAdmin)
+	if err != nil {
+		return err
+	}
+	if changes, _ := result.RowsAffected(); changes > 0 {
+		log.Printf("[admin] granted admin role to %s", username)
+	}
+	return nil
+}
+
+func (u *User) isAdmin() bool {
+	return u != nil && u.Role == roleAdmin
+}
+
+func (a *App) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.userFromRequest(r)
+		if err != nil && !errors.Is(err, errTokenScope) {
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, err.Error())
+			return
+		}
+		if !user.isAdmin() {
+			writeError(w, http.StatusForbidden, codeAdminRequired, "Admin access required")
+			return
+		}
+		ctx := context.WithValue(r.Context(), authContextKey{}, user)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func (a *App) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 200 || limit <= 0 {
+		limit = 200
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	where := "1 = 1"
+	args := []interface{}{}
+	if query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q"))); query != "" {
+		where = "LOWER(u.username) LIKE ? ESCAPE '\\'"
+		args = append(args, "%"+escapeLikePattern(query)+"%")
+	}
+	args = append(args, limit, offset)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT u.id, u.username, u.role, u.created_at,
+			(SELECT COUNT(*) FROM decks d WHERE d.user_id = u.id) as deck_count,
+			(SELECT COUNT(*) FROM sessions s WHERE s.user_id = u.id AND s.expires_at > CURRENT_TIMESTAMP) as session_count,
+			u.last_login_at,
+			(SELECT MAX(day) FROM user_activity ua WHERE ua.user_id = u.id) as last_active,
+			u.banned_at IS NOT NULL as banned,
+			u.suspended_until,
+			COALESCE(u.suspended_until > CURRENT_TIMESTAMP, 0) as suspended
+		FROM users u
+		WHERE `+where+`
+		ORDER BY u.created_at DESC, u.id DESC
+		LIMIT ? OFFSET ?
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load users")
+		return
+	}
+	defer rows.Close()
+	users := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var username, role, createdAt string
+		var deckCount, sessionCount int
+		var lastLogin, lastActive, suspendedUntil sql.NullString
+		var banned, suspended bool
+		if err := rows.Scan(&id, &username, &role, &createdAt, &deckCount, &sessionCount, &lastLogin, &lastActive, &banned, &suspendedUntil, &suspended); err != nil {
+			continue
+		}
+		if !suspended {
+			suspendedUntil = sql.NullString{}
+		}
+		users = append(users, map[string]interface{}{
+			"id":             id,
+			"username":       username,
+			"role":           role,
+			"createdAt":      createdAt,
+			"deckCount":      deckCount,
+			"sessionCount":   sessionCount,
+			"lastLoginAt":    nullStringToPtr(lastLogin),
+			"lastActiveOn":   nullStringToPtr(lastActive),
+			"banned":         banned,
+			"suspendedUntil": nullStringToPtr(suspendedUntil),
+		})
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+type adminRolePayload struct {
+	Role string `json:"role"`
+}
+
+func (a *App) handleAdminSetRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id")
+		return
+	}
+	var payload adminRolePayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	if payload.Role != roleUser && payload.Role != roleAdmin {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "role must be user or admin")
+		return
+	}
+	if userID == a.currentUser(r).ID && payload.Role != roleAdmin {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Cannot remove your own admin role")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `UPDATE users SET role = ? WHERE id = ?`, payload.Role, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update user")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": userID, "role": payload.Role})
+}
+
+// handleAdminReloadCards re-imports cards.json in the background; the
+// upsert keeps the existing rows available while it runs.
+func (a *App) handleAdminReloadCards(w http.ResponseWriter, r *http.Request) {
+	path, err := resolveCardsJSONPath()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeUnavailable, err.Error())
+		return
+	}
+	if !cardsReloading.CompareAndSwap(false, true) {
+		writeError(w, http.StatusConflict, codeConflict, "Card reload already running")
+		return
+	}
+	go func() {
+		defer cardsReloading.Store(false)
+		log.Printf("[cards] reloading from %s", path)
+		if err := loadCardsFromJSON(a.db, path); err != nil {
+			log.Printf("[cards] reload failed: %v", err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"reloading": true, "path": path})
+}
+
+// handleAdminTakedownDeck removes a deck from every public surface without
+// deleting it from its owner's library.
+func (a *App) handleAdminTakedownDeck(w http.ResponseWriter, r *http.Request) {
+	deckID := chi.URLParam(r, "id")
+	found, err := a.takeDownDeck(r.Context(), deckID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to take down deck")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	log.Printf("[admin] %s took down deck %s", a.currentUser(r).Username, deckID)
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// takeDownDeck makes a deck private, unshared and no longer a precon,
+// reporting whether it exists.
+func (a *App) takeDownDeck(ctx context.Context, deckID string) (bool, error) {
+	result, err := a.db.ExecContext(ctx, `
+		UPDATE decks SET is_public = 0, share_token = NULL, is_precon = 0
+		WHERE id = ?
+	`, deckID)
+	if err != nil {
+		return false, err
+	}
+	changes, _ := result.RowsAffected()
+	return changes > 0, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxAdminResults      = 200
+	maxAdminActivityDays = 365
+)
+
+// activityTracker remembers which users were already counted active today so
+// only their first authenticated request of the day writes to user_activity.
+type activityTracker struct {
+	mu   sync.Mutex
+	day  string
+	seen map[int64]bool
+}
+
+func newActivityTracker() *activityTracker {
+	return &activityTracker{seen: make(map[int64]bool)}
+}
+
+// first reports whether this is the user's first activity on the UTC day.
+func (t *activityTracker) first(userID int64, day string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.day != day {
+		t.day = day
+		t.seen = make(map[int64]bool)
+	}
+	if t.seen[userID] {
+		return false
+	}
+	t.seen[userID] = true
+	return true
+}
+
+func (a *App) markActive(ctx context.Context, userID int64) {
+	day := time.Now().UTC().Format("2006-01-02")
+	if !a.activity.first(userID, day) {
+		return
+	}
+	_, _ = a.db.ExecContext(ctx, `INSERT OR IGNORE INTO user_activity (day, user_id) VALUES (?, ?)`, day, userID)
+}
+
+type adminRoom struct {
+	roomSummary
+	MemberCount int `json:"memberCount"`
+}
+
+// handleAdminRooms lists the live rooms, busiest first.
+func (a *App) handleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	summaries := a.rooms.Summaries()
+	rooms := make([]adminRoom, 0, len(summaries))
+	for _, summary := range summaries {
+		rooms = append(rooms, adminRoom{roomSummary: summary, MemberCount: len(summary.Clients)})
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].MemberCount != rooms[j].MemberCount {
+			return rooms[i].MemberCount > rooms[j].MemberCount
+		}
+		return rooms[i].RoomID < rooms[j].RoomID
+	})
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+type adminGameResult struct {
+	EventID    int64   `json:"eventId"`
+	RoomID     string  `json:"roomId"`
+	Winner     *string `json:"winner"`
+	Reason     string  `json:"reason,omitempty"`
+	ReportedBy *string `json:"reportedBy"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+// handleAdminResults lists the latest game results across rooms. The winner's
+// name is the one written to the room log when the result was stored.
+// Results a snapshot has compacted away are not listed.
+func (a *App) handleAdminResults(w http.ResponseWriter, r *http.Request) {
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > maxAdminResults || limit <= 0 {
+		limit = maxAdminResults
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT e.id, e.room_id, e.event_data, e.player_name, e.created_at, l.actor
+		FROM room_events e
+		LEFT JOIN room_log l ON l.event_id = e.id
+		WHERE e.event_type = ? AND e.reverted_at IS NULL
+		ORDER BY e.id DESC
+		LIMIT ? OFFSET ?
+	`, roomEventGameResult, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load game results")
+		return
+	}
+	defer rows.Close()
+	results := make([]adminGameResult, 0)
+	for rows.Next() {
+		var result adminGameResult
+		var data string
+		var reportedBy, actor sql.NullString
+		if err := rows.Scan(&result.EventID, &result.RoomID, &data, &reportedBy, &result.CreatedAt, &actor); err != nil {
+			continue
+		}
+		var stored struct {
+			WinnerPlayerID *string `json:"winnerPlayerId"`
+			Reason         string  `json:"reason"`
+		}
+		_ = json.Unmarshal([]byte(data), &stored)
+		result.Reason = stored.Reason
+		result.ReportedBy = nullStringToPtr(reportedBy)
+		if stored.WinnerPlayerID != nil {
+			result.Winner = nullStringToPtr(actor)
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+type adminActivityDay struct {
+	Day         string `json:"day"`
+	ActiveUsers int    `json:"activeUsers"`
+	Games       int    `json:"games"`
+}
+
+// handleAdminActivity counts, for each of the last days (UTC), the users who
+// made an authenticated request and the game results stored.
+// Days with nothing recorded are listed with zeros.
+func (a *App) handleAdminActivity(w http.ResponseWriter, r *http.Request) {
+	days := parseIntDefault(r.URL.Query().Get("days"), 30)
+	if days > maxAdminActivityDays || days <= 0 {
+		days = maxAdminActivityDays
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days).Format("2006-01-02")
+	counts := make(map[string]*adminActivityDay, days)
+	activity := make([]adminActivityDay, days)
+	for i := range activity {
+		activity[i].Day = today.AddDate(0, 0, i+1-days).Format("2006-01-02")
+		counts[activity[i].Day] = &activity[i]
+	}
+	for _, query := range []struct {
+		sql   string
+		count func(*adminActivityDay) *int
+	}{
+		{`SELECT day, COUNT(*) FROM user_activity WHERE day >= ? GROUP BY day`, func(d *adminActivityDay) *int { return &d.ActiveUsers }},
+		{`SELECT date(created_at), COUNT(*) FROM room_events WHERE event_type = '` + roomEventGameResult + `' AND reverted_at IS NULL AND created_at >= ? GROUP BY date(created_at)`, func(d *adminActivityDay) *int { return &d.Games }},
+	} {
+		rows, err := a.db.QueryContext(r.Context(), query.sql, since)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load activity")
+			return
+		}
+		for rows.Next() {
+			var day string
+			var count int
+			if rows.Scan(&day, &count) == nil && counts[day] != nil {
+				*query.count(counts[day]) = count
+			}
+		}
+		rows.Close()
+	}
+	writeJSON(w, http.StatusOK, activity)
+}
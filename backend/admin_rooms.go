@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxRoomCloseReasonLen = 500
+
+type adminCloseRoomPayload struct {
+	Reason string `json:"reason"`
+}
+
+// handleAdminRoom shows a room's live membership, when it is open on any
+// instance, and its last saved board state.
+func (a *App) handleAdminRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	live, ok := a.rooms.Summary(roomID)
+	var updatedAt sql.NullString
+	var boardState string
+	var version int64
+	persisted := a.db.QueryRowContext(r.Context(), `SELECT updated_at, board_state, version FROM rooms WHERE room_id = ?`, roomID).Scan(&updatedAt, &boardState, &version) == nil
+	if !ok && !persisted {
+		writeError(w, http.StatusNotFound, codeNotFound, "Room not found")
+		return
+	}
+	var eventCount int
+	_ = a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM room_events WHERE room_id = ?`, roomID).Scan(&eventCount)
+	room := map[string]interface{}{
+		"roomId":     roomID,
+		"live":       ok,
+		"persisted":  persisted,
+		"updatedAt":  nullStringToPtr(updatedAt),
+		"eventCount": eventCount,
+	}
+	if ok {
+		room["host"] = live.Host
+		room["clients"] = live.Clients
+		room["hasPassword"] = live.HasPassword
+	}
+	if persisted {
+		room["version"] = version
+		if json.Valid([]byte(boardState)) {
+			room["state"] = json.RawMessage(boardState)
+		}
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+// handleAdminCloseRoom shuts a stuck or abusive room: every member is sent
+// room:closed, the room is dropped here and on peers, and its saved state,
+// events and log are deleted.
+func (a *App) handleAdminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	var payload adminCloseRoomPayload
+	if err := decodeJSON(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	reason := strings.TrimSpace(payload.Reason)
+	if len(reason) > maxRoomCloseReasonLen {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "reason is too long")
+		return
+	}
+
+	socketIDs := a.rooms.Close(roomID)
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM rooms WHERE room_id = ?`, roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete room")
+		return
+	}
+	deleted, _ := result.RowsAffected()
+	if socketIDs == nil && deleted == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Room not found")
+		return
+	}
+
+	if socketIDs != nil {
+		message := "Closed by an admin"
+		if reason != "" {
+			message += ": " + reason
+		}
+		closed := WSMessage{Type: "room:closed", Payload: marshalPayload(ErrorPayload{Code: codeRoomClosed, Message: message})}
+		for _, socketID := range socketIDs {
+			a.leavePresenceRoom(socketID)
+			a.send(socketID, closed)
+		}
+		a.bus.publishLeave(socketIDs...)
+		a.closeRoom(roomID)
+	}
+	_ = recordAudit(r.Context(), a.db, a.currentUser(r), "room_close", 0, roomID, reason)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId":   roomID,
+		"notified": len(socketIDs),
+		"deleted":  deleted > 0,
+		"success":  true,
+	})
+}
+
+// Close drops the room and every socket's membership of it, returning the
+// sockets that were in it, host first, or nil when it is not open.
+func (r *RoomRegistry) Close(roomID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return nil
+	}
+	socketIDs := []string{room.HostSocketID}
+	for socketID := range room.Clients {
+		socketIDs = append(socketIDs, socketID)
+	}
+	for _, socketID := range socketIDs {
+		delete(r.socketToRoom, socketID)
+		delete(r.socketRole, socketID)
+	}
+	delete(r.rooms, roomID)
+	return socketIDs
+}
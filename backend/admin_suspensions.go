@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	auditSuspend   = "suspend"
+	auditBan       = "ban"
+	auditReinstate = "reinstate"
+
+	maxSuspensionDays      = 3650
+	maxSuspensionReasonLen = 500
+)
+
+// activeAccountClause limits a query joined on users u to accounts that are
+// neither banned nor inside a suspension. Sessions and API tokens of other
+// accounts stop authenticating.
+const activeAccountClause = `u.banned_at IS NULL AND (u.suspended_until IS NULL OR u.suspended_until <= CURRENT_TIMESTAMP)`
+
+type adminSuspendPayload struct {
+	Reason string `json:"reason"`
+	Days   int    `json:"days"`
+}
+
+// accountRestriction is why an account cannot sign in. Until is unset for a
+// ban.
+type accountRestriction struct {
+	Banned bool    `json:"banned"`
+	Until  *string `json:"until"`
+	Reason *string `json:"reason"`
+}
+
+func (r *accountRestriction) message() string {
+	if r.Banned {
+		return "This account is banned"
+	}
+	return "This account is suspended until " + *r.Until
+}
+
+// accountRestriction returns why the user cannot sign in, or nil when they
+// can.
+func (a *App) accountRestriction(ctx context.Context, userID int64) *accountRestriction {
+	var bannedAt, until, reason sql.NullString
+	var suspended bool
+	err := a.db.QueryRowContext(ctx, `
+		SELECT banned_at, suspended_until, suspension_reason,
+			COALESCE(suspended_until > CURRENT_TIMESTAMP, 0)
+		FROM users WHERE id = ?
+	`, userID).Scan(&bannedAt, &until, &reason, &suspended)
+	if err != nil || (!bannedAt.Valid && !suspended) {
+		return nil
+	}
+	restriction := &accountRestriction{Banned: bannedAt.Valid, Reason: nullStringToPtr(reason)}
+	if !restriction.Banned {
+		restriction.Until = nullStringToPtr(until)
+	}
+	return restriction
+}
+
+func writeAccountRestricted(w http.ResponseWriter, restriction *accountRestriction) {
+	writeErrorDetails(w, http.StatusForbidden, codeAccountSuspended, restriction.message(), restriction)
+}
+
+func (a *App) handleAdminSuspendUser(w http.ResponseWriter, r *http.Request) {
+	a.restrictUser(w, r, auditSuspend)
+}
+
+func (a *App) handleAdminBanUser(w http.ResponseWriter, r *http.Request) {
+	a.restrictUser(w, r, auditBan)
+}
+
+func (a *App) handleAdminReinstateUser(w http.ResponseWriter, r *http.Request) {
+	a.restrictUser(w, r, auditReinstate)
+}
+
+// restrictUser handles the suspend, ban and reinstate endpoints.
+func (a *App) restrictUser(w http.ResponseWriter, r *http.Request, action string) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id")
+		return
+	}
+	var payload adminSuspendPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	username, restriction, problem := a.restrictAccount(r.Context(), a.currentUser(r), userID, action, payload)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":          userID,
+		"username":    username,
+		"restriction": restriction,
+	})
+}
+
+// restrictAccount suspends, bans or reinstates an account and records it in
+// the audit log, returning the username and the restriction now in force.
+// Suspending or banning also revokes the account's sessions and closes its
+// sockets on this instance; sockets held by other instances stay open until
+// they reconnect, which the revoked sessions then refuse.
+func (a *App) restrictAccount(ctx context.Context, admin *User, userID int64, action string, payload adminSuspendPayload) (string, *accountRestriction, *apiError) {
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	switch {
+	case payload.Reason == "" && action != auditReinstate:
+		return "", nil, &apiError{http.StatusBadRequest, codeValidationFailed, "reason is required"}
+	case len(payload.Reason) > maxSuspensionReasonLen:
+		return "", nil, &apiError{http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("reason must be at most %d characters", maxSuspensionReasonLen)}
+	case action == auditSuspend && (payload.Days < 1 || payload.Days > maxSuspensionDays):
+		return "", nil, &apiError{http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("days must be between 1 and %d", maxSuspensionDays)}
+	}
+	var username, role string
+	if err := a.db.QueryRowContext(ctx, `SELECT username, role FROM users WHERE id = ?`, userID).Scan(&username, &role); err != nil {
+		return "", nil, &apiError{http.StatusNotFound, codeNotFound, "User not found"}
+	}
+	if action != auditReinstate && (userID == admin.ID || role == roleAdmin) {
+		return "", nil, &apiError{http.StatusBadRequest, codeValidationFailed, "Admins cannot be suspended or banned; change their role first"}
+	}
+
+	failed := &apiError{http.StatusInternalServerError, codeInternal, "Failed to update user"}
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, failed
+	}
+	defer tx.Rollback()
+	switch action {
+	case auditSuspend:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET suspended_until = datetime('now', ?), suspension_reason = ?
+			WHERE id = ?
+		`, fmt.Sprintf("+%d days", payload.Days), payload.Reason, userID)
+	case auditBan:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET banned_at = CURRENT_TIMESTAMP, suspension_reason = ?
+			WHERE id = ?
+		`, payload.Reason, userID)
+	default:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET banned_at = NULL, suspended_until = NULL, suspension_reason = NULL
+			WHERE id = ?
+		`, userID)
+	}
+	if err == nil && action != auditReinstate {
+		_, err = tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID)
+	}
+	if err == nil {
+		reason := payload.Reason
+		if action == auditSuspend {
+			reason = fmt.Sprintf("%s (%d days)", reason, payload.Days)
+		}
+		err = recordAudit(ctx, tx, admin, action, userID, username, reason)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return "", nil, failed
+	}
+	restriction := a.accountRestriction(ctx, userID)
+	if restriction != nil {
+		a.disconnectUser(userID, restriction.message())
+	}
+	return username, restriction, nil
+}
+
+// sqlExecer is a *sql.DB or a *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordAudit adds an admin action against a user to the audit log.
+func recordAudit(ctx context.Context, db sqlExecer, admin *User, action string, targetID int64, targetName string, reason string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO admin_audit (admin_id, admin_name, action, target_user_id, target_name, reason)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, admin.ID, admin.Username, action, nullIfZero(targetID), nullIfEmpty(targetName), nullIfEmpty(reason))
+	if err == nil {
+		log.Printf("[admin] %s: %s %s", admin.Username, action, targetName)
+	}
+	return err
+}
+
+// disconnectUser closes the user's sockets and event streams on this
+// instance, telling WebSocket clients why.
+func (a *App) disconnectUser(userID int64, reason string) {
+	for _, socketID := range a.presence.socketIDs(userID) {
+		a.clientsMu.RLock()
+		client := a.clients[socketID]
+		a.clientsMu.RUnlock()
+		if client == nil || client.bot != nil {
+			continue
+		}
+		client.mu.Lock()
+		if client.stream != nil {
+			if !client.stream.closed {
+				client.stream.closed = true
+				close(client.stream.overflow)
+			}
+		} else {
+			message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+			_ = client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+			_ = client.conn.Close()
+		}
+		client.mu.Unlock()
+	}
+}
+
+type adminAuditEntry struct {
+	ID         int64   `json:"id"`
+	AdminName  string  `json:"adminName"`
+	Action     string  `json:"action"`
+	TargetID   *int64  `json:"targetUserId"`
+	TargetName *string `json:"targetName"`
+	Reason     *string `json:"reason"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+func (a *App) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 200 || limit <= 0 {
+		limit = 200
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	where := "1 = 1"
+	args := []interface{}{}
+	if userID, err := strconv.ParseInt(r.URL.Query().Get("userId"), 10, 64); err == nil {
+		where = "target_user_id = ?"
+		args = append(args, userID)
+	}
+	args = append(args, limit, offset)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, admin_name, action, target_user_id, target_name, reason, created_at
+		FROM admin_audit
+		WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load the audit log")
+		return
+	}
+	defer rows.Close()
+	entries := make([]adminAuditEntry, 0)
+	for rows.Next() {
+		var entry adminAuditEntry
+		var targetID sql.NullInt64
+		var targetName, reason sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.AdminName, &entry.Action, &targetID, &targetName, &reason, &entry.CreatedAt); err != nil {
+			continue
+		}
+		if targetID.Valid {
+			entry.TargetID = &targetID.Int64
+		}
+		entry.TargetName = nullStringToPtr(targetName)
+		entry.Reason = nullStringToPtr(reason)
+		entries = append(entries, entry)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	apiTokenPrefix       = "mto_"
+	maxAPITokensPerUser  = 20
+	maxAPITokenNameLen   = 64
+	apiTokenMaxValidDays = 365
+)
+
+const (
+	scopeCardsRead   = "cards:read"
+	scopeDecksWrite  = "decks:write"
+	scopeRoomsEvents = "rooms:events"
+	scopeRoomsPlay   = "rooms:play"
+)
+
+var apiTokenScopes = map[string]bool{
+	scopeCardsRead:   true,
+	scopeDecksWrite:  true,
+	scopeRoomsEvents: true,
+	scopeRoomsPlay:   true,
+}
+
+var errTokenScope = errors.New("Token scope does not allow this request")
+
+type createAPITokenPayload struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// requestScope names the scope a bearer token needs to call the route, or
+// "" when tokens may not call it at all (account, session, and admin routes).
+func requestScope(r *http.Request) string {
+	path := apiRoute(r)
+	switch {
+	case strings.HasPrefix(path, "/cards/"):
+		return scopeCardsRead
+	case path == "/decks" || strings.HasPrefix(path, "/decks/"):
+		return scopeDecksWrite
+	case strings.HasPrefix(path, "/rooms/") && (strings.HasSuffix(path, "/events") || strings.HasSuffix(path, "/log") || strings.HasSuffix(path, "/stack")):
+		return scopeRoomsEvents
+	case path == "/ws", strings.HasPrefix(path, "/rooms/") && strings.HasSuffix(path, "/stream"):
+		return scopeRoomsPlay
+	}
+	return ""
+}
+
+func (a *App) userFromAPIToken(r *http.Request, token string) (*User, error) {
+	var user User
+	var tokenID, scopes string
+	err := a.db.QueryRowContext(r.Context(), `
+		SELECT u.id, u.username, u.role, t.id, t.scopes
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP) AND `+activeAccountClause+`
+	`, hashToken(token)).Scan(&user.ID, &user.Username, &user.Role, &tokenID, &scopes)
+	if err != nil {
+		return nil, errors.New("Invalid token")
+	}
+	scope := requestScope(r)
+	if scope == "" || !containsString(strings.Split(scopes, " "), scope) {
+		return nil, errTokenScope
+	}
+	_, _ = a.db.ExecContext(r.Context(), `
+		UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-60 seconds'))
+	`, tokenID)
+	a.markActive(r.Context(), user.ID)
+	// Tokens act as the user but never with admin rights.
+	user.Role = roleUser
+	return &user, nil
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, name, scopes, created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load tokens")
+		return
+	}
+	defer rows.Close()
+	tokens := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, scopes, createdAt string
+		var lastUsedAt, expiresAt sql.NullString
+		if err := rows.Scan(&id, &name, &scopes, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+			continue
+		}
+		tokens = append(tokens, map[string]interface{}{
+			"id":         id,
+			"name":       name,
+			"scopes":     strings.Fields(scopes),
+			"createdAt":  createdAt,
+			"lastUsedAt": nullStringToPtr(lastUsedAt),
+			"expiresAt":  nullStringToPtr(expiresAt),
+		})
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleCreateAPIToken mints a token; its value is only returned here.
+func (a *App) handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload createAPITokenPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || len(name) > maxAPITokenNameLen {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("name is required and must be at most %d characters", maxAPITokenNameLen))
+		return
+	}
+	scopes := make([]string, 0, len(payload.Scopes))
+	for _, scope := range payload.Scopes {
+		if !apiTokenScopes[scope] {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("unknown scope %q", scope))
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "at least one scope is required")
+		return
+	}
+	if payload.ExpiresInDays < 0 || payload.ExpiresInDays > apiTokenMaxValidDays {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("expiresInDays must be between 0 and %d", apiTokenMaxValidDays))
+		return
+	}
+	var count int
+	_ = a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, user.ID).Scan(&count)
+	if count >= maxAPITokensPerUser {
+		writeError(w, http.StatusUnprocessableEntity, codeLimitReached, fmt.Sprintf("Token limit reached (%d tokens per user)", maxAPITokensPerUser))
+		return
+	}
+	id := randomID(12)
+	token := apiTokenPrefix + randomID(24)
+	var expiresAt interface{}
+	if payload.ExpiresInDays > 0 {
+		expiresAt = fmt.Sprintf("+%d days", payload.ExpiresInDays)
+	}
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?, datetime('now', ?))
+	`, id, user.ID, name, hashToken(token), strings.Join(scopes, " "), expiresAt); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create token")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"name":   name,
+		"scopes": scopes,
+		"token":  token,
+	})
+}
+
+func (a *App) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, chi.URLParam(r, "tokenId"), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to revoke token")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Token not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
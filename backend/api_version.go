@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiV1Prefix is the versioned API tree. Every REST route and the WebSocket
+// live under it; the unversioned paths the frontend has always used
+// (/decks, /api/rooms/..., /ws) are aliases rewritten onto it, answered
+// with Deprecation and Link headers naming the successor. Health probes stay
+// at the root since they are not part of the API contract.
+const apiV1Prefix = "/api/v1"
+
+// legacyAPIPath maps an unversioned path onto /api/v1, reporting false for
+// paths that are already versioned or are not API routes.
+func legacyAPIPath(path string) (string, bool) {
+	switch {
+	case path == apiV1Prefix || strings.HasPrefix(path, apiV1Prefix+"/"):
+		return "", false
+	case path == "/health", path == "/healthz", path == "/readyz":
+		return "", false
+	case strings.HasPrefix(path, "/api/"):
+		return apiV1Prefix + strings.TrimPrefix(path, "/api"), true
+	default:
+		return apiV1Prefix + path, true
+	}
+}
+
+// legacyRoutesMiddleware serves the unversioned aliases. It runs before
+// everything else so logging, tracing and the per-route limits all see the
+// versioned path. Paths with no versioned route are left alone and 404 as
+// before.
+func (a *App) legacyRoutesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versioned, ok := legacyAPIPath(r.URL.Path)
+		if !ok || !a.router.Match(chi.NewRouteContext(), r.Method, versioned) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+versioned+`>; rel="successor-version"`)
+		r.URL.Path = versioned
+		if r.URL.RawPath != "" {
+			if rawVersioned, ok := legacyAPIPath(r.URL.RawPath); ok {
+				r.URL.RawPath = rawVersioned
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRoute returns the request path relative to /api/v1, which is what the
+// per-route policies (rate limits, body limits, token scopes) match on.
+func apiRoute(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+}
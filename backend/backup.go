@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	backupFilePrefix = "mtonline-"
+	backupFileSuffix = ".db"
+	backupTimeFormat = "20060102T150405Z"
+)
+
+var (
+	backupRunning    atomic.Bool
+	errBackupRunning = errors.New("a backup is already running")
+)
+
+// backupSettings come from BACKUP_DIR (default data/backups),
+// BACKUP_INTERVAL_HOURS (default 24, 0 turns the schedule off) and
+// BACKUP_KEEP, the number of newest backups retained (default 7). With
+// STORAGE_S3_BUCKET set, backups are uploaded under backups/ in the bucket
+// instead of BACKUP_DIR.
+type backupSettings struct {
+	store    objectStore
+	interval time.Duration
+	keep     int
+}
+
+type backupResult struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"sizeBytes"`
+	Removed   int    `json:"removed"`
+}
+
+func loadBackupSettings() (backupSettings, error) {
+	dir := strings.TrimSpace(os.Getenv("BACKUP_DIR"))
+	if dir == "" {
+		dir = filepath.Join(rootDir(), "data", "backups")
+	}
+	store, err := loadObjectStore(dir, "backups/")
+	if err != nil {
+		return backupSettings{}, err
+	}
+	return backupSettings{
+		store:    store,
+		interval: time.Duration(envInt("BACKUP_INTERVAL_HOURS", 24)) * time.Hour,
+		keep:     envInt("BACKUP_KEEP", 7),
+	}, nil
+}
+
+func (a *App) runBackups(settings backupSettings) {
+	if settings.interval <= 0 {
+		log.Printf("[backup] scheduled backups disabled")
+		return
+	}
+	ticker := time.NewTicker(settings.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := a.backupDatabase(settings)
+		if err != nil {
+			log.Printf("[backup] scheduled backup failed: %v", err)
+			continue
+		}
+		log.Printf("[backup] wrote %s (%d bytes), removed %d old backup(s)", result.Path, result.SizeBytes, result.Removed)
+	}
+}
+
+// backupStagingDir is where VACUUM INTO writes the copy before it is stored:
+// the backup directory itself when backups stay on disk, so that storing it
+// is a rename, and otherwise beside the database, which has room for it.
+func backupStagingDir(store objectStore) string {
+	if disk, ok := store.(*diskObjectStore); ok {
+		return disk.dir
+	}
+	if path, err := databaseFilePath(); err == nil {
+		return filepath.Dir(path)
+	}
+	return os.TempDir()
+}
+
+// backupDatabase writes a consistent copy of the live database with VACUUM
+// INTO, which runs as a read transaction and so does not block writers.
+// The copy is written under a temporary name and only stored once complete,
+// so a crash never leaves a truncated file that looks like a backup.
+func (a *App) backupDatabase(settings backupSettings) (backupResult, error) {
+	var result backupResult
+	if !backupRunning.CompareAndSwap(false, true) {
+		return result, errBackupRunning
+	}
+	defer backupRunning.Store(false)
+	staging := backupStagingDir(settings.store)
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return result, err
+	}
+	name := backupFilePrefix + time.Now().UTC().Format(backupTimeFormat) + backupFileSuffix
+	partial := filepath.Join(staging, name+".partial")
+	_ = os.Remove(partial)
+	defer os.Remove(partial)
+	if _, err := a.db.Exec(`VACUUM INTO ?`, partial); err != nil {
+		return result, err
+	}
+	info, err := os.Stat(partial)
+	if err != nil {
+		return result, err
+	}
+	if err := storeBackup(settings.store, name, partial); err != nil {
+		return result, err
+	}
+	result.Path = settings.store.location(name)
+	result.SizeBytes = info.Size()
+	result.Removed, err = pruneBackups(settings)
+	return result, err
+}
+
+func storeBackup(store objectStore, name string, partial string) error {
+	if disk, ok := store.(*diskObjectStore); ok {
+		return os.Rename(partial, disk.path(name))
+	}
+	file, err := os.Open(partial)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	return store.put(ctx, name, "application/vnd.sqlite3", file)
+}
+
+// pruneBackups removes all but the newest keep backups. Names sort by their
+// timestamp, so lexical order is age order.
+func pruneBackups(settings backupSettings) (int, error) {
+	if settings.keep <= 0 {
+		return 0, nil
+	}
+	ctx := context.Background()
+	listed, err := settings.store.list(ctx, backupFilePrefix)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, name := range listed {
+		if strings.HasSuffix(name, backupFileSuffix) {
+			names = append(names, name)
+		}
+	}
+	removed := 0
+	for len(names) > settings.keep {
+		if err := settings.store.remove(ctx, names[0]); err != nil {
+			return removed, err
+		}
+		names = names[1:]
+		removed++
+	}
+	return removed, nil
+}
+
+// handleAdminBackup takes a backup on demand, e.g. before an upgrade.
+func (a *App) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	result, err := a.backupDatabase(a.backups)
+	if errors.Is(err, errBackupRunning) {
+		writeError(w, http.StatusConflict, codeConflict, "Backup already running")
+		return
+	}
+	if err != nil {
+		log.Printf("[backup] manual backup failed: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Backup failed")
+		return
+	}
+	log.Printf("[admin] %s took a backup: %s", a.currentUser(r).Username, result.Path)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// databaseFilePath returns the SQLite file behind the configured DSN.
+func databaseFilePath() (string, error) {
+	dsn, err := databaseDSN()
+	if err != nil {
+		return "", err
+	}
+	path, _, _ := strings.Cut(dsn, "?")
+	path = strings.TrimPrefix(path, "file:")
+	if path == "" || path == ":memory:" {
+		return "", errors.New("database is not a file")
+	}
+	return path, nil
+}
+
+// runRestoreCommand implements `mtonline-backend restore <backup>`, where
+// the backup is a file or the name of one in the backup storage, such as
+// mtonline-20260101T030000Z.db in the bucket. It must run while the server
+// is stopped: the backup is checked with
+// integrity_check, the current database is kept beside it as
+// <name>.pre-restore-<time>, and the backup is moved into place together
+// with removing the old WAL, which would otherwise be replayed over it.
+func runRestoreCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: mtonline-backend restore <backup file>")
+	}
+	source := args[0]
+	target, err := databaseFilePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) && !strings.ContainsAny(source, `/\`) {
+		downloaded := target + ".download"
+		if err := downloadBackup(source, downloaded); err != nil {
+			return err
+		}
+		defer os.Remove(downloaded)
+		fmt.Printf("downloaded %s\n", source)
+		source = downloaded
+	}
+	if err := checkBackupIntegrity(source); err != nil {
+		return err
+	}
+	staged := target + ".restoring"
+	if err := copyFile(source, staged); err != nil {
+		return err
+	}
+	if _, err := os.Stat(target); err == nil {
+		kept := target + ".pre-restore-" + time.Now().UTC().Format(backupTimeFormat)
+		if err := os.Rename(target, kept); err != nil {
+			_ = os.Remove(staged)
+			return err
+		}
+		fmt.Printf("previous database kept as %s\n", kept)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(target + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s\n", target, args[0])
+	return nil
+}
+
+// downloadBackup copies a stored backup to a local file.
+func downloadBackup(name string, target string) error {
+	settings, err := loadBackupSettings()
+	if err != nil {
+		return err
+	}
+	object, err := settings.store.get(context.Background(), name)
+	if errors.Is(err, errObjectNotFound) {
+		return fmt.Errorf("%s: no such file or stored backup", name)
+	}
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, object); err != nil {
+		out.Close()
+		_ = os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
+
+func checkBackupIntegrity(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var status string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&status); err != nil {
+		return fmt.Errorf("%s is not a readable SQLite database: %w", path, err)
+	}
+	if status != "ok" {
+		return fmt.Errorf("%s failed integrity check: %s", path, status)
+	}
+	return nil
+}
+
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(target)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// bodyLimits caps request bodies per endpoint class. Sizes are bytes,
+// configured as MAX_BODY_BYTES (everything not listed below),
+// MAX_ROOM_STATE_BYTES (PUT/PATCH of a room's board state, and messages sent
+// over a room stream, which may carry one),
+// MAX_ROOM_EVENT_BYTES (room event appends), MAX_DECK_BODY_BYTES (deck
+// saves, imports and card batches) and MEDIA_MAX_BYTES (image uploads).
+// Room messages are held to the same classes by type; see forMessage.
+// WS_MAX_MESSAGE_BYTES caps a whole WebSocket message, by default the
+// largest payload limit plus room for the envelope.
+type bodyLimits struct {
+	defaultBytes   int64
+	roomStateBytes int64
+	roomEventBytes int64
+	deckBytes      int64
+	mediaBytes     int64
+	wsMessageBytes int64
+}
+
+// wsEnvelopeBytes is what a WebSocket message may add around its payload.
+const wsEnvelopeBytes = 1 << 10
+
+var errWSMessageTooLarge = errors.New("websocket message too large")
+
+func loadBodyLimits() bodyLimits {
+	limits := bodyLimits{
+		defaultBytes:   int64(envInt("MAX_BODY_BYTES", 64<<10)),
+		roomStateBytes: int64(envInt("MAX_ROOM_STATE_BYTES", 4<<20)),
+		roomEventBytes: int64(envInt("MAX_ROOM_EVENT_BYTES", 256<<10)),
+		deckBytes:      int64(envInt("MAX_DECK_BODY_BYTES", 1<<20)),
+		mediaBytes:     int64(envInt("MEDIA_MAX_BYTES", 4<<20)),
+	}
+	limits.wsMessageBytes = int64(envInt("WS_MAX_MESSAGE_BYTES", 0))
+	if limits.wsMessageBytes <= 0 {
+		limits.wsMessageBytes = max(limits.defaultBytes, limits.roomStateBytes, limits.roomEventBytes) + wsEnvelopeBytes
+	}
+	return limits
+}
+
+func (l bodyLimits) forRequest(r *http.Request) int64 {
+	path := apiRoute(r)
+	switch {
+	case strings.HasPrefix(path, "/rooms/") && (strings.HasSuffix(path, "/state") || strings.HasSuffix(path, "/stream")):
+		return l.roomStateBytes
+	case strings.HasPrefix(path, "/rooms/") && strings.HasSuffix(path, "/events"):
+		return l.roomEventBytes
+	case strings.HasPrefix(path, "/decks"), path == "/cards/batch", path == "/config/ui":
+		return l.deckBytes
+	case strings.HasPrefix(path, "/me/media/"):
+		return l.mediaBytes
+	default:
+		return l.defaultBytes
+	}
+}
+
+// forMessage caps a room message's payload by what it carries: the board
+// sync relayed between host and clients is a room state, a saved event an
+// event append, and anything else an ordinary request body.
+func (l bodyLimits) forMessage(messageType string) int64 {
+	switch messageType {
+	case "room:host_message", "room:client_message":
+		return l.roomStateBytes
+	case "room:save_event":
+		return l.roomEventBytes
+	default:
+		return l.defaultBytes
+	}
+}
+
+// readWSMessage reads the next message from the connection, giving up with
+// errWSMessageTooLarge once it runs past limit rather than buffering the
+// rest of it.
+func readWSMessage(conn *websocket.Conn, limit int64) ([]byte, error) {
+	_, reader, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return io.ReadAll(reader)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errWSMessageTooLarge
+	}
+	return data, nil
+}
+
+// bodyLimitMiddleware rejects bodies whose declared length is over the limit
+// up front and cuts off the rest while they are read; handlers report the
+// latter through writeBodyError.
+func bodyLimitMiddleware(limits bodyLimits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || apiRoute(r) == "/ws" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limit := limits.forRequest(r)
+			if limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				writeBodyTooLarge(w, limit)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+		fmt.Sprintf("Request body is too large (limit %d bytes)", limit), map[string]int64{"limitBytes": limit})
+}
+
+// writeBodyError answers a request whose body could not be read or decoded:
+// 413 when it ran past the size limit, otherwise 400 with message.
+func writeBodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, tooLarge.Limit)
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidRequest, message)
+}
+
+// applyServerTimeouts bounds how long a client may take to send a request
+// and read the response, so slow or stalled connections cannot pile up.
+// WebSocket connections clear these deadlines once upgraded.
+func applyServerTimeouts(server *http.Server) {
+	server.ReadHeaderTimeout = time.Duration(envInt("HTTP_READ_HEADER_TIMEOUT_SECONDS", 10)) * time.Second
+	server.ReadTimeout = time.Duration(envInt("HTTP_READ_TIMEOUT_SECONDS", 30)) * time.Second
+	server.WriteTimeout = time.Duration(envInt("HTTP_WRITE_TIMEOUT_SECONDS", 60)) * time.Second
+	server.IdleTimeout = time.Duration(envInt("HTTP_IDLE_TIMEOUT_SECONDS", 120)) * time.Second
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Automated players use the same protocol as the browser client:
+//
+//  1. Create an API token with the rooms:play scope (POST /me/tokens).
+//  2. Open /api/v1/ws, or GET /api/v1/rooms/{roomId}/stream where WebSockets
+//     are unavailable, with "Authorization: Bearer <token>". The bot then
+//     plays as the token's account.
+//  3. Send room:join with the roomId, password, playerId and playerName,
+//     then wait for the host's room:host_message carrying ROOM_STATE.
+//  4. Act by sending room:client_message with a message of
+//     {"type": "REQUEST_ACTION", "action": <CardAction>, "actorId": <playerId>},
+//     where the action is one of the CARD_ACTION kinds in
+//     room_event_schema.go. Cards belong to players by playerName.
+//
+// Everything the host broadcasts arrives as room:host_message, exactly as it
+// does for a person at the table.
+//
+// For solo playtesting the server can seat a goldfish itself: POST
+// /rooms/{roomId}/goldfish with a deck joins an in-process player that loads
+// the deck into its library, draws an opening hand and then draws a card
+// every turnSeconds. It leaves when the room closes or on DELETE
+// /rooms/{roomId}/goldfish/{socketId}.
+
+const (
+	goldfishDefaultName = "Goldfish"
+	goldfishDefaultTurn = 30 * time.Second
+	goldfishMinTurn     = 5 * time.Second
+	goldfishMaxTurn     = 10 * time.Minute
+	goldfishJoinTimeout = 30 * time.Second
+	maxGoldfishPerRoom  = 3
+	maxGoldfishNameLen  = 32
+)
+
+var errBotTokenRejected = errors.New("bot token rejected")
+
+// socketUser identifies who opens a WebSocket or room stream. A missing or
+// expired session cookie just means an anonymous player, as it always has,
+// but a bearer token that does not work is reported: only bots send one.
+func (a *App) socketUser(w http.ResponseWriter, r *http.Request) (*User, error) {
+	user, err := a.userFromRequest(r)
+	if err == nil {
+		return user, nil
+	}
+	if bearerToken(r) == "" {
+		return nil, nil
+	}
+	if errors.Is(err, errTokenScope) {
+		writeError(w, http.StatusForbidden, codeInsufficientScope, "Token needs the "+scopeRoomsPlay+" scope to join rooms")
+	} else {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, err.Error())
+	}
+	return nil, errBotTokenRejected
+}
+
+type goldfishPayload struct {
+	DeckID      string `json:"deckId"`
+	PlayerName  string `json:"playerName"`
+	TurnSeconds int    `json:"turnSeconds"`
+}
+
+// goldfishBot is a server-side player. Its client receives room messages
+// through a roomStream that the bot reads instead of an HTTP response.
+type goldfishBot struct {
+	app      *App
+	client   *WSClient
+	roomID   string
+	playerID string
+	name     string
+	password string
+	turn     time.Duration
+	library  []map[string]interface{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func (a *App) handleAddGoldfish(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	var payload goldfishPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	name := strings.TrimSpace(payload.PlayerName)
+	if name == "" {
+		name = goldfishDefaultName
+	}
+	if len(name) > maxGoldfishNameLen {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("playerName must be at most %d characters", maxGoldfishNameLen))
+		return
+	}
+	name, problem := a.filterText("playerName", name)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	turn := goldfishDefaultTurn
+	if payload.TurnSeconds != 0 {
+		turn = time.Duration(payload.TurnSeconds) * time.Second
+		if turn < goldfishMinTurn || turn > goldfishMaxTurn {
+			writeError(w, http.StatusBadRequest, codeValidationFailed,
+				fmt.Sprintf("turnSeconds must be between %d and %d", int(goldfishMinTurn.Seconds()), int(goldfishMaxTurn.Seconds())))
+			return
+		}
+	}
+	password, ok := a.rooms.password(roomID)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Room not found")
+		return
+	}
+	if a.rooms.hasPlayerName(roomID, name) {
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("A player named %q is already in the room", name))
+		return
+	}
+	if a.goldfishCount(roomID) >= maxGoldfishPerRoom {
+		writeError(w, http.StatusUnprocessableEntity, codeLimitReached, fmt.Sprintf("Goldfish limit reached (%d per room)", maxGoldfishPerRoom))
+		return
+	}
+	// Room access comes from the room token, so the caller's own decks are
+	// only visible when they are signed in as well.
+	user, _ := a.userFromRequest(r)
+	row, err := a.loadVisibleDeck(r.Context(), user, payload.DeckID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	var entries []deckEntry
+	if err := json.Unmarshal([]byte(row.Entries), &entries); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidDeck, "Deck entries are not in a known format")
+		return
+	}
+	cards := libraryCards(entries)
+	if len(cards) < openingHandSize || len(cards) > maxLibraryCards {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidDeck,
+			fmt.Sprintf("Deck needs between %d and %d library cards", openingHandSize, maxLibraryCards))
+		return
+	}
+
+	bot := &goldfishBot{
+		app:      a,
+		roomID:   roomID,
+		playerID: "goldfish-" + randomID(4),
+		name:     name,
+		password: password,
+		turn:     turn,
+		library:  a.deckLibrary(r.Context(), cards, name, randomSeed()),
+		stop:     make(chan struct{}),
+	}
+	bot.client = &WSClient{
+		id: randomID(8),
+		stream: &roomStream{
+			roomID:   roomID,
+			secret:   randomID(16),
+			events:   make(chan []byte, streamBuffer),
+			overflow: make(chan struct{}),
+		},
+		bot: bot,
+	}
+	a.registerClient(bot.client)
+	go bot.run()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"socketId":    bot.client.id,
+		"playerId":    bot.playerID,
+		"playerName":  bot.name,
+		"librarySize": len(bot.library),
+		"turnSeconds": int(turn.Seconds()),
+	})
+}
+
+func (a *App) handleRemoveGoldfish(w http.ResponseWriter, r *http.Request) {
+	a.clientsMu.RLock()
+	client := a.clients[chi.URLParam(r, "socketId")]
+	a.clientsMu.RUnlock()
+	if client == nil || client.bot == nil || client.bot.roomID != chi.URLParam(r, "roomId") {
+		writeError(w, http.StatusNotFound, codeNotFound, "Goldfish not found")
+		return
+	}
+	client.bot.stopOnce.Do(func() { close(client.bot.stop) })
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (a *App) goldfishCount(roomID string) int {
+	a.clientsMu.RLock()
+	defer a.clientsMu.RUnlock()
+	count := 0
+	for _, client := range a.clients {
+		if client.bot != nil && client.bot.roomID == roomID {
+			count++
+		}
+	}
+	return count
+}
+
+func (b *goldfishBot) run() {
+	defer b.app.unregisterClient(b.client)
+	b.handle("room:join", RoomJoinPayload{
+		RoomID:     b.roomID,
+		Password:   b.password,
+		PlayerID:   b.playerID,
+		PlayerName: b.name,
+	})
+	if !b.waitForTable() {
+		return
+	}
+	b.act(map[string]interface{}{"kind": "replaceLibrary", "cards": b.library, "playerName": b.name})
+	drawn := 0
+	for ; drawn < openingHandSize; drawn++ {
+		b.act(map[string]interface{}{"kind": "drawFromLibrary", "playerName": b.name})
+	}
+	log.Printf("[goldfish] %s joined %s with %d cards", b.name, b.roomID, len(b.library))
+
+	turn := time.NewTicker(b.turn)
+	defer turn.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.client.stream.overflow:
+			return
+		case data := <-b.client.stream.events:
+			if b.roomClosed(data) {
+				return
+			}
+		case <-turn.C:
+			// A goldfish has no decisions to make: its turn is a draw.
+			if drawn < len(b.library) {
+				b.act(map[string]interface{}{"kind": "drawFromLibrary", "playerName": b.name})
+				drawn++
+			}
+		}
+	}
+}
+
+// waitForTable waits until the host has seated the bot, which it signals by
+// sending the room state.
+func (b *goldfishBot) waitForTable() bool {
+	timeout := time.NewTimer(goldfishJoinTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return false
+		case <-b.client.stream.overflow:
+			return false
+		case <-timeout.C:
+			log.Printf("[goldfish] %s gave up joining %s: the host did not answer", b.name, b.roomID)
+			return false
+		case data := <-b.client.stream.events:
+			var message WSMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+			switch message.Type {
+			case "room:error", "room:closed":
+				log.Printf("[goldfish] %s could not join %s: %s", b.name, b.roomID, message.Payload)
+				return false
+			case "room:host_message":
+				var hostMessage struct {
+					Type    string `json:"type"`
+					Message string `json:"message"`
+				}
+				_ = json.Unmarshal(message.Payload, &hostMessage)
+				switch hostMessage.Type {
+				case "ROOM_STATE":
+					return true
+				case "ERROR":
+					log.Printf("[goldfish] %s was turned away from %s: %s", b.name, b.roomID, hostMessage.Message)
+					return false
+				}
+			}
+		}
+	}
+}
+
+func (b *goldfishBot) roomClosed(data []byte) bool {
+	var message WSMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return false
+	}
+	return message.Type == "room:closed"
+}
+
+func (b *goldfishBot) handle(messageType string, payload interface{}) {
+	b.app.handleWSMessage(b.client, WSMessage{Type: messageType, Payload: marshalPayload(payload)})
+}
+
+// act asks the host to apply a card action, as a seated player's client does.
+func (b *goldfishBot) act(action map[string]interface{}) {
+	b.handle("room:client_message", RoomClientMessagePayload{
+		RoomID: b.roomID,
+		Message: map[string]interface{}{
+			"type":    "REQUEST_ACTION",
+			"action":  action,
+			"actorId": b.playerID,
+		},
+	})
+}
+
+func (r *RoomRegistry) password(roomID string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return "", false
+	}
+	return room.Password, true
+}
+
+func (r *RoomRegistry) hasPlayerName(roomID string, name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return false
+	}
+	if room.HostPlayerName == name {
+		return true
+	}
+	for _, client := range room.Clients {
+		if client.PlayerName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	capacityRooms   = "rooms"
+	capacityClients = "clients"
+)
+
+// capacityLimits keep a spike of players from taking an instance down by
+// exhausting its memory. MAX_ROOMS caps the rooms the instance holds, counting
+// those mirrored from peers over the room bus since they take the same
+// memory, and MAX_CLIENTS the sockets on this instance that are in a room;
+// 0 leaves either unlimited. They are checked when a room is created or
+// joined, so players already in a room are never dropped; creates racing
+// each other can overshoot a limit by a few. Refusals are counted for
+// /metrics and /admin/capacity.
+type capacityLimits struct {
+	maxRooms        int
+	maxClients      int
+	rejectedRooms   atomic.Int64
+	rejectedClients atomic.Int64
+}
+
+type capacityUsage struct {
+	Current  int   `json:"current"`
+	Limit    int   `json:"limit"`
+	Rejected int64 `json:"rejected"`
+}
+
+type capacityReport struct {
+	Rooms     capacityUsage `json:"rooms"`
+	Clients   capacityUsage `json:"clients"`
+	Connected int           `json:"connected"`
+}
+
+func loadCapacityLimits() *capacityLimits {
+	return &capacityLimits{
+		maxRooms:   envInt("MAX_ROOMS", 0),
+		maxClients: envInt("MAX_CLIENTS", 0),
+	}
+}
+
+// counts returns the rooms held in memory and how many of the sockets in a
+// room local says are this instance's.
+func (r *RoomRegistry) counts(local func(string) bool) (rooms int, inRooms int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for socketID := range r.socketToRoom {
+		if local(socketID) {
+			inRooms++
+		}
+	}
+	return len(r.rooms), inRooms
+}
+
+func (a *App) capacityReport() capacityReport {
+	a.clientsMu.RLock()
+	defer a.clientsMu.RUnlock()
+	rooms, inRooms := a.rooms.counts(func(socketID string) bool { return a.clients[socketID] != nil })
+	return capacityReport{
+		Rooms:     capacityUsage{Current: rooms, Limit: a.capacity.maxRooms, Rejected: a.capacity.rejectedRooms.Load()},
+		Clients:   capacityUsage{Current: inRooms, Limit: a.capacity.maxClients, Rejected: a.capacity.rejectedClients.Load()},
+		Connected: len(a.clients),
+	}
+}
+
+// admitToRoom checks the limits before the client creates or joins a room,
+// and tells them which one is reached when it refuses.
+func (a *App) admitToRoom(client *WSClient, creating bool) bool {
+	limits := a.capacity
+	if limits.maxRooms <= 0 && limits.maxClients <= 0 {
+		return true
+	}
+	report := a.capacityReport()
+	kind, usage := "", capacityUsage{}
+	switch {
+	case creating && limits.maxRooms > 0 && report.Rooms.Current >= limits.maxRooms:
+		kind, usage = capacityRooms, report.Rooms
+		limits.rejectedRooms.Add(1)
+	case limits.maxClients > 0 && report.Clients.Current >= limits.maxClients:
+		kind, usage = capacityClients, report.Clients
+		limits.rejectedClients.Add(1)
+	default:
+		return true
+	}
+	message := "This server is hosting as many rooms as it can; try again later"
+	if kind == capacityClients {
+		message = "This server has as many players as it can take; try again later"
+	}
+	a.sendErrorDetails(client.id, codeServerFull, message, map[string]interface{}{
+		"limit":   kind,
+		"current": usage.Current,
+		"max":     usage.Limit,
+	})
+	return false
+}
+
+func (a *App) handleAdminCapacity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.capacityReport())
+}
+
+// handleMetrics reports the room registry's counts in the Prometheus text
+// format. With METRICS_TOKEN set, scrapers must send it as a bearer token.
+func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if token := strings.TrimSpace(os.Getenv("METRICS_TOKEN")); token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Metrics token required")
+			return
+		}
+	}
+	report := a.capacityReport()
+	var b strings.Builder
+	gauge := func(name string, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("mtonline_rooms", "Rooms held in memory, including those mirrored from peers.", report.Rooms.Current)
+	gauge("mtonline_room_clients", "Sockets on this instance that are in a room.", report.Clients.Current)
+	gauge("mtonline_connected_clients", "Sockets connected to this instance.", report.Connected)
+	gauge("mtonline_max_rooms", "MAX_ROOMS, or 0 when unlimited.", report.Rooms.Limit)
+	gauge("mtonline_max_clients", "MAX_CLIENTS, or 0 when unlimited.", report.Clients.Limit)
+	b.WriteString("# HELP mtonline_capacity_rejections_total Room creates and joins refused by a capacity limit.\n")
+	b.WriteString("# TYPE mtonline_capacity_rejections_total counter\n")
+	fmt.Fprintf(&b, "mtonline_capacity_rejections_total{limit=%q} %d\n", capacityRooms, report.Rooms.Rejected)
+	fmt.Fprintf(&b, "mtonline_capacity_rejections_total{limit=%q} %d\n", capacityClients, report.Clients.Rejected)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
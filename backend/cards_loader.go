@@ -14,6 +14,29 @@ import (
 
 const cardsImportBatchLog = 50000
 
+const upsertCardSQL = `
+	INSERT INTO cards (
+		id, name, name_normalized, set_code, collector_number, type_line,
+		mana_cost, oracle_text, image_url, back_image_url, set_name, layout, prints_search_uri,
+		art_crop_url, border_crop_url
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name = excluded.name,
+		name_normalized = excluded.name_normalized,
+		set_code = excluded.set_code,
+		collector_number = excluded.collector_number,
+		type_line = excluded.type_line,
+		mana_cost = excluded.mana_cost,
+		oracle_text = excluded.oracle_text,
+		image_url = excluded.image_url,
+		back_image_url = excluded.back_image_url,
+		set_name = excluded.set_name,
+		layout = excluded.layout,
+		prints_search_uri = excluded.prints_search_uri,
+		art_crop_url = excluded.art_crop_url,
+		border_crop_url = excluded.border_crop_url
+`
+
 type scryfallFace struct {
 	OracleText string            `json:"oracle_text"`
 	ImageUris  map[string]string `json:"image_uris"`
@@ -32,6 +55,7 @@ type scryfallCard struct {
 	PrintsSearchURI string            `json:"prints_search_uri"`
 	ImageUris       map[string]string `json:"image_uris"`
 	CardFaces       []scryfallFace    `json:"card_faces"`
+	Legalities      map[string]string `json:"legalities"`
 }
 
 func ensureCardsLoaded(db *sql.DB) error {
@@ -105,30 +129,13 @@ func loadCardsFromJSON(db *sql.DB, path string) error {
 		return err
 	}
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO cards (
-			id, name, name_normalized, set_code, collector_number, type_line,
-			mana_cost, oracle_text, image_url, back_image_url, set_name, layout, prints_search_uri
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(id) DO UPDATE SET
-			name = excluded.name,
-			name_normalized = excluded.name_normalized,
-			set_code = excluded.set_code,
-			collector_number = excluded.collector_number,
-			type_line = excluded.type_line,
-			mana_cost = excluded.mana_cost,
-			oracle_text = excluded.oracle_text,
-			image_url = excluded.image_url,
-			back_image_url = excluded.back_image_url,
-			set_name = excluded.set_name,
-			layout = excluded.layout,
-			prints_search_uri = excluded.prints_search_uri
-	`)
+	stmt, err := tx.Prepare(upsertCardSQL)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
+	bans := make(map[formatBanKey]importedBan)
 	count := 0
 	for decoder.More() {
 		var card scryfallCard
@@ -142,47 +149,47 @@ func loadCardsFromJSON(db *sql.DB, path string) error {
 			continue
 		}
 
-		name := strings.TrimSpace(card.Name)
-		nameNormalized := strings.ToLower(name)
-		setCode := strings.ToLower(strings.TrimSpace(card.Set))
-		if setCode == "" {
-			setCode = ""
-		}
-
-		imageURL := pickImageURL(card)
-		backImageURL := pickBackImageURL(card)
-		oracleText := extractOracleText(card)
-
-		if _, err = stmt.Exec(
-			card.ID,
-			name,
-			nameNormalized,
-			nullIfEmptyString(setCode),
-			nullIfEmptyString(strings.TrimSpace(card.CollectorNumber)),
-			nullIfEmptyString(strings.TrimSpace(card.TypeLine)),
-			nullIfEmptyString(strings.TrimSpace(card.ManaCost)),
-			nullIfEmptyString(oracleText),
-			nullIfEmptyString(imageURL),
-			nullIfEmptyString(backImageURL),
-			nullIfEmptyString(strings.TrimSpace(card.SetName)),
-			nullIfEmptyString(strings.TrimSpace(card.Layout)),
-			nullIfEmptyString(strings.TrimSpace(card.PrintsSearchURI)),
-		); err != nil {
+		if _, err = stmt.Exec(cardInsertArgs(card)...); err != nil {
 			return err
 		}
+		collectFormatBans(bans, card)
 		count++
 		if count%cardsImportBatchLog == 0 {
 			log.Printf("[cards] imported %d...", count)
 		}
 	}
 
+	if err = replaceImportedBans(tx, bans); err != nil {
+		return err
+	}
 	if err = tx.Commit(); err != nil {
 		return err
 	}
-	log.Printf("[cards] import complete (%d cards)", count)
+	log.Printf("[cards] import complete (%d cards, %d format bans)", count, len(bans))
 	return nil
 }
 
+func cardInsertArgs(card scryfallCard) []interface{} {
+	name := strings.TrimSpace(card.Name)
+	return []interface{}{
+		card.ID,
+		name,
+		strings.ToLower(name),
+		nullIfEmptyString(strings.ToLower(strings.TrimSpace(card.Set))),
+		nullIfEmptyString(strings.TrimSpace(card.CollectorNumber)),
+		nullIfEmptyString(strings.TrimSpace(card.TypeLine)),
+		nullIfEmptyString(strings.TrimSpace(card.ManaCost)),
+		nullIfEmptyString(extractOracleText(card)),
+		nullIfEmptyString(pickImageURL(card)),
+		nullIfEmptyString(pickBackImageURL(card)),
+		nullIfEmptyString(strings.TrimSpace(card.SetName)),
+		nullIfEmptyString(strings.TrimSpace(card.Layout)),
+		nullIfEmptyString(strings.TrimSpace(card.PrintsSearchURI)),
+		nullIfEmptyString(pickFrontImage(card, imageSizeArtCrop)),
+		nullIfEmptyString(pickFrontImage(card, imageSizeBorderCrop)),
+	}
+}
+
 func nullIfEmptyString(value string) interface{} {
 	if strings.TrimSpace(value) == "" {
 		return nil
@@ -249,6 +256,18 @@ func pickBackImageURL(card scryfallCard) string {
 	return ""
 }
 
+// pickFrontImage returns one of the card's image URIs by key, from the first
+// face for cards whose faces have separate images.
+func pickFrontImage(card scryfallCard, key string) string {
+	if url := strings.TrimSpace(card.ImageUris[key]); url != "" {
+		return url
+	}
+	if len(card.CardFaces) > 0 {
+		return strings.TrimSpace(card.CardFaces[0].ImageUris[key])
+	}
+	return ""
+}
+
 func pickBestImage(uris map[string]string) string {
 	if uris == nil {
 		return ""
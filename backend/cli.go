@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// cliCommand is one `mtonline-backend <name>` subcommand. Each parses its
+// own flags; the TOML file and environment are loaded before any of them
+// run, so they all see the same database and paths as the server.
+type cliCommand struct {
+	summary string
+	run     func(config *Config, args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"serve":        {"run the API and WebSocket server (the default)", runServeCommand},
+	"migrate":      {"apply pending migrations, or `migrate status` to list them", runMigrateCLI},
+	"import-cards": {"load cards.json into the database", runImportCardsCommand},
+	"create-admin": {"create an admin account or promote an existing one", runCreateAdminCommand},
+	"prune-rooms":  {"delete rooms idle for longer than the retention window", runPruneRoomsCommand},
+	"backup":       {"write a database backup now", runBackupCommand},
+	"restore":      {"replace the database with a backup (server must be stopped)", runRestoreCLI},
+}
+
+// runCLI dispatches to a subcommand. With no arguments, or only flags, the
+// server starts as it always has.
+func runCLI(config *Config, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && !isHelpArg(args[0]) {
+		return runServeCommand(config, args)
+	}
+	name := args[0]
+	if name == "help" || isHelpArg(name) {
+		printCLIUsage(os.Stdout)
+		return nil
+	}
+	command, ok := cliCommands[name]
+	if !ok {
+		printCLIUsage(os.Stderr)
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return command.run(config, args[1:])
+}
+
+func isHelpArg(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func printCLIUsage(out *os.File) {
+	fmt.Fprintln(out, "usage: mtonline-backend [command] [flags]")
+	fmt.Fprintln(out)
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-13s %s\n", name, cliCommands[name].summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run `mtonline-backend <command> -h` for a command's flags.")
+}
+
+// parseCLIFlags parses a subcommand's flags, treating -h as success so the
+// usage text is not followed by an error.
+func parseCLIFlags(flags *flag.FlagSet, args []string) (bool, error) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// openMigratedDatabase opens the configured database for a one-off command
+// and brings its schema up to date first.
+func openMigratedDatabase() (*sql.DB, error) {
+	db, err := openDatabase(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if _, err := runMigrations(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return db, nil
+}
+
+func runMigrateCLI(config *Config, args []string) error {
+	db, err := openDatabase(nil)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	return runMigrateCommand(db, args)
+}
+
+func runRestoreCLI(config *Config, args []string) error {
+	return runRestoreCommand(args)
+}
+
+func runImportCardsCommand(config *Config, args []string) error {
+	flags := flag.NewFlagSet("import-cards", flag.ContinueOnError)
+	path := flags.String("path", "", "cards.json to import (default: CARDS_JSON_PATH or the usual locations)")
+	if ok, err := parseCLIFlags(flags, args); !ok {
+		return err
+	}
+	if *path == "" {
+		resolved, err := resolveCardsJSONPath()
+		if err != nil {
+			return err
+		}
+		*path = resolved
+	}
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := loadCardsFromJSON(db, *path); err != nil {
+		return err
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM cards`).Scan(&count); err != nil {
+		return err
+	}
+	fmt.Printf("imported %s: %d cards in the database\n", *path, count)
+	return nil
+}
+
+func runCreateAdminCommand(config *Config, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := flags.String("username", "", "account to create or promote")
+	password := flags.String("password", "", "password for a new account, or a new password for an existing one (read from stdin when omitted for a new account)")
+	if ok, err := parseCLIFlags(flags, args); !ok {
+		return err
+	}
+	*username = strings.TrimSpace(*username)
+	if len(*username) < 3 {
+		return errors.New("-username must be at least 3 characters")
+	}
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var userID int64
+	err = db.QueryRow(`SELECT id FROM users WHERE username = ?`, *username).Scan(&userID)
+	if err == nil {
+		if _, err := db.Exec(`UPDATE users SET role = ? WHERE id = ?`, roleAdmin, userID); err != nil {
+			return err
+		}
+		if *password != "" {
+			if _, err := db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hashPassword(*password), userID); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("promoted %s (id %d) to admin\n", *username, userID)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("a password is required for a new account")
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if len(*password) < 4 {
+		return errors.New("password must be at least 4 characters")
+	}
+	result, err := db.Exec(`
+		INSERT INTO users (username, password_hash, role)
+		VALUES (?, ?, ?)
+	`, *username, hashPassword(*password), roleAdmin)
+	if err != nil {
+		return err
+	}
+	userID, _ = result.LastInsertId()
+	fmt.Printf("created admin %s (id %d)\n", *username, userID)
+	return nil
+}
+
+// runPruneRoomsCommand prunes from outside the server, so it cannot see
+// which rooms a running instance has open; the retention window is what
+// keeps those safe, as they are updated while played.
+func runPruneRoomsCommand(config *Config, args []string) error {
+	flags := flag.NewFlagSet("prune-rooms", flag.ContinueOnError)
+	days := flags.Int("days", loadRoomRetentionDays(), "delete rooms idle for more than this many days")
+	if ok, err := parseCLIFlags(flags, args); !ok {
+		return err
+	}
+	if *days <= 0 {
+		return errors.New("-days must be positive")
+	}
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	app := &App{db: db, rooms: NewRoomRegistry()}
+	result, err := app.pruneStaleRooms(*days)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pruned %d rooms, %d events, %d snapshots older than %d days\n", result.Rooms, result.Events, result.Snapshots, *days)
+	return nil
+}
+
+func runBackupCommand(config *Config, args []string) error {
+	settings, err := loadBackupSettings()
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory to write the backup to (default: the configured backup storage)")
+	flags.IntVar(&settings.keep, "keep", settings.keep, "number of newest backups to keep (0 keeps all)")
+	if ok, err := parseCLIFlags(flags, args); !ok {
+		return err
+	}
+	if *dir != "" {
+		settings.store = &diskObjectStore{dir: *dir}
+	}
+	db, err := openDatabase(nil)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	app := &App{db: db}
+	result, err := app.backupDatabase(settings)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (%d bytes), removed %d old backup(s)\n", result.Path, result.SizeBytes, result.Removed)
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressSettings controls response compression. COMPRESS_LEVEL is the
+// gzip level (1-9, 0 disables compression) and COMPRESS_MIN_BYTES the
+// smallest body worth compressing; smaller responses go out as-is since the
+// gzip framing would eat most of the savings.
+type compressSettings struct {
+	level    int
+	minBytes int
+}
+
+func loadCompressSettings() compressSettings {
+	level := envInt("COMPRESS_LEVEL", 5)
+	if level > gzip.BestCompression {
+		level = gzip.BestCompression
+	}
+	if level < 0 {
+		level = 0
+	}
+	return compressSettings{level: level, minBytes: envInt("COMPRESS_MIN_BYTES", 1024)}
+}
+
+// compressibleTypes are the response types worth compressing: the JSON API
+// plus the text formats the admin and export endpoints produce.
+var compressibleTypes = map[string]bool{
+	"application/json":         true,
+	"application/javascript":   true,
+	"application/xml":          true,
+	"image/svg+xml":            true,
+	"text/plain":               true,
+	"text/html":                true,
+	"text/css":                 true,
+	"text/csv":                 true,
+	"application/x-ndjson":     true,
+	"application/problem+json": true,
+}
+
+// compressMiddleware gzips responses for clients that accept it. The body is
+// buffered until it reaches minBytes, so the decision can take both the
+// content type and the size into account.
+func compressMiddleware(settings compressSettings) func(http.Handler) http.Handler {
+	pool := sync.Pool{New: func() interface{} {
+		writer, _ := gzip.NewWriterLevel(io.Discard, settings.level)
+		return writer
+	}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if settings.level == 0 || r.Method == http.MethodHead || apiRoute(r) == "/ws" || isRoomStream(r) ||
+				r.Header.Get("Range") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, pool: &pool, minBytes: settings.minBytes}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, honouring
+// an explicit q=0.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil && q == 0 {
+					accepted = false
+				}
+			}
+		}
+		if accepted {
+			return true
+		}
+	}
+	return false
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	pool     *sync.Pool
+	minBytes int
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status != 0 || c.decided {
+		return
+	}
+	c.status = status
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.decided {
+		if c.gz != nil {
+			return c.gz.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf.Write(p)
+	if c.buf.Len() >= c.minBytes {
+		if err := c.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide picks compressed or plain output, sends the header and flushes what
+// has been buffered so far.
+func (c *compressWriter) decide() error {
+	c.decided = true
+	header := c.Header()
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.shouldCompress() {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		c.gz = c.pool.Get().(*gzip.Writer)
+		c.gz.Reset(c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if c.gz != nil {
+		_, err = c.gz.Write(c.buf.Bytes())
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf.Bytes())
+	}
+	c.buf.Reset()
+	return err
+}
+
+func (c *compressWriter) shouldCompress() bool {
+	header := c.Header()
+	if c.status < http.StatusOK || c.status == http.StatusNoContent || c.status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !compressibleTypes[mediaType] {
+		return false
+	}
+	// Caches must keep gzip and identity copies apart whether or not this
+	// particular response ends up compressed.
+	header.Add("Vary", "Accept-Encoding")
+	return c.buf.Len() >= c.minBytes
+}
+
+func (c *compressWriter) close() {
+	if !c.decided {
+		if c.status == 0 && c.buf.Len() == 0 {
+			// The handler wrote nothing; leave the implicit 200 to net/http.
+			return
+		}
+		_ = c.decide()
+	}
+	if c.gz != nil {
+		_ = c.gz.Close()
+		c.gz.Reset(io.Discard)
+		c.pool.Put(c.gz)
+		c.gz = nil
+	}
+}
+
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		_ = c.decide()
+	}
+	if c.gz != nil {
+		_ = c.gz.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := c.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("compress: underlying writer does not support hijacking")
+}
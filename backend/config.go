@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const defaultConfigFile = "mtonline.toml"
+
+// Config is the server's effective configuration. It can come from a TOML
+// file (MTONLINE_CONFIG, or mtonline.toml in the working directory when
+// present), with each setting overridden by its environment variable. Values
+// taken from the file are exported to the environment, so the loaders that
+// read env directly see the same settings.
+//
+// Field tags: toml is the key within the section, env lists the variables
+// that override it (first set wins), default is what the server uses when
+// the setting is absent, and secret hides the value when printed.
+type Config struct {
+	Server struct {
+		Port           int    `toml:"port" env:"API_PORT,PORT" default:"3000"`
+		ClientHost     string `toml:"client_host" env:"VITE_CLIENT_HOST"`
+		ClientPort     string `toml:"client_port" env:"VITE_CLIENT_PORT"`
+		TLSCert        string `toml:"tls_cert" env:"TLS_CERT"`
+		TLSKey         string `toml:"tls_key" env:"TLS_KEY"`
+		TrustedProxies string `toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+		AllowedOrigins string `toml:"allowed_origins" env:"ALLOWED_ORIGINS"`
+
+		ReadHeaderTimeoutSecs int `toml:"read_header_timeout_seconds" env:"HTTP_READ_HEADER_TIMEOUT_SECONDS" default:"10"`
+		ReadTimeoutSecs       int `toml:"read_timeout_seconds" env:"HTTP_READ_TIMEOUT_SECONDS" default:"30"`
+		WriteTimeoutSecs      int `toml:"write_timeout_seconds" env:"HTTP_WRITE_TIMEOUT_SECONDS" default:"60"`
+		IdleTimeoutSecs       int `toml:"idle_timeout_seconds" env:"HTTP_IDLE_TIMEOUT_SECONDS" default:"120"`
+		MaxBodyBytes          int `toml:"max_body_bytes" env:"MAX_BODY_BYTES" default:"65536"`
+		MaxRoomStateBytes     int `toml:"max_room_state_bytes" env:"MAX_ROOM_STATE_BYTES" default:"4194304"`
+		MaxRoomEventBytes     int `toml:"max_room_event_bytes" env:"MAX_ROOM_EVENT_BYTES" default:"262144"`
+		MaxDeckBodyBytes      int `toml:"max_deck_body_bytes" env:"MAX_DECK_BODY_BYTES" default:"1048576"`
+		WSMaxMessageBytes     int `toml:"ws_max_message_bytes" env:"WS_MAX_MESSAGE_BYTES"`
+		CompressLevel         int `toml:"compress_level" env:"COMPRESS_LEVEL" default:"5"`
+		CompressMinBytes      int `toml:"compress_min_bytes" env:"COMPRESS_MIN_BYTES" default:"1024"`
+		MaxRooms              int `toml:"max_rooms" env:"MAX_ROOMS" default:"0"`
+		MaxClients            int `toml:"max_clients" env:"MAX_CLIENTS" default:"0"`
+
+		MetricsToken string `toml:"metrics_token" env:"METRICS_TOKEN" secret:"true"`
+	} `toml:"server"`
+	Database struct {
+		Path                 string `toml:"path" env:"DATABASE_PATH" default:"data/mtonline.db"`
+		URL                  string `toml:"url" env:"DATABASE_URL" secret:"true"`
+		BusyTimeoutMS        int    `toml:"busy_timeout_ms" env:"DB_BUSY_TIMEOUT_MS" default:"5000"`
+		QueryTimeoutMS       int    `toml:"query_timeout_ms" env:"QUERY_TIMEOUT_MS" default:"10000"`
+		SearchQueryTimeoutMS int    `toml:"search_query_timeout_ms" env:"QUERY_TIMEOUT_SEARCH_MS" default:"2000"`
+		AdminQueryTimeoutMS  int    `toml:"admin_query_timeout_ms" env:"QUERY_TIMEOUT_ADMIN_MS" default:"60000"`
+		CacheSize            int    `toml:"cache_size" env:"DB_CACHE_SIZE" default:"-2000"`
+		Synchronous          string `toml:"synchronous" env:"DB_SYNCHRONOUS"`
+	} `toml:"database"`
+	Cards struct {
+		JSONPath         string `toml:"json_path" env:"CARDS_JSON_PATH"`
+		ScryfallFallback bool   `toml:"scryfall_fallback" env:"SCRYFALL_FALLBACK"`
+	} `toml:"cards"`
+	Auth struct {
+		AdminUsername         string `toml:"admin_username" env:"ADMIN_USERNAME"`
+		CSRFMode              string `toml:"csrf_mode" env:"CSRF_MODE" default:"report"`
+		CookieSecure          string `toml:"cookie_secure" env:"COOKIE_SECURE" default:"auto"`
+		CookieSameSite        string `toml:"cookie_samesite" env:"COOKIE_SAMESITE" default:"lax"`
+		CookieDomain          string `toml:"cookie_domain" env:"COOKIE_DOMAIN"`
+		LoginRateMaxAttempts  int    `toml:"login_rate_max_attempts" env:"LOGIN_RATE_MAX_ATTEMPTS" default:"10"`
+		LoginRateWindowSecs   int    `toml:"login_rate_window_seconds" env:"LOGIN_RATE_WINDOW_SECONDS" default:"60"`
+		LoginLockoutThreshold int    `toml:"login_lockout_threshold" env:"LOGIN_LOCKOUT_THRESHOLD" default:"5"`
+		LoginLockoutSeconds   int    `toml:"login_lockout_seconds" env:"LOGIN_LOCKOUT_SECONDS" default:"30"`
+	} `toml:"auth"`
+	OAuth struct {
+		RedirectBase        string `toml:"redirect_base" env:"OAUTH_REDIRECT_BASE"`
+		SuccessRedirect     string `toml:"success_redirect" env:"OAUTH_SUCCESS_REDIRECT"`
+		DiscordClientID     string `toml:"discord_client_id" env:"DISCORD_CLIENT_ID"`
+		DiscordClientSecret string `toml:"discord_client_secret" env:"DISCORD_CLIENT_SECRET" secret:"true"`
+		GoogleClientID      string `toml:"google_client_id" env:"GOOGLE_CLIENT_ID"`
+		GoogleClientSecret  string `toml:"google_client_secret" env:"GOOGLE_CLIENT_SECRET" secret:"true"`
+	} `toml:"oauth"`
+	Decks struct {
+		MaxEntries      int    `toml:"max_entries" env:"DECK_MAX_ENTRIES" default:"500"`
+		MaxEntriesBytes int    `toml:"max_entries_bytes" env:"DECK_MAX_ENTRIES_BYTES" default:"262144"`
+		MaxPerUser      int    `toml:"max_per_user" env:"DECK_MAX_PER_USER" default:"200"`
+		MaxRawTextBytes int    `toml:"max_raw_text_bytes" env:"DECK_MAX_RAW_TEXT_BYTES" default:"65536"`
+		PreconOwner     string `toml:"precon_owner" env:"PRECON_OWNER"`
+	} `toml:"decks"`
+	Rooms struct {
+		TokenSecret             string `toml:"token_secret" env:"ROOM_TOKEN_SECRET" secret:"true"`
+		TokenTTLSeconds         int    `toml:"token_ttl_seconds" env:"ROOM_TOKEN_TTL_SECONDS" default:"3600"`
+		IdleTimeoutSeconds      int    `toml:"idle_timeout_seconds" env:"ROOM_IDLE_TIMEOUT_SECONDS" default:"300"`
+		IdleAction              string `toml:"idle_action" env:"ROOM_IDLE_ACTION" default:"none"`
+		StateDeltas             bool   `toml:"state_deltas" env:"ROOM_STATE_DELTAS" default:"true"`
+		RetentionDays           int    `toml:"retention_days" env:"ROOM_RETENTION_DAYS" default:"30"`
+		SnapshotIntervalSeconds int    `toml:"snapshot_interval_seconds" env:"ROOM_SNAPSHOT_INTERVAL_SECONDS" default:"300"`
+		SnapshotEventThreshold  int    `toml:"snapshot_event_threshold" env:"ROOM_SNAPSHOT_EVENT_THRESHOLD" default:"500"`
+		BusURL                  string `toml:"bus_url" env:"ROOM_BUS_URL" secret:"true"`
+		BusChannel              string `toml:"bus_channel" env:"ROOM_BUS_CHANNEL" default:"mtonline:rooms"`
+		Emotes                  string `toml:"emotes" env:"ROOM_EMOTES"`
+		EmotesPerMinute         int    `toml:"emotes_per_minute" env:"ROOM_EMOTES_PER_MINUTE" default:"20"`
+	} `toml:"rooms"`
+	Groups struct {
+		EventReminderMinutes int `toml:"event_reminder_minutes" env:"GROUP_EVENT_REMINDER_MINUTES" default:"60"`
+	} `toml:"groups"`
+	RateLimit struct {
+		AuthPerIP      int `toml:"auth_per_ip" env:"RATE_LIMIT_AUTH_PER_IP" default:"20"`
+		AuthPerUser    int `toml:"auth_per_user" env:"RATE_LIMIT_AUTH_PER_USER" default:"20"`
+		DecksPerIP     int `toml:"decks_per_ip" env:"RATE_LIMIT_DECKS_PER_IP" default:"60"`
+		DecksPerUser   int `toml:"decks_per_user" env:"RATE_LIMIT_DECKS_PER_USER" default:"30"`
+		CardsPerIP     int `toml:"cards_per_ip" env:"RATE_LIMIT_CARDS_PER_IP" default:"600"`
+		CardsPerUser   int `toml:"cards_per_user" env:"RATE_LIMIT_CARDS_PER_USER" default:"600"`
+		RoomsPerIP     int `toml:"rooms_per_ip" env:"RATE_LIMIT_ROOMS_PER_IP" default:"1200"`
+		RoomsPerUser   int `toml:"rooms_per_user" env:"RATE_LIMIT_ROOMS_PER_USER" default:"0"`
+		DefaultPerIP   int `toml:"default_per_ip" env:"RATE_LIMIT_DEFAULT_PER_IP" default:"600"`
+		DefaultPerUser int `toml:"default_per_user" env:"RATE_LIMIT_DEFAULT_PER_USER" default:"300"`
+	} `toml:"rate_limit"`
+	Storage struct {
+		S3Endpoint        string `toml:"s3_endpoint" env:"STORAGE_S3_ENDPOINT"`
+		S3Bucket          string `toml:"s3_bucket" env:"STORAGE_S3_BUCKET"`
+		S3Region          string `toml:"s3_region" env:"STORAGE_S3_REGION" default:"us-east-1"`
+		S3AccessKeyID     string `toml:"s3_access_key_id" env:"STORAGE_S3_ACCESS_KEY_ID"`
+		S3SecretAccessKey string `toml:"s3_secret_access_key" env:"STORAGE_S3_SECRET_ACCESS_KEY" secret:"true"`
+	} `toml:"storage"`
+	Backup struct {
+		Dir           string `toml:"dir" env:"BACKUP_DIR" default:"data/backups"`
+		IntervalHours int    `toml:"interval_hours" env:"BACKUP_INTERVAL_HOURS" default:"24"`
+		Keep          int    `toml:"keep" env:"BACKUP_KEEP" default:"7"`
+	} `toml:"backup"`
+	Replays struct {
+		ExportDir  string `toml:"export_dir" env:"REPLAY_EXPORT_DIR" default:"data/replays"`
+		ExportKeep int    `toml:"export_keep" env:"REPLAY_EXPORT_KEEP" default:"10"`
+	} `toml:"replays"`
+	Media struct {
+		Dir               string `toml:"dir" env:"MEDIA_DIR" default:"data/media"`
+		MaxBytes          int    `toml:"max_bytes" env:"MEDIA_MAX_BYTES" default:"4194304"`
+		PublicURL         string `toml:"public_url" env:"MEDIA_PUBLIC_URL"`
+		S3Endpoint        string `toml:"s3_endpoint" env:"MEDIA_S3_ENDPOINT"`
+		S3Bucket          string `toml:"s3_bucket" env:"MEDIA_S3_BUCKET"`
+		S3Region          string `toml:"s3_region" env:"MEDIA_S3_REGION" default:"us-east-1"`
+		S3AccessKeyID     string `toml:"s3_access_key_id" env:"MEDIA_S3_ACCESS_KEY_ID"`
+		S3SecretAccessKey string `toml:"s3_secret_access_key" env:"MEDIA_S3_SECRET_ACCESS_KEY" secret:"true"`
+	} `toml:"media"`
+	Moderation struct {
+		BlockedWords     string `toml:"blocked_words" env:"BLOCKED_WORDS"`
+		BlockedWordsFile string `toml:"blocked_words_file" env:"BLOCKED_WORDS_FILE"`
+		WordFilterMode   string `toml:"word_filter_mode" env:"WORD_FILTER_MODE" default:"reject"`
+	} `toml:"moderation"`
+	Stats struct {
+		RefreshSeconds int `toml:"refresh_seconds" env:"STATS_REFRESH_SECONDS" default:"600"`
+	} `toml:"stats"`
+	Tracing struct {
+		Endpoint       string  `toml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+		TracesEndpoint string  `toml:"traces_endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+		Headers        string  `toml:"headers" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`
+		ServiceName    string  `toml:"service_name" env:"OTEL_SERVICE_NAME" default:"mtonline-backend"`
+		SampleRatio    float64 `toml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1"`
+	} `toml:"tracing"`
+
+	path    string
+	sources map[string]string
+}
+
+// configSetting is one leaf of Config with its tags resolved.
+type configSetting struct {
+	key    string
+	envs   []string
+	def    string
+	secret bool
+	value  reflect.Value
+}
+
+func (c *Config) settings() []configSetting {
+	var settings []configSetting
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i)
+		if section.Tag.Get("toml") == "" {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			settings = append(settings, configSetting{
+				key:    section.Tag.Get("toml") + "." + field.Tag.Get("toml"),
+				envs:   strings.Split(field.Tag.Get("env"), ","),
+				def:    field.Tag.Get("default"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  root.Field(i).Field(j),
+			})
+		}
+	}
+	return settings
+}
+
+// loadConfig reads the config file, applies environment overrides and
+// validates the result.
+func loadConfig() (*Config, error) {
+	config := &Config{sources: make(map[string]string)}
+	path := strings.TrimSpace(os.Getenv("MTONLINE_CONFIG"))
+	required := path != ""
+	if path == "" {
+		path = defaultConfigFile
+	}
+	file := map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		if file, err = parseTOML(string(data)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		config.path = path
+	} else if required || !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var problems []string
+	for _, setting := range config.settings() {
+		raw, source := setting.def, "default"
+		fromEnv := false
+		for _, env := range setting.envs {
+			if value, ok := os.LookupEnv(env); ok && strings.TrimSpace(value) != "" {
+				raw, source, fromEnv = strings.TrimSpace(value), "env "+env, true
+				break
+			}
+		}
+		if value, ok := file[setting.key]; ok {
+			delete(file, setting.key)
+			if !fromEnv {
+				raw, source = value, "file"
+				os.Setenv(setting.envs[0], value)
+			}
+		}
+		if err := setConfigValue(setting.value, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", setting.key, source, err))
+		}
+		config.sources[setting.key] = source
+	}
+	for key := range file {
+		problems = append(problems, fmt.Sprintf("%s: unknown setting", key))
+	}
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return config, nil
+}
+
+func setConfigValue(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		if raw == "" {
+			return nil
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", raw)
+		}
+		field.SetInt(int64(value))
+	case reflect.Float64:
+		if raw == "" {
+			return nil
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number, got %q", raw)
+		}
+		field.SetFloat(value)
+	case reflect.Bool:
+		switch strings.ToLower(raw) {
+		case "1", "true", "yes", "on":
+			field.SetBool(true)
+		case "", "0", "false", "no", "off":
+			field.SetBool(false)
+		default:
+			return fmt.Errorf("must be true or false, got %q", raw)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	oneOf := func(key string, value string, allowed ...string) {
+		for _, option := range allowed {
+			if strings.EqualFold(value, option) {
+				return
+			}
+		}
+		problems = append(problems, fmt.Sprintf("%s: must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("server.port: must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		problems = append(problems, "server.tls_cert and server.tls_key must be set together")
+	}
+	if c.Database.Synchronous != "" {
+		oneOf("database.synchronous", c.Database.Synchronous, "OFF", "NORMAL", "FULL", "EXTRA")
+	}
+	oneOf("auth.csrf_mode", c.Auth.CSRFMode, csrfModeOff, csrfModeReport, csrfModeEnforce)
+	oneOf("auth.cookie_secure", c.Auth.CookieSecure, "auto", "true", "false", "1", "yes", "on")
+	oneOf("auth.cookie_samesite", c.Auth.CookieSameSite, "lax", "strict", "none")
+	oneOf("rooms.idle_action", c.Rooms.IdleAction, idleActionNone, idleActionPass, idleActionConcede)
+	oneOf("moderation.word_filter_mode", c.Moderation.WordFilterMode, wordFilterOff, wordFilterReject, wordFilterMask)
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, fmt.Sprintf("tracing.sample_ratio: must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+	return problems
+}
+
+// logEffective prints every setting and where it came from, with secrets
+// redacted.
+func (c *Config) logEffective() {
+	if c.path != "" {
+		log.Printf("[config] loaded %s", c.path)
+	}
+	for _, setting := range c.settings() {
+		value := fmt.Sprint(setting.value.Interface())
+		if setting.secret && value != "" {
+			value = "[redacted]"
+		}
+		log.Printf("[config] %s = %q (%s)", setting.key, value, c.sources[setting.key])
+	}
+}
+
+// parseTOML reads the subset of TOML the config file needs: [section]
+// tables, comments, and key = value pairs whose values are strings,
+// integers, floats, booleans or arrays of strings. Keys come back as
+// "section.key"; arrays are joined with commas, the form the env vars use.
+func parseTOML(input string) (map[string]string, error) {
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNo, key)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, ch := range line {
+		switch {
+		case quote != 0 && ch == quote && (quote == '\'' || i == 0 || line[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", errors.New("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	default:
+		number := strings.ReplaceAll(raw, "_", "")
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			return "", fmt.Errorf("unsupported value %s", raw)
+		}
+		return number, nil
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrfToken"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+const (
+	csrfModeOff     = "off"
+	csrfModeReport  = "report"
+	csrfModeEnforce = "enforce"
+)
+
+// loadCSRFMode reads CSRF_MODE. "report" only logs missing or mismatched
+// tokens so older clients keep working while they are updated to send the
+// header; "enforce" rejects them.
+func loadCSRFMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("CSRF_MODE"))); mode {
+	case csrfModeOff, csrfModeEnforce:
+		return mode
+	default:
+		return csrfModeReport
+	}
+}
+
+func (a *App) setCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, r, &http.Cookie{
+		Name:   csrfCookieName,
+		Value:  randomID(16),
+		MaxAge: sessionTTLSeconds,
+		Path:   "/",
+	})
+}
+
+func (a *App) clearCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, r, &http.Cookie{
+		Name:   csrfCookieName,
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/",
+	})
+}
+
+// csrfMiddleware implements double-submit tokens for cookie-authenticated
+// requests: state-changing methods must echo the csrfToken cookie in the
+// X-CSRF-Token header. Bearer-token and anonymous requests are exempt since
+// a browser cannot attach those on a cross-site request.
+func (a *App) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.csrfMode == csrfModeOff || bearerToken(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if session, err := r.Cookie(cookieName); err != nil || session.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		csrfCookie, err := r.Cookie(csrfCookieName)
+		if err != nil || csrfCookie.Value == "" {
+			a.setCSRFCookie(w, r)
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := r.Header.Get(csrfHeaderName)
+		if err == nil && header != "" && subtle.ConstantTimeCompare([]byte(header), []byte(csrfCookie.Value)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.csrfMode == csrfModeEnforce {
+			writeError(w, http.StatusForbidden, codeCSRFFailed, "Missing or invalid CSRF token")
+			return
+		}
+		log.Printf("[csrf] %s %s without a valid token", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
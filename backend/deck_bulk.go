@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const maxBulkDecks = 100
+
+// bulkDeckPayload imports many decks at once, e.g. when moving a collection
+// over from another site. Each deck is a POST /decks body, except that
+// entries may be left out: the deck's rawText is then parsed as a decklist
+// and its cards resolved, as POST /decks/parse does.
+type bulkDeckPayload struct {
+	Decks []createDeckPayload `json:"decks"`
+}
+
+// bulkDeckResult reports one deck of an import, in request order.
+type bulkDeckResult struct {
+	Index   int                    `json:"index"`
+	Success bool                   `json:"success"`
+	Deck    map[string]interface{} `json:"deck,omitempty"`
+	Code    string                 `json:"code,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Details interface{}            `json:"details,omitempty"`
+}
+
+type bulkDeckResponse struct {
+	Created int              `json:"created"`
+	Failed  int              `json:"failed"`
+	Results []bulkDeckResult `json:"results"`
+}
+
+// handleBulkCreateDecks validates every deck on its own and saves the valid
+// ones in a single transaction. A deck that fails validation, or would go
+// past the user's deck quota, is reported in its result without stopping the
+// others; a failure to save fails the whole request and saves nothing.
+func (a *App) handleBulkCreateDecks(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload bulkDeckPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	if len(payload.Decks) == 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "decks must be a non-empty array")
+		return
+	}
+	if len(payload.Decks) > maxBulkDecks {
+		writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("at most %d decks can be imported at once", maxBulkDecks))
+		return
+	}
+
+	remaining := -1
+	if a.deckLimits.MaxDecksPerUser > 0 {
+		var count int
+		if err := a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM decks WHERE user_id = ?`, user.ID).Scan(&count); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to check deck quota")
+			return
+		}
+		remaining = a.deckLimits.MaxDecksPerUser - count
+	}
+
+	response := bulkDeckResponse{Results: make([]bulkDeckResult, len(payload.Decks))}
+	type preparedDeck struct {
+		index int
+		row   *deckRow
+		tags  []string
+	}
+	var prepared []preparedDeck
+	for i, deck := range payload.Decks {
+		response.Results[i].Index = i
+		if deck.Entries == nil && strings.TrimSpace(deck.RawText) != "" {
+			entries, lineErrors := a.resolveDecklist(r.Context(), deck.RawText)
+			if len(lineErrors) > 0 {
+				response.Results[i].fail(codeValidationFailed, "rawText has lines that could not be imported", map[string]interface{}{"lines": lineErrors})
+				continue
+			}
+			if len(entries) == 0 {
+				response.Results[i].fail(codeValidationFailed, "rawText has no cards", nil)
+				continue
+			}
+			deck.Entries = marshalPayload(entries)
+		}
+		row, tags, problem := a.prepareDeck(deck)
+		if problem != nil {
+			response.Results[i].fail(problem.Code, problem.Message, nil)
+			continue
+		}
+		violations, err := a.deckBanViolations(r.Context(), row.Format.String, row.Entries)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to check the ban list")
+			return
+		}
+		if len(violations) > 0 {
+			response.Results[i].fail(codeInvalidDeck, banViolationMessage(row.Format.String, violations), map[string]interface{}{"violations": violations})
+			continue
+		}
+		if remaining == 0 {
+			response.Results[i].fail(codeLimitReached, fmt.Sprintf("Deck limit reached (%d decks per user)", a.deckLimits.MaxDecksPerUser), nil)
+			continue
+		}
+		if remaining > 0 {
+			remaining--
+		}
+		prepared = append(prepared, preparedDeck{i, row, tags})
+	}
+
+	if len(prepared) > 0 {
+		tx, err := a.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save decks")
+			return
+		}
+		defer tx.Rollback()
+		for _, deck := range prepared {
+			if err := insertDeck(r.Context(), tx, user.ID, deck.row, deck.tags); err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save decks")
+				return
+			}
+		}
+		// The count above only sorts out which decks fit; the one in the
+		// transaction is what keeps concurrent imports under the limit.
+		if limitErr := a.checkDeckQuota(r.Context(), tx, user.ID); limitErr != nil {
+			limitErr.write(w)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save decks")
+			return
+		}
+	}
+	for _, deck := range prepared {
+		saved := deckRowToMap(deck.row)
+		saved["tags"] = deck.tags
+		response.Results[deck.index].Success = true
+		response.Results[deck.index].Deck = saved
+	}
+	response.Created = len(prepared)
+	response.Failed = len(payload.Decks) - len(prepared)
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (result *bulkDeckResult) fail(code string, message string, details interface{}) {
+	result.Code = code
+	result.Error = message
+	result.Details = details
+}
+
+// resolveDecklist parses a text decklist into deck entries, reporting lines
+// that could not be read or name cards that do not exist. Cards are only
+// checked when the card data is loaded.
+func (a *App) resolveDecklist(ctx context.Context, rawText string) ([]deckEntry, []decklistLineError) {
+	parsed, lineErrors := parseDecklist(strings.ReplaceAll(rawText, "\r\n", "\n"))
+	if len(parsed) > 0 && a.ensureCardsAvailable() {
+		requests := make([]batchCardRequest, len(parsed))
+		for i, entry := range parsed {
+			requests[i] = batchCardRequest{Name: entry.Name, SetCode: entry.SetCode, CollectorNumber: entry.CollectorNumber}
+		}
+		for i, result := range a.resolveCards(ctx, requests) {
+			if _, ok := result.(cardResponse); !ok {
+				lineErrors = append(lineErrors, decklistLineError{Line: parsed[i].Line, Text: parsed[i].Name, Error: "Card not found"})
+			}
+		}
+	}
+	entries := make([]deckEntry, len(parsed))
+	for i, entry := range parsed {
+		entries[i] = entry.deckEntry
+	}
+	return entries, lineErrors
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxDeckCommentLength = 2000
+
+type deckCommentPayload struct {
+	Body string `json:"body"`
+}
+
+func (a *App) handleDeckComments(w http.ResponseWriter, r *http.Request) {
+	deck, err := a.loadVisibleDeck(r.Context(), a.currentUser(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 100 || limit <= 0 {
+		limit = 100
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	var total int
+	_ = a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM deck_comments WHERE deck_id = ?`, deck.ID).Scan(&total)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT c.id, c.user_id, u.username, c.body, c.created_at
+		FROM deck_comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.deck_id = ?
+		ORDER BY c.created_at ASC, c.id ASC
+		LIMIT ? OFFSET ?
+	`, deck.ID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load comments")
+		return
+	}
+	defer rows.Close()
+	comments := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, authorID int64
+		var author, body, createdAt string
+		if err := rows.Scan(&id, &authorID, &author, &body, &createdAt); err != nil {
+			continue
+		}
+		comments = append(comments, map[string]interface{}{
+			"id":        id,
+			"deckId":    deck.ID,
+			"authorId":  authorID,
+			"author":    author,
+			"body":      body,
+			"createdAt": createdAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"comments": comments,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+func (a *App) handleCreateDeckComment(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	deck, err := a.loadVisibleDeck(r.Context(), user, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	if deck.IsPublic != 1 {
+		writeError(w, http.StatusForbidden, codeForbidden, "Comments are only available on public decks")
+		return
+	}
+	var payload deckCommentPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	body := strings.TrimSpace(payload.Body)
+	if body == "" {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Comment body is required")
+		return
+	}
+	if len(body) > maxDeckCommentLength {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Comment is too long")
+		return
+	}
+	body, problem := a.filterText("Comment", body)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO deck_comments (deck_id, user_id, body)
+		VALUES (?, ?, ?)
+	`, deck.ID, user.ID, body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to post comment")
+		return
+	}
+	id, _ := result.LastInsertId()
+	var ownerID int64
+	if err := a.db.QueryRowContext(r.Context(), `SELECT user_id FROM decks WHERE id = ?`, deck.ID).Scan(&ownerID); err == nil && ownerID != user.ID {
+		a.notify(ownerID, notifyDeckComment, map[string]interface{}{
+			"deckId":    deck.ID,
+			"deckName":  deck.Name,
+			"commentId": id,
+			"userId":    user.ID,
+			"username":  user.Username,
+		})
+	}
+	var createdAt string
+	_ = a.db.QueryRowContext(r.Context(), `SELECT created_at FROM deck_comments WHERE id = ?`, id).Scan(&createdAt)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":        id,
+		"deckId":    deck.ID,
+		"authorId":  user.ID,
+		"author":    user.Username,
+		"body":      body,
+		"createdAt": createdAt,
+	})
+}
+
+// handleDeleteDeckComment lets the comment author, the deck owner, or an
+// admin remove a comment.
+func (a *App) handleDeleteDeckComment(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	commentID, err := strconv.ParseInt(chi.URLParam(r, "commentId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid comment id")
+		return
+	}
+	var authorID, ownerID int64
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT c.user_id, d.user_id
+		FROM deck_comments c
+		JOIN decks d ON c.deck_id = d.id
+		WHERE c.id = ? AND c.deck_id = ?
+	`, commentID, chi.URLParam(r, "id")).Scan(&authorID, &ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Comment not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete comment")
+		return
+	}
+	if user.ID != authorID && user.ID != ownerID && !user.isAdmin() {
+		writeError(w, http.StatusForbidden, codeForbidden, "Not allowed to delete this comment")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `DELETE FROM deck_comments WHERE id = ?`, commentID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete comment")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
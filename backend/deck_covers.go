@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+var basicLandNames = map[string]bool{
+	"plains":                true,
+	"island":                true,
+	"swamp":                 true,
+	"mountain":              true,
+	"forest":                true,
+	"wastes":                true,
+	"snow-covered plains":   true,
+	"snow-covered island":   true,
+	"snow-covered swamp":    true,
+	"snow-covered mountain": true,
+	"snow-covered forest":   true,
+}
+
+// autoDeckCover picks the first commander, falling back to the most-played
+// non-basic card in the mainboard.
+func autoDeckCover(entries []deckEntry) (deckEntry, bool) {
+	var best deckEntry
+	found := false
+	for _, entry := range entries {
+		switch entrySection(entry) {
+		case "commander":
+			return entry, true
+		case "mainboard":
+			if basicLandNames[normalizeCardName(entry.Name)] {
+				continue
+			}
+			if !found || entry.Quantity > best.Quantity {
+				best = entry
+				found = true
+			}
+		}
+	}
+	return best, found
+}
+
+func findDeckEntry(entries []deckEntry, name string) (deckEntry, bool) {
+	normalized := normalizeCardName(name)
+	for _, entry := range entries {
+		if section := entrySection(entry); section == "tokens" || section == "maybeboard" {
+			continue
+		}
+		if normalizeCardName(entry.Name) == normalized {
+			return entry, true
+		}
+	}
+	return deckEntry{}, false
+}
+
+// applyDeckCover sets the deck's cover card. A non-empty requested name must
+// be a card in the deck; an empty one resets to automatic selection. With no
+// request the current cover is kept while it is still in the deck.
+func (a *App) applyDeckCover(row *deckRow, requested *string) error {
+	var entries []deckEntry
+	_ = json.Unmarshal([]byte(row.Entries), &entries)
+	var cover deckEntry
+	var ok bool
+	switch {
+	case requested != nil && *requested != "":
+		if cover, ok = findDeckEntry(entries, *requested); !ok {
+			return errors.New("coverCard must be a card in the deck")
+		}
+	case requested == nil && row.CoverCard.Valid:
+		if _, ok = findDeckEntry(entries, row.CoverCard.String); ok {
+			return nil
+		}
+		fallthrough
+	default:
+		cover, ok = autoDeckCover(entries)
+	}
+	if !ok {
+		row.CoverCard = sql.NullString{}
+		row.CoverImageURL = sql.NullString{}
+		return nil
+	}
+	row.CoverCard = sql.NullString{String: cover.Name, Valid: true}
+	row.CoverImageURL = a.deckCoverImage(cover)
+	return nil
+}
+
+func (a *App) deckCoverImage(cover deckEntry) sql.NullString {
+	if !a.ensureCardsAvailable() {
+		return sql.NullString{}
+	}
+	card, ok := a.resolveBatchCard(context.Background(), batchCardRequest{
+		Name:            cover.Name,
+		SetCode:         cover.SetCode,
+		CollectorNumber: cover.CollectorNumber,
+	}).(cardResponse)
+	if !ok || card.ImageURL == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *card.ImageURL, Valid: true}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var deckExportSections = []struct {
+	Key   string
+	Title string
+}{
+	{"commander", "Commander"},
+	{"companion", "Companion"},
+	{"mainboard", "Deck"},
+	{"sideboard", "Sideboard"},
+	{"maybeboard", "Maybeboard"},
+	{"tokens", "Tokens"},
+}
+
+var deckExportFilenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func entrySection(entry deckEntry) string {
+	if entry.Section != "" {
+		return entry.Section
+	}
+	if entry.IsCommander {
+		return "commander"
+	}
+	if entry.IsToken {
+		return "tokens"
+	}
+	return "mainboard"
+}
+
+func groupEntriesBySection(entries []deckEntry) map[string][]deckEntry {
+	grouped := make(map[string][]deckEntry)
+	for _, entry := range entries {
+		section := entrySection(entry)
+		grouped[section] = append(grouped[section], entry)
+	}
+	return grouped
+}
+
+func formatArenaDecklist(entries []deckEntry) string {
+	grouped := groupEntriesBySection(entries)
+	var blocks []string
+	for _, section := range deckExportSections {
+		if section.Key == "maybeboard" || section.Key == "tokens" || len(grouped[section.Key]) == 0 {
+			continue
+		}
+		lines := []string{section.Title}
+		for _, entry := range grouped[section.Key] {
+			line := fmt.Sprintf("%d %s", entry.Quantity, entry.Name)
+			if entry.SetCode != "" {
+				line += fmt.Sprintf(" (%s)", strings.ToUpper(entry.SetCode))
+				if entry.CollectorNumber != "" {
+					line += " " + entry.CollectorNumber
+				}
+			}
+			lines = append(lines, line)
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// MTGO lists have no headers: the main deck comes first, then a blank line
+// and the sideboard. Commanders and companions are listed in the sideboard.
+func formatMTGODecklist(entries []deckEntry) string {
+	grouped := groupEntriesBySection(entries)
+	var main, side []string
+	for _, entry := range grouped["mainboard"] {
+		main = append(main, fmt.Sprintf("%d %s", entry.Quantity, entry.Name))
+	}
+	for _, key := range []string{"commander", "companion", "sideboard"} {
+		for _, entry := range grouped[key] {
+			side = append(side, fmt.Sprintf("%d %s", entry.Quantity, entry.Name))
+		}
+	}
+	text := strings.Join(main, "\n")
+	if len(side) > 0 {
+		text += "\n\n" + strings.Join(side, "\n")
+	}
+	return text
+}
+
+// formatPlainDecklist mirrors the client's formatDecklist output so exports
+// round-trip through the decklist parser.
+func formatPlainDecklist(entries []deckEntry) string {
+	grouped := groupEntriesBySection(entries)
+	var blocks []string
+	for _, section := range deckExportSections {
+		if len(grouped[section.Key]) == 0 {
+			continue
+		}
+		title := section.Title
+		if section.Key == "mainboard" {
+			title = "Mainboard"
+		}
+		lines := []string{title}
+		for _, entry := range grouped[section.Key] {
+			line := fmt.Sprintf("%dx %s", entry.Quantity, entry.Name)
+			if entry.PrintTag != "" {
+				line += fmt.Sprintf(" (%s)", entry.PrintTag)
+			} else if entry.SetCode != "" {
+				line += fmt.Sprintf(" (%s%s)", strings.ToUpper(entry.SetCode), entry.CollectorNumber)
+			}
+			lines = append(lines, line)
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// fillPrintings resolves set codes and collector numbers for entries that
+// were saved by name only, which Arena imports require.
+func (a *App) fillPrintings(ctx context.Context, entries []deckEntry) {
+	var missing []int
+	var requests []batchCardRequest
+	for i, entry := range entries {
+		if entry.SetCode != "" && entry.CollectorNumber != "" {
+			continue
+		}
+		missing = append(missing, i)
+		requests = append(requests, batchCardRequest{Name: entry.Name, SetCode: entry.SetCode})
+	}
+	if len(requests) == 0 || !a.ensureCardsAvailable() {
+		return
+	}
+	for i, result := range a.resolveCards(ctx, requests) {
+		card, ok := result.(cardResponse)
+		if !ok || card.SetCode == nil || card.CollectorNumber == nil {
+			continue
+		}
+		entry := &entries[missing[i]]
+		entry.SetCode = *card.SetCode
+		entry.CollectorNumber = *card.CollectorNumber
+	}
+}
+
+func (a *App) handleExportDeck(w http.ResponseWriter, r *http.Request) {
+	row, err := a.loadVisibleDeck(r.Context(), a.currentUser(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	var entries []deckEntry
+	if err := json.Unmarshal([]byte(row.Entries), &entries); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidDeck, "Deck entries are not in a known format")
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	var text string
+	switch format {
+	case "", "plain":
+		format = "plain"
+		text = formatPlainDecklist(entries)
+	case "arena":
+		a.fillPrintings(r.Context(), entries)
+		text = formatArenaDecklist(entries)
+	case "mtgo":
+		text = formatMTGODecklist(entries)
+	default:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "format must be arena, mtgo, or plain")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Query().Get("download") != "" {
+		filename := strings.Trim(deckExportFilenameUnsafe.ReplaceAllString(row.Name, "-"), "-")
+		if filename == "" {
+			filename = "deck"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.txt"`, filename, format))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(text + "\n"))
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxDeckFolderNameLength = 64
+
+type deckFolderPayload struct {
+	Name string `json:"name"`
+}
+
+type moveDeckPayload struct {
+	FolderID *string `json:"folderId"`
+	Position *int    `json:"position"`
+}
+
+type reorderPayload struct {
+	IDs []string `json:"ids"`
+}
+
+func (a *App) ownsDeckFolder(ctx context.Context, userID int64, folderID string) bool {
+	var exists int
+	err := a.db.QueryRowContext(ctx, `SELECT 1 FROM deck_folders WHERE id = ? AND user_id = ?`, folderID, userID).Scan(&exists)
+	return err == nil
+}
+
+func validateDeckFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Folder name is required")
+	}
+	if len(name) > maxDeckFolderNameLength {
+		return "", errors.New("Folder name is too long")
+	}
+	return name, nil
+}
+
+func (a *App) handleDeckFolders(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT f.id, f.name, f.position, f.created_at,
+			(SELECT COUNT(*) FROM decks d WHERE d.folder_id = f.id) as deck_count
+		FROM deck_folders f
+		WHERE f.user_id = ?
+		ORDER BY f.position ASC, f.created_at ASC
+	`, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load folders")
+		return
+	}
+	defer rows.Close()
+	folders := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, createdAt string
+		var position, deckCount int
+		if err := rows.Scan(&id, &name, &position, &createdAt, &deckCount); err != nil {
+			continue
+		}
+		folders = append(folders, map[string]interface{}{
+			"id":        id,
+			"name":      name,
+			"position":  position,
+			"deckCount": deckCount,
+			"createdAt": createdAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, folders)
+}
+
+func (a *App) handleCreateDeckFolder(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload deckFolderPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	name, err := validateDeckFolderName(payload.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	id := randomID(16)
+	var position int
+	_ = a.db.QueryRowContext(r.Context(), `SELECT COALESCE(MAX(position), -1) + 1 FROM deck_folders WHERE user_id = ?`, user.ID).Scan(&position)
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO deck_folders (id, user_id, name, position)
+		VALUES (?, ?, ?, ?)
+	`, id, user.ID, name, position); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create folder")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":        id,
+		"name":      name,
+		"position":  position,
+		"deckCount": 0,
+	})
+}
+
+func (a *App) handleRenameDeckFolder(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload deckFolderPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	name, err := validateDeckFolderName(payload.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `UPDATE deck_folders SET name = ? WHERE id = ? AND user_id = ?`, name, chi.URLParam(r, "folderId"), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to rename folder")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Folder not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleDeleteDeckFolder removes the folder; its decks move back to the root.
+func (a *App) handleDeleteDeckFolder(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	folderID := chi.URLParam(r, "folderId")
+	if !a.ownsDeckFolder(r.Context(), user.ID, folderID) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Folder not found")
+		return
+	}
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete folder")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), `UPDATE decks SET folder_id = NULL WHERE folder_id = ? AND user_id = ?`, folderID, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete folder")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `DELETE FROM deck_folders WHERE id = ? AND user_id = ?`, folderID, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete folder")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete folder")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (a *App) handleReorderDeckFolders(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload reorderPayload
+	if err := decodeJSON(r, &payload); err != nil || payload.IDs == nil {
+		writeBodyError(w, err, "ids must be an array")
+		return
+	}
+	if err := a.applyOrder(r.Context(), `UPDATE deck_folders SET position = ? WHERE id = ? AND user_id = ?`, user.ID, payload.IDs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to reorder folders")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleMoveDeck moves a deck into a folder (or the root when folderId is
+// null), appending it unless an explicit position is given.
+func (a *App) handleMoveDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload moveDeckPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	deckID := chi.URLParam(r, "id")
+	if _, err := a.loadOwnedDeck(r.Context(), user.ID, deckID); err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	var folderID sql.NullString
+	if payload.FolderID != nil && *payload.FolderID != "" {
+		if !a.ownsDeckFolder(r.Context(), user.ID, *payload.FolderID) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Folder not found")
+			return
+		}
+		folderID = sql.NullString{String: *payload.FolderID, Valid: true}
+	}
+	position := 0
+	if payload.Position != nil {
+		position = *payload.Position
+	} else {
+		_ = a.db.QueryRowContext(r.Context(), `
+			SELECT COALESCE(MAX(position), -1) + 1 FROM decks
+			WHERE user_id = ? AND folder_id IS ?
+		`, user.ID, folderID).Scan(&position)
+	}
+	if _, err := a.db.ExecContext(r.Context(), `UPDATE decks SET folder_id = ?, position = ? WHERE id = ? AND user_id = ?`, folderID, position, deckID, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to move deck")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       deckID,
+		"folderId": nullStringToPtr(folderID),
+		"position": position,
+	})
+}
+
+func (a *App) handleReorderDecks(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload reorderPayload
+	if err := decodeJSON(r, &payload); err != nil || payload.IDs == nil {
+		writeBodyError(w, err, "ids must be an array")
+		return
+	}
+	if err := a.applyOrder(r.Context(), `UPDATE decks SET position = ? WHERE id = ? AND user_id = ?`, user.ID, payload.IDs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to reorder decks")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (a *App) applyOrder(ctx context.Context, statement string, userID int64, ids []string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for position, id := range ids {
+		if _, err := tx.ExecContext(ctx, statement, position, id, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type deckLimits struct {
+	MaxDecksPerUser int
+	MaxEntries      int
+	MaxRawTextBytes int
+	MaxEntriesBytes int
+}
+
+func loadDeckLimits() deckLimits {
+	return deckLimits{
+		MaxDecksPerUser: envInt("DECK_MAX_PER_USER", 200),
+		MaxEntries:      envInt("DECK_MAX_ENTRIES", 500),
+		MaxRawTextBytes: envInt("DECK_MAX_RAW_TEXT_BYTES", 64*1024),
+		MaxEntriesBytes: envInt("DECK_MAX_ENTRIES_BYTES", 256*1024),
+	}
+}
+
+// checkDeckSize returns the error to reject a deck with, or nil when the
+// deck fits the configured limits.
+func (l deckLimits) checkDeckSize(rawText string, entries json.RawMessage) *apiError {
+	if l.MaxRawTextBytes > 0 && len(rawText) > l.MaxRawTextBytes {
+		return &apiError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("rawText exceeds %d bytes", l.MaxRawTextBytes)}
+	}
+	if l.MaxEntriesBytes > 0 && len(entries) > l.MaxEntriesBytes {
+		return &apiError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("entries exceed %d bytes", l.MaxEntriesBytes)}
+	}
+	if l.MaxEntries > 0 {
+		var list []json.RawMessage
+		if err := json.Unmarshal(entries, &list); err == nil && len(list) > l.MaxEntries {
+			return &apiError{http.StatusUnprocessableEntity, codeLimitReached, fmt.Sprintf("a deck can have at most %d entries", l.MaxEntries)}
+		}
+	}
+	return nil
+}
+
+// checkDeckQuota counts the user's decks inside the transaction that has
+// just inserted their new ones, and returns the error to roll it back with
+// when that went over the limit. The insert holds SQLite's write lock, so no
+// concurrent create can commit between it and the count, and two of them
+// cannot both get in under the limit.
+func (a *App) checkDeckQuota(ctx context.Context, tx *sql.Tx, userID int64) *apiError {
+	if a.deckLimits.MaxDecksPerUser <= 0 {
+		return nil
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM decks WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		return &apiError{http.StatusInternalServerError, codeInternal, "Failed to check deck quota"}
+	}
+	if count > a.deckLimits.MaxDecksPerUser {
+		return &apiError{http.StatusUnprocessableEntity, codeLimitReached, fmt.Sprintf("Deck limit reached (%d decks per user)", a.deckLimits.MaxDecksPerUser)}
+	}
+	return nil
+}
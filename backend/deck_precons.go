@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Precons are starter decks curated by admins or the account named in
+// PRECON_OWNER. They are listed for everyone and can be copied without being
+// public.
+func loadPreconOwner() string {
+	return strings.TrimSpace(os.Getenv("PRECON_OWNER"))
+}
+
+func (a *App) canCuratePrecons(user *User) bool {
+	return user.isAdmin() || (user != nil && a.preconOwner != "" && user.Username == a.preconOwner)
+}
+
+func (a *App) loadPreconDeck(ctx context.Context, deckID string) (*deckRow, error) {
+	return scanDeckRow(a.db.QueryRowContext(ctx, `SELECT `+deckColumns+` FROM decks WHERE id = ? AND is_precon = 1`, deckID))
+}
+
+func (a *App) handlePreconDecks(w http.ResponseWriter, r *http.Request) {
+	where := "is_precon = 1"
+	var args []interface{}
+	if format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); format != "" {
+		where += " AND format = ?"
+		args = append(args, format)
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT `+deckColumns+`
+		FROM decks
+		WHERE `+where+`
+		ORDER BY position ASC, name ASC
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load precons")
+		return
+	}
+	defer rows.Close()
+	decks := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		row, err := scanDeckRow(rows)
+		if err != nil {
+			continue
+		}
+		decks = append(decks, map[string]interface{}{
+			"id":            row.ID,
+			"name":          row.Name,
+			"rawText":       row.RawText,
+			"entries":       json.RawMessage(row.Entries),
+			"format":        nullStringToPtr(row.Format),
+			"coverCard":     nullStringToPtr(row.CoverCard),
+			"coverImageUrl": nullStringToPtr(row.CoverImageURL),
+			"createdAt":     row.CreatedAt,
+		})
+	}
+	a.attachDeckTags(r.Context(), decks)
+	writeJSON(w, http.StatusOK, decks)
+}
+
+func (a *App) handleCopyPreconDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	source, err := a.loadPreconDeck(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	a.copyDeck(w, r, user, source)
+}
+
+func (a *App) handleMarkPrecon(w http.ResponseWriter, r *http.Request) {
+	a.setPrecon(w, r, true)
+}
+
+func (a *App) handleUnmarkPrecon(w http.ResponseWriter, r *http.Request) {
+	a.setPrecon(w, r, false)
+}
+
+func (a *App) setPrecon(w http.ResponseWriter, r *http.Request, precon bool) {
+	user := a.currentUser(r)
+	if !a.canCuratePrecons(user) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Not allowed to manage precons")
+		return
+	}
+	value := 0
+	if precon {
+		value = 1
+	}
+	result, err := a.db.ExecContext(r.Context(), `UPDATE decks SET is_precon = ? WHERE id = ? AND user_id = ?`, value, chi.URLParam(r, "id"), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update deck")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"precon": precon})
+}
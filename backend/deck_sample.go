@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	openingHandSize = 7
+	maxLibraryCards = 250
+)
+
+// libraryCards expands the entries that start the game in the library into
+// one element per physical card.
+func libraryCards(entries []deckEntry) []deckEntry {
+	var cards []deckEntry
+	for _, entry := range entries {
+		if entrySection(entry) != "mainboard" || entry.NoDeck {
+			continue
+		}
+		for i := 0; i < entry.Quantity; i++ {
+			card := entry
+			card.Quantity = 1
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+// deckLibrary shuffles the deck with seed and builds the cards the client's
+// replaceLibrary action expects, the last element being the top card.
+func (a *App) deckLibrary(ctx context.Context, cards []deckEntry, owner string, seed int64) []map[string]interface{} {
+	shuffleWithSeed(cards, seed)
+	return a.deckBoardCards(ctx, cards, owner, "library")
+}
+
+// deckBoardCards builds board cards for deck entries, filled in from the card
+// database when it is loaded.
+func (a *App) deckBoardCards(ctx context.Context, cards []deckEntry, owner string, zone string) []map[string]interface{} {
+	var resolved []interface{}
+	if a.ensureCardsAvailable() {
+		requests := make([]batchCardRequest, len(cards))
+		for i, card := range cards {
+			requests[i] = batchCardRequest{Name: card.Name, SetCode: card.SetCode, CollectorNumber: card.CollectorNumber}
+		}
+		resolved = a.resolveCards(ctx, requests)
+	}
+	built := make([]map[string]interface{}, len(cards))
+	for i, card := range cards {
+		entry := map[string]interface{}{
+			"id":         randomID(8),
+			"name":       card.Name,
+			"ownerId":    owner,
+			"position":   map[string]int{"x": 0, "y": 0},
+			"tapped":     false,
+			"zone":       zone,
+			"stackIndex": i,
+		}
+		if i < len(resolved) {
+			if details, ok := resolved[i].(cardResponse); ok {
+				entry["name"] = details.Name
+				entry["imageUrl"] = details.ImageURL
+				entry["backImageUrl"] = details.BackImageURL
+				entry["oracleText"] = details.OracleText
+				entry["manaCost"] = details.ManaCost
+				entry["typeLine"] = details.TypeLine
+				entry["setName"] = details.SetName
+			}
+		}
+		built[i] = entry
+	}
+	return built
+}
+
+func (a *App) handleSampleHand(w http.ResponseWriter, r *http.Request) {
+	row, err := a.loadVisibleDeck(r.Context(), a.currentUser(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	mulligans := parseIntDefault(r.URL.Query().Get("mulligans"), 0)
+	if mulligans < 0 || mulligans >= openingHandSize {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "mulligans must be between 0 and 6")
+		return
+	}
+	var entries []deckEntry
+	if err := json.Unmarshal([]byte(row.Entries), &entries); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidDeck, "Deck entries are not in a known format")
+		return
+	}
+	library := libraryCards(entries)
+	if len(library) < openingHandSize {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidDeck, "Deck has fewer than 7 cards in the library")
+		return
+	}
+	seed := randomSeed()
+	shuffleWithSeed(library, seed)
+	drawn := library[:openingHandSize]
+
+	requests := make([]batchCardRequest, len(drawn))
+	for i, card := range drawn {
+		requests[i] = batchCardRequest{Name: card.Name, SetCode: card.SetCode, CollectorNumber: card.CollectorNumber}
+	}
+	var resolved []interface{}
+	if a.ensureCardsAvailable() {
+		resolved = a.resolveCards(r.Context(), requests)
+	}
+	hand := make([]map[string]interface{}, len(drawn))
+	for i, card := range drawn {
+		item := map[string]interface{}{
+			"name":            card.Name,
+			"setCode":         card.SetCode,
+			"collectorNumber": card.CollectorNumber,
+		}
+		if i < len(resolved) {
+			if details, ok := resolved[i].(cardResponse); ok {
+				item["card"] = details
+			}
+		}
+		hand[i] = item
+	}
+	// London mulligan: always draw seven, then put one card on the bottom
+	// per mulligan taken.
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hand":        hand,
+		"mulligans":   mulligans,
+		"bottomCount": mulligans,
+		"keepCount":   openingHandSize - mulligans,
+		"librarySize": len(library) - openingHandSize,
+		"seed":        seed,
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// indexDeckCards refreshes the deck_cards lookup table used by deck search
+// from the stored entries blob.
+func indexDeckCards(ctx context.Context, tx *sql.Tx, deckID string, entries string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM deck_cards WHERE deck_id = ?`, deckID); err != nil {
+		return err
+	}
+	var parsed []deckEntry
+	if err := json.Unmarshal([]byte(entries), &parsed); err != nil {
+		return nil
+	}
+	for _, entry := range parsed {
+		name := normalizeCardName(entry.Name)
+		if name == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO deck_cards (deck_id, name_normalized, quantity)
+			VALUES (?, ?, ?)
+			ON CONFLICT(deck_id, name_normalized) DO UPDATE SET quantity = quantity + excluded.quantity
+		`, deckID, name, entry.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureDeckCardsIndexed backfills deck_cards for decks saved before the
+// index existed.
+func ensureDeckCardsIndexed(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id, entries FROM decks
+		WHERE NOT EXISTS (SELECT 1 FROM deck_cards c WHERE c.deck_id = decks.id)
+	`)
+	if err != nil {
+		return err
+	}
+	type pending struct{ id, entries string }
+	var decks []pending
+	for rows.Next() {
+		var deck pending
+		if err := rows.Scan(&deck.id, &deck.entries); err == nil {
+			decks = append(decks, deck)
+		}
+	}
+	rows.Close()
+	if len(decks) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, deck := range decks {
+		if err := indexDeckCards(context.Background(), tx, deck.id, deck.entries); err != nil {
+			return err
+		}
+	}
+	log.Printf("[decks] indexed cards for %d decks", len(decks))
+	return tx.Commit()
+}
+
+func (a *App) handleSearchDecks(w http.ResponseWriter, r *http.Request) {
+	query := normalizeCardName(r.URL.Query().Get("q"))
+	if len(query) < 2 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "q must be at least 2 characters")
+		return
+	}
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 100 || limit <= 0 {
+		limit = 100
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	pattern := "%" + escapeLikePattern(query) + "%"
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT d.id, d.name, d.raw_text, d.entries, d.created_at, u.username, d.format, d.cover_card, d.cover_image_url,
+			(SELECT COUNT(*) FROM deck_likes l WHERE l.deck_id = d.id) as likes,
+			EXISTS(SELECT 1 FROM deck_cards c WHERE c.deck_id = d.id AND c.name_normalized = ?) as exact_card,
+			(SELECT GROUP_CONCAT(name_normalized, '|') FROM deck_cards c WHERE c.deck_id = d.id AND c.name_normalized LIKE ? ESCAPE '\') as matched
+		FROM decks d
+		JOIN users u ON d.user_id = u.id
+		WHERE d.is_public = 1
+		  AND (
+			LOWER(d.name) LIKE ? ESCAPE '\'
+			OR LOWER(u.username) LIKE ? ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM deck_cards c WHERE c.deck_id = d.id AND c.name_normalized LIKE ? ESCAPE '\')
+		  )
+		ORDER BY exact_card DESC, likes DESC, d.created_at DESC
+		LIMIT ? OFFSET ?
+	`, query, pattern, pattern, pattern, pattern, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to search decks")
+		return
+	}
+	defer rows.Close()
+	decks := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, rawText, entries, createdAt, author string
+		var format, matched, coverCard, coverImageURL sql.NullString
+		var likes int
+		var exactCard bool
+		if err := rows.Scan(&id, &name, &rawText, &entries, &createdAt, &author, &format, &coverCard, &coverImageURL, &likes, &exactCard, &matched); err != nil {
+			continue
+		}
+		matchedCards := []string{}
+		if matched.Valid && matched.String != "" {
+			matchedCards = strings.Split(matched.String, "|")
+		}
+		decks = append(decks, map[string]interface{}{
+			"id":            id,
+			"name":          name,
+			"rawText":       rawText,
+			"entries":       json.RawMessage(entries),
+			"createdAt":     createdAt,
+			"author":        author,
+			"format":        nullStringToPtr(format),
+			"coverCard":     nullStringToPtr(coverCard),
+			"coverImageUrl": nullStringToPtr(coverImageURL),
+			"likes":         likes,
+			"matchedCards":  matchedCards,
+		})
+	}
+	a.attachDeckTags(r.Context(), decks)
+	writeJSON(w, http.StatusOK, decks)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	deckVisibilityPrivate  = "private"
+	deckVisibilityPublic   = "public"
+	deckVisibilityUnlisted = "unlisted"
+)
+
+// Public decks are flagged with is_public and appear in the feed. Unlisted
+// decks stay out of the feed but carry a share token that grants read access.
+func deckVisibility(row *deckRow) string {
+	switch {
+	case row.IsPublic == 1:
+		return deckVisibilityPublic
+	case row.ShareToken.Valid:
+		return deckVisibilityUnlisted
+	default:
+		return deckVisibilityPrivate
+	}
+}
+
+func applyDeckVisibility(row *deckRow, visibility string) error {
+	switch visibility {
+	case "", deckVisibilityPrivate:
+		row.IsPublic = 0
+		row.ShareToken = sql.NullString{}
+	case deckVisibilityPublic:
+		row.IsPublic = 1
+	case deckVisibilityUnlisted:
+		row.IsPublic = 0
+		if !row.ShareToken.Valid {
+			row.ShareToken = sql.NullString{String: randomID(12), Valid: true}
+		}
+	default:
+		return errors.New("visibility must be private, public, or unlisted")
+	}
+	return nil
+}
+
+func (a *App) loadSharedDeck(ctx context.Context, shareToken string) (*deckRow, error) {
+	if shareToken == "" {
+		return nil, sql.ErrNoRows
+	}
+	return scanDeckRow(a.db.QueryRowContext(ctx, `SELECT `+deckColumns+` FROM decks WHERE share_token = ?`, shareToken))
+}
+
+func (a *App) handleSharedDeck(w http.ResponseWriter, r *http.Request) {
+	row, err := a.loadSharedDeck(r.Context(), chi.URLParam(r, "shareToken"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	var author string
+	_ = a.db.QueryRowContext(r.Context(), `SELECT u.username FROM decks d JOIN users u ON d.user_id = u.id WHERE d.id = ?`, row.ID).Scan(&author)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":            row.ID,
+		"name":          row.Name,
+		"rawText":       row.RawText,
+		"entries":       json.RawMessage(row.Entries),
+		"visibility":    deckVisibility(row),
+		"format":        nullStringToPtr(row.Format),
+		"coverCard":     nullStringToPtr(row.CoverCard),
+		"coverImageUrl": nullStringToPtr(row.CoverImageURL),
+		"createdAt":     row.CreatedAt,
+		"author":        author,
+	})
+}
+
+func (a *App) handleCopySharedDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	source, err := a.loadSharedDeck(r.Context(), chi.URLParam(r, "shareToken"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	a.copyDeck(w, r, user, source)
+}
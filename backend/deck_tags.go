@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxDeckTags      = 10
+	maxDeckTagLength = 32
+)
+
+var knownDeckFormats = map[string]bool{
+	"standard":    true,
+	"pioneer":     true,
+	"modern":      true,
+	"legacy":      true,
+	"vintage":     true,
+	"pauper":      true,
+	"commander":   true,
+	"brawl":       true,
+	"oathbreaker": true,
+	"limited":     true,
+	"casual":      true,
+}
+
+func normalizeDeckTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxDeckTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxDeckTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxDeckTags {
+		return nil, fmt.Errorf("a deck can have at most %d tags", maxDeckTags)
+	}
+	return normalized, nil
+}
+
+func normalizeDeckFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" || knownDeckFormats[format] {
+		return format, nil
+	}
+	return "", errors.New("unknown deck format")
+}
+
+func setDeckTags(ctx context.Context, tx *sql.Tx, deckID string, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM deck_tags WHERE deck_id = ?`, deckID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO deck_tags (deck_id, tag) VALUES (?, ?)`, deckID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachDeckTags loads the tags for every listed deck in a single query.
+func (a *App) attachDeckTags(ctx context.Context, decks []map[string]interface{}) {
+	if len(decks) == 0 {
+		return
+	}
+	placeholders := make([]string, len(decks))
+	args := make([]interface{}, len(decks))
+	byID := make(map[string]map[string]interface{}, len(decks))
+	for i, deck := range decks {
+		id, _ := deck["id"].(string)
+		placeholders[i] = "?"
+		args[i] = id
+		byID[id] = deck
+		deck["tags"] = []string{}
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT deck_id, tag FROM deck_tags
+		WHERE deck_id IN (`+strings.Join(placeholders, ",")+`)
+		ORDER BY tag
+	`, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deckID, tag string
+		if err := rows.Scan(&deckID, &tag); err != nil {
+			continue
+		}
+		if deck := byID[deckID]; deck != nil {
+			deck["tags"] = append(deck["tags"].([]string), tag)
+		}
+	}
+}
+
+// publicDeckFilters builds the WHERE clause shared by the public feed and
+// its facet counts from the ?tag= and ?format= query parameters.
+func publicDeckFilters(r *http.Request) (string, []interface{}) {
+	clauses := []string{"d.is_public = 1"}
+	var args []interface{}
+	if format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); format != "" {
+		clauses = append(clauses, "d.format = ?")
+		args = append(args, format)
+	}
+	for _, tag := range r.URL.Query()["tag"] {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		clauses = append(clauses, "EXISTS (SELECT 1 FROM deck_tags t WHERE t.deck_id = d.id AND t.tag = ?)")
+		args = append(args, tag)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+type deckFacet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+func (a *App) handlePublicDeckFacets(w http.ResponseWriter, r *http.Request) {
+	where, args := publicDeckFilters(r)
+	tagRows, err := a.db.QueryContext(r.Context(), `
+		SELECT t.tag, COUNT(*) FROM deck_tags t
+		JOIN decks d ON d.id = t.deck_id
+		WHERE `+where+`
+		GROUP BY t.tag
+		ORDER BY COUNT(*) DESC, t.tag
+		LIMIT 100
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load facets")
+		return
+	}
+	tags := scanDeckFacets(tagRows)
+	formatRows, err := a.db.QueryContext(r.Context(), `
+		SELECT d.format, COUNT(*) FROM decks d
+		WHERE `+where+` AND d.format IS NOT NULL
+		GROUP BY d.format
+		ORDER BY COUNT(*) DESC, d.format
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load facets")
+		return
+	}
+	formats := scanDeckFacets(formatRows)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tags":    tags,
+		"formats": formats,
+	})
+}
+
+func scanDeckFacets(rows *sql.Rows) []deckFacet {
+	defer rows.Close()
+	facets := make([]deckFacet, 0)
+	for rows.Next() {
+		var facet deckFacet
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			continue
+		}
+		facets = append(facets, facet)
+	}
+	return facets
+}
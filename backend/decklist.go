@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type deckEntry struct {
+	Quantity        int      `json:"quantity"`
+	Name            string   `json:"name"`
+	SetCode         string   `json:"setCode,omitempty"`
+	CollectorNumber string   `json:"collectorNumber,omitempty"`
+	PrintTag        string   `json:"printTag,omitempty"`
+	FinishTags      []string `json:"finishTags,omitempty"`
+	Section         string   `json:"section,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+	Flags           []string `json:"flags,omitempty"`
+	IsCommander     bool     `json:"isCommander,omitempty"`
+	IsToken         bool     `json:"isToken,omitempty"`
+	NoDeck          bool     `json:"noDeck,omitempty"`
+}
+
+type decklistLineError struct {
+	Line  int    `json:"line"`
+	Text  string `json:"text"`
+	Error string `json:"error"`
+}
+
+type parsedDeckEntry struct {
+	deckEntry
+	Line int           `json:"line"`
+	Card *cardResponse `json:"card,omitempty"`
+}
+
+var (
+	decklistSectionHeader = regexp.MustCompile(`(?i)^(commander|companion|deck|main\s*deck|mainboard|maindeck|sideboard|maybeboard|tokens?)\s*(?:\(\d+\))?:?$`)
+	decklistSideboardTag  = regexp.MustCompile(`(?i)^SB[:\-]?\s*`)
+	decklistBracket       = regexp.MustCompile(`\s*\[([^\]]+)\]\s*$`)
+	decklistBracketFlag   = regexp.MustCompile(`\{([^}]+)\}`)
+	decklistFinish        = regexp.MustCompile(`\*[^*]+\*`)
+	decklistFinishStrip   = regexp.MustCompile(`\s*\*[^*]+\*\s*`)
+	decklistLine          = regexp.MustCompile(`(?i)^(\d+)\s*x?\s+([^(]+?)(?:\s+\(([^)]+)\)(?:\s+([A-Za-z0-9★-]+))?)?$`)
+	decklistPrintTag      = regexp.MustCompile(`^([A-Za-z]{2,5})(\d+)?$`)
+	decklistSetCode       = regexp.MustCompile(`^[A-Za-z0-9]{2,5}$`)
+)
+
+// parseDecklist understands MTGA exports ("4 Lightning Bolt (2XM) 129"),
+// MTGO lists ("SB: 2 Duress") and the plain format produced by the client's
+// formatDecklist, including section headers and [label{flags}] suffixes.
+func parseDecklist(raw string) ([]parsedDeckEntry, []decklistLineError) {
+	entries := make([]parsedDeckEntry, 0)
+	errs := make([]decklistLineError, 0)
+	currentSection := "mainboard"
+	for index, rawLine := range strings.Split(raw, "\n") {
+		lineNumber := index + 1
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match := decklistSectionHeader.FindStringSubmatch(line); match != nil {
+			currentSection = decklistSectionKey(match[1])
+			continue
+		}
+		if strings.EqualFold(line, "About") || strings.HasPrefix(strings.ToLower(line), "name ") {
+			continue
+		}
+
+		section := currentSection
+		normalized := line
+		if decklistSideboardTag.MatchString(normalized) {
+			normalized = decklistSideboardTag.ReplaceAllString(normalized, "")
+			section = "sideboard"
+		}
+
+		var bracketLabel string
+		var flags []string
+		if loc := decklistBracket.FindStringSubmatchIndex(normalized); loc != nil {
+			bracketText := strings.TrimSpace(normalized[loc[2]:loc[3]])
+			normalized = strings.TrimSpace(normalized[:loc[0]])
+			bracketLabel = strings.TrimSpace(strings.SplitN(bracketText, "{", 2)[0])
+			for _, flagMatch := range decklistBracketFlag.FindAllStringSubmatch(bracketText, -1) {
+				for _, flag := range strings.FieldsFunc(flagMatch[1], func(r rune) bool { return r == ',' || r == ' ' }) {
+					flags = append(flags, flag)
+				}
+			}
+		}
+
+		finishTags := decklistFinish.FindAllString(normalized, -1)
+		normalized = strings.Join(strings.Fields(decklistFinishStrip.ReplaceAllString(normalized, " ")), " ")
+
+		match := decklistLine.FindStringSubmatch(normalized)
+		if match == nil {
+			errs = append(errs, decklistLineError{Line: lineNumber, Text: line, Error: "unrecognized line"})
+			continue
+		}
+		quantity, err := strconv.Atoi(match[1])
+		if err != nil || quantity <= 0 {
+			errs = append(errs, decklistLineError{Line: lineNumber, Text: line, Error: "invalid quantity"})
+			continue
+		}
+		entry := deckEntry{
+			Quantity:   quantity,
+			Name:       strings.TrimSpace(match[2]),
+			FinishTags: finishTags,
+		}
+		if tag := strings.TrimSpace(match[3]); tag != "" {
+			entry.PrintTag = tag
+			if tagMatch := decklistPrintTag.FindStringSubmatch(tag); tagMatch != nil {
+				entry.SetCode = strings.ToLower(tagMatch[1])
+				entry.CollectorNumber = tagMatch[2]
+			} else if decklistSetCode.MatchString(tag) {
+				entry.SetCode = strings.ToLower(tag)
+			}
+		}
+		if entry.CollectorNumber == "" && match[4] != "" {
+			entry.CollectorNumber = match[4]
+		}
+
+		if bracketLabel != "" {
+			label := strings.ToLower(bracketLabel)
+			switch {
+			case strings.Contains(label, "commander"):
+				section = "commander"
+			case strings.Contains(label, "token"):
+				section = "tokens"
+			case strings.Contains(label, "maybeboard"):
+				section = "maybeboard"
+			case strings.Contains(label, "sideboard"):
+				section = "sideboard"
+			case strings.Contains(label, "main"):
+				section = "mainboard"
+			}
+			entry.Tags = []string{bracketLabel}
+		}
+		entry.Flags = flags
+		entry.Section = section
+		entry.IsCommander = section == "commander"
+		entry.IsToken = section == "tokens"
+		for _, flag := range flags {
+			if strings.EqualFold(flag, "nodeck") {
+				entry.NoDeck = true
+			}
+		}
+		entries = append(entries, parsedDeckEntry{deckEntry: entry, Line: lineNumber})
+	}
+	return entries, errs
+}
+
+func decklistSectionKey(header string) string {
+	header = strings.ToLower(strings.Join(strings.Fields(header), ""))
+	switch header {
+	case "commander":
+		return "commander"
+	case "companion":
+		return "companion"
+	case "sideboard":
+		return "sideboard"
+	case "maybeboard":
+		return "maybeboard"
+	case "token", "tokens":
+		return "tokens"
+	default:
+		return "mainboard"
+	}
+}
+
+type parseDecklistPayload struct {
+	RawText string `json:"rawText"`
+}
+
+func (a *App) handleParseDecklist(w http.ResponseWriter, r *http.Request) {
+	var payload parseDecklistPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	if strings.TrimSpace(payload.RawText) == "" {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "rawText is required")
+		return
+	}
+	entries, errs := parseDecklist(strings.ReplaceAll(payload.RawText, "\r\n", "\n"))
+	if len(entries) > 0 && a.ensureCardsAvailable() {
+		requests := make([]batchCardRequest, len(entries))
+		for i, entry := range entries {
+			requests[i] = batchCardRequest{
+				Name:            entry.Name,
+				SetCode:         entry.SetCode,
+				CollectorNumber: entry.CollectorNumber,
+			}
+		}
+		for i, result := range a.resolveCards(r.Context(), requests) {
+			card, ok := result.(cardResponse)
+			if !ok {
+				errs = append(errs, decklistLineError{Line: entries[i].Line, Text: entries[i].Name, Error: "Card not found"})
+				continue
+			}
+			entries[i].Card = &card
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"errors":  errs,
+	})
+}
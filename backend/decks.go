@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type updateDeckPayload struct {
+	Name       *string         `json:"name"`
+	Entries    json.RawMessage `json:"entries"`
+	RawText    *string         `json:"rawText"`
+	IsPublic   *bool           `json:"isPublic"`
+	Visibility *string         `json:"visibility"`
+	Format     *string         `json:"format"`
+	Tags       []string        `json:"tags"`
+	CoverCard  *string         `json:"coverCard"`
+}
+
+const (
+	maxDeckCommanders = 2
+	maxDeckCompanions = 1
+)
+
+var deckSectionKeys = map[string]bool{
+	"commander":  true,
+	"companion":  true,
+	"mainboard":  true,
+	"sideboard":  true,
+	"maybeboard": true,
+	"tokens":     true,
+}
+
+// deckSections is the structured form of a deck accepted on create/update.
+// It is flattened into entries carrying an explicit section before storage
+// so existing clients keep reading a plain entries array.
+type deckSections struct {
+	Commanders []deckEntry `json:"commanders"`
+	Companions []deckEntry `json:"companions"`
+	Mainboard  []deckEntry `json:"mainboard"`
+	Sideboard  []deckEntry `json:"sideboard"`
+	Maybeboard []deckEntry `json:"maybeboard"`
+	Tokens     []deckEntry `json:"tokens"`
+}
+
+func (s deckSections) flatten() []deckEntry {
+	var entries []deckEntry
+	for _, group := range []struct {
+		key     string
+		entries []deckEntry
+	}{
+		{"commander", s.Commanders},
+		{"companion", s.Companions},
+		{"mainboard", s.Mainboard},
+		{"sideboard", s.Sideboard},
+		{"maybeboard", s.Maybeboard},
+		{"tokens", s.Tokens},
+	} {
+		for _, entry := range group.entries {
+			entry.Section = group.key
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// normalizeDeckEntries validates entries given either as a flat array or as
+// a deckSections object and returns the flat array to store.
+func normalizeDeckEntries(raw json.RawMessage) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	var entries []deckEntry
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return nil, errors.New("entries must be an array of deck entries")
+		}
+	case strings.HasPrefix(trimmed, "{"):
+		var sections deckSections
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&sections); err != nil {
+			return nil, errors.New("entries sections must be commanders, companions, mainboard, sideboard, maybeboard, or tokens")
+		}
+		entries = sections.flatten()
+	default:
+		return nil, errors.New("entries must be an array or a sections object")
+	}
+	commanders, companions := 0, 0
+	for i := range entries {
+		entry := &entries[i]
+		entry.Name = strings.TrimSpace(entry.Name)
+		if entry.Name == "" {
+			return nil, fmt.Errorf("entries[%d]: name is required", i)
+		}
+		if entry.Quantity <= 0 {
+			return nil, fmt.Errorf("entries[%d]: quantity must be positive", i)
+		}
+		entry.Section = entrySection(*entry)
+		if !deckSectionKeys[entry.Section] {
+			return nil, fmt.Errorf("entries[%d]: unknown section %q", i, entry.Section)
+		}
+		entry.IsCommander = entry.Section == "commander"
+		entry.IsToken = entry.Section == "tokens"
+		switch entry.Section {
+		case "commander":
+			commanders += entry.Quantity
+		case "companion":
+			companions += entry.Quantity
+		}
+	}
+	if commanders > maxDeckCommanders {
+		return nil, fmt.Errorf("a deck can have at most %d commanders", maxDeckCommanders)
+	}
+	if companions > maxDeckCompanions {
+		return nil, fmt.Errorf("a deck can have at most %d companion", maxDeckCompanions)
+	}
+	if entries == nil {
+		entries = []deckEntry{}
+	}
+	return json.Marshal(entries)
+}
+
+const deckColumns = `id, name, raw_text, entries, is_public, created_at, forked_from, forked_from_name, forked_from_author, format, share_token, folder_id, position, cover_card, cover_image_url`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeckRow(scanner rowScanner) (*deckRow, error) {
+	var row deckRow
+	if err := scanner.Scan(&row.ID, &row.Name, &row.RawText, &row.Entries, &row.IsPublic, &row.CreatedAt, &row.ForkedFrom, &row.ForkedFromName, &row.ForkedFromAuthor, &row.Format, &row.ShareToken, &row.FolderID, &row.Position, &row.CoverCard, &row.CoverImageURL); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (a *App) loadOwnedDeck(ctx context.Context, userID int64, deckID string) (*deckRow, error) {
+	return scanDeckRow(a.db.QueryRowContext(ctx, `SELECT `+deckColumns+` FROM decks WHERE id = ? AND user_id = ?`, deckID, userID))
+}
+
+// loadVisibleDeck returns a deck the user owns, any public or precon deck, or
+// a deck shared with one of the user's groups.
+func (a *App) loadVisibleDeck(ctx context.Context, user *User, deckID string) (*deckRow, error) {
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+	return scanDeckRow(a.db.QueryRowContext(ctx, `
+		SELECT `+deckColumns+` FROM decks
+		WHERE id = ? AND (user_id = ? OR is_public = 1 OR is_precon = 1
+			OR id IN (SELECT gd.deck_id FROM group_decks gd JOIN group_members gm ON gm.group_id = gd.group_id WHERE gm.user_id = ?))
+	`, deckID, userID, userID))
+}
+
+func deckRowToMap(row *deckRow) map[string]interface{} {
+	deck := map[string]interface{}{
+		"id":            row.ID,
+		"name":          row.Name,
+		"rawText":       row.RawText,
+		"entries":       json.RawMessage(row.Entries),
+		"isPublic":      row.IsPublic == 1,
+		"visibility":    deckVisibility(row),
+		"format":        nullStringToPtr(row.Format),
+		"folderId":      nullStringToPtr(row.FolderID),
+		"position":      row.Position,
+		"coverCard":     nullStringToPtr(row.CoverCard),
+		"coverImageUrl": nullStringToPtr(row.CoverImageURL),
+		"createdAt":     row.CreatedAt,
+	}
+	if row.ShareToken.Valid {
+		deck["shareToken"] = row.ShareToken.String
+	}
+	if row.ForkedFromName.Valid {
+		deck["forkedFrom"] = map[string]interface{}{
+			"id":     nullStringToPtr(row.ForkedFrom),
+			"name":   row.ForkedFromName.String,
+			"author": nullStringToPtr(row.ForkedFromAuthor),
+		}
+	}
+	return deck
+}
+
+func recordDeckRevision(ctx context.Context, tx *sql.Tx, deckID string, name string, rawText string, entries string) (int, error) {
+	var revision int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(revision), 0) + 1 FROM deck_revisions WHERE deck_id = ?`, deckID).Scan(&revision); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO deck_revisions (deck_id, revision, name, raw_text, entries)
+		VALUES (?, ?, ?, ?, ?)
+	`, deckID, revision, name, rawText, entries); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// saveDeckContent writes the deck and a new revision. Tags are replaced
+// only when non-nil.
+func (a *App) saveDeckContent(ctx context.Context, row *deckRow, tags []string) (int, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE decks SET name = ?, raw_text = ?, entries = ?, is_public = ?, format = ?, share_token = ?, cover_card = ?, cover_image_url = ?
+		WHERE id = ?
+	`, row.Name, row.RawText, row.Entries, row.IsPublic, row.Format, row.ShareToken, row.CoverCard, row.CoverImageURL, row.ID); err != nil {
+		return 0, err
+	}
+	if tags != nil {
+		if err := setDeckTags(ctx, tx, row.ID, tags); err != nil {
+			return 0, err
+		}
+	}
+	if err := indexDeckCards(ctx, tx, row.ID, row.Entries); err != nil {
+		return 0, err
+	}
+	revision, err := recordDeckRevision(ctx, tx, row.ID, row.Name, row.RawText, row.Entries)
+	if err != nil {
+		return 0, err
+	}
+	return revision, tx.Commit()
+}
+
+func (a *App) handleUpdateDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	var payload updateDeckPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	row, err := a.loadOwnedDeck(r.Context(), user.ID, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	if payload.Name != nil {
+		if strings.TrimSpace(*payload.Name) == "" {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, "Name cannot be empty")
+			return
+		}
+		name, problem := a.filterText("Deck name", *payload.Name)
+		if problem != nil {
+			problem.write(w)
+			return
+		}
+		row.Name = name
+	}
+	if payload.RawText != nil {
+		if strings.TrimSpace(*payload.RawText) == "" {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, "rawText cannot be empty")
+			return
+		}
+		row.RawText = *payload.RawText
+	}
+	if payload.Entries != nil {
+		entries, err := normalizeDeckEntries(payload.Entries)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+			return
+		}
+		row.Entries = string(entries)
+	}
+	if payload.Visibility != nil {
+		if err := applyDeckVisibility(row, *payload.Visibility); err != nil {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+			return
+		}
+	} else if payload.IsPublic != nil {
+		visibility := deckVisibilityPrivate
+		if *payload.IsPublic {
+			visibility = deckVisibilityPublic
+		}
+		_ = applyDeckVisibility(row, visibility)
+	}
+	if payload.Format != nil {
+		format, err := normalizeDeckFormat(*payload.Format)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+			return
+		}
+		row.Format = sql.NullString{String: format, Valid: format != ""}
+	}
+	if limitErr := a.deckLimits.checkDeckSize(row.RawText, json.RawMessage(row.Entries)); limitErr != nil {
+		limitErr.write(w)
+		return
+	}
+	if err := a.applyDeckCover(row, payload.CoverCard); err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	if (payload.Entries != nil || payload.Format != nil) && a.checkDeckBans(w, r, row) {
+		return
+	}
+	var tags []string
+	if payload.Tags != nil {
+		if tags, err = normalizeDeckTags(payload.Tags); err != nil {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+			return
+		}
+	}
+	revision, err := a.saveDeckContent(r.Context(), row, tags)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save deck")
+		return
+	}
+	deck := deckRowToMap(row)
+	deck["revision"] = revision
+	a.attachDeckTags(r.Context(), []map[string]interface{}{deck})
+	writeJSON(w, http.StatusOK, deck)
+}
+
+func (a *App) handleDeckRevisions(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if _, err := a.loadOwnedDeck(r.Context(), user.ID, id); err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT revision, name, raw_text, entries, created_at
+		FROM deck_revisions
+		WHERE deck_id = ?
+		ORDER BY revision DESC
+	`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load revisions")
+		return
+	}
+	defer rows.Close()
+	revisions := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var revision int
+		var name, rawText, entries, createdAt string
+		if err := rows.Scan(&revision, &name, &rawText, &entries, &createdAt); err != nil {
+			continue
+		}
+		revisions = append(revisions, map[string]interface{}{
+			"revision":  revision,
+			"name":      name,
+			"rawText":   rawText,
+			"entries":   json.RawMessage(entries),
+			"createdAt": createdAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, revisions)
+}
+
+func (a *App) handleRevertDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	target, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil || target < 1 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid revision")
+		return
+	}
+	row, err := a.loadOwnedDeck(r.Context(), user.ID, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT name, raw_text, entries
+		FROM deck_revisions
+		WHERE deck_id = ? AND revision = ?
+	`, id, target).Scan(&row.Name, &row.RawText, &row.Entries)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Revision not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load revision")
+		return
+	}
+	_ = a.applyDeckCover(row, nil)
+	revision, err := a.saveDeckContent(r.Context(), row, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save deck")
+		return
+	}
+	deck := deckRowToMap(row)
+	deck["revision"] = revision
+	deck["revertedFrom"] = target
+	a.attachDeckTags(r.Context(), []map[string]interface{}{deck})
+	writeJSON(w, http.StatusOK, deck)
+}
+
+type copyDeckPayload struct {
+	Name string `json:"name"`
+}
+
+func (a *App) handleCopyDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	source, err := a.loadVisibleDeck(r.Context(), user, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	a.copyDeck(w, r, user, source)
+}
+
+func (a *App) copyDeck(w http.ResponseWriter, r *http.Request, user *User, source *deckRow) {
+	var payload copyDeckPayload
+	if err := decodeJSON(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	var author string
+	if err := a.db.QueryRowContext(r.Context(), `SELECT u.username FROM decks d JOIN users u ON d.user_id = u.id WHERE d.id = ?`, source.ID).Scan(&author); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = source.Name
+	}
+	name, problem := a.filterText("Deck name", name)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	copied := &deckRow{
+		ID:               randomID(16),
+		Name:             name,
+		RawText:          source.RawText,
+		Entries:          source.Entries,
+		CreatedAt:        time.Now().UTC().Format(time.RFC3339),
+		ForkedFrom:       sql.NullString{String: source.ID, Valid: true},
+		ForkedFromName:   sql.NullString{String: source.Name, Valid: true},
+		ForkedFromAuthor: sql.NullString{String: author, Valid: true},
+		Format:           source.Format,
+		CoverCard:        source.CoverCard,
+		CoverImageURL:    source.CoverImageURL,
+	}
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO decks (id, user_id, name, raw_text, entries, is_public, forked_from, forked_from_name, forked_from_author, format, cover_card, cover_image_url)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?)
+	`, copied.ID, user.ID, copied.Name, copied.RawText, copied.Entries, source.ID, source.Name, author, copied.Format, copied.CoverCard, copied.CoverImageURL); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	if limitErr := a.checkDeckQuota(r.Context(), tx, user.ID); limitErr != nil {
+		limitErr.write(w)
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `INSERT INTO deck_tags (deck_id, tag) SELECT ?, tag FROM deck_tags WHERE deck_id = ?`, copied.ID, source.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	if err := indexDeckCards(r.Context(), tx, copied.ID, copied.Entries); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	if _, err := recordDeckRevision(r.Context(), tx, copied.ID, copied.Name, copied.RawText, copied.Entries); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to copy deck")
+		return
+	}
+	deck := deckRowToMap(copied)
+	a.attachDeckTags(r.Context(), []map[string]interface{}{deck})
+	writeJSON(w, http.StatusOK, deck)
+}
+
+func (a *App) deckLikeCount(ctx context.Context, deckID string) int {
+	var likes int
+	_ = a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM deck_likes WHERE deck_id = ?`, deckID).Scan(&likes)
+	return likes
+}
+
+func (a *App) handleLikeDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	deck, err := a.loadVisibleDeck(r.Context(), user, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO deck_likes (deck_id, user_id)
+		VALUES (?, ?)
+		ON CONFLICT(deck_id, user_id) DO NOTHING
+	`, deck.ID, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to like deck")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"liked": true, "likes": a.deckLikeCount(r.Context(), deck.ID)})
+}
+
+func (a *App) handleUnlikeDeck(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	deckID := chi.URLParam(r, "id")
+	if _, err := a.db.ExecContext(r.Context(), `DELETE FROM deck_likes WHERE deck_id = ? AND user_id = ?`, deckID, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to unlike deck")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"liked": false, "likes": a.deckLikeCount(r.Context(), deckID)})
+}
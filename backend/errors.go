@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error codes sent in the "code" field of REST error bodies and room:error
+// payloads. Clients branch on these rather than on the message text, so a
+// code never changes meaning once shipped; add a new one instead.
+const (
+	// Request problems.
+	codeInvalidRequest       = "invalid_request"   // malformed body or missing parameter
+	codeValidationFailed     = "validation_failed" // well-formed but a value is not acceptable
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeInvalidDeck          = "invalid_deck"
+	codeInvalidPatch         = "invalid_patch"
+	codeInvalidRoomState     = "invalid_room_state"
+	codeLimitReached         = "limit_reached"
+	codeBlockedWord          = "blocked_word" // a name or text contains a word the instance does not allow
+
+	// Identity and permissions.
+	codeUnauthenticated     = "unauthenticated"
+	codeInvalidCredentials  = "invalid_credentials"
+	codeRoomAccessRequired  = "room_access_required"
+	codeForbidden           = "forbidden"
+	codeAdminRequired       = "admin_required"
+	codeInsufficientScope   = "insufficient_scope"
+	codeCSRFFailed          = "csrf_failed"
+	codeUsernameTaken       = "username_taken"
+	codeRoomPasswordInvalid = "room_password_invalid"
+	codeAccountSuspended    = "account_suspended" // suspended or banned; details say until when and why
+
+	// Resource state.
+	codeNotFound        = "not_found"
+	codeConflict        = "conflict"
+	codeVersionMismatch = "version_mismatch"
+	codeRoomExists      = "room_exists"
+	codePlayerIDInUse   = "player_id_in_use"
+	codePlayerNameInUse = "player_name_in_use"
+	codeSeatTaken       = "seat_taken"
+	codeRoomFull        = "room_full"
+
+	// Server side.
+	codeRateLimited    = "rate_limited"
+	codeUnavailable    = "unavailable"
+	codeMaintenance    = "maintenance" // the server is in maintenance mode; details carry the window
+	codeServerFull     = "server_full" // the instance is at its room or player limit; details say which
+	codeUpstreamFailed = "upstream_failed"
+	codeInternal       = "internal"
+
+	// WebSocket only.
+	codeInvalidMessage   = "invalid_message"
+	codeUnknownMessage   = "unknown_message"
+	codeNotInRoom        = "not_in_room"
+	codeHostDisconnected = "host_disconnected"
+	codeRoomClosed       = "room_closed" // an admin closed the room
+	codeInvalidEvent     = "invalid_event"
+	codeNothingToUndo    = "nothing_to_undo"
+)
+
+// apiError is an error a helper hands back to its handler to send as is.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) write(w http.ResponseWriter) {
+	writeError(w, e.Status, e.Code, e.Message)
+}
+
+// errorBody is the REST error envelope. "error" repeats the message for
+// clients written before codes existed.
+func errorBody(code string, message string, details interface{}) map[string]interface{} {
+	body := map[string]interface{}{"error": message, "code": code, "message": message}
+	if details != nil {
+		body["details"] = details
+	}
+	return body
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, errorBody(code, message, nil))
+}
+
+func writeErrorDetails(w http.ResponseWriter, status int, code string, message string, details interface{}) {
+	writeJSON(w, status, errorBody(code, message, details))
+}
+
+// sendError reports a failed WebSocket request with the same code and
+// message a REST client would get.
+func (a *App) sendError(clientID string, code string, message string) {
+	a.sendErrorDetails(clientID, code, message, nil)
+}
+
+func (a *App) sendErrorDetails(clientID string, code string, message string, details interface{}) {
+	a.send(clientID, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Code: code, Message: message, Details: details})})
+}
+
+// roomErrorCode maps the room registry's errors to codes.
+func roomErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errRoomExists):
+		return codeRoomExists
+	case errors.Is(err, errRoomNotFound):
+		return codeNotFound
+	case errors.Is(err, errRoomPassword):
+		return codeRoomPasswordInvalid
+	case errors.Is(err, errPlayerIDInUse):
+		return codePlayerIDInUse
+	case errors.Is(err, errPlayerNameInUse):
+		return codePlayerNameInUse
+	case errors.Is(err, errSeatTaken):
+		return codeSeatTaken
+	case errors.Is(err, errRoomFull):
+		return codeRoomFull
+	case errors.Is(err, errTeamSize):
+		return codeValidationFailed
+	default:
+		return codeInternal
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	banStatusBanned     = "banned"
+	banStatusRestricted = "restricted"
+	// banStatusAllowed lifts an imported ban for this instance.
+	banStatusAllowed = "allowed"
+
+	banSourceImport = "import"
+	banSourceAdmin  = "admin"
+
+	maxBanReasonLen = 500
+)
+
+// Each format's ban list starts from the legalities in cards.json: every
+// card Scryfall lists as banned or restricted is stored with source
+// "import", and replaced on each card import. Admins add house bans and
+// restrictions on top, or mark an imported ban as allowed; their entries are
+// kept across imports. Decks saved with a format, and decks picked in a room
+// lobby, may not play a banned card or more than one copy of a restricted
+// one.
+type formatBan struct {
+	CardName  string  `json:"cardName"`
+	Status    string  `json:"status"`
+	Source    string  `json:"source"`
+	Reason    *string `json:"reason,omitempty"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+type formatBanPayload struct {
+	CardName string `json:"cardName"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+}
+
+// banViolation is a card that keeps a deck out of its format.
+type banViolation struct {
+	CardName string `json:"cardName"`
+	Status   string `json:"status"`
+	Quantity int    `json:"quantity"`
+}
+
+type formatBanKey struct {
+	format string
+	name   string
+}
+
+// importedBan is a banned or restricted card seen during a card import.
+type importedBan struct {
+	cardName string
+	status   string
+}
+
+// collectFormatBans notes the card's bans and restrictions in known formats.
+func collectFormatBans(bans map[formatBanKey]importedBan, card scryfallCard) {
+	name := strings.TrimSpace(card.Name)
+	for format, legality := range card.Legalities {
+		if !knownDeckFormats[format] || (legality != banStatusBanned && legality != banStatusRestricted) {
+			continue
+		}
+		bans[formatBanKey{format, normalizeCardName(name)}] = importedBan{name, legality}
+	}
+}
+
+// replaceImportedBans swaps the imported entries for the ones just read,
+// leaving cards an admin has ruled on alone.
+func replaceImportedBans(tx *sql.Tx, bans map[formatBanKey]importedBan) error {
+	if _, err := tx.Exec(`DELETE FROM format_bans WHERE source = ?`, banSourceImport); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO format_bans (format, name_normalized, card_name, status, source)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(format, name_normalized) DO NOTHING
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, ban := range bans {
+		if _, err := stmt.Exec(key.format, key.name, ban.cardName, ban.status, banSourceImport); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *App) formatBans(ctx context.Context, format string) ([]formatBan, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT card_name, status, source, reason, updated_at
+		FROM format_bans
+		WHERE format = ?
+		ORDER BY card_name
+	`, format)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := make([]formatBan, 0)
+	for rows.Next() {
+		var ban formatBan
+		var reason sql.NullString
+		if err := rows.Scan(&ban.CardName, &ban.Status, &ban.Source, &reason, &ban.UpdatedAt); err != nil {
+			return nil, err
+		}
+		ban.Reason = nullStringToPtr(reason)
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+// deckBanViolations lists the cards that keep the deck's entries out of the
+// format, in deck order. Maybeboard and token entries are not played and do
+// not count.
+func (a *App) deckBanViolations(ctx context.Context, format string, entriesJSON string) ([]banViolation, error) {
+	if format == "" {
+		return nil, nil
+	}
+	entries, err := decodeDeckEntries(entriesJSON)
+	if err != nil {
+		// Unreadable entries hold no cards to check; the deck is refused
+		// elsewhere when that matters.
+		return nil, nil
+	}
+	rows, err := a.db.QueryContext(ctx, `SELECT name_normalized, card_name, status FROM format_bans WHERE format = ? AND status != ?`, format, banStatusAllowed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := make(map[string]banViolation)
+	for rows.Next() {
+		var name string
+		var ban banViolation
+		if err := rows.Scan(&name, &ban.CardName, &ban.Status); err != nil {
+			return nil, err
+		}
+		bans[name] = ban
+		// Decks name double-faced and split cards by their front face.
+		if front, _, ok := strings.Cut(name, " // "); ok {
+			bans[front] = ban
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	var order []string
+	for _, entry := range entries {
+		switch entrySection(entry) {
+		case "maybeboard", "tokens":
+			continue
+		}
+		name := normalizeCardName(entry.Name)
+		if _, seen := counts[name]; !seen {
+			order = append(order, name)
+		}
+		counts[name] += entry.Quantity
+	}
+	var violations []banViolation
+	for _, name := range order {
+		ban, ok := bans[name]
+		if !ok || (ban.Status == banStatusRestricted && counts[name] <= 1) {
+			continue
+		}
+		ban.Quantity = counts[name]
+		violations = append(violations, ban)
+	}
+	return violations, nil
+}
+
+func decodeDeckEntries(entriesJSON string) ([]deckEntry, error) {
+	var entries []deckEntry
+	err := json.Unmarshal([]byte(entriesJSON), &entries)
+	return entries, err
+}
+
+func banViolationMessage(format string, violations []banViolation) string {
+	names := make([]string, len(violations))
+	for i, violation := range violations {
+		names[i] = violation.CardName
+	}
+	return "Deck is not legal in " + format + ": " + strings.Join(names, ", ")
+}
+
+// checkDeckBans answers a save of a deck that breaks its format's ban list,
+// reporting whether it did.
+func (a *App) checkDeckBans(w http.ResponseWriter, r *http.Request, row *deckRow) bool {
+	violations, err := a.deckBanViolations(r.Context(), row.Format.String, row.Entries)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to check the ban list")
+		return true
+	}
+	if len(violations) == 0 {
+		return false
+	}
+	writeErrorDetails(w, http.StatusUnprocessableEntity, codeInvalidDeck, banViolationMessage(row.Format.String, violations), map[string]interface{}{"violations": violations})
+	return true
+}
+
+func (a *App) handleFormatBanlist(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(chi.URLParam(r, "format"))
+	if !knownDeckFormats[format] {
+		writeError(w, http.StatusNotFound, codeNotFound, "Unknown format")
+		return
+	}
+	bans, err := a.formatBans(r.Context(), format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load the ban list")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"format": format, "cards": bans})
+}
+
+// handleAdminSetFormatBan bans, restricts or allows a card in a format.
+func (a *App) handleAdminSetFormatBan(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(chi.URLParam(r, "format"))
+	if !knownDeckFormats[format] {
+		writeError(w, http.StatusNotFound, codeNotFound, "Unknown format")
+		return
+	}
+	var payload formatBanPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	switch payload.Status {
+	case banStatusBanned, banStatusRestricted, banStatusAllowed:
+	default:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "status must be banned, restricted or allowed")
+		return
+	}
+	reason := strings.TrimSpace(payload.Reason)
+	if len(reason) > maxBanReasonLen {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "reason must be at most "+strconv.Itoa(maxBanReasonLen)+" characters")
+		return
+	}
+	cardName, err := a.canonicalCardName(r.Context(), payload.CardName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Card not found")
+		return
+	}
+
+	admin := a.currentUser(r)
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO format_bans (format, name_normalized, card_name, status, source, reason, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(format, name_normalized) DO UPDATE SET
+			card_name = excluded.card_name,
+			status = excluded.status,
+			source = excluded.source,
+			reason = excluded.reason,
+			updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`, format, normalizeCardName(cardName), cardName, payload.Status, banSourceAdmin, nullIfEmpty(reason), admin.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update the ban list")
+		return
+	}
+	_ = recordAudit(r.Context(), a.db, admin, "format_"+payload.Status, 0, format+": "+cardName, reason)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"format": format, "cardName": cardName, "status": payload.Status, "success": true})
+}
+
+// handleAdminDeleteFormatBan removes a card from a format's list. An
+// imported ban comes back with the next card import; mark it allowed to
+// lift it for good.
+func (a *App) handleAdminDeleteFormatBan(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(chi.URLParam(r, "format"))
+	cardName := strings.TrimSpace(r.URL.Query().Get("card"))
+	if cardName == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "card parameter is required")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM format_bans WHERE format = ? AND name_normalized = ?`, format, normalizeCardName(cardName))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update the ban list")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Card is not on the list")
+		return
+	}
+	_ = recordAudit(r.Context(), a.db, a.currentUser(r), "format_unlist", 0, format+": "+cardName, "")
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// canonicalCardName returns the card's name as imported, matching a
+// double-faced card by its front face. Without card data the name is taken
+// as given.
+func (a *App) canonicalCardName(ctx context.Context, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("card name is required")
+	}
+	if !a.ensureCardsAvailable() {
+		return name, nil
+	}
+	normalized := normalizeCardName(name)
+	var names []string
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT DISTINCT name FROM cards
+		WHERE name_normalized = ? OR name_normalized LIKE ? ESCAPE '\'
+		LIMIT 5
+	`, normalized, escapeLikePattern(normalized)+" // %")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var found string
+		if err := rows.Scan(&found); err == nil {
+			names = append(names, found)
+		}
+	}
+	if len(names) == 0 {
+		return "", errors.New("card not found")
+	}
+	// An exact match sorts ahead of the longer double-faced names.
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) < len(names[j]) })
+	return names[0], nil
+}
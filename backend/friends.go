@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	friendStatusPending  = "pending"
+	friendStatusAccepted = "accepted"
+)
+
+type friendRequestPayload struct {
+	Username string `json:"username"`
+}
+
+// friendIDs returns the users with an accepted friendship with userID.
+func (a *App) friendIDs(userID int64) []int64 {
+	rows, err := a.db.Query(`
+		SELECT CASE WHEN user_id = ? THEN friend_id ELSE user_id END
+		FROM friends
+		WHERE (user_id = ? OR friend_id = ?) AND status = ?
+	`, userID, userID, userID, friendStatusAccepted)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (a *App) handleFriends(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT f.user_id, f.friend_id, f.status, f.created_at, u.id, u.username, u.avatar_url
+		FROM friends f
+		JOIN users u ON u.id = CASE WHEN f.user_id = ? THEN f.friend_id ELSE f.user_id END
+		WHERE f.user_id = ? OR f.friend_id = ?
+		ORDER BY u.username
+	`, user.ID, user.ID, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load friends")
+		return
+	}
+	defer rows.Close()
+	friends := make([]map[string]interface{}, 0)
+	incoming := make([]map[string]interface{}, 0)
+	outgoing := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var requesterID, addresseeID, otherID int64
+		var status, createdAt, username string
+		var avatarURL sql.NullString
+		if err := rows.Scan(&requesterID, &addresseeID, &status, &createdAt, &otherID, &username, &avatarURL); err != nil {
+			continue
+		}
+		entry := map[string]interface{}{
+			"userId":    otherID,
+			"username":  username,
+			"avatarUrl": nullStringToPtr(avatarURL),
+			"since":     createdAt,
+		}
+		switch {
+		case status == friendStatusAccepted:
+			online, roomID := a.presence.status(otherID)
+			entry["online"] = online
+			entry["roomId"] = nullIfEmpty(roomID)
+			friends = append(friends, entry)
+		case requesterID == user.ID:
+			outgoing = append(outgoing, entry)
+		default:
+			incoming = append(incoming, entry)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"friends":  friends,
+		"incoming": incoming,
+		"outgoing": outgoing,
+	})
+}
+
+// handleFriendRequest sends a request by username. A request to someone who
+// already asked us accepts theirs instead.
+func (a *App) handleFriendRequest(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload friendRequestPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	var targetID int64
+	err := a.db.QueryRowContext(r.Context(), `SELECT id FROM users WHERE username = ?`, strings.TrimSpace(payload.Username)).Scan(&targetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to send request")
+		return
+	}
+	if targetID == user.ID {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Cannot befriend yourself")
+		return
+	}
+	accepted, err := a.db.ExecContext(r.Context(), `
+		UPDATE friends SET status = ?
+		WHERE user_id = ? AND friend_id = ? AND status = ?
+	`, friendStatusAccepted, targetID, user.ID, friendStatusPending)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to send request")
+		return
+	}
+	if changes, _ := accepted.RowsAffected(); changes > 0 {
+		a.notifyFriendship(user, targetID)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"userId": targetID, "status": friendStatusAccepted})
+		return
+	}
+	var existing string
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT status FROM friends
+		WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)
+	`, user.ID, targetID, targetID, user.ID).Scan(&existing)
+	if err == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"userId": targetID, "status": existing})
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO friends (user_id, friend_id, status)
+		VALUES (?, ?, ?)
+	`, user.ID, targetID, friendStatusPending); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to send request")
+		return
+	}
+	a.notify(targetID, notifyFriendRequest, map[string]interface{}{"userId": user.ID, "username": user.Username})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"userId": targetID, "status": friendStatusPending})
+}
+
+func (a *App) handleAcceptFriend(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	requesterID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `
+		UPDATE friends SET status = ?
+		WHERE user_id = ? AND friend_id = ? AND status = ?
+	`, friendStatusAccepted, requesterID, user.ID, friendStatusPending)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to accept request")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Friend request not found")
+		return
+	}
+	a.notifyFriendship(user, requesterID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"userId": requesterID, "status": friendStatusAccepted})
+}
+
+// handleRemoveFriend unfriends, declines an incoming request, or cancels an
+// outgoing one.
+func (a *App) handleRemoveFriend(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	otherID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `
+		DELETE FROM friends
+		WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)
+	`, user.ID, otherID, otherID, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to remove friend")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Friend not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// notifyFriendship tells both sides about a new friendship along with the
+// other's current presence.
+func (a *App) notifyFriendship(user *User, otherID int64) {
+	var otherName string
+	_ = a.db.QueryRow(`SELECT username FROM users WHERE id = ?`, otherID).Scan(&otherName)
+	a.notify(otherID, notifyFriendAccepted, map[string]interface{}{"userId": user.ID, "username": user.Username})
+	for _, side := range []struct {
+		to       int64
+		id       int64
+		username string
+	}{
+		{otherID, user.ID, user.Username},
+		{user.ID, otherID, otherName},
+	} {
+		online, roomID := a.presence.status(side.id)
+		a.sendToUser(side.to, WSMessage{
+			Type: "friend:accepted",
+			Payload: marshalPayload(map[string]interface{}{
+				"userId":   side.id,
+				"username": side.username,
+				"online":   online,
+				"roomId":   nullIfEmpty(roomID),
+			}),
+		})
+	}
+}
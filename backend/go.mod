@@ -8,4 +8,4 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.24
 )
 
-require github.com/joho/godotenv v1.5.1 // indirect
+require github.com/joho/godotenv v1.5.1
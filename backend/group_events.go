@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	rsvpGoing    = "going"
+	rsvpMaybe    = "maybe"
+	rsvpDeclined = "declined"
+
+	notifyGameReminder = "game_reminder"
+	notifyGameStarting = "game_starting"
+
+	maxGroupEventTitleLength = 100
+	groupEventCheckInterval  = 30 * time.Second
+	// groupEventOpenWindow is how late a game is still opened, e.g. after
+	// the server was down through its start time.
+	groupEventOpenWindow = "-1 day"
+)
+
+// A group's members schedule games for the group with a start time, an
+// optional format and a number of seats, and answer them going, maybe or
+// declined; once the seats are taken no one else can be going. Those going
+// or maybe are sent a game_reminder notification GROUP_EVENT_REMINDER_MINUTES
+// before the start. At the start time the game is given a room id and
+// password and those going are sent game_starting with the room id. The
+// first of them to room:join it opens the room as its host, for the group,
+// and everyone going joins without the password; no one else can join.
+type groupEventPayload struct {
+	Title    string `json:"title"`
+	Format   string `json:"format"`
+	StartsAt string `json:"startsAt"`
+	MaxSeats int    `json:"maxSeats"`
+}
+
+type rsvpPayload struct {
+	Status string `json:"status"`
+}
+
+type groupEventRSVP struct {
+	UserID   int64  `json:"userId"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+}
+
+type groupEvent struct {
+	ID        string           `json:"id"`
+	GroupID   string           `json:"groupId"`
+	Title     string           `json:"title"`
+	Format    *string          `json:"format"`
+	StartsAt  string           `json:"startsAt"`
+	MaxSeats  int              `json:"maxSeats"`
+	CreatedBy *string          `json:"createdBy"`
+	RoomID    *string          `json:"roomId"`
+	Going     int              `json:"going"`
+	RSVPs     []groupEventRSVP `json:"rsvps"`
+	MyRSVP    *string          `json:"myRsvp"`
+}
+
+// scheduledGame is an opened game a player is going to.
+type scheduledGame struct {
+	groupID  string
+	password string
+}
+
+func loadGroupEventReminderMinutes() int {
+	return envInt("GROUP_EVENT_REMINDER_MINUTES", 60)
+}
+
+// loadGroupEvents returns the group's events, or the one with eventID, with
+// their RSVPs as seen by userID. Events more than a day past their start are
+// left out of the list.
+func (a *App) loadGroupEvents(ctx context.Context, groupID string, eventID string, userID int64) ([]*groupEvent, error) {
+	where := "e.group_id = ? AND e.starts_at >= datetime('now', ?)"
+	args := []interface{}{groupID, groupEventOpenWindow}
+	if eventID != "" {
+		where, args = "e.group_id = ? AND e.id = ?", []interface{}{groupID, eventID}
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT e.id, e.title, e.format, e.starts_at, e.max_seats, u.username, e.room_id
+		FROM group_events e
+		LEFT JOIN users u ON u.id = e.created_by
+		WHERE `+where+`
+		ORDER BY e.starts_at, e.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*groupEvent, 0)
+	byID := make(map[string]*groupEvent)
+	for rows.Next() {
+		event := &groupEvent{GroupID: groupID, RSVPs: make([]groupEventRSVP, 0)}
+		var format, createdBy, roomID sql.NullString
+		if err := rows.Scan(&event.ID, &event.Title, &format, &event.StartsAt, &event.MaxSeats, &createdBy, &roomID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		event.Format, event.CreatedBy, event.RoomID = nullStringToPtr(format), nullStringToPtr(createdBy), nullStringToPtr(roomID)
+		events = append(events, event)
+		byID[event.ID] = event
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(events) == 0 {
+		return events, err
+	}
+
+	rows, err = a.db.QueryContext(ctx, `
+		SELECT r.event_id, r.user_id, u.username, r.status
+		FROM group_event_rsvps r
+		JOIN group_events e ON e.id = r.event_id
+		JOIN users u ON u.id = r.user_id
+		WHERE `+where+`
+		ORDER BY r.updated_at, u.username
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var rsvp groupEventRSVP
+		if err := rows.Scan(&id, &rsvp.UserID, &rsvp.Username, &rsvp.Status); err != nil {
+			return nil, err
+		}
+		event := byID[id]
+		if event == nil {
+			continue
+		}
+		event.RSVPs = append(event.RSVPs, rsvp)
+		if rsvp.Status == rsvpGoing {
+			event.Going++
+		}
+		if rsvp.UserID == userID {
+			status := rsvp.Status
+			event.MyRSVP = &status
+		}
+	}
+	return events, rows.Err()
+}
+
+// handleGroupEvents lists the group's calendar: its games from a day ago on,
+// soonest first.
+func (a *App) handleGroupEvents(w http.ResponseWriter, r *http.Request) {
+	user, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	events, err := a.loadGroupEvents(r.Context(), groupID, "", user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load events")
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (a *App) handleGroupEvent(w http.ResponseWriter, r *http.Request) {
+	user, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	events, err := a.loadGroupEvents(r.Context(), groupID, chi.URLParam(r, "eventId"), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load event")
+		return
+	}
+	if len(events) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Event not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, events[0])
+}
+
+// handleCreateGroupEvent schedules a game. Its creator is going.
+func (a *App) handleCreateGroupEvent(w http.ResponseWriter, r *http.Request) {
+	user, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	var payload groupEventPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	title := strings.TrimSpace(payload.Title)
+	format := strings.ToLower(strings.TrimSpace(payload.Format))
+	startsAt, err := time.Parse(time.RFC3339, strings.TrimSpace(payload.StartsAt))
+	switch {
+	case title == "":
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "title is required")
+		return
+	case len(title) > maxGroupEventTitleLength:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "title is too long")
+		return
+	case format != "" && !knownDeckFormats[format]:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Unknown format")
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "startsAt must be an RFC 3339 time")
+		return
+	case !startsAt.After(time.Now()):
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "startsAt must be in the future")
+		return
+	case payload.MaxSeats < 2 || payload.MaxSeats > maxRoomSeats:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "maxSeats must be between 2 and "+strconv.Itoa(maxRoomSeats))
+		return
+	}
+	title, problem := a.filterText("Title", title)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+
+	id := randomID(16)
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to schedule game")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO group_events (id, group_id, title, format, starts_at, max_seats, created_by)
+		VALUES (?, ?, ?, ?, datetime(?), ?, ?)
+	`, id, groupID, title, nullIfEmpty(format), startsAt.UTC().Format(time.RFC3339), payload.MaxSeats, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to schedule game")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO group_event_rsvps (event_id, user_id, status)
+		VALUES (?, ?, ?)
+	`, id, user.ID, rsvpGoing); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to schedule game")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to schedule game")
+		return
+	}
+	events, err := a.loadGroupEvents(r.Context(), groupID, id, user.ID)
+	if err != nil || len(events) == 0 {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load event")
+		return
+	}
+	writeJSON(w, http.StatusOK, events[0])
+}
+
+// handleDeleteGroupEvent cancels a game. Its creator and the group's owner
+// can do so.
+func (a *App) handleDeleteGroupEvent(w http.ResponseWriter, r *http.Request) {
+	user, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	eventID := chi.URLParam(r, "eventId")
+	var createdBy sql.NullInt64
+	err := a.db.QueryRowContext(r.Context(), `SELECT created_by FROM group_events WHERE id = ? AND group_id = ?`, eventID, groupID).Scan(&createdBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to cancel game")
+		return
+	}
+	if createdBy.Int64 != user.ID && role != groupRoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the game's creator or the group's owner can cancel it")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `DELETE FROM group_events WHERE id = ?`, eventID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to cancel game")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleGroupEventRSVP records the member's answer. Going is refused once the
+// game's seats are taken.
+func (a *App) handleGroupEventRSVP(w http.ResponseWriter, r *http.Request) {
+	user, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	var payload rsvpPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	switch payload.Status {
+	case rsvpGoing, rsvpMaybe, rsvpDeclined:
+	default:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "status must be going, maybe or declined")
+		return
+	}
+	eventID := chi.URLParam(r, "eventId")
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save RSVP")
+		return
+	}
+	defer tx.Rollback()
+	var maxSeats, going int
+	err = tx.QueryRowContext(r.Context(), `
+		SELECT e.max_seats,
+			(SELECT COUNT(*) FROM group_event_rsvps r WHERE r.event_id = e.id AND r.status = ? AND r.user_id != ?)
+		FROM group_events e
+		WHERE e.id = ? AND e.group_id = ?
+	`, rsvpGoing, user.ID, eventID, groupID).Scan(&maxSeats, &going)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save RSVP")
+		return
+	}
+	if payload.Status == rsvpGoing && going >= maxSeats {
+		writeError(w, http.StatusConflict, codeConflict, "Every seat is taken")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO group_event_rsvps (event_id, user_id, status)
+		VALUES (?, ?, ?)
+		ON CONFLICT(event_id, user_id) DO UPDATE SET status = excluded.status, updated_at = CURRENT_TIMESTAMP
+	`, eventID, user.ID, payload.Status); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save RSVP")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save RSVP")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"eventId": eventID, "status": payload.Status})
+}
+
+func (a *App) runGroupEvents(reminderMinutes int) {
+	ticker := time.NewTicker(groupEventCheckInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		if err := a.sendGroupEventReminders(reminderMinutes); err != nil {
+			log.Printf("[groups] failed to send game reminders: %v", err)
+		}
+		if err := a.openGroupEvents(); err != nil {
+			log.Printf("[groups] failed to open scheduled games: %v", err)
+		}
+	}
+}
+
+// dueGroupEvent is a game whose reminder or start time has come.
+type dueGroupEvent struct {
+	id       string
+	groupID  string
+	title    string
+	startsAt string
+}
+
+func (a *App) dueGroupEvents(query string, args ...interface{}) ([]dueGroupEvent, error) {
+	rows, err := a.db.Query(`SELECT id, group_id, title, starts_at FROM group_events WHERE `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []dueGroupEvent
+	for rows.Next() {
+		var event dueGroupEvent
+		if err := rows.Scan(&event.id, &event.groupID, &event.title, &event.startsAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// notifyGroupEvent notifies the members of the group who answered the game
+// with one of the statuses.
+func (a *App) notifyGroupEvent(event dueGroupEvent, kind string, payload map[string]interface{}, statuses ...string) {
+	args := []interface{}{event.groupID, event.id}
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	rows, err := a.db.Query(`
+		SELECT r.user_id FROM group_event_rsvps r
+		JOIN group_members m ON m.user_id = r.user_id AND m.group_id = ?
+		WHERE r.event_id = ? AND r.status IN (?`+strings.Repeat(", ?", len(statuses)-1)+`)
+	`, args...)
+	if err != nil {
+		log.Printf("[groups] failed to load the players of %s: %v", event.id, err)
+		return
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err == nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+	payload["groupId"], payload["eventId"], payload["title"], payload["startsAt"] = event.groupID, event.id, event.title, event.startsAt
+	for _, userID := range userIDs {
+		a.notify(userID, kind, payload)
+	}
+}
+
+// sendGroupEventReminders reminds the players of games starting soon. Each
+// game is claimed with its reminded_at, so only one instance sends it.
+func (a *App) sendGroupEventReminders(minutes int) error {
+	if minutes <= 0 {
+		return nil
+	}
+	events, err := a.dueGroupEvents(`reminded_at IS NULL AND room_id IS NULL AND starts_at <= datetime('now', ?) AND starts_at > datetime('now', ?)`,
+		"+"+strconv.Itoa(minutes)+" minutes", groupEventOpenWindow)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		claimed, err := a.db.Exec(`UPDATE group_events SET reminded_at = CURRENT_TIMESTAMP WHERE id = ? AND reminded_at IS NULL`, event.id)
+		if err != nil {
+			return err
+		}
+		if changes, _ := claimed.RowsAffected(); changes == 0 {
+			continue
+		}
+		a.notifyGroupEvent(event, notifyGameReminder, map[string]interface{}{}, rsvpGoing, rsvpMaybe)
+	}
+	return nil
+}
+
+// openGroupEvents gives the games whose start time has come their room.
+func (a *App) openGroupEvents() error {
+	events, err := a.dueGroupEvents(`room_id IS NULL AND starts_at <= CURRENT_TIMESTAMP AND starts_at > datetime('now', ?)`, groupEventOpenWindow)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		roomID := "game-" + randomID(6)
+		claimed, err := a.db.Exec(`UPDATE group_events SET room_id = ?, room_password = ? WHERE id = ? AND room_id IS NULL`, roomID, randomID(16), event.id)
+		if err != nil {
+			return err
+		}
+		if changes, _ := claimed.RowsAffected(); changes == 0 {
+			continue
+		}
+		a.notifyGroupEvent(event, notifyGameStarting, map[string]interface{}{"roomId": roomID}, rsvpGoing)
+	}
+	return nil
+}
+
+// scheduledGameFor returns the opened game behind roomID when the user is
+// going to it.
+func (a *App) scheduledGameFor(roomID string, userID int64) (scheduledGame, bool) {
+	var game scheduledGame
+	if userID == 0 {
+		return game, false
+	}
+	err := a.db.QueryRow(`
+		SELECT e.group_id, e.room_password
+		FROM group_events e
+		JOIN group_event_rsvps r ON r.event_id = e.id AND r.user_id = ? AND r.status = ?
+		JOIN group_members m ON m.group_id = e.group_id AND m.user_id = r.user_id
+		WHERE e.room_id = ?
+	`, userID, rsvpGoing, roomID).Scan(&game.groupID, &game.password)
+	return game, err == nil
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	groupRoleOwner  = "owner"
+	groupRoleMember = "member"
+
+	maxGroupNameLength        = 64
+	maxGroupDescriptionLength = 500
+)
+
+// Groups give a playgroup or a store's league its own space on an instance.
+// Whoever creates a group owns it and hands out its invite code; anyone with
+// the code joins as a member. Members can share their decks with the group,
+// which lets the other members view, copy and play them as if they were
+// public, and can create rooms for the group (room:create's groupId), which
+// the group's room listing shows while they are open. Non-members are told a
+// group does not exist.
+type groupPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type joinGroupPayload struct {
+	InviteCode string `json:"inviteCode"`
+}
+
+// groupRole returns the user's role in the group, or sql.ErrNoRows when they
+// are not a member.
+func (a *App) groupRole(ctx context.Context, groupID string, userID int64) (string, error) {
+	var role string
+	err := a.db.QueryRowContext(ctx, `SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&role)
+	return role, err
+}
+
+// groupMember checks the request's user belongs to the URL's group, and
+// answers the request when they do not.
+func (a *App) groupMember(w http.ResponseWriter, r *http.Request) (*User, string, string, bool) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return nil, "", "", false
+	}
+	groupID := chi.URLParam(r, "groupId")
+	role, err := a.groupRole(r.Context(), groupID, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Group not found")
+		return nil, "", "", false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load group")
+		return nil, "", "", false
+	}
+	return user, groupID, role, true
+}
+
+func (a *App) validateGroupPayload(payload groupPayload) (groupPayload, *apiError) {
+	name := strings.TrimSpace(payload.Name)
+	description := strings.TrimSpace(payload.Description)
+	switch {
+	case name == "":
+		return payload, &apiError{http.StatusBadRequest, codeValidationFailed, "Group name is required"}
+	case len(name) > maxGroupNameLength:
+		return payload, &apiError{http.StatusBadRequest, codeValidationFailed, "Group name is too long"}
+	case len(description) > maxGroupDescriptionLength:
+		return payload, &apiError{http.StatusBadRequest, codeValidationFailed, "Description is too long"}
+	}
+	name, problem := a.filterText("Group name", name)
+	if problem != nil {
+		return payload, problem
+	}
+	description, problem = a.filterText("Description", description)
+	if problem != nil {
+		return payload, problem
+	}
+	return groupPayload{Name: name, Description: description}, nil
+}
+
+// handleGroups lists the groups the current user belongs to.
+func (a *App) handleGroups(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT g.id, g.name, g.description, g.created_at, m.role,
+			(SELECT COUNT(*) FROM group_members c WHERE c.group_id = g.id)
+		FROM group_members m
+		JOIN groups g ON g.id = m.group_id
+		WHERE m.user_id = ?
+		ORDER BY g.name
+	`, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load groups")
+		return
+	}
+	defer rows.Close()
+	groups := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, createdAt, role string
+		var description sql.NullString
+		var members int
+		if err := rows.Scan(&id, &name, &description, &createdAt, &role, &members); err != nil {
+			continue
+		}
+		groups = append(groups, map[string]interface{}{
+			"id":          id,
+			"name":        name,
+			"description": nullStringToPtr(description),
+			"role":        role,
+			"memberCount": members,
+			"createdAt":   createdAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (a *App) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload groupPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	payload, problem := a.validateGroupPayload(payload)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	id, inviteCode := randomID(16), randomID(8)
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create group")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO groups (id, name, description, owner_id, invite_code)
+		VALUES (?, ?, ?, ?, ?)
+	`, id, payload.Name, nullIfEmpty(payload.Description), user.ID, inviteCode); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create group")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO group_members (group_id, user_id, role)
+		VALUES (?, ?, ?)
+	`, id, user.ID, groupRoleOwner); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create group")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create group")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":          id,
+		"name":        payload.Name,
+		"description": nullIfEmpty(payload.Description),
+		"role":        groupRoleOwner,
+		"inviteCode":  inviteCode,
+		"memberCount": 1,
+	})
+}
+
+// handleGroup shows a group and its members, with the presence of each. Only
+// the owner sees the invite code.
+func (a *App) handleGroup(w http.ResponseWriter, r *http.Request) {
+	_, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	var name, inviteCode, createdAt string
+	var description sql.NullString
+	if err := a.db.QueryRowContext(r.Context(), `SELECT name, description, invite_code, created_at FROM groups WHERE id = ?`, groupID).Scan(&name, &description, &inviteCode, &createdAt); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load group")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT u.id, u.username, u.avatar_url, m.role, m.joined_at
+		FROM group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = ?
+		ORDER BY m.role = ? DESC, u.username
+	`, groupID, groupRoleOwner)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load group")
+		return
+	}
+	defer rows.Close()
+	members := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var userID int64
+		var username, memberRole, joinedAt string
+		var avatarURL sql.NullString
+		if err := rows.Scan(&userID, &username, &avatarURL, &memberRole, &joinedAt); err != nil {
+			continue
+		}
+		online, roomID := a.presence.status(userID)
+		members = append(members, map[string]interface{}{
+			"userId":    userID,
+			"username":  username,
+			"avatarUrl": nullStringToPtr(avatarURL),
+			"role":      memberRole,
+			"joinedAt":  joinedAt,
+			"online":    online,
+			"roomId":    nullIfEmpty(roomID),
+		})
+	}
+	group := map[string]interface{}{
+		"id":          groupID,
+		"name":        name,
+		"description": nullStringToPtr(description),
+		"role":        role,
+		"members":     members,
+		"createdAt":   createdAt,
+	}
+	if role == groupRoleOwner {
+		group["inviteCode"] = inviteCode
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+func (a *App) handleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	_, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	if role != groupRoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the owner can edit the group")
+		return
+	}
+	var payload groupPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	payload, problem := a.validateGroupPayload(payload)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `UPDATE groups SET name = ?, description = ? WHERE id = ?`, payload.Name, nullIfEmpty(payload.Description), groupID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update group")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": groupID, "name": payload.Name, "description": nullIfEmpty(payload.Description)})
+}
+
+func (a *App) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	_, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	if role != groupRoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the owner can delete the group")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `DELETE FROM groups WHERE id = ?`, groupID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete group")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleResetGroupInvite replaces the invite code, so a leaked one stops
+// working. Members who already joined stay.
+func (a *App) handleResetGroupInvite(w http.ResponseWriter, r *http.Request) {
+	_, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	if role != groupRoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the owner can change the invite code")
+		return
+	}
+	inviteCode := randomID(8)
+	if _, err := a.db.ExecContext(r.Context(), `UPDATE groups SET invite_code = ? WHERE id = ?`, inviteCode, groupID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to change the invite code")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": groupID, "inviteCode": inviteCode})
+}
+
+func (a *App) handleJoinGroup(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload joinGroupPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	var groupID, name string
+	err := a.db.QueryRowContext(r.Context(), `SELECT id, name FROM groups WHERE invite_code = ?`, strings.TrimSpace(payload.InviteCode)).Scan(&groupID, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Invite code not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to join group")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO group_members (group_id, user_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT(group_id, user_id) DO NOTHING
+	`, groupID, user.ID, groupRoleMember); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to join group")
+		return
+	}
+	role, _ := a.groupRole(r.Context(), groupID, user.ID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": groupID, "name": name, "role": role})
+}
+
+// handleRemoveGroupMember lets a member leave, or the owner remove a member.
+// The decks the member shared leave the group with them. The owner cannot
+// leave; they delete the group instead.
+func (a *App) handleRemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	user, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id")
+		return
+	}
+	switch {
+	case memberID == user.ID && role == groupRoleOwner:
+		writeError(w, http.StatusConflict, codeConflict, "The owner cannot leave the group")
+		return
+	case memberID != user.ID && role != groupRoleOwner:
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the owner can remove members")
+		return
+	}
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to remove member")
+		return
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(r.Context(), `DELETE FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, memberID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to remove member")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Member not found")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		DELETE FROM group_decks
+		WHERE group_id = ? AND deck_id IN (SELECT id FROM decks WHERE user_id = ?)
+	`, groupID, memberID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to remove member")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to remove member")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleGroupDecks lists the decks shared with the group, newest first.
+func (a *App) handleGroupDecks(w http.ResponseWriter, r *http.Request) {
+	_, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT d.id, d.name, d.format, d.cover_card, d.cover_image_url, u.username, gd.shared_at
+		FROM group_decks gd
+		JOIN decks d ON d.id = gd.deck_id
+		JOIN users u ON u.id = d.user_id
+		WHERE gd.group_id = ?
+		ORDER BY gd.shared_at DESC, d.name
+	`, groupID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load decks")
+		return
+	}
+	defer rows.Close()
+	decks := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, author, sharedAt string
+		var format, coverCard, coverImageURL sql.NullString
+		if err := rows.Scan(&id, &name, &format, &coverCard, &coverImageURL, &author, &sharedAt); err != nil {
+			continue
+		}
+		decks = append(decks, map[string]interface{}{
+			"id":            id,
+			"name":          name,
+			"format":        nullStringToPtr(format),
+			"coverCard":     nullStringToPtr(coverCard),
+			"coverImageUrl": nullStringToPtr(coverImageURL),
+			"author":        author,
+			"sharedAt":      sharedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, decks)
+}
+
+// handleShareGroupDeck shares one of the member's decks with the group.
+func (a *App) handleShareGroupDeck(w http.ResponseWriter, r *http.Request) {
+	user, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	deckID := chi.URLParam(r, "deckId")
+	var owner int64
+	err := a.db.QueryRowContext(r.Context(), `SELECT user_id FROM decks WHERE id = ?`, deckID).Scan(&owner)
+	if err != nil || owner != user.ID {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO group_decks (group_id, deck_id)
+		VALUES (?, ?)
+		ON CONFLICT(group_id, deck_id) DO NOTHING
+	`, groupID, deckID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to share deck")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"groupId": groupID, "deckId": deckID, "success": true})
+}
+
+// handleUnshareGroupDeck takes a deck out of the group. The deck's author and
+// the group's owner can do so.
+func (a *App) handleUnshareGroupDeck(w http.ResponseWriter, r *http.Request) {
+	user, groupID, role, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	deckID := chi.URLParam(r, "deckId")
+	var owner int64
+	err := a.db.QueryRowContext(r.Context(), `
+		SELECT d.user_id FROM group_decks gd JOIN decks d ON d.id = gd.deck_id
+		WHERE gd.group_id = ? AND gd.deck_id = ?
+	`, groupID, deckID).Scan(&owner)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
+		return
+	}
+	if owner != user.ID && role != groupRoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the deck's author or the group's owner can unshare it")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), `DELETE FROM group_decks WHERE group_id = ? AND deck_id = ?`, groupID, deckID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to unshare deck")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleGroupRooms lists the group's open rooms on every instance.
+func (a *App) handleGroupRooms(w http.ResponseWriter, r *http.Request) {
+	_, groupID, _, ok := a.groupMember(w, r)
+	if !ok {
+		return
+	}
+	rooms := a.rooms.GroupRooms(groupID)
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomID < rooms[j].RoomID })
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// GroupRooms summarizes the open rooms created for the group.
+func (r *RoomRegistry) GroupRooms(groupID string) []roomSummary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	summaries := make([]roomSummary, 0)
+	for _, room := range r.rooms {
+		if room.GroupID == groupID {
+			summaries = append(summaries, summarizeRoom(room))
+		}
+	}
+	return summaries
+}
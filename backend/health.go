@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+var processStarted = time.Now()
+
+// cardImportStatus tracks the startup card import, which runs after the
+// server starts listening so liveness probes pass while it does.
+type cardImportStatus struct {
+	mu         sync.RWMutex
+	importing  bool
+	err        string
+	finishedAt time.Time
+}
+
+var cardsImport cardImportStatus
+
+func (s *cardImportStatus) begin() {
+	s.mu.Lock()
+	s.importing = true
+	s.err = ""
+	s.mu.Unlock()
+}
+
+func (s *cardImportStatus) finish(err error) {
+	s.mu.Lock()
+	s.importing = false
+	s.finishedAt = time.Now().UTC()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+}
+
+// startCardImport marks the import as running before returning, so readiness
+// never reports ready between the listener starting and the import.
+func startCardImport(db *sql.DB) {
+	cardsImport.begin()
+	go func() {
+		err := ensureCardsLoaded(db)
+		cardsImport.finish(err)
+		if err != nil {
+			log.Printf("cards load skipped: %v", err)
+		}
+	}()
+}
+
+type componentStatus struct {
+	Status string                 `json:"status"`
+	Error  string                 `json:"error,omitempty"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+// handleHealthz is the liveness probe: it only reports that the process is
+// serving requests.
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "ok",
+		"timestamp":     time.Now().UTC().Format(time.RFC3339),
+		"uptimeSeconds": int64(time.Since(processStarted).Seconds()),
+	})
+}
+
+// handleReadyz is the readiness probe. It fails while the database is
+// unreachable, migrations are pending or the startup card import is still
+// running. A missing cards.json does not fail it, since the server can run
+// without the card database, but it is reported as degraded.
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	components := map[string]componentStatus{
+		"database":   a.databaseReadiness(r.Context()),
+		"migrations": a.migrationReadiness(),
+		"cards":      a.cardReadiness(),
+	}
+	status, code := "ok", http.StatusOK
+	for _, component := range components {
+		switch component.Status {
+		case "down", "pending":
+			status, code = "unavailable", http.StatusServiceUnavailable
+		case "degraded":
+			if code == http.StatusOK {
+				status = "degraded"
+			}
+		}
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status":     status,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"components": components,
+	})
+}
+
+func (a *App) databaseReadiness(ctx context.Context) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	started := time.Now()
+	if err := a.db.PingContext(ctx); err != nil {
+		return componentStatus{Status: "down", Error: err.Error()}
+	}
+	var one int
+	if err := a.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return componentStatus{Status: "down", Error: err.Error()}
+	}
+	return componentStatus{Status: "ok", Detail: map[string]interface{}{
+		"latencyMs": time.Since(started).Milliseconds(),
+	}}
+}
+
+func (a *App) migrationReadiness() componentStatus {
+	states, err := migrationStatus(a.db)
+	if err != nil {
+		return componentStatus{Status: "down", Error: err.Error()}
+	}
+	var pending []string
+	latest := 0
+	for _, state := range states {
+		if state.AppliedAt == "" {
+			pending = append(pending, state.Name)
+		} else if state.Version > latest {
+			latest = state.Version
+		}
+	}
+	detail := map[string]interface{}{"version": latest}
+	if len(pending) > 0 {
+		detail["pending"] = pending
+		return componentStatus{Status: "pending", Detail: detail}
+	}
+	return componentStatus{Status: "ok", Detail: detail}
+}
+
+func (a *App) cardReadiness() componentStatus {
+	cardsImport.mu.RLock()
+	importing, importErr, finishedAt := cardsImport.importing, cardsImport.err, cardsImport.finishedAt
+	cardsImport.mu.RUnlock()
+	detail := map[string]interface{}{"reloading": cardsReloading.Load()}
+	if !finishedAt.IsZero() {
+		detail["finishedAt"] = finishedAt.Format(time.RFC3339)
+	}
+	if importing {
+		return componentStatus{Status: "pending", Detail: detail}
+	}
+	if !a.ensureCardsAvailable() {
+		return componentStatus{Status: "degraded", Error: importErr, Detail: detail}
+	}
+	return componentStatus{Status: "ok", Detail: detail}
+}
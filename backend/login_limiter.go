@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginLimiter throttles authentication attempts per key (client IP or
+// username) with a sliding window, and locks a key out with exponential
+// backoff once it accumulates too many consecutive failures.
+type loginLimiter struct {
+	window           time.Duration
+	maxAttempts      int
+	lockoutThreshold int
+	baseLockout      time.Duration
+	maxLockout       time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*loginAttempts
+	lastSweep time.Time
+}
+
+type loginAttempts struct {
+	times       []time.Time
+	failures    int
+	lockedUntil time.Time
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{
+		window:           time.Duration(envInt("LOGIN_RATE_WINDOW_SECONDS", 60)) * time.Second,
+		maxAttempts:      envInt("LOGIN_RATE_MAX_ATTEMPTS", 10),
+		lockoutThreshold: envInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		baseLockout:      time.Duration(envInt("LOGIN_LOCKOUT_SECONDS", 30)) * time.Second,
+		maxLockout:       15 * time.Minute,
+		entries:          make(map[string]*loginAttempts),
+	}
+}
+
+// allow records an attempt for every key and returns how long the caller
+// must wait when any of them is locked out or over the window limit.
+func (l *loginLimiter) allow(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	var wait time.Duration
+	for _, key := range keys {
+		entry := l.entry(key)
+		if remaining := entry.lockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+		entry.times = pruneAttempts(entry.times, now.Add(-l.window))
+		if l.maxAttempts > 0 && len(entry.times) >= l.maxAttempts {
+			if remaining := entry.times[0].Add(l.window).Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, key := range keys {
+		entry := l.entries[key]
+		entry.times = append(entry.times, now)
+	}
+	return 0
+}
+
+func (l *loginLimiter) recordFailure(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		entry := l.entry(key)
+		entry.failures++
+		if l.lockoutThreshold <= 0 || entry.failures < l.lockoutThreshold {
+			continue
+		}
+		lockout := time.Duration(float64(l.baseLockout) * math.Pow(2, float64(entry.failures-l.lockoutThreshold)))
+		if lockout > l.maxLockout || lockout <= 0 {
+			lockout = l.maxLockout
+		}
+		entry.lockedUntil = now.Add(lockout)
+	}
+}
+
+func (l *loginLimiter) recordSuccess(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if entry := l.entries[key]; entry != nil {
+			entry.failures = 0
+			entry.lockedUntil = time.Time{}
+		}
+	}
+}
+
+func (l *loginLimiter) entry(key string) *loginAttempts {
+	entry := l.entries[key]
+	if entry == nil {
+		entry = &loginAttempts{}
+		l.entries[key] = entry
+	}
+	return entry
+}
+
+// sweep drops entries with no recent attempts and no active lockout. Failure
+// counts are forgotten along with them, so backoff resets after a quiet spell.
+func (l *loginLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	cutoff := now.Add(-l.maxLockout)
+	for key, entry := range l.entries {
+		last := entry.lockedUntil
+		if n := len(entry.times); n > 0 && entry.times[n-1].After(last) {
+			last = entry.times[n-1]
+		}
+		if last.Before(cutoff) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func pruneAttempts(times []time.Time, cutoff time.Time) []time.Time {
+	index := 0
+	for index < len(times) && !times[index].After(cutoff) {
+		index++
+	}
+	return times[index:]
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func loginLimiterKeys(r *http.Request, username string) []string {
+	keys := []string{"ip:" + clientIP(r)}
+	if username = strings.ToLower(strings.TrimSpace(username)); username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	writeTooManyRequests(w, wait, "Too many attempts, try again later")
+}
+
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	body := errorBody(codeRateLimited, message, map[string]int{"retryAfter": seconds})
+	body["retryAfter"] = seconds
+	writeJSON(w, http.StatusTooManyRequests, body)
+}
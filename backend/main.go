@@ -8,21 +8,22 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"flag"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
@@ -30,11 +31,44 @@ const (
 )
 
 type App struct {
-	db        *sql.DB
-	rooms     *RoomRegistry
-	router    *chi.Mux
-	clientsMu sync.RWMutex
-	clients   map[string]*WSClient
+	config      *Config
+	db          *sql.DB
+	scryfall    *scryfallClient
+	deckLimits  deckLimits
+	preconOwner string
+	loginLimit  *loginLimiter
+	rateLimits  *rateLimiter
+	oauth       *oauthConfig
+	csrfMode    string
+	cookies     cookiePolicy
+	origins     originAllowlist
+	presence    *presenceTracker
+	roomTokens  *roomTokenSigner
+	tracer      *tracer
+	bus         *roomBus
+	webhooks    *webhookDispatcher
+	media       *mediaStore
+	backups     backupSettings
+	replays     *replayExports
+	gameLog     *roomLog
+	reveals     *revealTracker
+	scries      *scryTracker
+	stacks      *stackTracker
+	lobbies     *lobbyTracker
+	idle        *idleTracker
+	resyncs     *resyncTracker
+	deltas      *deltaTracker
+	activity    *activityTracker
+	words       *wordFilter
+	emotes      emoteSettings
+	capacity    *capacityLimits
+	bodyLimits  bodyLimits
+	maintenance *maintenanceMode
+	stats       *statsCache
+	rooms       *RoomRegistry
+	router      *chi.Mux
+	clientsMu   sync.RWMutex
+	clients     map[string]*WSClient
 }
 
 type RoomRegistry struct {
@@ -50,19 +84,42 @@ type RoomState struct {
 	HostSocketID   string
 	HostPlayerID   string
 	HostPlayerName string
-	Clients        map[string]ClientInfo
+	HostUserID     int64
+	HostSeat       int
+	// TeamSize is the number of seats per team in a team game such as
+	// Two-Headed Giant, or 0. See room_teams.go.
+	TeamSize int
+	// HouseRules are the room's house rules, or nil. See
+	// room_house_rules.go.
+	HouseRules *houseRules
+	// GroupID is the group the room was created for, or "". See groups.go.
+	GroupID string
+	Clients map[string]ClientInfo
+	// TurnOrder lists seats in turn order; seats it leaves out follow in
+	// seat order. See room_seats.go.
+	TurnOrder []int
 }
 
 type ClientInfo struct {
 	PlayerID   string `json:"playerId"`
 	PlayerName string `json:"playerName"`
+	UserID     int64  `json:"userId,omitempty"`
+	Seat       int    `json:"seat,omitempty"`
+	Team       int    `json:"team,omitempty"`
 }
 
+// Seat in the create and join payloads asks for a seat number; without one
+// the lowest free seat is taken.
 type RoomCreatePayload struct {
-	RoomID     string `json:"roomId"`
-	Password   string `json:"password"`
-	PlayerID   string `json:"playerId"`
-	PlayerName string `json:"playerName"`
+	RoomID     string      `json:"roomId"`
+	Password   string      `json:"password"`
+	PlayerID   string      `json:"playerId"`
+	PlayerName string      `json:"playerName"`
+	Seat       int         `json:"seat,omitempty"`
+	TeamSize   int         `json:"teamSize,omitempty"`
+	HouseRules *houseRules `json:"houseRules,omitempty"`
+	GroupID    string      `json:"groupId,omitempty"`
+	UserID     int64       `json:"-"`
 }
 
 type RoomJoinPayload struct {
@@ -70,6 +127,17 @@ type RoomJoinPayload struct {
 	Password   string `json:"password"`
 	PlayerID   string `json:"playerId"`
 	PlayerName string `json:"playerName"`
+	Seat       int    `json:"seat,omitempty"`
+	// RoomToken resumes a seat after a reconnect: the player id and name
+	// come from the token, and the password is not asked for again.
+	RoomToken string `json:"roomToken,omitempty"`
+	// AcceptDeltas asks for full-board host messages as JSON patches.
+	AcceptDeltas bool  `json:"acceptDeltas,omitempty"`
+	UserID       int64 `json:"-"`
+	resumed      bool
+	// invited is set for players going to the scheduled game the room was
+	// opened for, who need no password. See group_events.go.
+	invited bool
 }
 
 type RoomClientMessagePayload struct {
@@ -89,29 +157,66 @@ type RoomEventPayload struct {
 	EventData  json.RawMessage `json:"eventData"`
 	PlayerID   string          `json:"playerId"`
 	PlayerName string          `json:"playerName"`
+	UserID     int64           `json:"-"`
 }
 
 type RoomClientJoinedPayload struct {
-	RoomID     string `json:"roomId"`
-	PlayerID   string `json:"playerId"`
-	PlayerName string `json:"playerName"`
-	SocketID   string `json:"socketId"`
+	RoomID     string      `json:"roomId"`
+	PlayerID   string      `json:"playerId"`
+	PlayerName string      `json:"playerName"`
+	SocketID   string      `json:"socketId"`
+	Seat       int         `json:"seat"`
+	Team       int         `json:"team,omitempty"`
+	TeamSize   int         `json:"teamSize,omitempty"`
+	HouseRules *houseRules `json:"houseRules,omitempty"`
+	RoomToken  string      `json:"roomToken,omitempty"`
+	// PlaymatURL and CardBackURL are the player's uploaded images, if any.
+	PlaymatURL  string `json:"playmatUrl,omitempty"`
+	CardBackURL string `json:"cardBackUrl,omitempty"`
 }
 
 type RoomClientLeftPayload struct {
 	RoomID   string `json:"roomId"`
 	PlayerID string `json:"playerId"`
 	SocketID string `json:"socketId"`
+	Seat     int    `json:"seat"`
 }
 
+// ErrorPayload is the room:error payload, the same envelope REST errors
+// use; see errors.go for the codes.
 type ErrorPayload struct {
-	Message string `json:"message"`
-}
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+var (
+	errRoomExists      = errors.New("room already exists")
+	errRoomNotFound    = errors.New("room not found")
+	errRoomPassword    = errors.New("incorrect password")
+	errPlayerIDInUse   = errors.New("player id already in use")
+	errPlayerNameInUse = errors.New("a player with that name is already in the room")
+	errSeatTaken       = errors.New("seat is taken")
+	errRoomFull        = errors.New("room is full")
+	errTeamSize        = errors.New("teamSize must be between 2 and 4")
+)
 
 type WSClient struct {
 	id   string
 	conn *websocket.Conn
-	mu   sync.Mutex
+	// stream is set instead of conn for a Server-Sent Events client, and
+	// for a server-side bot, which reads it in place of a response.
+	stream   *roomStream
+	bot      *goldfishBot
+	mu       sync.Mutex
+	userID   int64
+	username string
+	// lastActive is when the client last sent a message, in Unix
+	// nanoseconds; see room_idle.go.
+	lastActive atomic.Int64
+	// acceptDeltas is set when the client joined asking for board patches;
+	// see room_deltas.go.
+	acceptDeltas atomic.Bool
 }
 
 type WSMessage struct {
@@ -131,7 +236,17 @@ func (r *RoomRegistry) Create(roomID string, payload RoomCreatePayload, socketID
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if _, exists := r.rooms[roomID]; exists {
-		return errors.New("room already exists")
+		return errRoomExists
+	}
+	if payload.Seat < 0 || payload.Seat > maxRoomSeats {
+		return errSeatTaken
+	}
+	if payload.TeamSize != 0 && (payload.TeamSize < 2 || payload.TeamSize > maxTeamSize) {
+		return errTeamSize
+	}
+	seat := payload.Seat
+	if seat == 0 {
+		seat = 1
 	}
 	r.rooms[roomID] = &RoomState{
 		ID:             roomID,
@@ -139,6 +254,11 @@ func (r *RoomRegistry) Create(roomID string, payload RoomCreatePayload, socketID
 		HostSocketID:   socketID,
 		HostPlayerID:   payload.PlayerID,
 		HostPlayerName: payload.PlayerName,
+		HostUserID:     payload.UserID,
+		HostSeat:       seat,
+		TeamSize:       payload.TeamSize,
+		HouseRules:     payload.HouseRules,
+		GroupID:        payload.GroupID,
 		Clients:        make(map[string]ClientInfo),
 	}
 	r.socketToRoom[socketID] = roomID
@@ -151,14 +271,46 @@ func (r *RoomRegistry) Join(roomID string, payload RoomJoinPayload, socketID str
 	defer r.mu.Unlock()
 	room, ok := r.rooms[roomID]
 	if !ok {
-		return nil, errors.New("room not found")
+		return nil, errRoomNotFound
 	}
-	if room.Password != payload.Password {
-		return nil, errors.New("incorrect password")
+	if room.Password != payload.Password && !payload.resumed && !payload.invited {
+		return nil, errRoomPassword
+	}
+	// A player id still held in the room can only be taken over with that
+	// player's room token, or by the same signed-in account.
+	held := func(playerID string, userID int64) bool {
+		return playerID == payload.PlayerID && !payload.resumed && (userID == 0 || userID != payload.UserID)
+	}
+	if held(room.HostPlayerID, room.HostUserID) {
+		return nil, errPlayerIDInUse
+	}
+	for _, client := range room.Clients {
+		if held(client.PlayerID, client.UserID) {
+			return nil, errPlayerIDInUse
+		}
+	}
+	// Private zones and reveals are keyed by player name, so two players may
+	// not share one; a player coming back under their own id keeps theirs.
+	taken := func(playerID string, name string) bool {
+		return name == payload.PlayerName && playerID != payload.PlayerID
+	}
+	if taken(room.HostPlayerID, room.HostPlayerName) {
+		return nil, errPlayerNameInUse
+	}
+	for _, client := range room.Clients {
+		if taken(client.PlayerID, client.PlayerName) {
+			return nil, errPlayerNameInUse
+		}
+	}
+	seat, err := room.claimSeat(payload.Seat)
+	if err != nil {
+		return nil, err
 	}
 	room.Clients[socketID] = ClientInfo{
 		PlayerID:   payload.PlayerID,
 		PlayerName: payload.PlayerName,
+		UserID:     payload.UserID,
+		Seat:       seat,
 	}
 	r.socketToRoom[socketID] = roomID
 	r.socketRole[socketID] = "client"
@@ -212,6 +364,22 @@ func (r *RoomRegistry) ClientInfo(roomID string, socketID string) (ClientInfo, b
 	return info, ok
 }
 
+// Member returns the membership of a socket in the room, host included.
+func (r *RoomRegistry) Member(roomID string, socketID string) (ClientInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return ClientInfo{}, false
+	}
+	if room.HostSocketID == socketID {
+		return room.host(), true
+	}
+	info, ok := room.Clients[socketID]
+	info.Team = room.team(info.Seat)
+	return info, ok
+}
+
 func (r *RoomRegistry) ClientSocketIDs(roomID string) []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -226,50 +394,196 @@ func (r *RoomRegistry) ClientSocketIDs(roomID string) []string {
 	return ids
 }
 
+type roomSummary struct {
+	RoomID      string       `json:"roomId"`
+	Host        ClientInfo   `json:"host"`
+	Clients     []ClientInfo `json:"clients"`
+	HasPassword bool         `json:"hasPassword"`
+}
+
+func summarizeRoom(room *RoomState) roomSummary {
+	summary := roomSummary{
+		RoomID:      room.ID,
+		Host:        room.host(),
+		Clients:     make([]ClientInfo, 0, len(room.Clients)),
+		HasPassword: room.Password != "",
+	}
+	for _, info := range room.Clients {
+		info.Team = room.team(info.Seat)
+		summary.Clients = append(summary.Clients, info)
+	}
+	sort.Slice(summary.Clients, func(i, j int) bool { return summary.Clients[i].Seat < summary.Clients[j].Seat })
+	return summary
+}
+
+func (r *RoomRegistry) Summaries() []roomSummary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	summaries := make([]roomSummary, 0, len(r.rooms))
+	for _, room := range r.rooms {
+		summaries = append(summaries, summarizeRoom(room))
+	}
+	return summaries
+}
+
+func (r *RoomRegistry) Summary(roomID string) (roomSummary, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return roomSummary{}, false
+	}
+	return summarizeRoom(room), true
+}
+
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Printf("dotenv not loaded: %v", err)
 	}
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := runCLI(config, os.Args[1:]); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// runServeCommand starts the API and WebSocket server. It only returns on
+// flag errors; failures after that are fatal.
+func runServeCommand(config *Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listenPort := flags.String("port", "", "port to listen on (default: API_PORT, PORT or 3000)")
+	if ok, err := parseCLIFlags(flags, args); !ok {
+		return err
+	}
+	if *listenPort != "" {
+		os.Setenv("API_PORT", *listenPort)
+	}
+	config.logEffective()
 
-	db, err := openDatabase()
+	tracer := loadTracer()
+	db, err := openDatabase(tracer)
 	if err != nil {
 		log.Fatalf("failed to open database: %v", err)
 	}
 	defer db.Close()
-	if err := ensureSchema(db); err != nil {
-		log.Fatalf("failed to ensure schema: %v", err)
+	if _, err := runMigrations(db); err != nil {
+		log.Fatalf("failed to run migrations: %v", err)
+	}
+	if err := ensureAdminUser(db); err != nil {
+		log.Fatalf("failed to seed admin user: %v", err)
+	}
+	if err := migrateLegacySessions(db); err != nil {
+		log.Fatalf("failed to migrate sessions: %v", err)
 	}
 	if err := ensureUIConfig(db); err != nil {
 		log.Fatalf("failed to ensure ui config: %v", err)
 	}
-	if err := ensureCardsLoaded(db); err != nil {
-		log.Printf("cards load skipped: %v", err)
+	if err := ensureDeckCardsIndexed(db); err != nil {
+		log.Printf("deck card index skipped: %v", err)
 	}
 
 	app := &App{
-		db:      db,
-		rooms:   NewRoomRegistry(),
-		router:  chi.NewRouter(),
-		clients: make(map[string]*WSClient),
-	}
-
+		config:      config,
+		db:          db,
+		scryfall:    newScryfallClient(db),
+		deckLimits:  loadDeckLimits(),
+		preconOwner: loadPreconOwner(),
+		loginLimit:  newLoginLimiter(),
+		rateLimits:  loadRateLimiter(),
+		oauth:       loadOAuthConfig(),
+		csrfMode:    loadCSRFMode(),
+		cookies:     loadCookiePolicy(),
+		origins:     loadOriginAllowlist(),
+		presence:    newPresenceTracker(),
+		roomTokens:  loadRoomTokenSigner(),
+		tracer:      tracer,
+		webhooks:    newWebhookDispatcher(db),
+		gameLog:     newRoomLog(),
+		reveals:     newRevealTracker(),
+		scries:      newScryTracker(),
+		stacks:      newStackTracker(),
+		lobbies:     newLobbyTracker(),
+		idle:        loadIdleTracker(),
+		emotes:      loadEmoteSettings(),
+		resyncs:     newResyncTracker(),
+		deltas:      loadDeltaTracker(),
+		activity:    newActivityTracker(),
+		maintenance: &maintenanceMode{},
+		capacity:    loadCapacityLimits(),
+		bodyLimits:  loadBodyLimits(),
+		stats:       &statsCache{},
+		rooms:       NewRoomRegistry(),
+		router:      chi.NewRouter(),
+		clients:     make(map[string]*WSClient),
+	}
+
+	app.loadMaintenance()
+	if app.words, err = loadWordFilter(); err != nil {
+		log.Fatalf("failed to load blocked words: %v", err)
+	}
+	if app.media, err = loadMediaStore(); err != nil {
+		log.Fatalf("failed to configure media storage: %v", err)
+	}
+	if app.backups, err = loadBackupSettings(); err != nil {
+		log.Fatalf("failed to configure backup storage: %v", err)
+	}
+	if app.replays, err = loadReplayExports(); err != nil {
+		log.Fatalf("failed to configure replay export storage: %v", err)
+	}
+	if app.bus, err = loadRoomBus(app); err != nil {
+		log.Fatalf("failed to configure room bus: %v", err)
+	}
+
+	app.router.Use(app.legacyRoutesMiddleware)
 	app.router.Use(middleware.RequestID)
-	app.router.Use(middleware.RealIP)
+	app.router.Use(app.traceMiddleware)
+	// Without TRUSTED_PROXIES the forwarding headers are ignored and the
+	// client is the direct peer; configure it when running behind a reverse
+	// proxy.
+	if trusted := loadTrustedProxies(); len(trusted) > 0 {
+		app.router.Use(trustedProxyMiddleware(trusted))
+	}
+	app.router.Use(compressMiddleware(loadCompressSettings()))
 	app.router.Use(middleware.Recoverer)
 	app.router.Use(app.corsMiddleware)
-
-	app.router.HandleFunc("/ws", app.handleWS)
+	app.router.Use(app.rateLimitMiddleware)
+	app.router.Use(bodyLimitMiddleware(app.bodyLimits))
+	app.router.Use(queryTimeoutMiddleware(loadQueryTimeouts()))
+	app.router.Use(app.csrfMiddleware)
 
 	app.registerRoutes()
-
+	startCardImport(db)
+	app.bus.start()
+	go app.runRoomCompaction(loadRoomCompactionConfig())
+	go app.runRoomRetention(loadRoomRetentionDays())
+	go app.runIdleChecks()
+	go app.runStatsRefresh()
+	go app.runGroupEvents(loadGroupEventReminderMinutes())
+	go app.runBackups(app.backups)
+
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		log.Fatalf("failed to load TLS certificate: %v", err)
+	}
 	port := resolvePort("API_PORT", "PORT", "3000")
 	addr := "0.0.0.0:" + port
-	log.Printf("[api] listening on %s", addr)
-	log.Printf("[ws] listening on %s", addr)
-
-	if err := http.ListenAndServe(addr, app.router); err != nil {
+	server := &http.Server{Addr: addr, Handler: app.router, TLSConfig: tlsConfig}
+	applyServerTimeouts(server)
+	if tlsConfig != nil {
+		log.Printf("[api] listening on %s (https)", addr)
+		log.Printf("[ws] listening on %s (wss)", addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("[api] listening on %s", addr)
+		log.Printf("[ws] listening on %s", addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("server failed: %v", err)
 	}
+	return nil
 }
 
 func (a *App) handleWS(w http.ResponseWriter, r *http.Request) {
@@ -279,41 +593,67 @@ func (a *App) handleWS(w http.ResponseWriter, r *http.Request) {
 			if origin == "" {
 				return true
 			}
-			return isOriginAllowed(origin, buildAllowedOrigins())
+			return a.origins.allows(origin)
 		},
 	}
 
+	user, err := a.socketUser(w, r)
+	if err != nil {
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("[ws] upgrade failed: %v", err)
 		return
 	}
+	defer conn.Close()
 
 	client := &WSClient{
 		id:   randomID(8),
 		conn: conn,
 	}
+	if user != nil {
+		client.userID = user.ID
+		client.username = user.Username
+	}
 	a.registerClient(client)
 	defer a.unregisterClient(client)
+	a.greetDuringMaintenance(client)
 
 	for {
-		_, data, err := conn.ReadMessage()
+		data, err := readWSMessage(conn, a.bodyLimits.wsMessageBytes)
+		if errors.Is(err, errWSMessageTooLarge) {
+			// The rest of the message is never read, so the connection
+			// cannot carry on.
+			limit := a.bodyLimits.wsMessageBytes
+			log.Printf("[ws] closing %s: message over %d bytes", client.id, limit)
+			a.sendErrorDetails(client.id, codePayloadTooLarge, "Message is too large (limit "+strconv.FormatInt(limit, 10)+" bytes)", map[string]int64{"limitBytes": limit})
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "message too large"), time.Now().Add(time.Second))
+			break
+		}
 		if err != nil {
 			break
 		}
 		var message WSMessage
 		if err := json.Unmarshal(data, &message); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "invalid message"})})
+			a.sendError(client.id, codeInvalidMessage, "invalid message")
 			continue
 		}
+		span := a.traceWSMessage(client, message)
 		a.handleWSMessage(client, message)
+		span.End()
 	}
 }
 
 func (a *App) registerClient(client *WSClient) {
 	a.clientsMu.Lock()
-	defer a.clientsMu.Unlock()
 	a.clients[client.id] = client
+	a.clientsMu.Unlock()
+
+	if client.userID != 0 && a.presence.connect(client.userID, client.id) {
+		a.broadcastPresence(client, "friend:online", nil)
+	}
 }
 
 func (a *App) unregisterClient(client *WSClient) {
@@ -321,40 +661,122 @@ func (a *App) unregisterClient(client *WSClient) {
 	delete(a.clients, client.id)
 	a.clientsMu.Unlock()
 
-	roomID, role, info, wasHost := a.rooms.RemoveSocket(client.id)
+	if client.userID != 0 && a.presence.disconnect(client.userID, client.id) {
+		a.broadcastPresence(client, "friend:offline", nil)
+	}
+
+	a.resyncs.forget(client.id)
+	a.bus.publishLeave(client.id)
+	if closed := a.leaveRoom(client.id, a.send); closed != "" {
+		a.closeRoom(closed)
+	}
+}
+
+// closeRoom forgets a room this instance closed and reports it to webhooks.
+// Peers learn of the close over the bus and only forget it, so the webhook
+// fires once.
+func (a *App) closeRoom(roomID string) {
+	a.forgetRoom(roomID)
+	a.webhooks.emit(webhookRoomClosed, map[string]string{"roomId": roomID})
+}
+
+// forgetRoom drops what this instance keeps in memory for a closed room.
+func (a *App) forgetRoom(roomID string) {
+	a.gameLog.forget(roomID)
+	a.reveals.forget(roomID)
+	a.scries.forget(roomID)
+	a.stacks.forget(roomID)
+	a.lobbies.forget(roomID)
+	a.idle.forget(roomID)
+	a.deltas.forget(roomID)
+}
+
+// leaveRoom removes a departed socket from its room and tells the others,
+// delivering through send. It returns the room's id when the socket was its
+// host, which closes the room.
+func (a *App) leaveRoom(socketID string, send func(string, WSMessage)) string {
+	clientIDs := a.rooms.ClientSocketIDs(a.rooms.roomOf(socketID))
+	roomID, role, info, wasHost := a.rooms.RemoveSocket(socketID)
 	if roomID == "" {
-		return
+		return ""
 	}
 	if wasHost {
-		a.broadcastToRoom(roomID, a.rooms.ClientSocketIDs(roomID), WSMessage{
-			Type:    "room:closed",
-			Payload: marshalPayload(ErrorPayload{Message: "Host disconnected"}),
-		})
-		return
+		for _, id := range clientIDs {
+			a.leavePresenceRoom(id)
+			send(id, WSMessage{
+				Type:    "room:closed",
+				Payload: marshalPayload(ErrorPayload{Code: codeHostDisconnected, Message: "Host disconnected"}),
+			})
+		}
+		return roomID
 	}
 	if role == "client" && info != nil {
 		hostID := a.rooms.HostSocket(roomID)
-		a.send(hostID, WSMessage{
+		send(hostID, WSMessage{
 			Type: "room:client_left",
 			Payload: marshalPayload(RoomClientLeftPayload{
 				RoomID:   roomID,
 				PlayerID: info.PlayerID,
-				SocketID: client.id,
+				SocketID: socketID,
+				Seat:     info.Seat,
 			}),
 		})
+		a.broadcastSeats(roomID, send)
+	}
+	return ""
+}
+
+// createRoom opens a room with the client as its host and sends them
+// room:created.
+func (a *App) createRoom(client *WSClient, payload RoomCreatePayload) {
+	if !a.admitToRoom(client, true) {
+		return
+	}
+	if err := a.rooms.Create(payload.RoomID, payload, client.id); err != nil {
+		a.sendError(client.id, roomErrorCode(err), err.Error())
+		return
 	}
+	host, _ := a.rooms.Member(payload.RoomID, client.id)
+	a.bus.publishRoom(payload.RoomID, client.id)
+	a.enterPresenceRoom(client, payload.RoomID)
+	a.recordParticipant(client, payload.RoomID, payload.PlayerID, payload.PlayerName, "host")
+	a.webhooks.emit(webhookRoomCreated, map[string]interface{}{
+		"roomId":         payload.RoomID,
+		"hostPlayerName": payload.PlayerName,
+		"hasPassword":    payload.Password != "",
+	})
+	a.send(client.id, WSMessage{
+		Type: "room:created",
+		Payload: marshalPayload(a.withMedia(RoomClientJoinedPayload{
+			RoomID:     payload.RoomID,
+			PlayerID:   payload.PlayerID,
+			PlayerName: payload.PlayerName,
+			SocketID:   client.id,
+			Seat:       host.Seat,
+			Team:       host.Team,
+			TeamSize:   payload.TeamSize,
+			HouseRules: payload.HouseRules,
+			RoomToken:  a.roomTokens.issue(payload.RoomID, host),
+		}, client.userID)),
+	})
 }
 
 func (a *App) handleWSMessage(client *WSClient, message WSMessage) {
+	client.lastActive.Store(time.Now().UnixNano())
+	if limit := a.bodyLimits.forMessage(message.Type); limit > 0 && int64(len(message.Payload)) > limit {
+		a.sendErrorDetails(client.id, codePayloadTooLarge, message.Type+" payload is too large (limit "+strconv.FormatInt(limit, 10)+" bytes)",
+			map[string]interface{}{"type": message.Type, "limitBytes": limit})
+		return
+	}
 	switch message.Type {
 	case "room:create":
 		var payload RoomCreatePayload
 		if err := json.Unmarshal(message.Payload, &payload); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "invalid payload"})})
+			a.sendError(client.id, codeInvalidRequest, "invalid payload")
 			return
 		}
 		if payload.RoomID == "" {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "roomId is required"})})
+			a.sendError(client.id, codeInvalidRequest, "roomId is required")
 			return
 		}
 		if payload.PlayerID == "" {
@@ -363,27 +785,42 @@ func (a *App) handleWSMessage(client *WSClient, message WSMessage) {
 		if payload.PlayerName == "" {
 			payload.PlayerName = "Host"
 		}
-		if err := a.rooms.Create(payload.RoomID, payload, client.id); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: err.Error()})})
+		name, ok := a.words.apply(payload.PlayerName)
+		if !ok {
+			a.sendError(client.id, codeBlockedWord, blockedWordMessage("player name"))
 			return
 		}
-		a.send(client.id, WSMessage{
-			Type: "room:created",
-			Payload: marshalPayload(RoomClientJoinedPayload{
-				RoomID:     payload.RoomID,
-				PlayerID:   payload.PlayerID,
-				PlayerName: payload.PlayerName,
-				SocketID:   client.id,
-			}),
-		})
+		payload.PlayerName = name
+		if window := a.maintenance.current(); window.Active {
+			a.sendError(client.id, codeMaintenance, window.notice())
+			return
+		}
+		rules, problem := payload.HouseRules.normalize()
+		if problem != "" {
+			a.sendError(client.id, codeValidationFailed, problem)
+			return
+		}
+		payload.HouseRules = rules
+		if payload.GroupID != "" {
+			if _, err := a.groupRole(context.Background(), payload.GroupID, client.userID); err != nil {
+				a.sendError(client.id, codeForbidden, "only the group's members can create rooms for it")
+				return
+			}
+		}
+		payload.UserID = client.userID
+		a.createRoom(client, payload)
 	case "room:join":
 		var payload RoomJoinPayload
 		if err := json.Unmarshal(message.Payload, &payload); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "invalid payload"})})
+			a.sendError(client.id, codeInvalidRequest, "invalid payload")
 			return
 		}
 		if payload.RoomID == "" {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "roomId is required"})})
+			a.sendError(client.id, codeInvalidRequest, "roomId is required")
+			return
+		}
+		if payload.RoomToken != "" && !a.resumeRoomToken(client, &payload) {
+			a.sendError(client.id, codeRoomAccessRequired, "room token is invalid or expired")
 			return
 		}
 		if payload.PlayerID == "" {
@@ -392,37 +829,69 @@ func (a *App) handleWSMessage(client *WSClient, message WSMessage) {
 		if payload.PlayerName == "" {
 			payload.PlayerName = "Player"
 		}
+		name, ok := a.words.apply(payload.PlayerName)
+		if !ok {
+			a.sendError(client.id, codeBlockedWord, blockedWordMessage("player name"))
+			return
+		}
+		payload.PlayerName = name
+		payload.UserID = client.userID
+		game, invited := a.scheduledGameFor(payload.RoomID, client.userID)
+		payload.invited = invited
+		if !a.admitToRoom(client, false) {
+			return
+		}
 		if _, err := a.rooms.Join(payload.RoomID, payload, client.id); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: err.Error()})})
+			if invited && errors.Is(err, errRoomNotFound) {
+				// The first player to arrive opens a scheduled game's room.
+				if window := a.maintenance.current(); window.Active {
+					a.sendError(client.id, codeMaintenance, window.notice())
+					return
+				}
+				a.createRoom(client, RoomCreatePayload{
+					RoomID:     payload.RoomID,
+					Password:   game.password,
+					PlayerID:   payload.PlayerID,
+					PlayerName: payload.PlayerName,
+					Seat:       payload.Seat,
+					GroupID:    game.groupID,
+					UserID:     client.userID,
+				})
+				return
+			}
+			a.sendError(client.id, roomErrorCode(err), err.Error())
 			return
 		}
-		a.send(client.id, WSMessage{
-			Type: "room:joined",
-			Payload: marshalPayload(RoomClientJoinedPayload{
-				RoomID:     payload.RoomID,
-				PlayerID:   payload.PlayerID,
-				PlayerName: payload.PlayerName,
-				SocketID:   client.id,
-			}),
-		})
+		member, _ := a.rooms.Member(payload.RoomID, client.id)
+		client.acceptDeltas.Store(payload.AcceptDeltas)
+		a.bus.publishRoom(payload.RoomID, client.id)
+		a.enterPresenceRoom(client, payload.RoomID)
+		a.recordParticipant(client, payload.RoomID, payload.PlayerID, payload.PlayerName, "client")
+		joined := a.withMedia(RoomClientJoinedPayload{
+			RoomID:     payload.RoomID,
+			PlayerID:   payload.PlayerID,
+			PlayerName: payload.PlayerName,
+			SocketID:   client.id,
+			Seat:       member.Seat,
+			Team:       member.Team,
+			TeamSize:   a.rooms.TeamSize(payload.RoomID),
+			HouseRules: a.rooms.HouseRules(payload.RoomID),
+		}, client.userID)
+		self := joined
+		self.RoomToken = a.roomTokens.issue(payload.RoomID, member)
+		a.send(client.id, WSMessage{Type: "room:joined", Payload: marshalPayload(self)})
+		// The host relays the images to the rest of the table in its state.
 		hostID := a.rooms.HostSocket(payload.RoomID)
-		a.send(hostID, WSMessage{
-			Type: "room:client_joined",
-			Payload: marshalPayload(RoomClientJoinedPayload{
-				RoomID:     payload.RoomID,
-				PlayerID:   payload.PlayerID,
-				PlayerName: payload.PlayerName,
-				SocketID:   client.id,
-			}),
-		})
+		a.send(hostID, WSMessage{Type: "room:client_joined", Payload: marshalPayload(joined)})
+		a.broadcastSeats(payload.RoomID, a.send)
 	case "room:client_message":
 		var payload RoomClientMessagePayload
 		if err := json.Unmarshal(message.Payload, &payload); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "invalid payload"})})
+			a.sendError(client.id, codeInvalidRequest, "invalid payload")
 			return
 		}
 		if payload.RoomID == "" {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "roomId is required"})})
+			a.sendError(client.id, codeInvalidRequest, "roomId is required")
 			return
 		}
 		info, _ := a.rooms.ClientInfo(payload.RoomID, client.id)
@@ -440,41 +909,100 @@ func (a *App) handleWSMessage(client *WSClient, message WSMessage) {
 	case "room:host_message":
 		var payload RoomHostMessagePayload
 		if err := json.Unmarshal(message.Payload, &payload); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "invalid payload"})})
+			a.sendError(client.id, codeInvalidRequest, "invalid payload")
 			return
 		}
 		if payload.RoomID == "" {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "roomId is required"})})
+			a.sendError(client.id, codeInvalidRequest, "roomId is required")
 			return
 		}
 		if payload.TargetSocketID != "" {
+			a.resyncs.settle(payload.TargetSocketID, payload.RoomID)
+			if messageType := hostMessageType(payload.Message); messageType != "" {
+				a.deltas.diverge(payload.RoomID, messageType, payload.TargetSocketID)
+			}
 			a.send(payload.TargetSocketID, WSMessage{
 				Type:    "room:host_message",
 				Payload: marshalPayload(payload.Message),
 			})
 			return
 		}
-		clients := a.rooms.ClientSocketIDs(payload.RoomID)
-		a.broadcastToRoom(payload.RoomID, clients, WSMessage{
-			Type:    "room:host_message",
-			Payload: marshalPayload(payload.Message),
-		})
+		a.broadcastHostMessage(payload.RoomID, a.rooms.ClientSocketIDs(payload.RoomID), payload.Message)
 	case "room:save_event":
 		var payload RoomEventPayload
 		if err := json.Unmarshal(message.Payload, &payload); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "invalid payload"})})
+			a.sendError(client.id, codeInvalidRequest, "invalid payload")
 			return
 		}
 		if payload.RoomID == "" || strings.TrimSpace(payload.EventType) == "" || payload.EventData == nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "roomId, eventType, and eventData are required"})})
+			a.sendError(client.id, codeInvalidRequest, "roomId, eventType, and eventData are required")
+			return
+		}
+		// Events are attributed to the socket's own membership so a player
+		// cannot record actions under someone else's name.
+		member, ok := a.rooms.Member(payload.RoomID, client.id)
+		if !ok {
+			a.sendError(client.id, codeNotInRoom, "not in room")
 			return
 		}
+		payload.PlayerID = member.PlayerID
+		payload.PlayerName = member.PlayerName
+		payload.UserID = member.UserID
 		if err := a.storeRoomEvent(payload); err != nil {
-			a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "failed to save event"})})
+			var invalid *eventValidationError
+			if errors.As(err, &invalid) {
+				a.sendErrorDetails(client.id, codeInvalidEvent, "invalid event", invalid.Details)
+				return
+			}
+			a.sendError(client.id, codeInternal, "failed to save event")
 			return
 		}
+	case "room:undo":
+		a.handleRoomUndo(client, message.Payload, false)
+	case "room:redo":
+		a.handleRoomUndo(client, message.Payload, true)
+	case "room:reveal":
+		a.handleRoomReveal(client, message.Payload)
+	case "room:reveal_end":
+		a.handleRoomRevealEnd(client, message.Payload)
+	case "room:peek_request":
+		a.handlePeekRequest(client, message.Payload)
+	case "room:peek_decline":
+		a.handlePeekDecline(client, message.Payload)
+	case "room:scry":
+		a.handleRoomScry(client, message.Payload)
+	case "room:scry_resolve":
+		a.handleRoomScryResolve(client, message.Payload)
+	case "room:commander_damage":
+		a.handleCommanderDamage(client, message.Payload)
+	case "room:team_life":
+		a.handleTeamLife(client, message.Payload)
+	case "room:seat_change", "room:turn_order", "room:seat_message":
+		a.handleSeatMessage(client, message.Type, message.Payload)
+	case "room:stack_push", "room:stack_resolve", "room:priority_pass", "room:priority_set":
+		a.handleStackMessage(client, message.Type, message.Payload)
+	case "room:supplemental_deck", "room:planeswalk", "room:scheme":
+		a.handleSupplementalMessage(client, message.Type, message.Payload)
+	case "room:deck_select", "room:game_start", "room:rematch":
+		a.handleLobbyMessage(client, message.Type, message.Payload)
+	case "room:mulligan", "room:mulligan_keep":
+		a.handleMulligan(client, message.Type, message.Payload)
+	case "room:card_counter":
+		a.handleCardCounter(client, message.Payload)
+	case "room:create_custom_card":
+		a.handleCreateCustomCard(client, message.Payload)
+	case "room:token":
+		a.handleRoomTokenRefresh(client, message.Payload)
+	case "room:house_rules":
+		a.handleHouseRules(client, message.Payload)
+	case "room:emote":
+		a.handleRoomEmote(client, message.Payload)
+	case "room:idle_settings":
+		a.handleIdleSettings(client, message.Payload)
+	case "room:request_state":
+		a.handleRequestState(client, message.Payload)
 	default:
-		a.send(client.id, WSMessage{Type: "room:error", Payload: marshalPayload(ErrorPayload{Message: "unknown message"})})
+		a.sendError(client.id, codeUnknownMessage, "unknown message")
 	}
 }
 
@@ -482,19 +1010,32 @@ func (a *App) send(socketID string, message WSMessage) {
 	if socketID == "" {
 		return
 	}
+	if !a.sendLocal(socketID, message) {
+		a.bus.forward(socketID, message)
+	}
+}
+
+// sendLocal writes to a socket held by this instance, reporting false when
+// there is no such socket.
+func (a *App) sendLocal(socketID string, message WSMessage) bool {
 	a.clientsMu.RLock()
 	client := a.clients[socketID]
 	a.clientsMu.RUnlock()
 	if client == nil {
-		return
+		return false
 	}
 	payload, err := json.Marshal(message)
 	if err != nil {
-		return
+		return true
 	}
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	if client.stream != nil {
+		client.stream.push(payload)
+		return true
+	}
 	_ = client.conn.WriteMessage(websocket.TextMessage, payload)
+	return true
 }
 
 func (a *App) broadcastToRoom(_ string, socketIDs []string, message WSMessage) {
@@ -503,6 +1044,25 @@ func (a *App) broadcastToRoom(_ string, socketIDs []string, message WSMessage) {
 	}
 }
 
+// broadcastAll sends a message to every connected client, on this instance
+// and on its peers.
+func (a *App) broadcastAll(message WSMessage) {
+	a.broadcastLocal(message)
+	a.bus.publishBroadcast(message)
+}
+
+func (a *App) broadcastLocal(message WSMessage) {
+	a.clientsMu.RLock()
+	socketIDs := make([]string, 0, len(a.clients))
+	for id := range a.clients {
+		socketIDs = append(socketIDs, id)
+	}
+	a.clientsMu.RUnlock()
+	for _, id := range socketIDs {
+		a.sendLocal(id, message)
+	}
+}
+
 func marshalPayload(payload interface{}) json.RawMessage {
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -512,87 +1072,240 @@ func marshalPayload(payload interface{}) json.RawMessage {
 }
 
 func (a *App) registerRoutes() {
-	r := a.router
+	a.router.Get("/health", a.handleHealthz)
+	a.router.Get("/healthz", a.handleHealthz)
+	a.router.Get("/readyz", a.handleReadyz)
+	a.router.Get("/metrics", a.handleMetrics)
+	a.router.Get("/api/openapi.json", a.handleOpenAPI)
+	a.router.Get("/api/docs", a.handleAPIDocs)
+	a.router.Route(apiV1Prefix, a.registerAPIRoutes)
+}
 
-	r.Get("/health", a.handleHealth)
+func (a *App) registerAPIRoutes(r chi.Router) {
+	r.HandleFunc("/ws", a.handleWS)
 
 	r.Post("/register", a.handleRegister)
 	r.Post("/login", a.handleLogin)
 	r.Post("/logout", a.requireAuth(a.handleLogout))
 	r.Get("/me", a.optionalAuth(a.handleMe))
+	r.Get("/auth/{provider}", a.handleOAuthStart)
+	r.Get("/auth/{provider}/callback", a.handleOAuthCallback)
+	r.Get("/maintenance", a.handleMaintenance)
+	r.Get("/me/settings", a.requireAuth(a.handleSettings))
+	r.Put("/me/media/{kind}", a.requireAuth(a.handleUploadMedia))
+	r.Delete("/me/media/{kind}", a.requireAuth(a.handleDeleteMedia))
+	r.Get("/me/tokens", a.requireAuth(a.handleAPITokens))
+	r.Post("/me/tokens", a.requireAuth(a.handleCreateAPIToken))
+	r.Delete("/me/tokens/{tokenId}", a.requireAuth(a.handleRevokeAPIToken))
+	r.Get("/me/notifications", a.requireAuth(a.handleNotifications))
+	r.Post("/me/notifications/read", a.requireAuth(a.handleMarkNotificationsRead))
+	r.Delete("/me/notifications/{notificationId}", a.requireAuth(a.handleDeleteNotification))
+	r.Get("/reports", a.requireAuth(a.handleReports))
+	r.Post("/reports", a.requireAuth(a.handleCreateReport))
+	r.Get("/me/matches", a.requireAuth(a.handleMatchHistory))
+	r.Get("/me/sessions", a.requireAuth(a.handleSessions))
+	r.Delete("/me/sessions", a.requireAuth(a.handleRevokeOtherSessions))
+	r.Delete("/me/sessions/{sessionId}", a.requireAuth(a.handleRevokeSession))
+
+	r.Get("/groups", a.requireAuth(a.handleGroups))
+	r.Post("/groups", a.requireAuth(a.handleCreateGroup))
+	r.Post("/groups/join", a.requireAuth(a.handleJoinGroup))
+	r.Get("/groups/{groupId}", a.requireAuth(a.handleGroup))
+	r.Put("/groups/{groupId}", a.requireAuth(a.handleUpdateGroup))
+	r.Delete("/groups/{groupId}", a.requireAuth(a.handleDeleteGroup))
+	r.Post("/groups/{groupId}/invite", a.requireAuth(a.handleResetGroupInvite))
+	r.Delete("/groups/{groupId}/members/{userId}", a.requireAuth(a.handleRemoveGroupMember))
+	r.Get("/groups/{groupId}/decks", a.requireAuth(a.handleGroupDecks))
+	r.Put("/groups/{groupId}/decks/{deckId}", a.requireAuth(a.handleShareGroupDeck))
+	r.Delete("/groups/{groupId}/decks/{deckId}", a.requireAuth(a.handleUnshareGroupDeck))
+	r.Get("/groups/{groupId}/rooms", a.requireAuth(a.handleGroupRooms))
+	r.Get("/groups/{groupId}/events", a.requireAuth(a.handleGroupEvents))
+	r.Post("/groups/{groupId}/events", a.requireAuth(a.handleCreateGroupEvent))
+	r.Get("/groups/{groupId}/events/{eventId}", a.requireAuth(a.handleGroupEvent))
+	r.Delete("/groups/{groupId}/events/{eventId}", a.requireAuth(a.handleDeleteGroupEvent))
+	r.Put("/groups/{groupId}/events/{eventId}/rsvp", a.requireAuth(a.handleGroupEventRSVP))
+
+	r.Get("/friends", a.requireAuth(a.handleFriends))
+	r.Post("/friends/requests", a.requireAuth(a.handleFriendRequest))
+	r.Post("/friends/requests/{userId}/accept", a.requireAuth(a.handleAcceptFriend))
+	r.Delete("/friends/{userId}", a.requireAuth(a.handleRemoveFriend))
+	r.Post("/friends/{userId}/invite", a.requireAuth(a.handleRoomInvite))
 
 	r.Get("/decks", a.requireAuth(a.handleDecks))
-	r.Get("/decks/public", a.handlePublicDecks)
+	r.Get("/decks/public", a.optionalAuth(a.handlePublicDecks))
+	r.Get("/decks/public/facets", a.handlePublicDeckFacets)
+	r.Get("/decks/search", a.handleSearchDecks)
+	r.Get("/decks/precons", a.handlePreconDecks)
+	r.Post("/decks/precons/{id}/copy", a.requireAuth(a.handleCopyPreconDeck))
+	r.Get("/decks/folders", a.requireAuth(a.handleDeckFolders))
+	r.Post("/decks/folders", a.requireAuth(a.handleCreateDeckFolder))
+	r.Post("/decks/folders/reorder", a.requireAuth(a.handleReorderDeckFolders))
+	r.Put("/decks/folders/{folderId}", a.requireAuth(a.handleRenameDeckFolder))
+	r.Delete("/decks/folders/{folderId}", a.requireAuth(a.handleDeleteDeckFolder))
+	r.Post("/decks/reorder", a.requireAuth(a.handleReorderDecks))
+	r.Get("/decks/shared/{shareToken}", a.handleSharedDeck)
+	r.Post("/decks/shared/{shareToken}/copy", a.requireAuth(a.handleCopySharedDeck))
 	r.Post("/decks", a.requireAuth(a.handleCreateDeck))
+	r.Post("/decks/bulk", a.requireAuth(a.handleBulkCreateDecks))
+	r.Post("/decks/parse", a.handleParseDecklist)
+	r.Put("/decks/{id}", a.requireAuth(a.handleUpdateDeck))
 	r.Delete("/decks/{id}", a.requireAuth(a.handleDeleteDeck))
+	r.Post("/decks/{id}/copy", a.requireAuth(a.handleCopyDeck))
+	r.Post("/decks/{id}/move", a.requireAuth(a.handleMoveDeck))
+	r.Post("/decks/{id}/precon", a.requireAuth(a.handleMarkPrecon))
+	r.Delete("/decks/{id}/precon", a.requireAuth(a.handleUnmarkPrecon))
+	r.Post("/decks/{id}/like", a.requireAuth(a.handleLikeDeck))
+	r.Delete("/decks/{id}/like", a.requireAuth(a.handleUnlikeDeck))
+	r.Get("/decks/{id}/export", a.optionalAuth(a.handleExportDeck))
+	r.Get("/decks/{id}/sample-hand", a.optionalAuth(a.handleSampleHand))
+	r.Get("/decks/{id}/comments", a.optionalAuth(a.handleDeckComments))
+	r.Post("/decks/{id}/comments", a.requireAuth(a.handleCreateDeckComment))
+	r.Delete("/decks/{id}/comments/{commentId}", a.requireAuth(a.handleDeleteDeckComment))
+	r.Get("/decks/{id}/revisions", a.requireAuth(a.handleDeckRevisions))
+	r.Post("/decks/{id}/revert/{revision}", a.requireAuth(a.handleRevertDeck))
+
+	r.Get("/formats/{format}/banlist", a.handleFormatBanlist)
 
 	r.Get("/cards/search", a.handleCardSearch)
 	r.Get("/cards/prints", a.handleCardPrints)
+	r.Get("/cards/supplemental", a.handleSupplementalCards)
 	r.Get("/cards/{setCode}/{collectorNumber}", a.handleCardCollector)
 	r.Post("/cards/batch", a.handleCardsBatch)
 
-	r.Get("/config/ui", a.handleGetUIConfig)
-	r.Post("/config/ui", a.requireAuth(a.handleUpdateUIConfig))
-
-	r.Post("/api/rooms/{roomId}/state", a.handleSaveRoomState)
-	r.Get("/api/rooms/{roomId}/state", a.handleLoadRoomState)
-	r.Post("/api/rooms/{roomId}/events", a.handleSaveRoomEvent)
-	r.Get("/api/rooms/{roomId}/events", a.handleLoadRoomEvents)
-}
+	r.Get("/admin/users", a.requireAdmin(a.handleAdminUsers))
+	r.Put("/admin/users/{userId}/role", a.requireAdmin(a.handleAdminSetRole))
+	r.Post("/admin/users/{userId}/suspend", a.requireAdmin(a.handleAdminSuspendUser))
+	r.Post("/admin/users/{userId}/ban", a.requireAdmin(a.handleAdminBanUser))
+	r.Post("/admin/users/{userId}/reinstate", a.requireAdmin(a.handleAdminReinstateUser))
+	r.Get("/admin/audit", a.requireAdmin(a.handleAdminAudit))
+	r.Get("/admin/reports", a.requireAdmin(a.handleAdminReports))
+	r.Put("/admin/maintenance", a.requireAdmin(a.handleAdminSetMaintenance))
+	r.Post("/admin/reports/{reportId}/resolve", a.requireAdmin(a.handleAdminResolveReport))
+	r.Get("/admin/rooms", a.requireAdmin(a.handleAdminRooms))
+	r.Get("/admin/capacity", a.requireAdmin(a.handleAdminCapacity))
+	r.Get("/admin/rooms/{roomId}", a.requireAdmin(a.handleAdminRoom))
+	r.Post("/admin/rooms/{roomId}/close", a.requireAdmin(a.handleAdminCloseRoom))
+	r.Get("/admin/results", a.requireAdmin(a.handleAdminResults))
+	r.Get("/admin/activity", a.requireAdmin(a.handleAdminActivity))
+	r.Post("/admin/rooms/prune", a.requireAdmin(a.handleAdminPruneRooms))
+	r.Post("/admin/cards/reload", a.requireAdmin(a.handleAdminReloadCards))
+	r.Post("/admin/backup", a.requireAdmin(a.handleAdminBackup))
+	r.Post("/admin/decks/{id}/takedown", a.requireAdmin(a.handleAdminTakedownDeck))
+	r.Put("/admin/formats/{format}/banlist", a.requireAdmin(a.handleAdminSetFormatBan))
+	r.Delete("/admin/formats/{format}/banlist", a.requireAdmin(a.handleAdminDeleteFormatBan))
+	r.Get("/admin/webhooks", a.requireAdmin(a.handleAdminWebhooks))
+	r.Post("/admin/webhooks", a.requireAdmin(a.handleCreateWebhook))
+	r.Delete("/admin/webhooks/{webhookId}", a.requireAdmin(a.handleDeleteWebhook))
+	r.Post("/admin/webhooks/{webhookId}/test", a.requireAdmin(a.handleTestWebhook))
 
-func (a *App) handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{
-		"status":    "ok",
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-	})
+	r.Get("/config/ui", a.handleGetUIConfig)
+	r.Post("/config/ui", a.requireAdmin(a.handleUpdateUIConfig))
+
+	r.Get("/rooms/emotes", a.handleEmotes)
+	r.Post("/rooms/{roomId}/state", a.requireRoomToken(a.handleSaveRoomState))
+	r.Get("/rooms/{roomId}/state", a.requireRoomAccess(a.handleLoadRoomState))
+	r.Patch("/rooms/{roomId}/state", a.requireRoomToken(a.handlePatchRoomState))
+	r.Post("/rooms/{roomId}/events", a.requireRoomToken(a.handleSaveRoomEvent))
+	r.Get("/rooms/{roomId}/events", a.requireRoomAccess(a.handleLoadRoomEvents))
+	r.Get("/rooms/{roomId}/log", a.requireRoomAccess(a.handleRoomLog))
+	r.Get("/rooms/{roomId}/log/export", a.requireRoomAccess(a.handleExportRoomLog))
+	r.Get("/rooms/{roomId}/stack", a.requireRoomAccess(a.handleRoomStack))
+	r.Get("/rooms/{roomId}/replay", a.requireRoomAccess(a.handleRoomReplay))
+	r.Get("/rooms/{roomId}/stream", a.handleRoomStream)
+	r.Post("/rooms/{roomId}/stream", a.handleRoomStreamSend)
+	r.Post("/rooms/{roomId}/goldfish", a.requireRoomAccess(a.handleAddGoldfish))
+	r.Delete("/rooms/{roomId}/goldfish/{socketId}", a.requireRoomAccess(a.handleRemoveGoldfish))
+	r.Get("/media/{name}", a.handleMedia)
+	r.Get("/stats/rooms", a.handleRoomStats)
+	r.Get("/stats/cards", a.handleCardStats)
+	// A replay is identified by the id of the room it was recorded in.
+	r.Get("/replays/{roomId}/at", a.requireRoomAccess(a.handleReplayAt))
+	r.Post("/replays/{roomId}/verify", a.requireRoomAccess(a.handleVerifyReplay))
+	r.Get("/replays/{roomId}/exports", a.requireRoomAccess(a.handleReplayExports))
+	r.Post("/replays/{roomId}/exports", a.requireRoomAccess(a.handleCreateReplayExport))
+	r.Get("/replays/{roomId}/exports/{name}", a.requireRoomAccess(a.handleReplayExport))
 }
 
 func (a *App) handleGetUIConfig(w http.ResponseWriter, r *http.Request) {
-	row := a.db.QueryRow(`SELECT payload FROM ui_configs WHERE name = 'default'`)
+	row := a.db.QueryRowContext(r.Context(), `SELECT payload, version FROM ui_configs WHERE name = 'default'`)
 	var payload string
-	if err := row.Scan(&payload); err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "ui config not found"})
+	var version int64
+	if err := row.Scan(&payload, &version); err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "ui config not found")
+		return
+	}
+	etag := uiConfigETag(version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write([]byte(payload))
 }
 
+// handleUpdateUIConfig saves the config and tells every connected client, so
+// open tables reload their menus without a page refresh. Since the change
+// reaches everyone, only admins may make it.
 func (a *App) handleUpdateUIConfig(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+		writeBodyError(w, err, "invalid body")
 		return
 	}
 	if !json.Valid(body) {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid json")
+		return
+	}
+	if details := validateUIConfig(body); len(details) > 0 {
+		writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, "invalid ui config", details)
 		return
 	}
-	if _, err := a.db.Exec(`
+	var version int64
+	if err := a.db.QueryRowContext(r.Context(), `
 		INSERT INTO ui_configs (name, payload, updated_at)
 		VALUES ('default', ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(name) DO UPDATE SET
 			payload = excluded.payload,
+			version = ui_configs.version + 1,
 			updated_at = CURRENT_TIMESTAMP
-	`, string(body)); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save ui config"})
+		RETURNING version
+	`, string(body)).Scan(&version); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "failed to save ui config")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+	etag := uiConfigETag(version)
+	a.broadcastAll(WSMessage{Type: "config:updated", Payload: marshalPayload(map[string]interface{}{
+		"name":    "default",
+		"version": version,
+		"etag":    etag,
+	})})
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "version": version})
 }
 
 type authContextKey struct{}
 
 type User struct {
-	ID       int64  `json:"id"`
-	Username string `json:"username"`
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"-"`
 }
 
 func (a *App) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := a.userFromRequest(r)
+		if errors.Is(err, errTokenScope) {
+			writeError(w, http.StatusForbidden, codeInsufficientScope, err.Error())
+			return
+		}
 		if err != nil {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, err.Error())
+			return
+		}
+		if !a.allowUser(w, r, user) {
 			return
 		}
 		ctx := context.WithValue(r.Context(), authContextKey{}, user)
@@ -603,21 +1316,33 @@ func (a *App) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 func (a *App) optionalAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, _ := a.userFromRequest(r)
+		if !a.allowUser(w, r, user) {
+			return
+		}
 		ctx := context.WithValue(r.Context(), authContextKey{}, user)
 		next(w, r.WithContext(ctx))
 	}
 }
 
 func (a *App) userFromRequest(r *http.Request) (*User, error) {
+	if token := bearerToken(r); token != "" {
+		return a.userFromAPIToken(r, token)
+	}
 	cookie, err := r.Cookie(cookieName)
 	if err != nil || cookie.Value == "" {
 		return nil, errors.New("Not authenticated")
 	}
 	var user User
-	row := a.db.QueryRow(`SELECT id, username FROM users WHERE session_id = ?`, cookie.Value)
-	if err := row.Scan(&user.ID, &user.Username); err != nil {
+	row := a.db.QueryRowContext(r.Context(), `
+		SELECT u.id, u.username, u.role, s.id
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.token_hash = ? AND s.expires_at > CURRENT_TIMESTAMP AND `+activeAccountClause+`
+	`, hashToken(cookie.Value))
+	if err := row.Scan(&user.ID, &user.Username, &user.Role, &user.SessionID); err != nil {
 		return nil, errors.New("Invalid session")
 	}
+	a.markActive(r.Context(), user.ID)
 	return &user, nil
 }
 
@@ -634,41 +1359,56 @@ type authPayload struct {
 func (a *App) handleRegister(w http.ResponseWriter, r *http.Request) {
 	var payload authPayload
 	if err := decodeJSON(r, &payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	if wait := a.loginLimit.allow(loginLimiterKeys(r, "")...); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+	if a.refuseDuringMaintenance(w) {
 		return
 	}
 	if strings.TrimSpace(payload.Username) == "" || strings.TrimSpace(payload.Password) == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Username and password are required"})
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Username and password are required")
 		return
 	}
 	if len(payload.Username) < 3 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Username must be at least 3 characters"})
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Username must be at least 3 characters")
+		return
+	}
+	if a.words.blocked(payload.Username) {
+		writeError(w, http.StatusBadRequest, codeBlockedWord, blockedWordMessage("Username"))
 		return
 	}
 	if len(payload.Password) < 4 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Password must be at least 4 characters"})
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Password must be at least 4 characters")
 		return
 	}
-	sessionID := randomID(32)
 	passwordHash := hashPassword(payload.Password)
-	result, err := a.db.Exec(`
-		INSERT INTO users (username, password_hash, session_id)
+	role := initialRole(payload.Username)
+	result, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO users (username, password_hash, role)
 		VALUES (?, ?, ?)
-	`, payload.Username, passwordHash, sessionID)
+	`, payload.Username, passwordHash, role)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Username already exists"})
+			writeError(w, http.StatusBadRequest, codeUsernameTaken, "Username already exists")
 			return
 		}
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Registration failed"})
+		writeError(w, http.StatusInternalServerError, codeInternal, "Registration failed")
 		return
 	}
 	userID, _ := result.LastInsertId()
-	setSessionCookie(w, sessionID)
+	if err := a.startSession(w, r, userID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Registration failed")
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"user": map[string]interface{}{
 			"id":       userID,
 			"username": payload.Username,
+			"role":     role,
 		},
 	})
 }
@@ -676,26 +1416,40 @@ func (a *App) handleRegister(w http.ResponseWriter, r *http.Request) {
 func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var payload authPayload
 	if err := decodeJSON(r, &payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		writeBodyError(w, err, "Invalid request")
 		return
 	}
 	if strings.TrimSpace(payload.Username) == "" || strings.TrimSpace(payload.Password) == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Username and password are required"})
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Username and password are required")
+		return
+	}
+	limiterKeys := loginLimiterKeys(r, payload.Username)
+	if wait := a.loginLimit.allow(limiterKeys...); wait > 0 {
+		writeTooManyAttempts(w, wait)
 		return
 	}
 	passwordHash := hashPassword(payload.Password)
 	var user User
-	row := a.db.QueryRow(`SELECT id, username FROM users WHERE username = ? AND password_hash = ?`, payload.Username, passwordHash)
-	if err := row.Scan(&user.ID, &user.Username); err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
+	row := a.db.QueryRowContext(r.Context(), `SELECT id, username, role FROM users WHERE username = ? AND password_hash = ?`, payload.Username, passwordHash)
+	if err := row.Scan(&user.ID, &user.Username, &user.Role); err != nil {
+		a.loginLimit.recordFailure(limiterKeys...)
+		writeError(w, http.StatusUnauthorized, codeInvalidCredentials, "Invalid credentials")
+		return
+	}
+	// Only the username is cleared so a valid login cannot reset an IP that
+	// is guessing passwords for other accounts.
+	a.loginLimit.recordSuccess(limiterKeys[1:]...)
+	if restriction := a.accountRestriction(r.Context(), user.ID); restriction != nil {
+		writeAccountRestricted(w, restriction)
+		return
+	}
+	if a.refuseLoginDuringMaintenance(w, r, user.ID) {
 		return
 	}
-	sessionID := randomID(32)
-	if _, err := a.db.Exec(`UPDATE users SET session_id = ? WHERE id = ?`, sessionID, user.ID); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Login failed"})
+	if err := a.startSession(w, r, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Login failed")
 		return
 	}
-	setSessionCookie(w, sessionID)
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"user": user,
 	})
@@ -704,74 +1458,83 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	user := a.currentUser(r)
 	if user == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Not authenticated"})
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
 		return
 	}
-	_, _ = a.db.Exec(`UPDATE users SET session_id = NULL WHERE id = ?`, user.ID)
-	http.SetCookie(w, &http.Cookie{
+	_, _ = a.db.ExecContext(r.Context(), `DELETE FROM sessions WHERE id = ?`, user.SessionID)
+	a.setCookie(w, r, &http.Cookie{
 		Name:     cookieName,
 		Value:    "",
 		MaxAge:   -1,
 		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
 		Path:     "/",
 	})
+	a.clearCSRFCookie(w, r)
 	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
 func (a *App) handleMe(w http.ResponseWriter, r *http.Request) {
 	user := a.currentUser(r)
 	if user == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Not authenticated"})
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
 		return
 	}
+	var avatarURL sql.NullString
+	_ = a.db.QueryRowContext(r.Context(), `SELECT avatar_url FROM users WHERE id = ?`, user.ID).Scan(&avatarURL)
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"user": user,
+		"user": map[string]interface{}{
+			"id":        user.ID,
+			"username":  user.Username,
+			"role":      user.Role,
+			"avatarUrl": nullStringToPtr(avatarURL),
+		},
 	})
 }
 
 type deckRow struct {
-	ID        string
-	Name      string
-	RawText   string
-	Entries   string
-	IsPublic  int
-	CreatedAt string
+	ID               string
+	Name             string
+	RawText          string
+	Entries          string
+	IsPublic         int
+	CreatedAt        string
+	ForkedFrom       sql.NullString
+	ForkedFromName   sql.NullString
+	ForkedFromAuthor sql.NullString
+	Format           sql.NullString
+	ShareToken       sql.NullString
+	FolderID         sql.NullString
+	Position         int
+	CoverCard        sql.NullString
+	CoverImageURL    sql.NullString
 }
 
 func (a *App) handleDecks(w http.ResponseWriter, r *http.Request) {
 	user := a.currentUser(r)
 	if user == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Not authenticated"})
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
 		return
 	}
-	rows, err := a.db.Query(`
-		SELECT id, name, raw_text, entries, is_public, created_at
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT `+deckColumns+`
 		FROM decks
 		WHERE user_id = ?
-		ORDER BY created_at DESC
+		ORDER BY position ASC, created_at DESC
 	`, user.ID)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load decks"})
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load decks")
 		return
 	}
 	defer rows.Close()
 	decks := make([]map[string]interface{}, 0)
 	for rows.Next() {
-		var row deckRow
-		if err := rows.Scan(&row.ID, &row.Name, &row.RawText, &row.Entries, &row.IsPublic, &row.CreatedAt); err != nil {
+		row, err := scanDeckRow(rows)
+		if err != nil {
 			continue
 		}
-		deck := map[string]interface{}{
-			"id":        row.ID,
-			"name":      row.Name,
-			"rawText":   row.RawText,
-			"entries":   json.RawMessage(row.Entries),
-			"isPublic":  row.IsPublic == 1,
-			"createdAt": row.CreatedAt,
-		}
-		decks = append(decks, deck)
+		decks = append(decks, deckRowToMap(row))
 	}
+	a.attachDeckTags(r.Context(), decks)
 	writeJSON(w, http.StatusOK, decks)
 }
 
@@ -781,100 +1544,202 @@ func (a *App) handlePublicDecks(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
-	rows, err := a.db.Query(`
-		SELECT d.id, d.name, d.raw_text, d.entries, d.created_at, u.username as author
+	orderBy := "d.created_at DESC"
+	switch r.URL.Query().Get("sort") {
+	case "", "recent":
+	case "popular":
+		orderBy = "likes DESC, d.created_at DESC"
+	default:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "sort must be popular or recent")
+		return
+	}
+	var viewerID int64
+	if user := a.currentUser(r); user != nil {
+		viewerID = user.ID
+	}
+	where, filterArgs := publicDeckFilters(r)
+	args := append([]interface{}{viewerID}, filterArgs...)
+	args = append(args, limit, offset)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT d.id, d.name, d.raw_text, d.entries, d.created_at, u.username as author, d.format, d.cover_card, d.cover_image_url,
+			(SELECT COUNT(*) FROM deck_likes l WHERE l.deck_id = d.id) as likes,
+			EXISTS(SELECT 1 FROM deck_likes l WHERE l.deck_id = d.id AND l.user_id = ?) as liked
 		FROM decks d
 		JOIN users u ON d.user_id = u.id
-		WHERE d.is_public = 1
-		ORDER BY d.created_at DESC
+		WHERE `+where+`
+		ORDER BY `+orderBy+`
 		LIMIT ? OFFSET ?
-	`, limit, offset)
+	`, args...)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load decks"})
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load decks")
 		return
 	}
 	defer rows.Close()
 	decks := make([]map[string]interface{}, 0)
 	for rows.Next() {
 		var id, name, rawText, entries, createdAt, author string
-		if err := rows.Scan(&id, &name, &rawText, &entries, &createdAt, &author); err != nil {
+		var format, coverCard, coverImageURL sql.NullString
+		var likes int
+		var liked bool
+		if err := rows.Scan(&id, &name, &rawText, &entries, &createdAt, &author, &format, &coverCard, &coverImageURL, &likes, &liked); err != nil {
 			continue
 		}
 		decks = append(decks, map[string]interface{}{
-			"id":        id,
-			"name":      name,
-			"rawText":   rawText,
-			"entries":   json.RawMessage(entries),
-			"createdAt": createdAt,
-			"author":    author,
+			"id":            id,
+			"name":          name,
+			"rawText":       rawText,
+			"entries":       json.RawMessage(entries),
+			"createdAt":     createdAt,
+			"author":        author,
+			"format":        nullStringToPtr(format),
+			"coverCard":     nullStringToPtr(coverCard),
+			"coverImageUrl": nullStringToPtr(coverImageURL),
+			"likes":         likes,
+			"liked":         liked,
 		})
 	}
+	a.attachDeckTags(r.Context(), decks)
 	writeJSON(w, http.StatusOK, decks)
 }
 
 type createDeckPayload struct {
-	Name     string          `json:"name"`
-	Entries  json.RawMessage `json:"entries"`
-	RawText  string          `json:"rawText"`
-	IsPublic bool            `json:"isPublic"`
+	Name       string          `json:"name"`
+	Entries    json.RawMessage `json:"entries"`
+	RawText    string          `json:"rawText"`
+	IsPublic   bool            `json:"isPublic"`
+	Visibility string          `json:"visibility"`
+	Format     string          `json:"format"`
+	Tags       []string        `json:"tags"`
+	CoverCard  string          `json:"coverCard"`
 }
 
 func (a *App) handleCreateDeck(w http.ResponseWriter, r *http.Request) {
 	user := a.currentUser(r)
 	if user == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Not authenticated"})
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
 		return
 	}
 	var payload createDeckPayload
 	if err := decodeJSON(r, &payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		writeBodyError(w, err, "Invalid request")
 		return
 	}
-	if strings.TrimSpace(payload.Name) == "" || payload.Entries == nil || strings.TrimSpace(payload.RawText) == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Name, entries, and rawText are required"})
+	row, tags, problem := a.prepareDeck(payload)
+	if problem != nil {
+		problem.write(w)
 		return
 	}
-	id := randomID(16)
-	isPublicInt := 0
-	if payload.IsPublic {
-		isPublicInt = 1
+	if a.checkDeckBans(w, r, row) {
+		return
 	}
-	if _, err := a.db.Exec(`
-		INSERT INTO decks (id, user_id, name, raw_text, entries, is_public)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, id, user.ID, payload.Name, payload.RawText, string(payload.Entries), isPublicInt); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save deck"})
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save deck")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"id":        id,
-		"name":      payload.Name,
-		"rawText":   payload.RawText,
-		"entries":   payload.Entries,
-		"isPublic":  payload.IsPublic,
-		"createdAt": time.Now().UTC().Format(time.RFC3339),
-	})
+	defer tx.Rollback()
+	if err := insertDeck(r.Context(), tx, user.ID, row, tags); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save deck")
+		return
+	}
+	if limitErr := a.checkDeckQuota(r.Context(), tx, user.ID); limitErr != nil {
+		limitErr.write(w)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save deck")
+		return
+	}
+	deck := deckRowToMap(row)
+	deck["tags"] = tags
+	writeJSON(w, http.StatusOK, deck)
+}
+
+// prepareDeck validates a new deck and returns the row and tags to insert.
+func (a *App) prepareDeck(payload createDeckPayload) (*deckRow, []string, *apiError) {
+	if strings.TrimSpace(payload.Name) == "" || payload.Entries == nil || strings.TrimSpace(payload.RawText) == "" {
+		return nil, nil, &apiError{http.StatusBadRequest, codeValidationFailed, "Name, entries, and rawText are required"}
+	}
+	entries, err := normalizeDeckEntries(payload.Entries)
+	if err != nil {
+		return nil, nil, &apiError{http.StatusBadRequest, codeValidationFailed, err.Error()}
+	}
+	payload.Entries = entries
+	name, problem := a.filterText("Deck name", payload.Name)
+	if problem != nil {
+		return nil, nil, problem
+	}
+	payload.Name = name
+	if limitErr := a.deckLimits.checkDeckSize(payload.RawText, payload.Entries); limitErr != nil {
+		return nil, nil, limitErr
+	}
+	format, err := normalizeDeckFormat(payload.Format)
+	if err != nil {
+		return nil, nil, &apiError{http.StatusBadRequest, codeValidationFailed, err.Error()}
+	}
+	tags, err := normalizeDeckTags(payload.Tags)
+	if err != nil {
+		return nil, nil, &apiError{http.StatusBadRequest, codeValidationFailed, err.Error()}
+	}
+	row := &deckRow{
+		ID:        randomID(16),
+		Name:      payload.Name,
+		RawText:   payload.RawText,
+		Entries:   string(payload.Entries),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Format:    sql.NullString{String: format, Valid: format != ""},
+	}
+	visibility := payload.Visibility
+	if visibility == "" && payload.IsPublic {
+		visibility = deckVisibilityPublic
+	}
+	if err := applyDeckVisibility(row, visibility); err != nil {
+		return nil, nil, &apiError{http.StatusBadRequest, codeValidationFailed, err.Error()}
+	}
+	if err := a.applyDeckCover(row, &payload.CoverCard); err != nil {
+		return nil, nil, &apiError{http.StatusBadRequest, codeValidationFailed, err.Error()}
+	}
+	return row, tags, nil
+}
+
+// insertDeck writes a prepared deck with its tags, card index and first
+// revision.
+func insertDeck(ctx context.Context, tx *sql.Tx, userID int64, row *deckRow, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO decks (id, user_id, name, raw_text, entries, is_public, format, share_token, cover_card, cover_image_url)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, row.ID, userID, row.Name, row.RawText, row.Entries, row.IsPublic, row.Format, row.ShareToken, row.CoverCard, row.CoverImageURL); err != nil {
+		return err
+	}
+	if err := setDeckTags(ctx, tx, row.ID, tags); err != nil {
+		return err
+	}
+	if err := indexDeckCards(ctx, tx, row.ID, row.Entries); err != nil {
+		return err
+	}
+	_, err := recordDeckRevision(ctx, tx, row.ID, row.Name, row.RawText, row.Entries)
+	return err
 }
 
 func (a *App) handleDeleteDeck(w http.ResponseWriter, r *http.Request) {
 	user := a.currentUser(r)
 	if user == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Not authenticated"})
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
 		return
 	}
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Deck id is required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Deck id is required")
 		return
 	}
-	result, err := a.db.Exec(`DELETE FROM decks WHERE id = ? AND user_id = ?`, id, user.ID)
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM decks WHERE id = ? AND user_id = ?`, id, user.ID)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete deck"})
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete deck")
 		return
 	}
 	changes, _ := result.RowsAffected()
 	if changes == 0 {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Deck not found"})
+		writeError(w, http.StatusNotFound, codeNotFound, "Deck not found")
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
@@ -893,6 +1758,8 @@ type cardRow struct {
 	SetCode         sql.NullString
 	CollectorNumber sql.NullString
 	PrintsSearchURI sql.NullString
+	ArtCropURL      sql.NullString
+	BorderCropURL   sql.NullString
 }
 
 type cardResponse struct {
@@ -906,6 +1773,7 @@ type cardResponse struct {
 	SetCode         *string `json:"setCode,omitempty"`
 	CollectorNumber *string `json:"collectorNumber,omitempty"`
 	PrintsSearchURI *string `json:"printsSearchUri,omitempty"`
+	crops           cardImageCrops
 }
 
 type cardPrintRow struct {
@@ -915,6 +1783,8 @@ type cardPrintRow struct {
 	SetName         sql.NullString
 	ImageURL        sql.NullString
 	BackImageURL    sql.NullString
+	ArtCropURL      sql.NullString
+	BorderCropURL   sql.NullString
 }
 
 type cardPrintResponse struct {
@@ -924,16 +1794,22 @@ type cardPrintResponse struct {
 	SetName         *string `json:"setName,omitempty"`
 	ImageURL        *string `json:"imageUrl,omitempty"`
 	BackImageURL    *string `json:"backImageUrl,omitempty"`
+	crops           cardImageCrops
 }
 
 func (a *App) handleCardSearch(w http.ResponseWriter, r *http.Request) {
 	if !a.ensureCardsAvailable() {
-		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Cards data not loaded. Ensure cards.json is available and restart the Go backend."})
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Cards data not loaded. Ensure cards.json is available and restart the Go backend.")
 		return
 	}
 	name := strings.TrimSpace(r.URL.Query().Get("name"))
 	if name == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name parameter is required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "name parameter is required")
+		return
+	}
+	size, problem := parseImageSize(r)
+	if problem != nil {
+		problem.write(w)
 		return
 	}
 	setCode := strings.TrimSpace(r.URL.Query().Get("set"))
@@ -942,42 +1818,50 @@ func (a *App) handleCardSearch(w http.ResponseWriter, r *http.Request) {
 	if setCode != "" {
 		setLower = strings.ToLower(setCode)
 	}
-	card, err := a.findCardByName(queryLower, setLower)
+	card, err := a.findCardByName(r.Context(), queryLower, setLower)
 	if err != nil && setLower != "" {
-		card, err = a.findCardByName(queryLower, "")
+		card, err = a.findCardByName(r.Context(), queryLower, "")
 	}
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Card not found"})
+		card, err = a.scryfallLookup(r.Context(), name, setLower, "")
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Card not found")
 		return
 	}
-	writeJSON(w, http.StatusOK, cardRowToResponse(card))
+	writeJSON(w, http.StatusOK, cardRowToResponse(card).withImageSize(size))
 }
 
 func (a *App) handleCardPrints(w http.ResponseWriter, r *http.Request) {
 	if !a.ensureCardsAvailable() {
-		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Cards data not loaded. Ensure cards.json is available and restart the Go backend."})
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Cards data not loaded. Ensure cards.json is available and restart the Go backend.")
 		return
 	}
 	name := strings.TrimSpace(r.URL.Query().Get("name"))
 	if name == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name parameter is required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "name parameter is required")
+		return
+	}
+	size, problem := parseImageSize(r)
+	if problem != nil {
+		problem.write(w)
 		return
 	}
 	queryLower := strings.ToLower(name)
-	best, err := a.findCardByName(queryLower, "")
+	best, err := a.findCardByName(r.Context(), queryLower, "")
 	if err != nil || best == nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Card not found"})
+		writeError(w, http.StatusNotFound, codeNotFound, "Card not found")
 		return
 	}
-	rows, err := a.db.Query(`
-		SELECT name, set_code, collector_number, set_name, image_url, back_image_url
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT name, set_code, collector_number, set_name, image_url, back_image_url, art_crop_url, border_crop_url
 		FROM cards
 		WHERE name_normalized = ?
 		ORDER BY set_code, collector_number
 		LIMIT 500
 	`, best.NameNormalized)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch prints"})
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch prints")
 		return
 	}
 	defer rows.Close()
@@ -985,94 +1869,178 @@ func (a *App) handleCardPrints(w http.ResponseWriter, r *http.Request) {
 	results := make([]cardPrintResponse, 0, 64)
 	for rows.Next() {
 		var row cardPrintRow
-		if err := rows.Scan(&row.Name, &row.SetCode, &row.CollectorNumber, &row.SetName, &row.ImageURL, &row.BackImageURL); err != nil {
+		if err := rows.Scan(&row.Name, &row.SetCode, &row.CollectorNumber, &row.SetName, &row.ImageURL, &row.BackImageURL, &row.ArtCropURL, &row.BorderCropURL); err != nil {
 			continue
 		}
-		results = append(results, cardPrintResponse{
+		printing := cardPrintResponse{
 			Name:            row.Name,
 			SetCode:         nullStringToPtr(row.SetCode),
 			CollectorNumber: nullStringToPtr(row.CollectorNumber),
 			SetName:         nullStringToPtr(row.SetName),
 			ImageURL:        nullStringToPtr(row.ImageURL),
 			BackImageURL:    nullStringToPtr(row.BackImageURL),
-		})
+			crops:           cardImageCrops{nullStringToPtr(row.ArtCropURL), nullStringToPtr(row.BorderCropURL)},
+		}
+		results = append(results, printing.withImageSize(size))
 	}
 	writeJSON(w, http.StatusOK, results)
 }
 
 func (a *App) handleCardCollector(w http.ResponseWriter, r *http.Request) {
 	if !a.ensureCardsAvailable() {
-		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Cards data not loaded. Ensure cards.json is available and restart the Go backend."})
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Cards data not loaded. Ensure cards.json is available and restart the Go backend.")
 		return
 	}
 	setCode := chi.URLParam(r, "setCode")
 	collectorNumber := chi.URLParam(r, "collectorNumber")
 	if setCode == "" || collectorNumber == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "setCode and collectorNumber are required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "setCode and collectorNumber are required")
+		return
+	}
+	size, problem := parseImageSize(r)
+	if problem != nil {
+		problem.write(w)
 		return
 	}
-	card, err := a.selectBySetCollector(strings.ToLower(setCode), collectorNumber)
+	card, err := a.selectBySetCollector(r.Context(), strings.ToLower(setCode), collectorNumber)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Card not found"})
+		card, err = a.scryfallLookup(r.Context(), "", strings.ToLower(setCode), collectorNumber)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Card not found")
 		return
 	}
-	writeJSON(w, http.StatusOK, cardRowToResponse(card))
+	writeJSON(w, http.StatusOK, cardRowToResponse(card).withImageSize(size))
 }
 
+const cardsBatchWorkers = 8
+
+type batchCardRequest struct {
+	Name            string `json:"name"`
+	SetCode         string `json:"setCode"`
+	CollectorNumber string `json:"collectorNumber"`
+	CustomID        string `json:"customId,omitempty"`
+}
+
+// RoomID in a batch request also resolves that room's custom cards.
 type batchRequest struct {
-	Cards []struct {
-		Name            string `json:"name"`
-		SetCode         string `json:"setCode"`
-		CollectorNumber string `json:"collectorNumber"`
-	} `json:"cards"`
+	Cards  []batchCardRequest `json:"cards"`
+	RoomID string             `json:"roomId,omitempty"`
 }
 
 func (a *App) handleCardsBatch(w http.ResponseWriter, r *http.Request) {
 	if !a.ensureCardsAvailable() {
-		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Cards data not loaded. Ensure cards.json is available and restart the Go backend."})
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Cards data not loaded. Ensure cards.json is available and restart the Go backend.")
 		return
 	}
 	var payload batchRequest
 	if err := decodeJSON(r, &payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		writeBodyError(w, err, "Invalid request")
 		return
 	}
 	if payload.Cards == nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cards must be an array"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "cards must be an array")
 		return
 	}
-	results := make([]interface{}, 0, len(payload.Cards))
-	for _, request := range payload.Cards {
-		if request.Name == "" && (request.SetCode == "" || request.CollectorNumber == "") {
-			results = append(results, map[string]interface{}{
-				"error":   "name or (setCode and collectorNumber) required",
-				"request": request,
-			})
+	size, problem := parseImageSize(r)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+	if payload.RoomID == "" {
+		writeJSON(w, http.StatusOK, resizeCardResults(a.resolveCards(r.Context(), payload.Cards), size))
+		return
+	}
+	custom := a.roomCustomCards(r.Context(), payload.RoomID)
+	results := make([]interface{}, len(payload.Cards))
+	var rest []batchCardRequest
+	var restIndex []int
+	for i, request := range payload.Cards {
+		if card, ok := findCustomCard(custom, request); ok {
+			results[i] = card
 			continue
 		}
-		var card *cardRow
-		var err error
-		if request.SetCode != "" && request.CollectorNumber != "" {
-			card, err = a.selectBySetCollector(strings.ToLower(request.SetCode), request.CollectorNumber)
+		if request.CustomID != "" {
+			results[i] = map[string]interface{}{"error": "Custom card not found", "request": request}
+			continue
 		}
-		if (card == nil || err != nil) && request.Name != "" {
-			queryName := normalizeCardName(request.Name)
-			setLower := strings.ToLower(request.SetCode)
-			card, err = a.findCardByName(queryName, setLower)
-			if (card == nil || err != nil) && setLower != "" {
-				card, err = a.findCardByName(queryName, "")
+		rest = append(rest, request)
+		restIndex = append(restIndex, i)
+	}
+	for i, result := range resizeCardResults(a.resolveCards(r.Context(), rest), size) {
+		results[restIndex[i]] = result
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// resizeCardResults sets the image size of the resolved cards in a batch.
+func resizeCardResults(results []interface{}, size string) []interface{} {
+	for i, result := range results {
+		if card, ok := result.(cardResponse); ok {
+			results[i] = card.withImageSize(size)
+		}
+	}
+	return results
+}
+
+// resolveCards looks up a batch of cards, letting only the first few the
+// local database misses fall back to Scryfall.
+func (a *App) resolveCards(ctx context.Context, requests []batchCardRequest) []interface{} {
+	ctx = withScryfallLookupLimit(ctx, scryfallBatchLookups)
+	results := make([]interface{}, len(requests))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := cardsBatchWorkers
+	if len(requests) < workers {
+		workers = len(requests)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				results[index] = a.resolveBatchCard(ctx, requests[index])
 			}
+		}()
+	}
+	for index := range requests {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (a *App) resolveBatchCard(ctx context.Context, request batchCardRequest) interface{} {
+	if request.Name == "" && (request.SetCode == "" || request.CollectorNumber == "") {
+		return map[string]interface{}{
+			"error":   "name or (setCode and collectorNumber) required",
+			"request": request,
 		}
-		if err != nil || card == nil {
-			results = append(results, map[string]interface{}{
-				"error":   "Card not found",
-				"request": request,
-			})
-			continue
+	}
+	var card *cardRow
+	var err error
+	if request.SetCode != "" && request.CollectorNumber != "" {
+		card, err = a.selectBySetCollector(ctx, strings.ToLower(request.SetCode), request.CollectorNumber)
+	}
+	if (card == nil || err != nil) && request.Name != "" {
+		queryName := normalizeCardName(request.Name)
+		setLower := strings.ToLower(request.SetCode)
+		card, err = a.findCardByName(ctx, queryName, setLower)
+		if (card == nil || err != nil) && setLower != "" {
+			card, err = a.findCardByName(ctx, queryName, "")
 		}
-		results = append(results, cardRowToResponse(card))
 	}
-	writeJSON(w, http.StatusOK, results)
+	if err != nil || card == nil {
+		card, err = a.scryfallLookup(ctx, request.Name, strings.ToLower(request.SetCode), request.CollectorNumber)
+	}
+	if err != nil || card == nil {
+		return map[string]interface{}{
+			"error":   "Card not found",
+			"request": request,
+		}
+	}
+	return cardRowToResponse(card)
 }
 
 type roomStatePayload struct {
@@ -1081,39 +2049,82 @@ type roomStatePayload struct {
 	Players           json.RawMessage `json:"players"`
 	CemeteryPositions json.RawMessage `json:"cemeteryPositions"`
 	LibraryPositions  json.RawMessage `json:"libraryPositions"`
+	CommanderDamage   json.RawMessage `json:"commanderDamage,omitempty"`
+	TeamLife          json.RawMessage `json:"teamLife,omitempty"`
+	Supplemental      json.RawMessage `json:"supplemental,omitempty"`
+	CustomCards       json.RawMessage `json:"customCards,omitempty"`
+	CardCounters      json.RawMessage `json:"cardCounters,omitempty"`
+	Private           json.RawMessage `json:"private,omitempty"`
 }
 
+// handleSaveRoomState replaces the whole state, every player's private zones
+// included, so only the host (or an admin) may do it; other players change
+// the state with PATCH, which keeps them to their own private cards.
 func (a *App) handleSaveRoomState(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "roomId")
 	if roomID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "roomId is required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "roomId is required")
+		return
+	}
+	if viewer := viewerFromRequest(r); !viewer.All && !a.rooms.isHostPlayer(roomID, viewer.PlayerID) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the host can replace the room state")
 		return
 	}
 	var payload roomStatePayload
 	if err := decodeJSON(r, &payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		writeBodyError(w, err, "Invalid request")
 		return
 	}
+	version, err := a.saveRoomState(roomID, payload)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save room state")
+		return
+	}
+	w.Header().Set("ETag", roomVersionETag(version))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "version": version})
+}
+
+// saveRoomState replaces the stored state, filling in missing sections, and
+// returns the new version. Commander damage, team life, card counters, the
+// supplemental decks and custom cards are kept when the client leaves them out.
+func (a *App) saveRoomState(roomID string, payload roomStatePayload) (int64, error) {
+	if len(payload.CommanderDamage) == 0 || len(payload.TeamLife) == 0 || len(payload.Supplemental) == 0 || len(payload.CustomCards) == 0 || len(payload.CardCounters) == 0 {
+		kept := a.storedServerState(roomID)
+		payload.CommanderDamage = ensureJSONDefault(payload.CommanderDamage, kept.CommanderDamage)
+		payload.TeamLife = ensureJSONDefault(payload.TeamLife, kept.TeamLife)
+		payload.Supplemental = ensureJSONDefault(payload.Supplemental, kept.Supplemental)
+		payload.CustomCards = ensureJSONDefault(payload.CustomCards, kept.CustomCards)
+		payload.CardCounters = ensureJSONDefault(payload.CardCounters, kept.CardCounters)
+	}
 	state := roomStatePayload{
 		Board:             ensureJSONDefault(payload.Board, []byte("[]")),
 		Counters:          ensureJSONDefault(payload.Counters, []byte("[]")),
 		Players:           ensureJSONDefault(payload.Players, []byte("[]")),
 		CemeteryPositions: ensureJSONDefault(payload.CemeteryPositions, []byte("{}")),
 		LibraryPositions:  ensureJSONDefault(payload.LibraryPositions, []byte("{}")),
+		CommanderDamage:   ensureJSONDefault(payload.CommanderDamage, []byte("{}")),
+		TeamLife:          payload.TeamLife,
+		Supplemental:      payload.Supplemental,
+		CustomCards:       payload.CustomCards,
+		CardCounters:      payload.CardCounters,
+		Private:           payload.Private,
 	}
 	stateJSON, _ := json.Marshal(state)
-	_, err := a.db.Exec(`
-		INSERT INTO rooms (room_id, board_state, updated_at)
-		VALUES (?, ?, CURRENT_TIMESTAMP)
+	stateJSON, err := splitPrivateZones(stateJSON)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	err = a.db.QueryRow(`
+		INSERT INTO rooms (room_id, board_state, version, updated_at)
+		VALUES (?, ?, 1, CURRENT_TIMESTAMP)
 		ON CONFLICT(room_id) DO UPDATE SET
 			board_state = excluded.board_state,
+			version = rooms.version + 1,
 			updated_at = CURRENT_TIMESTAMP
-	`, roomID, string(stateJSON))
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save room state"})
-		return
-	}
-	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+		RETURNING version
+	`, roomID, string(stateJSON)).Scan(&version)
+	return version, err
 }
 
 type roomEventPayload struct {
@@ -1126,101 +2137,206 @@ type roomEventPayload struct {
 func (a *App) handleSaveRoomEvent(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "roomId")
 	if roomID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "roomId is required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "roomId is required")
 		return
 	}
 	var payload RoomEventPayload
 	if err := decodeJSON(r, &payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		writeBodyError(w, err, "Invalid request")
 		return
 	}
 	payload.RoomID = roomID
 	if strings.TrimSpace(payload.EventType) == "" || payload.EventData == nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "roomId, eventType, and eventData are required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "roomId, eventType, and eventData are required")
 		return
 	}
+	if viewer := viewerFromRequest(r); !viewer.All {
+		payload.PlayerID = viewer.PlayerID
+		payload.PlayerName = viewer.PlayerName
+		payload.UserID = viewer.UserID
+	} else if user, _ := a.userFromRequest(r); user != nil {
+		payload.UserID = user.ID
+	}
 	if err := a.storeRoomEvent(payload); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save event"})
+		var invalid *eventValidationError
+		if errors.As(err, &invalid) {
+			writeErrorDetails(w, http.StatusBadRequest, codeInvalidEvent, "Invalid event", invalid.Details)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save event")
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
+// storeRoomEvent validates and records an event sent by a client.
 func (a *App) storeRoomEvent(payload RoomEventPayload) error {
+	if message, ok := serverEvents[payload.EventType]; ok {
+		return &eventValidationError{Details: []string{"eventType: " + payload.EventType + " events are recorded through " + message}}
+	}
+	if err := validateRoomEvent(payload.EventType, payload.EventData); err != nil {
+		return err
+	}
+	_, err := a.recordRoomEvent(payload)
+	return err
+}
+
+// recordRoomEvent stores an already validated event, writes its log line
+// and sends any webhook, returning the event id.
+func (a *App) recordRoomEvent(payload RoomEventPayload) (int64, error) {
 	_, _ = a.db.Exec(`
 		INSERT INTO rooms (room_id, board_state, updated_at)
 		VALUES (?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(room_id) DO NOTHING
 	`, payload.RoomID, "{}")
-	_, err := a.db.Exec(`
-		INSERT INTO room_events (room_id, event_type, event_data, player_id, player_name)
-		VALUES (?, ?, ?, ?, ?)
-	`, payload.RoomID, payload.EventType, string(payload.EventData), nullIfEmpty(payload.PlayerID), nullIfEmpty(payload.PlayerName))
-	return err
+	result, err := a.db.Exec(`
+		INSERT INTO room_events (room_id, event_type, event_data, player_id, player_name, user_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, payload.RoomID, payload.EventType, string(payload.EventData), nullIfEmpty(payload.PlayerID), nullIfEmpty(payload.PlayerName), nullIfZero(payload.UserID))
+	if err != nil {
+		return 0, err
+	}
+	eventID, _ := result.LastInsertId()
+	a.appendRoomLog(payload, eventID)
+	if payload.EventType == "CARD_ACTION" {
+		a.countDraw(payload)
+	}
+	if payload.EventType == roomEventGameResult {
+		var result map[string]interface{}
+		_ = json.Unmarshal(payload.EventData, &result)
+		// A draw has no winner and scores as "".
+		winner, _ := result["winnerPlayerId"].(string)
+		if winner != "" {
+			winner = a.rooms.playerNameByID(payload.RoomID, winner)
+		}
+		a.lobbies.setResult(payload.RoomID, winner)
+		a.recordGameTimes(payload.RoomID, eventID)
+		a.webhooks.emit(webhookGameFinished, map[string]interface{}{
+			"roomId":     payload.RoomID,
+			"result":     result,
+			"reportedBy": payload.PlayerName,
+		})
+	}
+	return eventID, nil
 }
 
+const maxRoomEventsPage = 1000
+
 func (a *App) handleLoadRoomEvents(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "roomId")
 	if roomID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "roomId is required"})
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "roomId is required")
 		return
 	}
-	rows, err := a.db.Query(`
-		SELECT id, event_type, event_data, player_id, player_name, created_at
+	// sinceId is exclusive, so a reconnecting client passes the last id it
+	// applied (or the previous nextCursor). Without a limit every matching
+	// event is returned and nextCursor is omitted.
+	query := r.URL.Query()
+	where := []string{"room_id = ?", "reverted_at IS NULL"}
+	args := []interface{}{roomID}
+	if sinceID := parseIntDefault(query.Get("sinceId"), 0); sinceID > 0 {
+		where = append(where, "id > ?")
+		args = append(args, sinceID)
+	}
+	if types := strings.TrimSpace(query.Get("type")); types != "" {
+		placeholders := make([]string, 0)
+		for _, eventType := range strings.Split(types, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				placeholders = append(placeholders, "?")
+				args = append(args, eventType)
+			}
+		}
+		if len(placeholders) > 0 {
+			where = append(where, "event_type IN ("+strings.Join(placeholders, ",")+")")
+		}
+	}
+	limit := parseIntDefault(query.Get("limit"), 0)
+	if limit > maxRoomEventsPage {
+		limit = maxRoomEventsPage
+	}
+	limitClause := ""
+	if limit > 0 {
+		limitClause = " LIMIT " + strconv.Itoa(limit+1)
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, event_type, event_data, player_id, player_name, user_id, created_at
 		FROM room_events
-		WHERE room_id = ?
-		ORDER BY created_at ASC, id ASC
-	`, roomID)
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id ASC`+limitClause, args...)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load events"})
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load events")
 		return
 	}
 	defer rows.Close()
 	var events []map[string]interface{}
 	for rows.Next() {
-		var id int64
-		var eventType, eventData, createdAt string
-		var playerID, playerName sql.NullString
-		if err := rows.Scan(&id, &eventType, &eventData, &playerID, &playerName, &createdAt); err != nil {
+		event, err := scanRoomEvent(rows)
+		if err != nil {
 			continue
 		}
-		event := map[string]interface{}{
-			"id":         id,
-			"eventType":  eventType,
-			"eventData":  json.RawMessage(eventData),
-			"playerId":   nullStringToPtr(playerID),
-			"playerName": nullStringToPtr(playerName),
-			"createdAt":  createdAt,
-		}
 		events = append(events, event)
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"events": events,
-	})
+	response := map[string]interface{}{
+		"events":     events,
+		"nextCursor": nil,
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+		response["events"] = events
+		response["nextCursor"] = events[limit-1]["id"]
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func scanRoomEvent(rows *sql.Rows) (map[string]interface{}, error) {
+	var id int64
+	var eventType, eventData, createdAt string
+	var playerID, playerName sql.NullString
+	var userID sql.NullInt64
+	if err := rows.Scan(&id, &eventType, &eventData, &playerID, &playerName, &userID, &createdAt); err != nil {
+		return nil, err
+	}
+	event := map[string]interface{}{
+		"id":         id,
+		"eventType":  eventType,
+		"eventData":  json.RawMessage(eventData),
+		"playerId":   nullStringToPtr(playerID),
+		"playerName": nullStringToPtr(playerName),
+		"userId":     nil,
+		"createdAt":  createdAt,
+	}
+	if userID.Valid {
+		event["userId"] = userID.Int64
+	}
+	return event, nil
 }
 
 func (a *App) handleLoadRoomState(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "roomId")
 	if roomID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "roomId is required"})
-		return
-	}
-	var stateJSON string
-	row := a.db.QueryRow(`SELECT board_state FROM rooms WHERE room_id = ?`, roomID)
-	if err := row.Scan(&stateJSON); err != nil {
-		defaultState := roomStatePayload{
-			Board:             []byte("[]"),
-			Counters:          []byte("[]"),
-			Players:           []byte("[]"),
-			CemeteryPositions: []byte("{}"),
-			LibraryPositions:  []byte("{}"),
-		}
-		writeJSON(w, http.StatusOK, defaultState)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "roomId is required")
 		return
 	}
+	state, version := a.roomStateView(r.Context(), roomID, viewerFromRequest(r))
+	w.Header().Set("ETag", roomVersionETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(stateJSON))
+	_, _ = w.Write(state)
+}
+
+// roomStateView returns the stored state as the viewer may see it, or the
+// empty state when none is stored, with its version.
+func (a *App) roomStateView(ctx context.Context, roomID string, viewer roomViewer) (json.RawMessage, int64) {
+	var stateJSON string
+	var version int64
+	row := a.db.QueryRowContext(ctx, `SELECT board_state, version FROM rooms WHERE room_id = ?`, roomID)
+	if err := row.Scan(&stateJSON, &version); err != nil || stateJSON == "{}" {
+		return marshalPayload(defaultRoomState()), version
+	}
+	if !viewer.All && viewer.PlayerName != "" {
+		viewer.Revealed = a.reveals.visibleTo(roomID, viewer.PlayerName)
+	}
+	return viewRoomState([]byte(stateJSON), viewer), version
 }
 
 func (a *App) ensureCardsAvailable() bool {
@@ -1249,22 +2365,22 @@ func normalizeCardName(input string) string {
 	return strings.Join(strings.Fields(normalized), " ")
 }
 
-func (a *App) findCardByName(queryLower string, setLower string) (*cardRow, error) {
+func (a *App) findCardByName(ctx context.Context, queryLower string, setLower string) (*cardRow, error) {
 	var rows []*cardRow
 	var err error
 	if setLower != "" {
-		rows, err = a.selectExactNameAndSet(queryLower, setLower)
+		rows, err = a.selectExactNameAndSet(ctx, queryLower, setLower)
 	} else {
-		rows, err = a.selectExactName(queryLower)
+		rows, err = a.selectExactName(ctx, queryLower)
 	}
 	if err == nil && len(rows) > 0 {
 		return rows[0], nil
 	}
 	pattern := "%" + escapeLikePattern(queryLower) + "%"
 	if setLower != "" {
-		rows, err = a.selectLikeNameAndSet(pattern, setLower, queryLower)
+		rows, err = a.selectLikeNameAndSet(ctx, pattern, setLower, queryLower)
 	} else {
-		rows, err = a.selectLikeName(pattern, queryLower)
+		rows, err = a.selectLikeName(ctx, pattern, queryLower)
 	}
 	if err != nil || len(rows) == 0 {
 		return nil, errors.New("not found")
@@ -1284,9 +2400,9 @@ func (a *App) findCardByName(queryLower string, setLower string) (*cardRow, erro
 	return best, nil
 }
 
-func (a *App) selectExactName(queryLower string) ([]*cardRow, error) {
-	rows, err := a.db.Query(`
-		SELECT id, name, name_normalized, type_line, mana_cost, oracle_text, image_url, back_image_url, set_name, set_code, collector_number, prints_search_uri
+func (a *App) selectExactName(ctx context.Context, queryLower string) ([]*cardRow, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT `+cardColumns+`
 		FROM cards
 		WHERE name_normalized = ?
 		ORDER BY set_code, collector_number
@@ -1299,9 +2415,9 @@ func (a *App) selectExactName(queryLower string) ([]*cardRow, error) {
 	return scanCardRows(rows), nil
 }
 
-func (a *App) selectExactNameAndSet(queryLower string, setLower string) ([]*cardRow, error) {
-	rows, err := a.db.Query(`
-		SELECT id, name, name_normalized, type_line, mana_cost, oracle_text, image_url, back_image_url, set_name, set_code, collector_number, prints_search_uri
+func (a *App) selectExactNameAndSet(ctx context.Context, queryLower string, setLower string) ([]*cardRow, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT `+cardColumns+`
 		FROM cards
 		WHERE name_normalized = ?
 		  AND set_code = ?
@@ -1315,9 +2431,9 @@ func (a *App) selectExactNameAndSet(queryLower string, setLower string) ([]*card
 	return scanCardRows(rows), nil
 }
 
-func (a *App) selectLikeName(pattern string, queryLower string) ([]*cardRow, error) {
-	rows, err := a.db.Query(`
-		SELECT id, name, name_normalized, type_line, mana_cost, oracle_text, image_url, back_image_url, set_name, set_code, collector_number, prints_search_uri
+func (a *App) selectLikeName(ctx context.Context, pattern string, queryLower string) ([]*cardRow, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT `+cardColumns+`
 		FROM cards
 		WHERE name_normalized LIKE ? ESCAPE '\'
 		ORDER BY INSTR(name_normalized, ?) ASC, name ASC
@@ -1330,9 +2446,9 @@ func (a *App) selectLikeName(pattern string, queryLower string) ([]*cardRow, err
 	return scanCardRows(rows), nil
 }
 
-func (a *App) selectLikeNameAndSet(pattern string, setLower string, queryLower string) ([]*cardRow, error) {
-	rows, err := a.db.Query(`
-		SELECT id, name, name_normalized, type_line, mana_cost, oracle_text, image_url, back_image_url, set_name, set_code, collector_number, prints_search_uri
+func (a *App) selectLikeNameAndSet(ctx context.Context, pattern string, setLower string, queryLower string) ([]*cardRow, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT `+cardColumns+`
 		FROM cards
 		WHERE name_normalized LIKE ? ESCAPE '\'
 		  AND set_code = ?
@@ -1346,15 +2462,21 @@ func (a *App) selectLikeNameAndSet(pattern string, setLower string, queryLower s
 	return scanCardRows(rows), nil
 }
 
-func (a *App) selectBySetCollector(setCode string, collectorNumber string) (*cardRow, error) {
-	row := a.db.QueryRow(`
-		SELECT id, name, name_normalized, type_line, mana_cost, oracle_text, image_url, back_image_url, set_name, set_code, collector_number, prints_search_uri
+func (a *App) selectBySetCollector(ctx context.Context, setCode string, collectorNumber string) (*cardRow, error) {
+	row := a.db.QueryRowContext(ctx, `
+		SELECT `+cardColumns+`
 		FROM cards
 		WHERE set_code = ? AND collector_number = ?
 		LIMIT 1
 	`, setCode, collectorNumber)
+	return scanCardRow(row)
+}
+
+const cardColumns = `id, name, name_normalized, type_line, mana_cost, oracle_text, image_url, back_image_url, set_name, set_code, collector_number, prints_search_uri, art_crop_url, border_crop_url`
+
+func scanCardRow(scanner rowScanner) (*cardRow, error) {
 	var card cardRow
-	if err := row.Scan(&card.ID, &card.Name, &card.NameNormalized, &card.TypeLine, &card.ManaCost, &card.OracleText, &card.ImageURL, &card.BackImageURL, &card.SetName, &card.SetCode, &card.CollectorNumber, &card.PrintsSearchURI); err != nil {
+	if err := scanner.Scan(&card.ID, &card.Name, &card.NameNormalized, &card.TypeLine, &card.ManaCost, &card.OracleText, &card.ImageURL, &card.BackImageURL, &card.SetName, &card.SetCode, &card.CollectorNumber, &card.PrintsSearchURI, &card.ArtCropURL, &card.BorderCropURL); err != nil {
 		return nil, err
 	}
 	return &card, nil
@@ -1363,11 +2485,11 @@ func (a *App) selectBySetCollector(setCode string, collectorNumber string) (*car
 func scanCardRows(rows *sql.Rows) []*cardRow {
 	var results []*cardRow
 	for rows.Next() {
-		var card cardRow
-		if err := rows.Scan(&card.ID, &card.Name, &card.NameNormalized, &card.TypeLine, &card.ManaCost, &card.OracleText, &card.ImageURL, &card.BackImageURL, &card.SetName, &card.SetCode, &card.CollectorNumber, &card.PrintsSearchURI); err != nil {
+		card, err := scanCardRow(rows)
+		if err != nil {
 			continue
 		}
-		results = append(results, &card)
+		results = append(results, card)
 	}
 	return results
 }
@@ -1378,6 +2500,7 @@ func cardRowToResponse(card *cardRow) cardResponse {
 		OracleText: nullStringToPtr(card.OracleText),
 		ManaCost:   nullStringToPtr(card.ManaCost),
 		TypeLine:   nullStringToPtr(card.TypeLine),
+		crops:      cardImageCrops{nullStringToPtr(card.ArtCropURL), nullStringToPtr(card.BorderCropURL)},
 	}
 	if card.ImageURL.Valid {
 		response.ImageURL = &card.ImageURL.String
@@ -1403,15 +2526,15 @@ func cardRowToResponse(card *cardRow) cardResponse {
 }
 
 func (a *App) corsMiddleware(next http.Handler) http.Handler {
-	allowedOrigins := buildAllowedOrigins()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		if origin != "" && isOriginAllowed(origin, allowedOrigins) {
+		if origin != "" && a.origins.allows(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Room-Token, X-Room-Password, X-Stream-Token, If-Match")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Retry-After, Deprecation, Link")
+			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
 		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -1421,35 +2544,6 @@ func (a *App) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func buildAllowedOrigins() []string {
-	clientHost := os.Getenv("VITE_CLIENT_HOST")
-	if clientHost == "" {
-		clientHost = "localhost"
-	}
-	clientPort := os.Getenv("VITE_CLIENT_PORT")
-	if clientPort == "" {
-		clientPort = "5173"
-	}
-	return []string{
-		fmt.Sprintf("http://%s:%s", clientHost, clientPort),
-		fmt.Sprintf("http://localhost:%s", clientPort),
-		fmt.Sprintf("http://127.0.0.1:%s", clientPort),
-		"https://mto.mesmer.tv",
-		"http://mto.mesmer.tv",
-		"https://www.mto.mesmer.tv",
-		"http://www.mto.mesmer.tv",
-	}
-}
-
-func isOriginAllowed(origin string, allowed []string) bool {
-	for _, entry := range allowed {
-		if origin == entry {
-			return true
-		}
-	}
-	return strings.HasPrefix(origin, "http://localhost:") || strings.HasPrefix(origin, "http://127.0.0.1:")
-}
-
 func resolvePort(primary string, fallback string, defaultValue string) string {
 	if value := strings.TrimSpace(os.Getenv(primary)); value != "" {
 		return value
@@ -1460,6 +2554,19 @@ func resolvePort(primary string, fallback string, defaultValue string) string {
 	return defaultValue
 }
 
+func envBool(key string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+func envInt(key string, defaultValue int) int {
+	return parseIntDefault(strings.TrimSpace(os.Getenv(key)), defaultValue)
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -1485,13 +2592,12 @@ func hashPassword(password string) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func setSessionCookie(w http.ResponseWriter, value string) {
-	http.SetCookie(w, &http.Cookie{
+func (a *App) setSessionCookie(w http.ResponseWriter, r *http.Request, value string) {
+	a.setCookie(w, r, &http.Cookie{
 		Name:     cookieName,
 		Value:    value,
 		HttpOnly: true,
-		MaxAge:   30 * 24 * 60 * 60,
-		SameSite: http.SameSiteLaxMode,
+		MaxAge:   sessionTTLSeconds,
 		Path:     "/",
 	})
 }
@@ -1554,6 +2660,13 @@ func nullIfEmpty(value string) interface{} {
 	return value
 }
 
+func nullIfZero(value int64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
 func nullStringToPtr(value sql.NullString) *string {
 	if !value.Valid {
 		return nil
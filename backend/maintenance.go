@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxMaintenanceMessageLen = 500
+	defaultMaintenanceNotice = "MTOnline is down for maintenance"
+)
+
+// An admin puts the server in maintenance mode ahead of a deploy with
+// PUT /admin/maintenance, optionally from a scheduled time. Once it is
+// active, sign-ins other than admins', registrations and new rooms are
+// refused with the notice; games already running carry on. Every change is
+// sent to all clients as server:maintenance, and a client that connects
+// while a window is announced gets it too, so the UI can show a banner.
+// The window is stored so it survives the restart it was announced for, and
+// peers learn of changes over the room bus.
+
+// maintenanceWindow is the announced maintenance, as stored and as sent in
+// server:maintenance and by GET /maintenance.
+type maintenanceWindow struct {
+	Enabled  bool    `json:"enabled"`
+	Active   bool    `json:"active"`
+	Message  string  `json:"message,omitempty"`
+	StartsAt *string `json:"startsAt"`
+	EndsAt   *string `json:"endsAt"`
+}
+
+type maintenancePayload struct {
+	Enabled  bool    `json:"enabled"`
+	Message  string  `json:"message"`
+	StartsAt *string `json:"startsAt"`
+	EndsAt   *string `json:"endsAt"`
+}
+
+type maintenanceMode struct {
+	mu     sync.RWMutex
+	window maintenanceWindow
+}
+
+// current returns the window with Active worked out for now.
+func (m *maintenanceMode) current() maintenanceWindow {
+	m.mu.RLock()
+	window := m.window
+	m.mu.RUnlock()
+	window.Active = window.Enabled
+	if window.Active && window.StartsAt != nil {
+		if startsAt, err := time.Parse(time.RFC3339, *window.StartsAt); err == nil && time.Now().Before(startsAt) {
+			window.Active = false
+		}
+	}
+	return window
+}
+
+func (m *maintenanceMode) set(window maintenanceWindow) {
+	m.mu.Lock()
+	m.window = window
+	m.mu.Unlock()
+}
+
+// notice is the message shown to refused users.
+func (w maintenanceWindow) notice() string {
+	message := w.Message
+	if message == "" {
+		message = defaultMaintenanceNotice
+	}
+	if w.EndsAt != nil {
+		message += " until " + *w.EndsAt
+	}
+	return message
+}
+
+// loadMaintenance reads the stored window at startup.
+func (a *App) loadMaintenance() {
+	var window maintenanceWindow
+	var message, startsAt, endsAt sql.NullString
+	err := a.db.QueryRow(`SELECT enabled, message, starts_at, ends_at FROM maintenance WHERE id = 1`).Scan(&window.Enabled, &message, &startsAt, &endsAt)
+	if err != nil {
+		return
+	}
+	window.Message = message.String
+	window.StartsAt = nullStringToPtr(startsAt)
+	window.EndsAt = nullStringToPtr(endsAt)
+	a.maintenance.set(window)
+	if window.Enabled {
+		log.Printf("[maintenance] %s", window.notice())
+	}
+}
+
+// refuseDuringMaintenance writes the maintenance error and reports true while
+// maintenance is active.
+func (a *App) refuseDuringMaintenance(w http.ResponseWriter) bool {
+	window := a.maintenance.current()
+	if !window.Active {
+		return false
+	}
+	writeErrorDetails(w, http.StatusServiceUnavailable, codeMaintenance, window.notice(), window)
+	return true
+}
+
+func (a *App) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.maintenance.current())
+}
+
+func (a *App) handleAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var payload maintenancePayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	window := maintenanceWindow{Enabled: payload.Enabled, Message: strings.TrimSpace(payload.Message)}
+	if len(window.Message) > maxMaintenanceMessageLen {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "message is too long")
+		return
+	}
+	var times []time.Time
+	for _, field := range []struct {
+		name  string
+		value *string
+		into  **string
+	}{
+		{"startsAt", payload.StartsAt, &window.StartsAt},
+		{"endsAt", payload.EndsAt, &window.EndsAt},
+	} {
+		if field.value == nil || *field.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, *field.value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, field.name+" must be an RFC 3339 time")
+			return
+		}
+		formatted := parsed.UTC().Format(time.RFC3339)
+		*field.into = &formatted
+		times = append(times, parsed)
+	}
+	if window.StartsAt != nil && window.EndsAt != nil && !times[1].After(times[0]) {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "endsAt must be after startsAt")
+		return
+	}
+	if !window.Enabled {
+		window = maintenanceWindow{}
+	}
+	_, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO maintenance (id, enabled, message, starts_at, ends_at, updated_at)
+		VALUES (1, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET
+			enabled = excluded.enabled, message = excluded.message,
+			starts_at = excluded.starts_at, ends_at = excluded.ends_at, updated_at = excluded.updated_at
+	`, window.Enabled, nullIfEmpty(window.Message), window.StartsAt, window.EndsAt)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save maintenance mode")
+		return
+	}
+	action, reason := "maintenance_off", ""
+	if window.Enabled {
+		action, reason = "maintenance_on", window.notice()
+	}
+	_ = recordAudit(r.Context(), a.db, a.currentUser(r), action, 0, "", reason)
+	a.maintenance.set(window)
+	message := WSMessage{Type: "server:maintenance", Payload: marshalPayload(a.maintenance.current())}
+	a.broadcastLocal(message)
+	a.bus.publishMaintenance(message)
+	writeJSON(w, http.StatusOK, a.maintenance.current())
+}
+
+// applyPeerMaintenance takes a window changed on another instance.
+func (a *App) applyPeerMaintenance(message WSMessage) {
+	var window maintenanceWindow
+	if json.Unmarshal(message.Payload, &window) != nil {
+		return
+	}
+	window.Active = false
+	a.maintenance.set(window)
+	a.broadcastLocal(message)
+}
+
+// refuseLoginDuringMaintenance is refuseDuringMaintenance for a sign-in,
+// which admins may still make.
+func (a *App) refuseLoginDuringMaintenance(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	if !a.maintenance.current().Active {
+		return false
+	}
+	var role string
+	if a.db.QueryRowContext(r.Context(), `SELECT role FROM users WHERE id = ?`, userID).Scan(&role) == nil && role == roleAdmin {
+		return false
+	}
+	return a.refuseDuringMaintenance(w)
+}
+
+// greetDuringMaintenance tells a newly connected client about an announced
+// window.
+func (a *App) greetDuringMaintenance(client *WSClient) {
+	if window := a.maintenance.current(); window.Enabled {
+		a.sendLocal(client.id, WSMessage{Type: "server:maintenance", Payload: marshalPayload(window)})
+	}
+}
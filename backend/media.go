@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	mediaPlaymat  = "playmat"
+	mediaCardBack = "card-back"
+
+	mediaMaxDimension = 4096
+	mediaCacheControl = "public, max-age=31536000, immutable"
+)
+
+// mediaKinds are the images a user can upload to customize their seat.
+var mediaKinds = map[string]bool{
+	mediaPlaymat:  true,
+	mediaCardBack: true,
+}
+
+// mediaExtensions are the accepted image types, recognized from the bytes
+// rather than the declared Content-Type.
+var mediaExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+var mediaNamePattern = regexp.MustCompile(`^[0-9a-f]{32}\.(png|jpg|webp)$`)
+
+// mediaStore keeps uploaded images under generated names, so a stored name
+// never changes content and can be cached forever.
+type mediaStore struct {
+	objects objectStore
+	// publicURL serves the uploads straight from their bucket; without it
+	// the API serves them.
+	publicURL string
+}
+
+// loadMediaStore picks where uploads go. With MEDIA_S3_BUCKET set they are
+// PUT to that S3-compatible bucket at MEDIA_S3_ENDPOINT (signed with
+// MEDIA_S3_ACCESS_KEY_ID and MEDIA_S3_SECRET_ACCESS_KEY for MEDIA_S3_REGION)
+// and linked from MEDIA_PUBLIC_URL, by default the bucket itself, which must
+// then serve them publicly. Otherwise they go to the shared storage, under
+// media/ in the STORAGE_S3_BUCKET or in MEDIA_DIR (default data/media), and
+// are served by the API unless MEDIA_PUBLIC_URL points at a public copy of
+// that prefix. MEDIA_MAX_BYTES caps an upload.
+func loadMediaStore() (*mediaStore, error) {
+	publicURL := strings.TrimRight(strings.TrimSpace(os.Getenv("MEDIA_PUBLIC_URL")), "/")
+	if settings := envS3Settings("MEDIA"); settings.bucket != "" {
+		objects, err := newS3ObjectStore(settings, "", "MEDIA")
+		if err != nil {
+			return nil, err
+		}
+		if publicURL == "" {
+			publicURL = objects.endpoint.String() + "/" + objects.bucket
+		}
+		return &mediaStore{objects: objects, publicURL: publicURL}, nil
+	}
+	dir := strings.TrimSpace(os.Getenv("MEDIA_DIR"))
+	if dir == "" {
+		dir = filepath.Join(rootDir(), "data", "media")
+	}
+	objects, err := loadObjectStore(dir, "media/")
+	if err != nil {
+		return nil, err
+	}
+	if _, onDisk := objects.(*diskObjectStore); onDisk {
+		publicURL = ""
+	}
+	return &mediaStore{objects: objects, publicURL: publicURL}, nil
+}
+
+func (m *mediaStore) url(name string) string {
+	if m.publicURL != "" {
+		return m.publicURL + "/" + name
+	}
+	return apiV1Prefix + "/media/" + name
+}
+
+type mediaImage struct {
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// inspectImage identifies an upload as PNG, JPEG or WebP and reads its
+// dimensions without decoding the pixels.
+func inspectImage(data []byte) (mediaImage, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := mediaExtensions[contentType]; !ok {
+		return mediaImage{}, errors.New("image must be a PNG, JPEG or WebP file")
+	}
+	result := mediaImage{ContentType: contentType}
+	if contentType == "image/webp" {
+		width, height, ok := webpDimensions(data)
+		if !ok {
+			return mediaImage{}, errors.New("image is not a valid WebP file")
+		}
+		result.Width, result.Height = width, height
+	} else {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return mediaImage{}, errors.New("image could not be read")
+		}
+		result.Width, result.Height = config.Width, config.Height
+	}
+	if result.Width > mediaMaxDimension || result.Height > mediaMaxDimension {
+		return mediaImage{}, fmt.Errorf("image must be at most %dx%d pixels", mediaMaxDimension, mediaMaxDimension)
+	}
+	return result, nil
+}
+
+// webpDimensions reads the canvas size from the first chunk of a WebP file,
+// which the standard library cannot decode.
+func webpDimensions(data []byte) (int, int, bool) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+	switch string(data[12:16]) {
+	case "VP8X":
+		width := 1 + (int(data[24]) | int(data[25])<<8 | int(data[26])<<16)
+		height := 1 + (int(data[27]) | int(data[28])<<8 | int(data[29])<<16)
+		return width, height, true
+	case "VP8L":
+		if data[20] != 0x2f {
+			return 0, 0, false
+		}
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return 1 + int(bits&0x3fff), 1 + int(bits>>14&0x3fff), true
+	case "VP8 ":
+		if data[23] != 0x9d || data[24] != 0x01 || data[25] != 0x2a {
+			return 0, 0, false
+		}
+		return int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff), int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff), true
+	}
+	return 0, 0, false
+}
+
+// userMedia returns the URLs of a user's uploads by kind.
+func (a *App) userMedia(ctx context.Context, userID int64) map[string]string {
+	media := map[string]string{}
+	if userID == 0 {
+		return media
+	}
+	rows, err := a.db.QueryContext(ctx, `SELECT kind, name FROM user_media WHERE user_id = ?`, userID)
+	if err != nil {
+		return media
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, name string
+		if rows.Scan(&kind, &name) == nil {
+			media[kind] = a.media.url(name)
+		}
+	}
+	return media
+}
+
+// withMedia adds the player's playmat and card back to a room payload so
+// the other players can draw their seat.
+func (a *App) withMedia(payload RoomClientJoinedPayload, userID int64) RoomClientJoinedPayload {
+	media := a.userMedia(context.Background(), userID)
+	payload.PlaymatURL = media[mediaPlaymat]
+	payload.CardBackURL = media[mediaCardBack]
+	return payload
+}
+
+func (a *App) handleSettings(w http.ResponseWriter, r *http.Request) {
+	media := a.userMedia(r.Context(), a.currentUser(r).ID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"playmatUrl":  nullIfEmpty(media[mediaPlaymat]),
+		"cardBackUrl": nullIfEmpty(media[mediaCardBack]),
+	})
+}
+
+// handleUploadMedia stores the request body, a raw image, as the user's
+// playmat or card back, replacing the previous one.
+func (a *App) handleUploadMedia(w http.ResponseWriter, r *http.Request) {
+	kind := chi.URLParam(r, "kind")
+	if !mediaKinds[kind] {
+		writeError(w, http.StatusNotFound, codeNotFound, "Unknown media kind")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, "Invalid image")
+		return
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be the image")
+		return
+	}
+	img, err := inspectImage(data)
+	if err != nil {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, err.Error())
+		return
+	}
+	user := a.currentUser(r)
+	name := randomID(16) + mediaExtensions[img.ContentType]
+	if err := a.media.objects.put(r.Context(), name, img.ContentType, bytes.NewReader(data)); err != nil {
+		log.Printf("[media] failed to store %s for user %d: %v", kind, user.ID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to store image")
+		return
+	}
+	var previous sql.NullString
+	_ = a.db.QueryRowContext(r.Context(), `SELECT name FROM user_media WHERE user_id = ? AND kind = ?`, user.ID, kind).Scan(&previous)
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO user_media (user_id, kind, name, content_type, size_bytes, width, height)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, kind) DO UPDATE SET
+			name = excluded.name,
+			content_type = excluded.content_type,
+			size_bytes = excluded.size_bytes,
+			width = excluded.width,
+			height = excluded.height,
+			created_at = CURRENT_TIMESTAMP
+	`, user.ID, kind, name, img.ContentType, len(data), img.Width, img.Height); err != nil {
+		_ = a.media.objects.remove(context.Background(), name)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save image")
+		return
+	}
+	if previous.Valid {
+		a.removeMedia(previous.String)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":        kind,
+		"url":         a.media.url(name),
+		"contentType": img.ContentType,
+		"sizeBytes":   len(data),
+		"width":       img.Width,
+		"height":      img.Height,
+	})
+}
+
+func (a *App) handleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	kind := chi.URLParam(r, "kind")
+	if !mediaKinds[kind] {
+		writeError(w, http.StatusNotFound, codeNotFound, "Unknown media kind")
+		return
+	}
+	user := a.currentUser(r)
+	var name string
+	err := a.db.QueryRowContext(r.Context(), `
+		DELETE FROM user_media WHERE user_id = ? AND kind = ? RETURNING name
+	`, user.ID, kind).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "No image uploaded")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete image")
+		return
+	}
+	a.removeMedia(name)
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// removeMedia deletes a replaced image in the background; a failure only
+// leaves an unreferenced file behind.
+func (a *App) removeMedia(name string) {
+	go func() {
+		if err := a.media.objects.remove(context.Background(), name); err != nil {
+			log.Printf("[media] failed to remove %s: %v", name, err)
+		}
+	}()
+}
+
+// handleMedia serves images that are not linked from a public bucket. Names
+// are generated per upload, so responses are cacheable forever.
+func (a *App) handleMedia(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if a.media.publicURL != "" || !mediaNamePattern.MatchString(name) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Image not found")
+		return
+	}
+	object, err := a.media.objects.get(r.Context(), name)
+	if errors.Is(err, errObjectNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Image not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[media] failed to read %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to read image")
+		return
+	}
+	defer object.Close()
+	for contentType, extension := range mediaExtensions {
+		if strings.HasSuffix(name, extension) {
+			w.Header().Set("Content-Type", contentType)
+		}
+	}
+	w.Header().Set("Cache-Control", mediaCacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if file, ok := object.(*os.File); ok {
+		if info, err := file.Stat(); err == nil {
+			http.ServeContent(w, r, name, info.ModTime(), file)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, object)
+}
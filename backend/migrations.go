@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema changes live in migrations/NNNN_name.sql and are applied in order,
+// each exactly once. Add a new file for every change; never edit one that has
+// shipped.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+type migrationState struct {
+	migration
+	AppliedAt string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".sql" {
+			continue
+		}
+		prefix, rest, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_description.sql", name)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+		body, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: rest, SQL: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func appliedMigrations(db *sql.DB) (map[int]string, error) {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigrations applies every pending migration, each in its own
+// transaction, and returns how many ran.
+func runMigrations(db *sql.DB) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		if err := upgradeLegacySchema(db); err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return count, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("[migrate] applied %04d_%s", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// upgradeLegacySchema brings a database created before migrations existed up
+// to the columns the baseline expects, since CREATE TABLE IF NOT EXISTS leaves
+// existing tables untouched. Each ALTER fails harmlessly when the column or
+// table is already in the right shape.
+func upgradeLegacySchema(db *sql.DB) error {
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return nil
+	}
+	log.Printf("[migrate] upgrading database created before migrations")
+	alters := []string{
+		`ALTER TABLE decks ADD COLUMN is_public INTEGER DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN avatar_url TEXT`,
+		`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user'`,
+		`ALTER TABLE rooms ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE room_events ADD COLUMN reverted_at DATETIME`,
+		`ALTER TABLE room_events ADD COLUMN user_id INTEGER`,
+		`ALTER TABLE cards ADD COLUMN prints_search_uri TEXT`,
+		`ALTER TABLE decks ADD COLUMN position INTEGER DEFAULT 0`,
+		`ALTER TABLE decks ADD COLUMN is_precon INTEGER DEFAULT 0`,
+	}
+	for _, column := range []string{"forked_from", "forked_from_name", "forked_from_author", "format", "share_token", "folder_id", "cover_card", "cover_image_url"} {
+		alters = append(alters, `ALTER TABLE decks ADD COLUMN `+column+` TEXT`)
+	}
+	for _, statement := range alters {
+		if _, err := db.Exec(statement); err != nil {
+			// Column already exists, ignore.
+		}
+	}
+	return nil
+}
+
+func migrationStatus(db *sql.DB) ([]migrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]migrationState, 0, len(migrations))
+	for _, m := range migrations {
+		states = append(states, migrationState{migration: m, AppliedAt: applied[m.Version]})
+	}
+	return states, nil
+}
+
+// runMigrateCommand implements `mtonline-backend migrate [up|status]` so
+// migrations can be applied or inspected without starting the server.
+func runMigrateCommand(db *sql.DB, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		count, err := runMigrations(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", count)
+		return nil
+	case "status":
+		states, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != "" {
+				applied = "applied " + state.AppliedAt
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up or status)", action)
+	}
+}
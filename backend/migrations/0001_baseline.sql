@@ -0,0 +1,226 @@
+-- Baseline schema. Uses IF NOT EXISTS so it also applies cleanly to
+-- databases created before migrations existed.
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	session_id TEXT,
+	avatar_url TEXT,
+	role TEXT NOT NULL DEFAULT 'user',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider TEXT NOT NULL,
+	provider_user_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	avatar_url TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (provider, provider_user_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	token_hash TEXT UNIQUE NOT NULL,
+	user_id INTEGER NOT NULL,
+	user_agent TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME,
+	expires_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS friends (
+	user_id INTEGER NOT NULL,
+	friend_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, friend_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (friend_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	read_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS decks (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	raw_text TEXT NOT NULL,
+	entries TEXT NOT NULL,
+	is_public INTEGER DEFAULT 0,
+	forked_from TEXT,
+	forked_from_name TEXT,
+	forked_from_author TEXT,
+	format TEXT,
+	share_token TEXT,
+	folder_id TEXT,
+	position INTEGER DEFAULT 0,
+	cover_card TEXT,
+	cover_image_url TEXT,
+	is_precon INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS deck_folders (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	position INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS deck_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deck_id TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	raw_text TEXT NOT NULL,
+	entries TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (deck_id, revision),
+	FOREIGN KEY (deck_id) REFERENCES decks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS deck_likes (
+	deck_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (deck_id, user_id),
+	FOREIGN KEY (deck_id) REFERENCES decks(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS deck_comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deck_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (deck_id) REFERENCES decks(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS deck_tags (
+	deck_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (deck_id, tag),
+	FOREIGN KEY (deck_id) REFERENCES decks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS deck_cards (
+	deck_id TEXT NOT NULL,
+	name_normalized TEXT NOT NULL,
+	quantity INTEGER NOT NULL DEFAULT 1,
+	PRIMARY KEY (deck_id, name_normalized),
+	FOREIGN KEY (deck_id) REFERENCES decks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+	room_id TEXT PRIMARY KEY,
+	board_state TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 0,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS room_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	event_data TEXT NOT NULL,
+	player_id TEXT,
+	player_name TEXT,
+	user_id INTEGER,
+	reverted_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS room_snapshots (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id TEXT NOT NULL,
+	state TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 0,
+	last_event_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS room_participants (
+	room_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	player_id TEXT NOT NULL,
+	player_name TEXT NOT NULL,
+	role TEXT NOT NULL,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (room_id, user_id),
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_friends_friend_id ON friends(friend_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, read_at);
+CREATE INDEX IF NOT EXISTS idx_decks_user_id ON decks(user_id);
+CREATE INDEX IF NOT EXISTS idx_decks_is_public ON decks(is_public);
+CREATE INDEX IF NOT EXISTS idx_decks_folder_id ON decks(folder_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_decks_share_token ON decks(share_token);
+CREATE INDEX IF NOT EXISTS idx_deck_revisions_deck_id ON deck_revisions(deck_id);
+CREATE INDEX IF NOT EXISTS idx_deck_comments_deck_id ON deck_comments(deck_id);
+CREATE INDEX IF NOT EXISTS idx_deck_tags_tag ON deck_tags(tag);
+CREATE INDEX IF NOT EXISTS idx_deck_cards_name ON deck_cards(name_normalized);
+CREATE INDEX IF NOT EXISTS idx_rooms_updated_at ON rooms(updated_at);
+CREATE INDEX IF NOT EXISTS idx_room_events_room_id ON room_events(room_id);
+CREATE INDEX IF NOT EXISTS idx_room_events_created_at ON room_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_room_snapshots_room_id ON room_snapshots(room_id);
+CREATE INDEX IF NOT EXISTS idx_room_participants_user_id ON room_participants(user_id);
+
+CREATE TABLE IF NOT EXISTS cards (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	name_normalized TEXT NOT NULL,
+	set_code TEXT,
+	collector_number TEXT,
+	type_line TEXT,
+	mana_cost TEXT,
+	oracle_text TEXT,
+	image_url TEXT,
+	back_image_url TEXT,
+	set_name TEXT,
+	layout TEXT,
+	prints_search_uri TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_cards_name_normalized ON cards(name_normalized);
+CREATE INDEX IF NOT EXISTS idx_cards_set_collector ON cards(set_code, collector_number);
+
+CREATE TABLE IF NOT EXISTS ui_configs (
+	name TEXT PRIMARY KEY,
+	payload TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,14 @@
+-- Outgoing webhooks configured by admins. The secret is kept in the clear
+-- since every delivery is signed with it.
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_delivery_at DATETIME,
+	last_status INTEGER,
+	last_error TEXT
+);
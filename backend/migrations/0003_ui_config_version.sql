@@ -0,0 +1,3 @@
+-- A version per UI config, bumped on every update and served as its ETag.
+
+ALTER TABLE ui_configs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,15 @@
+-- Images users upload to customize their seat: at most one per kind
+-- (playmat, card-back). name is the stored object, whose URL depends on the
+-- configured media store.
+
+CREATE TABLE IF NOT EXISTS user_media (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	name TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size_bytes INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, kind)
+);
@@ -0,0 +1,14 @@
+-- Human-readable lines derived from room events. Unlike room_events they are
+-- kept when a snapshot compacts the events they came from.
+
+CREATE TABLE IF NOT EXISTS room_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id TEXT NOT NULL,
+	event_id INTEGER,
+	actor TEXT NOT NULL,
+	message TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_log_room_id ON room_log(room_id, id);
@@ -0,0 +1,3 @@
+-- Plane and scheme cards are listed by layout for supplemental decks.
+
+CREATE INDEX IF NOT EXISTS idx_cards_layout ON cards(layout, name_normalized);
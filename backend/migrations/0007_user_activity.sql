@@ -0,0 +1,10 @@
+-- When each user last signed in, and one row per user per day they made an
+-- authenticated request, for the admin dashboard.
+
+ALTER TABLE users ADD COLUMN last_login_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS user_activity (
+	day TEXT NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	PRIMARY KEY (day, user_id)
+);
@@ -0,0 +1,20 @@
+-- Accounts an admin suspended until a date or banned outright, and the audit
+-- log of those actions. The log keeps the names so it still reads after an
+-- account is deleted.
+
+ALTER TABLE users ADD COLUMN suspended_until DATETIME;
+ALTER TABLE users ADD COLUMN banned_at DATETIME;
+ALTER TABLE users ADD COLUMN suspension_reason TEXT;
+
+CREATE TABLE IF NOT EXISTS admin_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	admin_name TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	target_name TEXT,
+	reason TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_created ON admin_audit(created_at);
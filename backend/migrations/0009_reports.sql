@@ -0,0 +1,21 @@
+-- Reports users file about decks, usernames and deck comments, and how an
+-- admin closed them. content keeps what was reported as it read at the time.
+
+CREATE TABLE IF NOT EXISTS reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	reporter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	target_id TEXT NOT NULL,
+	target_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	content TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'open',
+	action TEXT,
+	resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	resolved_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, id);
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports(kind, target_id);
+CREATE INDEX IF NOT EXISTS idx_reports_reporter ON reports(reporter_id, id);
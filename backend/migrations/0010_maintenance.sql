@@ -0,0 +1,10 @@
+-- The announced maintenance window, one row at most. Times are RFC 3339.
+
+CREATE TABLE IF NOT EXISTS maintenance (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	enabled INTEGER NOT NULL DEFAULT 0,
+	message TEXT,
+	starts_at TEXT,
+	ends_at TEXT,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+-- Scryfall's art and border crops of each card's front face, filled in by
+-- the next card import.
+
+ALTER TABLE cards ADD COLUMN art_crop_url TEXT;
+ALTER TABLE cards ADD COLUMN border_crop_url TEXT;
@@ -0,0 +1,16 @@
+-- Each format's banned and restricted cards. Rows with source 'import' come
+-- from the legalities in cards.json and are replaced by every card import;
+-- 'admin' rows are house rulings, including 'allowed' ones that lift an
+-- imported ban. Cards are matched by their normalized name.
+
+CREATE TABLE IF NOT EXISTS format_bans (
+	format TEXT NOT NULL,
+	name_normalized TEXT NOT NULL,
+	card_name TEXT NOT NULL,
+	status TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT 'import',
+	reason TEXT,
+	updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (format, name_normalized)
+);
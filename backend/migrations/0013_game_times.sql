@@ -0,0 +1,18 @@
+-- Each player's time as the active player in a finished game, stored with
+-- the game's GAME_RESULT by rooms whose turns the server tracks. event_id is
+-- the GAME_RESULT event; it has no foreign key because snapshots compact
+-- events away.
+
+CREATE TABLE IF NOT EXISTS game_times (
+	event_id INTEGER NOT NULL,
+	room_id TEXT NOT NULL,
+	player_name TEXT NOT NULL,
+	turns INTEGER NOT NULL,
+	total_ms INTEGER NOT NULL,
+	longest_ms INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (event_id, player_name),
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_game_times_room_id ON game_times(room_id);
@@ -0,0 +1,33 @@
+-- Games a group schedules, and its members' RSVPs. room_id and
+-- room_password are set when the game's start time comes; reminded_at when
+-- its reminder was sent. Times are UTC, in SQLite's datetime format.
+
+CREATE TABLE IF NOT EXISTS group_events (
+	id TEXT PRIMARY KEY,
+	group_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	format TEXT,
+	starts_at DATETIME NOT NULL,
+	max_seats INTEGER NOT NULL,
+	created_by INTEGER,
+	room_id TEXT,
+	room_password TEXT,
+	reminded_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS group_event_rsvps (
+	event_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (event_id, user_id),
+	FOREIGN KEY (event_id) REFERENCES group_events(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_events_group_id ON group_events(group_id, starts_at);
+CREATE INDEX IF NOT EXISTS idx_group_events_starts_at ON group_events(starts_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_group_events_room_id ON group_events(room_id);
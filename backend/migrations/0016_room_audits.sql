@@ -0,0 +1,17 @@
+-- Games dealt under the audit house rule: the seeds the turn order and
+-- libraries were shuffled with and the cards dealt, so the game's events can
+-- be checked against them later. They are kept apart from room_events so
+-- players cannot read each other's library order during the game. The
+-- game's events are those after after_event_id, up to the next audited deal.
+
+CREATE TABLE IF NOT EXISTS room_audits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id TEXT NOT NULL,
+	game INTEGER NOT NULL,
+	after_event_id INTEGER NOT NULL,
+	deal TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_audits_room_id ON room_audits(room_id, id);
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	notifyFriendRequest  = "friend_request"
+	notifyFriendAccepted = "friend_accepted"
+	notifyDeckComment    = "deck_comment"
+	notifyRoomInvite     = "room_invite"
+	notifyReportClosed   = "report_closed"
+)
+
+type markNotificationsPayload struct {
+	IDs []int64 `json:"ids"`
+}
+
+type roomInvitePayload struct {
+	RoomID string `json:"roomId"`
+}
+
+// notify stores a notification for the user and pushes it as notify:<kind>
+// to any sockets they have open.
+func (a *App) notify(userID int64, kind string, payload map[string]interface{}) {
+	data := marshalPayload(payload)
+	result, err := a.db.Exec(`
+		INSERT INTO notifications (user_id, kind, payload)
+		VALUES (?, ?, ?)
+	`, userID, kind, string(data))
+	if err != nil {
+		log.Printf("[notify] failed to store %s for user %d: %v", kind, userID, err)
+		return
+	}
+	id, _ := result.LastInsertId()
+	a.sendToUser(userID, WSMessage{
+		Type: "notify:" + kind,
+		Payload: marshalPayload(map[string]interface{}{
+			"id":      id,
+			"kind":    kind,
+			"payload": data,
+		}),
+	})
+}
+
+func (a *App) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 100 || limit <= 0 {
+		limit = 100
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	where := "user_id = ?"
+	if r.URL.Query().Get("unread") == "1" || r.URL.Query().Get("unread") == "true" {
+		where += " AND read_at IS NULL"
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, kind, payload, read_at, created_at
+		FROM notifications
+		WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, user.ID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load notifications")
+		return
+	}
+	defer rows.Close()
+	notifications := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var kind, payload, createdAt string
+		var readAt sql.NullString
+		if err := rows.Scan(&id, &kind, &payload, &readAt, &createdAt); err != nil {
+			continue
+		}
+		notifications = append(notifications, map[string]interface{}{
+			"id":        id,
+			"kind":      kind,
+			"payload":   json.RawMessage(payload),
+			"read":      readAt.Valid,
+			"readAt":    nullStringToPtr(readAt),
+			"createdAt": createdAt,
+		})
+	}
+	var unread int
+	_ = a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, user.ID).Scan(&unread)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"unreadCount":   unread,
+	})
+}
+
+// handleMarkNotificationsRead marks the listed notifications read, or all of
+// them when no ids are given.
+func (a *App) handleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload markNotificationsPayload
+	if err := decodeJSON(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	query := `UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL`
+	args := []interface{}{user.ID}
+	if len(payload.IDs) > 0 {
+		placeholders := make([]string, len(payload.IDs))
+		for i, id := range payload.IDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		query += ` AND id IN (` + strings.Join(placeholders, ",") + `)`
+	}
+	result, err := a.db.ExecContext(r.Context(), query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to update notifications")
+		return
+	}
+	updated, _ := result.RowsAffected()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "updated": updated})
+}
+
+func (a *App) handleDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "notificationId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid notification id")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM notifications WHERE id = ? AND user_id = ?`, id, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete notification")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Notification not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleRoomInvite invites a friend into a room the caller is currently in.
+func (a *App) handleRoomInvite(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	friendID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id")
+		return
+	}
+	var payload roomInvitePayload
+	if err := decodeJSON(r, &payload); err != nil || strings.TrimSpace(payload.RoomID) == "" {
+		writeBodyError(w, err, "roomId is required")
+		return
+	}
+	if !containsInt64(a.friendIDs(user.ID), friendID) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Friend not found")
+		return
+	}
+	if _, roomID := a.presence.status(user.ID); roomID != payload.RoomID {
+		writeError(w, http.StatusForbidden, codeForbidden, "You are not in that room")
+		return
+	}
+	a.notify(friendID, notifyRoomInvite, map[string]interface{}{
+		"userId":   user.ID,
+		"username": user.Username,
+		"roomId":   payload.RoomID,
+	})
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func containsInt64(values []int64, target int64) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	oauthStateCookie = "oauthState"
+	oauthStateMaxAge = 10 * 60
+	// oauthPath is where the flows are served, under the versioned API so
+	// that they keep working once the legacy aliases are turned off.
+	oauthPath = apiV1Prefix + "/auth/"
+)
+
+var oauthUsernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+type oauthProvider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthorizeURL string
+	TokenURL     string
+	ProfileURL   string
+	Scopes       []string
+	parseProfile func(data []byte) (oauthProfile, error)
+}
+
+type oauthProfile struct {
+	ID        string
+	Username  string
+	AvatarURL string
+}
+
+// oauthConfig holds the providers with credentials configured in the
+// environment. Callbacks are served from OAUTH_REDIRECT_BASE, which must be
+// the backend's public URL; each provider must have
+// <OAUTH_REDIRECT_BASE>/api/v1/auth/<provider>/callback registered.
+type oauthConfig struct {
+	providers       map[string]*oauthProvider
+	redirectBase    string
+	successRedirect string
+	httpClient      *http.Client
+}
+
+func loadOAuthConfig() *oauthConfig {
+	config := &oauthConfig{
+		providers:       make(map[string]*oauthProvider),
+		redirectBase:    strings.TrimRight(strings.TrimSpace(os.Getenv("OAUTH_REDIRECT_BASE")), "/"),
+		successRedirect: strings.TrimSpace(os.Getenv("OAUTH_SUCCESS_REDIRECT")),
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+	}
+	if config.successRedirect == "" {
+		config.successRedirect = "/"
+	}
+	for _, provider := range []*oauthProvider{
+		{
+			Name:         "discord",
+			ClientID:     os.Getenv("DISCORD_CLIENT_ID"),
+			ClientSecret: os.Getenv("DISCORD_CLIENT_SECRET"),
+			AuthorizeURL: "https://discord.com/oauth2/authorize",
+			TokenURL:     "https://discord.com/api/oauth2/token",
+			ProfileURL:   "https://discord.com/api/users/@me",
+			Scopes:       []string{"identify"},
+			parseProfile: parseDiscordProfile,
+		},
+		{
+			Name:         "google",
+			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			AuthorizeURL: "https://accounts.google.com/o/oauth2/v2/auth",
+			TokenURL:     "https://oauth2.googleapis.com/token",
+			ProfileURL:   "https://openidconnect.googleapis.com/v1/userinfo",
+			Scopes:       []string{"openid", "profile"},
+			parseProfile: parseGoogleProfile,
+		},
+	} {
+		if provider.ClientID == "" || provider.ClientSecret == "" {
+			continue
+		}
+		config.providers[provider.Name] = provider
+		log.Printf("[auth] %s login enabled", provider.Name)
+	}
+	return config
+}
+
+func (c *oauthConfig) callbackURL(provider *oauthProvider) string {
+	return c.redirectBase + oauthPath + provider.Name + "/callback"
+}
+
+func parseDiscordProfile(data []byte) (oauthProfile, error) {
+	var profile struct {
+		ID         string `json:"id"`
+		Username   string `json:"username"`
+		GlobalName string `json:"global_name"`
+		Avatar     string `json:"avatar"`
+	}
+	if err := json.Unmarshal(data, &profile); err != nil || profile.ID == "" {
+		return oauthProfile{}, errors.New("invalid discord profile")
+	}
+	result := oauthProfile{ID: profile.ID, Username: profile.Username}
+	if profile.GlobalName != "" {
+		result.Username = profile.GlobalName
+	}
+	if profile.Avatar != "" {
+		result.AvatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", profile.ID, profile.Avatar)
+	}
+	return result, nil
+}
+
+func parseGoogleProfile(data []byte) (oauthProfile, error) {
+	var profile struct {
+		Sub     string `json:"sub"`
+		Name    string `json:"name"`
+		Picture string `json:"picture"`
+	}
+	if err := json.Unmarshal(data, &profile); err != nil || profile.Sub == "" {
+		return oauthProfile{}, errors.New("invalid google profile")
+	}
+	return oauthProfile{ID: profile.Sub, Username: profile.Name, AvatarURL: profile.Picture}, nil
+}
+
+func (a *App) handleOAuthStart(w http.ResponseWriter, r *http.Request) {
+	provider := a.oauth.providers[chi.URLParam(r, "provider")]
+	if provider == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Login provider not configured")
+		return
+	}
+	state := randomID(16)
+	// The state cookie must survive the cross-site redirect back from the
+	// provider, so it stays Lax whatever COOKIE_SAMESITE says.
+	a.setCookie(w, r, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		HttpOnly: true,
+		MaxAge:   oauthStateMaxAge,
+		SameSite: http.SameSiteLaxMode,
+		Path:     oauthPath,
+	})
+	query := url.Values{}
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", a.oauth.callbackURL(provider))
+	query.Set("response_type", "code")
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	http.Redirect(w, r, provider.AuthorizeURL+"?"+query.Encode(), http.StatusFound)
+}
+
+// handleOAuthCallback finishes the flow. A request that already carries a
+// valid session links the identity to that account; otherwise the identity
+// logs into its linked account, creating one on first use.
+func (a *App) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider := a.oauth.providers[chi.URLParam(r, "provider")]
+	if provider == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Login provider not configured")
+		return
+	}
+	stateCookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || stateCookie.Value == "" || stateCookie.Value != r.URL.Query().Get("state") {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid OAuth state")
+		return
+	}
+	a.setCookie(w, r, &http.Cookie{Name: oauthStateCookie, Value: "", MaxAge: -1, SameSite: http.SameSiteLaxMode, Path: oauthPath})
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing authorization code")
+		return
+	}
+	profile, err := a.oauth.fetchProfile(provider, code)
+	if err != nil {
+		log.Printf("[auth] %s login failed: %v", provider.Name, err)
+		writeError(w, http.StatusBadGateway, codeUpstreamFailed, "Login provider request failed")
+		return
+	}
+	current, _ := a.userFromRequest(r)
+	userID, err := a.resolveOAuthUser(provider.Name, profile, current)
+	if err != nil {
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if current == nil || current.ID != userID {
+		if restriction := a.accountRestriction(r.Context(), userID); restriction != nil {
+			writeAccountRestricted(w, restriction)
+			return
+		}
+		if a.refuseLoginDuringMaintenance(w, r, userID) {
+			return
+		}
+		if err := a.startSession(w, r, userID); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Login failed")
+			return
+		}
+	}
+	http.Redirect(w, r, a.oauth.successRedirect, http.StatusFound)
+}
+
+func (c *oauthConfig) fetchProfile(provider *oauthProvider, code string) (oauthProfile, error) {
+	form := url.Values{}
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.callbackURL(provider))
+	req, err := http.NewRequest(http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := c.doJSON(req, &token); err != nil {
+		return oauthProfile{}, err
+	}
+	if token.AccessToken == "" {
+		return oauthProfile{}, errors.New("no access token returned")
+	}
+	req, err = http.NewRequest(http.MethodGet, provider.ProfileURL, nil)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	var raw json.RawMessage
+	if err := c.doJSON(req, &raw); err != nil {
+		return oauthProfile{}, err
+	}
+	return provider.parseProfile(raw)
+}
+
+func (c *oauthConfig) doJSON(req *http.Request, target interface{}) error {
+	req.Header.Set("User-Agent", "MTOnline/1.0")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (a *App) resolveOAuthUser(provider string, profile oauthProfile, current *User) (int64, error) {
+	var linkedID int64
+	err := a.db.QueryRow(`
+		SELECT user_id FROM user_identities
+		WHERE provider = ? AND provider_user_id = ?
+	`, provider, profile.ID).Scan(&linkedID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err == nil {
+		if current != nil && current.ID != linkedID {
+			return 0, errors.New("This account is already linked to another user")
+		}
+		_, _ = a.db.Exec(`
+			UPDATE user_identities SET avatar_url = ?
+			WHERE provider = ? AND provider_user_id = ?
+		`, nullIfEmpty(profile.AvatarURL), provider, profile.ID)
+		return linkedID, nil
+	}
+	var userID int64
+	if current != nil {
+		userID = current.ID
+	} else if userID, err = a.createOAuthUser(profile); err != nil {
+		return 0, err
+	}
+	if _, err := a.db.Exec(`
+		INSERT INTO user_identities (provider, provider_user_id, user_id, avatar_url)
+		VALUES (?, ?, ?, ?)
+	`, provider, profile.ID, userID, nullIfEmpty(profile.AvatarURL)); err != nil {
+		return 0, err
+	}
+	if profile.AvatarURL != "" {
+		_, _ = a.db.Exec(`UPDATE users SET avatar_url = ? WHERE id = ? AND avatar_url IS NULL`, profile.AvatarURL, userID)
+	}
+	return userID, nil
+}
+
+// createOAuthUser registers an account without a password, deriving a
+// unique username from the provider's display name.
+func (a *App) createOAuthUser(profile oauthProfile) (int64, error) {
+	base := oauthUsernameUnsafe.ReplaceAllString(profile.Username, "")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if len(base) < 3 || a.words.blocked(base) {
+		base = "player"
+	}
+	for attempt := 0; attempt < 20; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = fmt.Sprintf("%s%d", base, attempt+1)
+		}
+		result, err := a.db.Exec(`
+			INSERT INTO users (username, password_hash, avatar_url)
+			VALUES (?, '', ?)
+		`, username, nullIfEmpty(profile.AvatarURL))
+		if err == nil {
+			return result.LastInsertId()
+		}
+		if !strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, err
+		}
+	}
+	return 0, errors.New("Could not pick a username")
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// objectStore keeps the files the server writes besides its database:
+// uploaded images, database backups and stored replay exports. Names may
+// contain slashes, which the disk store turns into directories.
+type objectStore interface {
+	put(ctx context.Context, name string, contentType string, body io.ReadSeeker) error
+	get(ctx context.Context, name string) (io.ReadCloser, error)
+	remove(ctx context.Context, name string) error
+	// list returns the names starting with prefix in lexical order.
+	list(ctx context.Context, prefix string) ([]string, error)
+	// location describes where an object is kept, for logs and admins.
+	location(name string) string
+}
+
+// s3Settings reach an S3-compatible bucket such as AWS S3 or MinIO.
+type s3Settings struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// envS3Settings reads <prefix>_S3_ENDPOINT, _BUCKET, _REGION,
+// _ACCESS_KEY_ID and _SECRET_ACCESS_KEY.
+func envS3Settings(prefix string) s3Settings {
+	env := func(name string) string {
+		return strings.TrimSpace(os.Getenv(prefix + "_S3_" + name))
+	}
+	return s3Settings{
+		endpoint:  env("ENDPOINT"),
+		bucket:    env("BUCKET"),
+		region:    env("REGION"),
+		accessKey: env("ACCESS_KEY_ID"),
+		secretKey: env("SECRET_ACCESS_KEY"),
+	}
+}
+
+// loadObjectStore picks where one kind of file goes. With STORAGE_S3_BUCKET
+// set, every kind shares that bucket under its own key prefix, so a
+// containerized deployment needs no persistent volume besides the
+// database's; otherwise files are written under dir.
+func loadObjectStore(dir string, prefix string) (objectStore, error) {
+	settings := envS3Settings("STORAGE")
+	if settings.bucket == "" {
+		return &diskObjectStore{dir: dir}, nil
+	}
+	return newS3ObjectStore(settings, prefix, "STORAGE")
+}
+
+func newS3ObjectStore(settings s3Settings, prefix string, envPrefix string) (*s3ObjectStore, error) {
+	endpoint, err := url.Parse(strings.TrimRight(settings.endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("%s_S3_ENDPOINT must be an http or https URL when %s_S3_BUCKET is set", envPrefix, envPrefix)
+	}
+	if settings.accessKey == "" || settings.secretKey == "" {
+		return nil, fmt.Errorf("%s_S3_ACCESS_KEY_ID and %s_S3_SECRET_ACCESS_KEY are required when %s_S3_BUCKET is set", envPrefix, envPrefix, envPrefix)
+	}
+	if settings.region == "" {
+		settings.region = "us-east-1"
+	}
+	return &s3ObjectStore{
+		endpoint:  endpoint,
+		bucket:    settings.bucket,
+		prefix:    prefix,
+		region:    settings.region,
+		accessKey: settings.accessKey,
+		secretKey: settings.secretKey,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+type diskObjectStore struct {
+	dir string
+}
+
+func (s *diskObjectStore) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// put writes the object under a temporary name and renames it once complete,
+// so a reader never sees half a file.
+func (s *diskObjectStore) put(_ context.Context, name string, _ string, body io.ReadSeeker) error {
+	target := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	temp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	out, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		out.Close()
+		_ = os.Remove(temp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, target)
+}
+
+func (s *diskObjectStore) get(_ context.Context, name string) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	return file, err
+}
+
+func (s *diskObjectStore) remove(_ context.Context, name string) error {
+	err := os.Remove(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *diskObjectStore) list(_ context.Context, prefix string) ([]string, error) {
+	dir, _ := filepath.Split(filepath.FromSlash(prefix))
+	entries, err := os.ReadDir(filepath.Join(s.dir, dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := filepath.ToSlash(filepath.Join(dir, entry.Name()))
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *diskObjectStore) location(name string) string {
+	return s.path(name)
+}
+
+// s3ObjectStore keeps objects under prefix in a bucket, addressed
+// path-style so that MinIO and other S3-compatible stores work as well.
+type s3ObjectStore struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (s *s3ObjectStore) put(ctx context.Context, name string, contentType string, body io.ReadSeeker) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := s.request(ctx, http.MethodPut, s.prefix+name, nil, body, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if strings.HasPrefix(contentType, "image/") {
+		req.Header.Set("Cache-Control", mediaCacheControl)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3ObjectStore) get(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, s.prefix+name, nil, nil, sha256Hex(nil))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3ObjectStore) remove(ctx context.Context, name string) error {
+	req, err := s.request(ctx, http.MethodDelete, s.prefix+name, nil, nil, sha256Hex(nil))
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// list pages through ListObjectsV2.
+func (s *s3ObjectStore) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.request(ctx, http.MethodGet, "", query, nil, sha256Hex(nil))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, s.prefix))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *s3ObjectStore) location(name string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + name
+}
+
+// request builds a path-style request for the object, or for the bucket when
+// key is empty, signed with AWS Signature Version 4, which every
+// S3-compatible store accepts.
+func (s *s3ObjectStore) request(ctx context.Context, method string, key string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	target := *s.endpoint
+	target.Path = strings.TrimRight(target.Path, "/") + "/" + s.bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+	// SigV4 wants the query sorted and encoded with %20 for spaces.
+	target.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		method,
+		target.EscapedPath(),
+		target.RawQuery,
+		"host:" + target.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signingKey := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+	return req, nil
+}
+
+// do sends the request and returns the response when it succeeded; the
+// caller closes its body.
+func (s *s3ObjectStore) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodGet && req.URL.RawQuery == "" {
+		resp.Body.Close()
+		return nil, errObjectNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s %s: HTTP %d %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The OpenAPI document is generated from the router itself: every /api/v1
+// route appears in it, and apiOperations adds what the router cannot know
+// (a summary, who may call it and the Go types it reads and writes). A route
+// without an entry is still listed, so a new endpoint is never silently
+// missing from the document, only undescribed.
+
+type apiAuth int
+
+const (
+	authNone     apiAuth = iota
+	authOptional         // anonymous, but a session or token changes the result
+	authUser
+	authAdmin
+	authRoom
+	authRoomToken // a current room token; admins may use their session
+)
+
+type apiParam struct {
+	name        string
+	kind        string // OpenAPI scalar type; "array" is a repeated string
+	description string
+}
+
+type apiOperation struct {
+	tag      string
+	summary  string
+	auth     apiAuth
+	query    []apiParam
+	request  interface{}
+	response interface{}
+}
+
+// deckSchema, deckListSchema and successSchema stand in for responses the
+// handlers build as maps rather than structs; imageBody for a request body
+// that is a raw image rather than JSON.
+type deckSchema struct{}
+type deckListSchema struct{}
+type successSchema struct{}
+type formatBanlistSchema struct {
+	Format string      `json:"format"`
+	Cards  []formatBan `json:"cards"`
+}
+type imageBody struct{}
+
+var (
+	paginationParams = []apiParam{
+		{"limit", "integer", "page size"},
+		{"offset", "integer", "number of results to skip"},
+	}
+	publicDeckParams = []apiParam{
+		{"format", "string", "only decks of this format"},
+		{"tag", "array", "only decks carrying every given tag"},
+	}
+	imageSizeParam = apiParam{"size", "string", "image size: small, normal (default), large, art_crop or border_crop"}
+)
+
+var apiOperations = map[string]apiOperation{
+	"POST /register":                            {tag: "account", summary: "Create an account and start a session", request: authPayload{}, response: User{}},
+	"POST /login":                               {tag: "account", summary: "Start a session", request: authPayload{}, response: User{}},
+	"POST /logout":                              {tag: "account", summary: "End the current session", auth: authUser, response: successSchema{}},
+	"GET /me":                                   {tag: "account", summary: "The signed-in user", auth: authUser},
+	"GET /auth/{provider}":                      {tag: "account", summary: "Redirect to an OAuth provider"},
+	"GET /auth/{provider}/callback":             {tag: "account", summary: "OAuth provider callback"},
+	"GET /maintenance":                          {tag: "account", summary: "The announced maintenance window, for a banner", response: maintenanceWindow{}},
+	"GET /me/settings":                          {tag: "account", summary: "The signed-in user's playmat and card back", auth: authUser},
+	"PUT /me/media/{kind}":                      {tag: "account", summary: "Upload a playmat or card-back image (PNG, JPEG or WebP) as the request body", auth: authUser, request: imageBody{}},
+	"DELETE /me/media/{kind}":                   {tag: "account", summary: "Remove an uploaded playmat or card back", auth: authUser, response: successSchema{}},
+	"GET /me/tokens":                            {tag: "account", summary: "List API tokens", auth: authUser},
+	"POST /me/tokens":                           {tag: "account", summary: "Create an API token; the secret is only returned once", auth: authUser, request: createAPITokenPayload{}},
+	"DELETE /me/tokens/{tokenId}":               {tag: "account", summary: "Revoke an API token", auth: authUser},
+	"GET /me/notifications":                     {tag: "account", summary: "List notifications", auth: authUser, query: append([]apiParam{{"unread", "boolean", "only unread notifications"}}, paginationParams...)},
+	"GET /reports":                              {tag: "account", summary: "Your reports and what became of them", auth: authUser, query: paginationParams},
+	"POST /reports":                             {tag: "account", summary: "Report an offensive deck name, username or deck comment", auth: authUser, request: reportPayload{}},
+	"POST /me/notifications/read":               {tag: "account", summary: "Mark notifications read", auth: authUser, request: markNotificationsPayload{}},
+	"DELETE /me/notifications/{notificationId}": {tag: "account", summary: "Delete a notification", auth: authUser},
+	"GET /me/matches":                           {tag: "account", summary: "Match history", auth: authUser},
+	"GET /me/sessions":                          {tag: "account", summary: "List signed-in sessions", auth: authUser},
+	"DELETE /me/sessions":                       {tag: "account", summary: "Sign out every other session", auth: authUser},
+	"DELETE /me/sessions/{sessionId}":           {tag: "account", summary: "Sign out one session", auth: authUser},
+
+	"GET /groups":                                 {tag: "groups", summary: "List your groups", auth: authUser},
+	"POST /groups":                                {tag: "groups", summary: "Create a group", auth: authUser, request: groupPayload{}},
+	"POST /groups/join":                           {tag: "groups", summary: "Join a group with its invite code", auth: authUser, request: joinGroupPayload{}},
+	"GET /groups/{groupId}":                       {tag: "groups", summary: "Get a group and its members", auth: authUser},
+	"PUT /groups/{groupId}":                       {tag: "groups", summary: "Rename or describe a group", auth: authUser, request: groupPayload{}},
+	"DELETE /groups/{groupId}":                    {tag: "groups", summary: "Delete a group", auth: authUser},
+	"POST /groups/{groupId}/invite":               {tag: "groups", summary: "Replace the invite code", auth: authUser},
+	"DELETE /groups/{groupId}/members/{userId}":   {tag: "groups", summary: "Leave a group or remove a member", auth: authUser},
+	"GET /groups/{groupId}/decks":                 {tag: "groups", summary: "List decks shared with a group", auth: authUser},
+	"PUT /groups/{groupId}/decks/{deckId}":        {tag: "groups", summary: "Share a deck with a group", auth: authUser},
+	"DELETE /groups/{groupId}/decks/{deckId}":     {tag: "groups", summary: "Stop sharing a deck with a group", auth: authUser},
+	"GET /groups/{groupId}/rooms":                 {tag: "groups", summary: "List a group's open rooms", auth: authUser},
+	"GET /groups/{groupId}/events":                {tag: "groups", summary: "List a group's scheduled games", auth: authUser},
+	"POST /groups/{groupId}/events":               {tag: "groups", summary: "Schedule a game", auth: authUser, request: groupEventPayload{}},
+	"GET /groups/{groupId}/events/{eventId}":      {tag: "groups", summary: "Get a scheduled game", auth: authUser},
+	"DELETE /groups/{groupId}/events/{eventId}":   {tag: "groups", summary: "Cancel a scheduled game", auth: authUser},
+	"PUT /groups/{groupId}/events/{eventId}/rsvp": {tag: "groups", summary: "RSVP to a scheduled game", auth: authUser, request: rsvpPayload{}},
+
+	"GET /friends":                           {tag: "friends", summary: "List friends and pending requests", auth: authUser},
+	"POST /friends/requests":                 {tag: "friends", summary: "Send a friend request", auth: authUser, request: friendRequestPayload{}},
+	"POST /friends/requests/{userId}/accept": {tag: "friends", summary: "Accept a friend request", auth: authUser},
+	"DELETE /friends/{userId}":               {tag: "friends", summary: "Remove a friend", auth: authUser},
+	"POST /friends/{userId}/invite":          {tag: "friends", summary: "Invite a friend to a room", auth: authUser, request: roomInvitePayload{}},
+
+	"GET /decks":                              {tag: "decks", summary: "List your decks", auth: authUser, response: deckListSchema{}},
+	"POST /decks":                             {tag: "decks", summary: "Create a deck", auth: authUser, request: createDeckPayload{}, response: deckSchema{}},
+	"POST /decks/bulk":                        {tag: "decks", summary: "Import many decks at once, with a result per deck", auth: authUser, request: bulkDeckPayload{}, response: bulkDeckResponse{}},
+	"GET /decks/public":                       {tag: "decks", summary: "Browse public decks", auth: authOptional, query: append(append([]apiParam{}, publicDeckParams...), paginationParams...), response: deckListSchema{}},
+	"GET /decks/public/facets":                {tag: "decks", summary: "Tag and format counts for public decks", query: publicDeckParams},
+	"GET /decks/search":                       {tag: "decks", summary: "Search public decks by name, author or card", query: append([]apiParam{{"q", "string", "search text"}}, paginationParams...), response: deckListSchema{}},
+	"GET /decks/precons":                      {tag: "decks", summary: "List preconstructed decks", query: []apiParam{{"format", "string", "only decks of this format"}}, response: deckListSchema{}},
+	"POST /decks/precons/{id}/copy":           {tag: "decks", summary: "Copy a preconstructed deck into your decks", auth: authUser, response: deckSchema{}},
+	"GET /decks/folders":                      {tag: "decks", summary: "List your deck folders", auth: authUser},
+	"POST /decks/folders":                     {tag: "decks", summary: "Create a deck folder", auth: authUser, request: deckFolderPayload{}},
+	"POST /decks/folders/reorder":             {tag: "decks", summary: "Reorder deck folders", auth: authUser, request: reorderPayload{}, response: successSchema{}},
+	"PUT /decks/folders/{folderId}":           {tag: "decks", summary: "Rename a deck folder", auth: authUser, request: deckFolderPayload{}, response: successSchema{}},
+	"DELETE /decks/folders/{folderId}":        {tag: "decks", summary: "Delete a deck folder; its decks move to the top level", auth: authUser, response: successSchema{}},
+	"POST /decks/reorder":                     {tag: "decks", summary: "Reorder decks within a folder", auth: authUser, request: reorderPayload{}, response: successSchema{}},
+	"GET /decks/shared/{shareToken}":          {tag: "decks", summary: "Read a deck through its share link", response: deckSchema{}},
+	"POST /decks/shared/{shareToken}/copy":    {tag: "decks", summary: "Copy a shared deck into your decks", auth: authUser, response: deckSchema{}},
+	"POST /decks/parse":                       {tag: "decks", summary: "Parse a text decklist and resolve its cards", request: parseDecklistPayload{}},
+	"PUT /decks/{id}":                         {tag: "decks", summary: "Update a deck; omitted fields are left unchanged", auth: authUser, request: updateDeckPayload{}, response: deckSchema{}},
+	"DELETE /decks/{id}":                      {tag: "decks", summary: "Delete a deck", auth: authUser, response: successSchema{}},
+	"POST /decks/{id}/copy":                   {tag: "decks", summary: "Copy a deck", auth: authUser, request: copyDeckPayload{}, response: deckSchema{}},
+	"POST /decks/{id}/move":                   {tag: "decks", summary: "Move a deck to a folder or position", auth: authUser, request: moveDeckPayload{}, response: successSchema{}},
+	"POST /decks/{id}/precon":                 {tag: "decks", summary: "Mark a deck as a precon (precon owner only)", auth: authUser},
+	"DELETE /decks/{id}/precon":               {tag: "decks", summary: "Unmark a precon (precon owner only)", auth: authUser},
+	"POST /decks/{id}/like":                   {tag: "decks", summary: "Like a deck", auth: authUser},
+	"DELETE /decks/{id}/like":                 {tag: "decks", summary: "Remove your like", auth: authUser},
+	"GET /decks/{id}/export":                  {tag: "decks", summary: "Export a deck as text", auth: authOptional, query: []apiParam{{"format", "string", "export format"}, {"download", "boolean", "send as an attachment"}}},
+	"GET /decks/{id}/sample-hand":             {tag: "decks", summary: "Draw a sample opening hand", auth: authOptional, query: []apiParam{{"mulligans", "integer", "number of mulligans taken"}}},
+	"GET /decks/{id}/comments":                {tag: "decks", summary: "List comments on a deck", auth: authOptional, query: paginationParams},
+	"POST /decks/{id}/comments":               {tag: "decks", summary: "Comment on a deck", auth: authUser, request: deckCommentPayload{}},
+	"DELETE /decks/{id}/comments/{commentId}": {tag: "decks", summary: "Delete a comment", auth: authUser},
+	"GET /decks/{id}/revisions":               {tag: "decks", summary: "List a deck's saved revisions", auth: authUser},
+	"POST /decks/{id}/revert/{revision}":      {tag: "decks", summary: "Restore a deck to an earlier revision", auth: authUser, response: deckSchema{}},
+
+	"GET /formats/{format}/banlist": {tag: "cards", summary: "A format's banned, restricted and house-allowed cards", response: formatBanlistSchema{}},
+
+	"GET /cards/search":                      {tag: "cards", summary: "Find a card by name", query: []apiParam{{"name", "string", "card name (required)"}, {"set", "string", "preferred set code"}, imageSizeParam}, response: cardResponse{}},
+	"GET /cards/prints":                      {tag: "cards", summary: "List every printing of a card", query: []apiParam{{"name", "string", "card name (required)"}, imageSizeParam}, response: []cardPrintResponse{}},
+	"GET /cards/supplemental":                {tag: "cards", summary: "List the plane or scheme cards a supplemental deck can use", query: []apiParam{{"kind", "string", "planechase or archenemy (required)"}}, response: []supplementalCard{}},
+	"GET /cards/{setCode}/{collectorNumber}": {tag: "cards", summary: "Look up a printing by set and collector number", query: []apiParam{imageSizeParam}, response: cardResponse{}},
+	"POST /cards/batch":                      {tag: "cards", summary: "Resolve many cards at once, with a room's custom cards when roomId is set; unresolved entries carry an error", query: []apiParam{imageSizeParam}, request: batchRequest{}},
+
+	"GET /admin/users":                       {tag: "admin", summary: "List users", auth: authAdmin, query: append([]apiParam{{"q", "string", "username filter"}}, paginationParams...)},
+	"PUT /admin/users/{userId}/role":         {tag: "admin", summary: "Change a user's role", auth: authAdmin, request: adminRolePayload{}},
+	"POST /admin/users/{userId}/suspend":     {tag: "admin", summary: "Suspend a user for a number of days, signing them out everywhere", auth: authAdmin, request: adminSuspendPayload{}},
+	"POST /admin/users/{userId}/ban":         {tag: "admin", summary: "Ban a user, signing them out everywhere", auth: authAdmin, request: adminSuspendPayload{}},
+	"POST /admin/users/{userId}/reinstate":   {tag: "admin", summary: "Lift a user's suspension or ban", auth: authAdmin, request: adminSuspendPayload{}},
+	"PUT /admin/maintenance":                 {tag: "admin", summary: "Announce or end maintenance mode; while active, sign-ins and new rooms are refused", auth: authAdmin, request: maintenancePayload{}, response: maintenanceWindow{}},
+	"GET /admin/reports":                     {tag: "admin", summary: "Moderation queue; open reports oldest first", auth: authAdmin, query: append([]apiParam{{"status", "string", "open (default), resolved or dismissed"}}, paginationParams...)},
+	"POST /admin/reports/{reportId}/resolve": {tag: "admin", summary: "Act on a report and close every open report about the same thing", auth: authAdmin, request: resolveReportPayload{}},
+	"GET /admin/audit":                       {tag: "admin", summary: "List moderation actions taken against users", auth: authAdmin, query: append([]apiParam{{"userId", "integer", "only entries about this user"}}, paginationParams...)},
+	"GET /admin/rooms":                       {tag: "admin", summary: "List live rooms, busiest first", auth: authAdmin},
+	"GET /admin/capacity":                    {tag: "admin", summary: "Rooms and players on this instance against MAX_ROOMS and MAX_CLIENTS", auth: authAdmin, response: capacityReport{}},
+	"GET /admin/rooms/{roomId}":              {tag: "admin", summary: "Inspect a room's members and saved state", auth: authAdmin},
+	"POST /admin/rooms/{roomId}/close":       {tag: "admin", summary: "Close a room and delete its saved state", auth: authAdmin, request: adminCloseRoomPayload{}},
+	"GET /admin/results":                     {tag: "admin", summary: "List the latest game results", auth: authAdmin, query: paginationParams},
+	"GET /admin/activity":                    {tag: "admin", summary: "Daily active users and games", auth: authAdmin, query: []apiParam{{"days", "integer", "how many days back, up to 365"}}},
+	"POST /admin/rooms/prune":                {tag: "admin", summary: "Delete idle rooms", auth: authAdmin, query: []apiParam{{"days", "integer", "idle days before a room is deleted"}}, response: roomPruneResult{}},
+	"POST /admin/cards/reload":               {tag: "admin", summary: "Re-import cards.json", auth: authAdmin},
+	"POST /admin/backup":                     {tag: "admin", summary: "Write a database backup now", auth: authAdmin, response: backupResult{}},
+	"GET /admin/webhooks":                    {tag: "admin", summary: "List webhooks", auth: authAdmin},
+	"POST /admin/webhooks":                   {tag: "admin", summary: "Add a webhook; the signing secret is only returned once", auth: authAdmin, request: webhookPayload{}},
+	"DELETE /admin/webhooks/{webhookId}":     {tag: "admin", summary: "Remove a webhook", auth: authAdmin, response: successSchema{}},
+	"POST /admin/webhooks/{webhookId}/test":  {tag: "admin", summary: "Send a ping to a webhook", auth: authAdmin},
+	"POST /admin/decks/{id}/takedown":        {tag: "admin", summary: "Make a deck private", auth: authAdmin},
+	"PUT /admin/formats/{format}/banlist":    {tag: "admin", summary: "Ban, restrict or allow a card in a format; kept across card imports", auth: authAdmin, request: formatBanPayload{}},
+	"DELETE /admin/formats/{format}/banlist": {tag: "admin", summary: "Take a card off a format's list; imported bans return with the next import", auth: authAdmin, query: []apiParam{{"card", "string", "card name (required)"}}, response: successSchema{}},
+
+	"GET /config/ui":  {tag: "config", summary: "Read the shared UI configuration"},
+	"POST /config/ui": {tag: "config", summary: "Replace the shared UI configuration", auth: authAdmin, response: successSchema{}},
+
+	"GET /rooms/emotes":                          {tag: "rooms", summary: "The emotes players can send with room:emote"},
+	"POST /rooms/{roomId}/state":                 {tag: "rooms", summary: "Replace a room's board state (host only)", auth: authRoomToken, request: roomStatePayload{}},
+	"GET /rooms/{roomId}/state":                  {tag: "rooms", summary: "Load a room's board state", auth: authRoom, response: roomStatePayload{}},
+	"PATCH /rooms/{roomId}/state":                {tag: "rooms", summary: "Apply a JSON patch to a room's board state", auth: authRoomToken},
+	"POST /rooms/{roomId}/events":                {tag: "rooms", summary: "Append an event to a room's log", auth: authRoomToken, request: roomEventPayload{}, response: successSchema{}},
+	"GET /rooms/{roomId}/events":                 {tag: "rooms", summary: "Read a room's event log", auth: authRoom},
+	"GET /rooms/{roomId}/log":                    {tag: "rooms", summary: "A room's human-readable game log", auth: authRoom, query: []apiParam{{"sinceId", "integer", "only lines after this id"}, {"limit", "integer", "page size (at most 500)"}}},
+	"GET /rooms/{roomId}/log/export":             {tag: "rooms", summary: "A room's game log as a text or Markdown document", auth: authRoom, query: []apiParam{{"format", "string", "txt (default) or md"}, {"download", "boolean", "send as an attachment"}}},
+	"GET /rooms/{roomId}/stack":                  {tag: "rooms", summary: "The room's stack and whose priority it is", auth: authRoom},
+	"GET /rooms/{roomId}/replay":                 {tag: "rooms", summary: "Download a room's replay", auth: authRoom, query: []apiParam{{"download", "boolean", "send as an attachment"}}},
+	"GET /rooms/{roomId}/stream":                 {tag: "rooms", summary: "Room messages as Server-Sent Events, for networks that block WebSockets"},
+	"POST /rooms/{roomId}/stream":                {tag: "rooms", summary: "Send a WebSocket message from a room stream, identified by X-Stream-Token"},
+	"POST /rooms/{roomId}/goldfish":              {tag: "rooms", summary: "Seat a server-side goldfish that plays a deck by drawing each turn", auth: authRoom, request: goldfishPayload{}},
+	"DELETE /rooms/{roomId}/goldfish/{socketId}": {tag: "rooms", summary: "Remove a goldfish", auth: authRoom, response: successSchema{}},
+	"GET /replays/{roomId}/at":                   {tag: "rooms", summary: "Board state at a point in a replay", auth: authRoom, query: []apiParam{{"t", "string", "unix seconds or an RFC 3339 timestamp (required)"}}},
+	"GET /replays/{roomId}/exports":              {tag: "rooms", summary: "List a room's stored replay exports, newest first", auth: authRoom},
+	"POST /replays/{roomId}/exports":             {tag: "rooms", summary: "Store the room's replay as it is now, as spectators see it", auth: authRoom, response: replayExport{}},
+	"GET /replays/{roomId}/exports/{name}":       {tag: "rooms", summary: "Download a stored replay export", auth: authRoom},
+	"POST /replays/{roomId}/verify":              {tag: "rooms", summary: "Check a room's audited games against their recorded seeds", auth: authRoom},
+	"GET /media/{name}":                          {tag: "media", summary: "An uploaded image, when uploads are not served from a public bucket"},
+	"GET /stats/rooms":                           {tag: "stats", summary: "Room and player counts"},
+	"GET /stats/cards":                           {tag: "stats", summary: "Most played cards"},
+}
+
+// openAPIDocument builds the document from the routes registered on the
+// router, so it must be called after registerRoutes.
+func (a *App) openAPIDocument() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"error", "code", "message"},
+			"properties": map[string]interface{}{
+				"error":   map[string]interface{}{"type": "string", "description": "same as message, kept for older clients"},
+				"code":    map[string]interface{}{"type": "string", "description": "stable machine-readable error code"},
+				"message": map[string]interface{}{"type": "string"},
+				"details": map[string]interface{}{"description": "extra fields for some codes, such as limitBytes or retryAfter"},
+			},
+		},
+	}
+	builder := &openAPISchemas{schemas: schemas}
+	schemas["DeckEntry"] = builder.schemaFor(reflect.TypeOf(deckEntry{}))
+	schemas["Deck"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id":            map[string]interface{}{"type": "string"},
+			"name":          map[string]interface{}{"type": "string"},
+			"rawText":       map[string]interface{}{"type": "string"},
+			"entries":       map[string]interface{}{"type": "array", "items": schemaRef("DeckEntry")},
+			"visibility":    map[string]interface{}{"type": "string", "enum": []string{"private", "unlisted", "public"}},
+			"format":        map[string]interface{}{"type": "string", "nullable": true},
+			"folderId":      map[string]interface{}{"type": "string", "nullable": true},
+			"position":      map[string]interface{}{"type": "integer"},
+			"coverCard":     map[string]interface{}{"type": "string", "nullable": true},
+			"coverImageUrl": map[string]interface{}{"type": "string", "nullable": true},
+			"tags":          map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"author":        map[string]interface{}{"type": "string", "description": "public listings only"},
+			"likes":         map[string]interface{}{"type": "integer", "description": "public listings only"},
+			"revision":      map[string]interface{}{"type": "integer", "description": "after a save"},
+			"createdAt":     map[string]interface{}{"type": "string"},
+		},
+	}
+
+	paths := map[string]map[string]interface{}{}
+	_ = chi.Walk(a.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, apiV1Prefix+"/") {
+			return nil
+		}
+		route = strings.TrimPrefix(route, apiV1Prefix)
+		if route == "/ws" {
+			return nil
+		}
+		if paths[route] == nil {
+			paths[route] = map[string]interface{}{}
+		}
+		paths[route][strings.ToLower(method)] = builder.operation(method, route, apiOperations[method+" "+route])
+		return nil
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "MTOnline API",
+			"version": "1",
+			"description": "The deck, card and room API behind MTOnline. Errors use the Error schema; branch on its code. " +
+				"Browser sessions must echo the csrfToken cookie in an X-CSRF-Token header on writes. " +
+				"Live rooms use the WebSocket at " + apiV1Prefix + "/ws, which this document does not describe; " +
+				"bots join it with a " + scopeRoomsPlay + " token as described in bots.go.",
+		},
+		"servers": []map[string]interface{}{{"url": apiV1Prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": cookieName},
+				"apiToken": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A personal API token from POST /me/tokens. Tokens only reach routes their scopes (" + strings.Join([]string{scopeCardsRead, scopeDecksWrite, scopeRoomsEvents, scopeRoomsPlay}, ", ") + ") cover.",
+				},
+				"roomToken":    map[string]interface{}{"type": "apiKey", "in": "header", "name": roomTokenHeader, "description": "Issued to each participant on joining a room over the WebSocket and renewed with room:token."},
+				"roomPassword": map[string]interface{}{"type": "apiKey", "in": "header", "name": roomPasswordHeader},
+			},
+		},
+	}
+}
+
+func (b *openAPISchemas) operation(method string, route string, op apiOperation) map[string]interface{} {
+	tag := op.tag
+	if tag == "" {
+		tag = strings.SplitN(strings.TrimPrefix(route, "/"), "/", 2)[0]
+	}
+	operation := map[string]interface{}{
+		"tags":        []string{tag},
+		"operationId": operationID(method, route),
+	}
+	if op.summary != "" {
+		operation["summary"] = op.summary
+	}
+
+	var params []map[string]interface{}
+	for _, segment := range strings.Split(route, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, param := range op.query {
+		schema := map[string]interface{}{"type": param.kind}
+		if param.kind == "array" {
+			schema["items"] = map[string]interface{}{"type": "string"}
+		}
+		params = append(params, map[string]interface{}{
+			"name":        param.name,
+			"in":          "query",
+			"description": param.description,
+			"schema":      schema,
+		})
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+
+	if _, ok := op.request.(imageBody); ok {
+		binary := map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"image/png": binary, "image/jpeg": binary, "image/webp": binary},
+		}
+	} else if op.request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaFor(reflect.TypeOf(op.request))}},
+		}
+	}
+	success := map[string]interface{}{"description": "OK"}
+	if op.response != nil {
+		success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaFor(reflect.TypeOf(op.response))}}
+	}
+	operation["responses"] = map[string]interface{}{
+		"200": success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef("Error")}},
+		},
+	}
+	if security := operationSecurity(route, op.auth); security != nil {
+		operation["security"] = security
+	}
+	return operation
+}
+
+// operationSecurity lists the credentials a route accepts. Whether an API
+// token may call it comes from requestScope, the same check the server
+// applies.
+func operationSecurity(route string, auth apiAuth) []map[string][]string {
+	if auth == authNone {
+		return nil
+	}
+	if auth == authRoom {
+		return []map[string][]string{{"roomToken": {}}, {"roomPassword": {}}, {"session": {}}}
+	}
+	if auth == authRoomToken {
+		return []map[string][]string{{"roomToken": {}}, {"session": {}}}
+	}
+	security := []map[string][]string{{"session": {}}}
+	if auth != authAdmin {
+		probe := &http.Request{URL: &url.URL{Path: apiV1Prefix + route}}
+		if scope := requestScope(probe); scope != "" {
+			security = append(security, map[string][]string{"apiToken": {scope}})
+		}
+	}
+	if auth == authOptional {
+		security = append(security, map[string][]string{})
+	}
+	return security
+}
+
+func operationID(method string, route string) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+	for _, segment := range strings.Split(route, "/") {
+		segment = strings.Trim(segment, "{}")
+		for _, word := range strings.Split(segment, "-") {
+			if word != "" {
+				id.WriteString(strings.ToUpper(word[:1]) + word[1:])
+			}
+		}
+	}
+	return id.String()
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPISchemas turns Go types into schemas through their json tags, adding
+// each named struct to the components once.
+type openAPISchemas struct {
+	schemas map[string]interface{}
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	deckSchemaType = reflect.TypeOf(deckSchema{})
+	deckListType   = reflect.TypeOf(deckListSchema{})
+	successType    = reflect.TypeOf(successSchema{})
+)
+
+func (b *openAPISchemas) schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case rawMessageType:
+		return map[string]interface{}{}
+	case deckSchemaType:
+		return schemaRef("Deck")
+	case deckListType:
+		return map[string]interface{}{"type": "array", "items": schemaRef("Deck")}
+	case successType:
+		return map[string]interface{}{"type": "object", "properties": map[string]interface{}{"success": map[string]interface{}{"type": "boolean"}}}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := b.schemas[name]; !ok {
+			b.schemas[name] = map[string]interface{}{} // placeholder for recursive types
+			b.schemas[name] = b.structSchema(t)
+		}
+		return schemaRef(name)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (b *openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	b.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *openAPISchemas) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			b.addFields(field.Type, properties)
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaFor(field.Type)
+	}
+}
+
+// handleOpenAPI serves the document at /api/openapi.json.
+func (a *App) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.openAPIDocument())
+}
+
+// handleAPIDocs serves Swagger UI for the document. The UI itself is loaded
+// from a CDN rather than vendored.
+func (a *App) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(apiDocsPage))
+}
+
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>MTOnline API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// originAllowlist decides which browser origins may call the API with
+// credentials and open the WebSocket. ALLOWED_ORIGINS is a comma-separated
+// list of origins such as https://mto.example.com; a leading "*." on the host
+// matches any subdomain (https://*.example.com) and a ":*" port matches any
+// port (http://localhost:*). Without ALLOWED_ORIGINS the built-in list is
+// used: the Vite dev client, any localhost port and mto.mesmer.tv.
+type originAllowlist struct {
+	exact    map[string]bool
+	patterns []originPattern
+}
+
+type originPattern struct {
+	scheme string
+	// suffix is ".example.com" for "*.example.com", or empty for an exact
+	// host.
+	suffix  string
+	host    string
+	anyPort bool
+	port    string
+}
+
+func loadOriginAllowlist() originAllowlist {
+	raw := strings.TrimSpace(os.Getenv("ALLOWED_ORIGINS"))
+	if raw == "" {
+		return defaultOriginAllowlist()
+	}
+	allowlist := originAllowlist{exact: make(map[string]bool)}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimRight(strings.TrimSpace(entry), "/")
+		if entry == "" {
+			continue
+		}
+		if err := allowlist.add(entry); err != nil {
+			log.Printf("[cors] ignoring ALLOWED_ORIGINS entry %q: %v", entry, err)
+		}
+	}
+	return allowlist
+}
+
+func defaultOriginAllowlist() originAllowlist {
+	clientHost := os.Getenv("VITE_CLIENT_HOST")
+	if clientHost == "" {
+		clientHost = "localhost"
+	}
+	clientPort := os.Getenv("VITE_CLIENT_PORT")
+	if clientPort == "" {
+		clientPort = "5173"
+	}
+	allowlist := originAllowlist{exact: make(map[string]bool)}
+	for _, entry := range []string{
+		fmt.Sprintf("http://%s:%s", clientHost, clientPort),
+		"http://localhost:*",
+		"http://127.0.0.1:*",
+		"https://mto.mesmer.tv",
+		"http://mto.mesmer.tv",
+		"https://www.mto.mesmer.tv",
+		"http://www.mto.mesmer.tv",
+	} {
+		_ = allowlist.add(entry)
+	}
+	return allowlist
+}
+
+func (l *originAllowlist) add(entry string) error {
+	if entry == "*" {
+		return errors.New("a bare * would let any site act with the user's cookies; list the origins instead")
+	}
+	if !strings.Contains(entry, "*") {
+		parsed, err := url.Parse(entry)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" {
+			return errors.New("expected scheme://host[:port]")
+		}
+		l.exact[strings.ToLower(entry)] = true
+		return nil
+	}
+	scheme, rest, ok := strings.Cut(strings.ToLower(entry), "://")
+	if !ok || scheme == "" || rest == "" {
+		return errors.New("expected scheme://host[:port]")
+	}
+	pattern := originPattern{scheme: scheme}
+	host := rest
+	if index := strings.LastIndex(rest, ":"); index >= 0 {
+		host = rest[:index]
+		pattern.port = rest[index+1:]
+		pattern.anyPort = pattern.port == "*"
+	}
+	switch {
+	case strings.HasPrefix(host, "*."):
+		pattern.suffix = host[1:]
+	case strings.Contains(host, "*"):
+		return errors.New("a wildcard is only allowed as the leftmost label (*.example.com)")
+	default:
+		pattern.host = strings.Trim(host, "[]")
+	}
+	if strings.Contains(pattern.suffix, "*") || (!pattern.anyPort && strings.Contains(pattern.port, "*")) {
+		return errors.New("a wildcard is only allowed as the leftmost label or the whole port")
+	}
+	l.patterns = append(l.patterns, pattern)
+	return nil
+}
+
+func (l originAllowlist) allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	if l.exact[origin] {
+		return true
+	}
+	if len(l.patterns) == 0 {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	host, port := parsed.Hostname(), parsed.Port()
+	for _, pattern := range l.patterns {
+		if parsed.Scheme != pattern.scheme {
+			continue
+		}
+		if !pattern.anyPort && port != pattern.port {
+			continue
+		}
+		if pattern.suffix != "" {
+			// The subdomain must be non-empty: *.example.com does not match
+			// example.com itself.
+			if len(host) > len(pattern.suffix) && strings.HasSuffix(host, pattern.suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern.host {
+			return true
+		}
+	}
+	return false
+}
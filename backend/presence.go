@@ -0,0 +1,121 @@
+package main
+
+import "sync"
+
+// presenceTracker maps signed-in users to their open sockets and the room
+// each socket is in. Anonymous sockets are not tracked.
+type presenceTracker struct {
+	mu      sync.RWMutex
+	sockets map[int64]map[string]string
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		sockets: make(map[int64]map[string]string),
+	}
+}
+
+// connect reports whether this is the user's first open socket.
+func (p *presenceTracker) connect(userID int64, socketID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sockets := p.sockets[userID]
+	if sockets == nil {
+		sockets = make(map[string]string)
+		p.sockets[userID] = sockets
+	}
+	sockets[socketID] = ""
+	return len(sockets) == 1
+}
+
+// disconnect reports whether the user has no sockets left.
+func (p *presenceTracker) disconnect(userID int64, socketID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sockets := p.sockets[userID]
+	delete(sockets, socketID)
+	if len(sockets) > 0 {
+		return false
+	}
+	delete(p.sockets, userID)
+	return true
+}
+
+func (p *presenceTracker) setRoom(userID int64, socketID string, roomID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sockets := p.sockets[userID]; sockets != nil {
+		if _, ok := sockets[socketID]; ok {
+			sockets[socketID] = roomID
+		}
+	}
+}
+
+// status reports whether the user is online and a room one of their sockets
+// is in, if any.
+func (p *presenceTracker) status(userID int64) (bool, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	sockets := p.sockets[userID]
+	for _, roomID := range sockets {
+		if roomID != "" {
+			return true, roomID
+		}
+	}
+	return len(sockets) > 0, ""
+}
+
+func (p *presenceTracker) socketIDs(userID int64) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ids := make([]string, 0, len(p.sockets[userID]))
+	for id := range p.sockets[userID] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (a *App) sendToUser(userID int64, message WSMessage) {
+	for _, socketID := range a.presence.socketIDs(userID) {
+		a.send(socketID, message)
+	}
+}
+
+// broadcastPresence sends a friend:* event about the client's user to all of
+// that user's online friends.
+func (a *App) broadcastPresence(client *WSClient, eventType string, extra map[string]interface{}) {
+	if client.userID == 0 {
+		return
+	}
+	payload := map[string]interface{}{
+		"userId":   client.userID,
+		"username": client.username,
+	}
+	for key, value := range extra {
+		payload[key] = value
+	}
+	message := WSMessage{Type: eventType, Payload: marshalPayload(payload)}
+	for _, friendID := range a.friendIDs(client.userID) {
+		a.sendToUser(friendID, message)
+	}
+}
+
+func (a *App) enterPresenceRoom(client *WSClient, roomID string) {
+	if client.userID == 0 {
+		return
+	}
+	a.presence.setRoom(client.userID, client.id, roomID)
+	a.broadcastPresence(client, "friend:in_room", map[string]interface{}{"roomId": roomID})
+}
+
+// leavePresenceRoom clears the room of a socket whose room was closed under
+// it.
+func (a *App) leavePresenceRoom(socketID string) {
+	a.clientsMu.RLock()
+	client := a.clients[socketID]
+	a.clientsMu.RUnlock()
+	if client == nil || client.userID == 0 {
+		return
+	}
+	a.presence.setRoom(client.userID, client.id, "")
+}
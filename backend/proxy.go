@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type forwardedHTTPSKey struct{}
+
+// cookiePolicy applies the COOKIE_* settings to every cookie the server
+// sets. Secure "auto" marks cookies Secure when the request arrived over
+// HTTPS, directly or through a trusted proxy.
+type cookiePolicy struct {
+	Secure   string
+	SameSite http.SameSite
+	Domain   string
+}
+
+func loadCookiePolicy() cookiePolicy {
+	policy := cookiePolicy{
+		Secure:   strings.ToLower(strings.TrimSpace(os.Getenv("COOKIE_SECURE"))),
+		SameSite: http.SameSiteLaxMode,
+		Domain:   strings.TrimSpace(os.Getenv("COOKIE_DOMAIN")),
+	}
+	switch policy.Secure {
+	case "true", "false", "auto":
+	case "1", "yes", "on":
+		policy.Secure = "true"
+	default:
+		policy.Secure = "auto"
+	}
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("COOKIE_SAMESITE"))) {
+	case "strict":
+		policy.SameSite = http.SameSiteStrictMode
+	case "none":
+		policy.SameSite = http.SameSiteNoneMode
+		if policy.Secure == "false" {
+			log.Printf("[cookies] COOKIE_SAMESITE=none requires Secure cookies; browsers will reject them")
+		}
+	}
+	return policy
+}
+
+func (a *App) setCookie(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) {
+	switch a.cookies.Secure {
+	case "true":
+		cookie.Secure = true
+	case "auto":
+		cookie.Secure = isHTTPS(r)
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = a.cookies.SameSite
+	}
+	if a.cookies.Domain != "" {
+		cookie.Domain = a.cookies.Domain
+	}
+	http.SetCookie(w, cookie)
+}
+
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	forwarded, _ := r.Context().Value(forwardedHTTPSKey{}).(bool)
+	return forwarded
+}
+
+// loadTrustedProxies parses TRUSTED_PROXIES, a comma-separated list of IPs
+// or CIDR ranges whose forwarding headers are honored.
+func loadTrustedProxies() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("[proxy] ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isTrustedProxy(remoteAddr string, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxyMiddleware honors X-Forwarded-For, X-Real-IP, and
+// X-Forwarded-Proto only when the direct peer is a trusted proxy. The client
+// address is the right-most X-Forwarded-For hop not itself a trusted proxy.
+func trustedProxyMiddleware(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isTrustedProxy(r.RemoteAddr, trusted) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if proto := r.Header.Get("X-Forwarded-Proto"); strings.EqualFold(strings.TrimSpace(strings.Split(proto, ",")[0]), "https") {
+				r = r.WithContext(context.WithValue(r.Context(), forwardedHTTPSKey{}, true))
+			}
+			if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+				hops := strings.Split(forwarded, ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := strings.TrimSpace(hops[i])
+					if net.ParseIP(hop) == nil {
+						break
+					}
+					r.RemoteAddr = net.JoinHostPort(hop, "0")
+					if !isTrustedProxy(hop, trusted) {
+						break
+					}
+				}
+			} else if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+				r.RemoteAddr = net.JoinHostPort(realIP, "0")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
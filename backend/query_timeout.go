@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// queryTimeouts bound how long a request's database work may run. The
+// deadline is put on the request context, which the handlers pass to every
+// query, so a slow query is interrupted and an abandoned request (a client
+// that disconnected, a superseded autocomplete keystroke) stops holding the
+// SQLite lock as soon as it is cancelled. Configured in milliseconds as
+// QUERY_TIMEOUT_SEARCH_MS for card and deck search, QUERY_TIMEOUT_ADMIN_MS
+// for the admin API and QUERY_TIMEOUT_MS for everything else; zero disables
+// the deadline (cancellation still applies).
+type queryTimeouts struct {
+	search time.Duration
+	admin  time.Duration
+	other  time.Duration
+}
+
+func loadQueryTimeouts() queryTimeouts {
+	return queryTimeouts{
+		search: time.Duration(envInt("QUERY_TIMEOUT_SEARCH_MS", 2000)) * time.Millisecond,
+		admin:  time.Duration(envInt("QUERY_TIMEOUT_ADMIN_MS", 60000)) * time.Millisecond,
+		other:  time.Duration(envInt("QUERY_TIMEOUT_MS", 10000)) * time.Millisecond,
+	}
+}
+
+func (t queryTimeouts) forRequest(r *http.Request) time.Duration {
+	path := apiRoute(r)
+	switch {
+	case path == "/ws":
+		// The socket outlives any sensible deadline; WS messages do their
+		// own database work without the request context.
+		return 0
+	case isRoomStream(r):
+		return 0
+	case path == "/cards/batch":
+		return t.other
+	case strings.HasPrefix(path, "/cards/"), path == "/decks/search", strings.HasPrefix(path, "/decks/public"):
+		return t.search
+	case strings.HasPrefix(path, "/admin/"):
+		return t.admin
+	default:
+		return t.other
+	}
+}
+
+func queryTimeoutMiddleware(timeouts queryTimeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeouts.forRequest(r)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rateLimitSweepInterval = 10 * time.Minute
+
+// rateLimitClass groups endpoints that share a limit. Limits are requests
+// per minute, configured as RATE_LIMIT_<CLASS>_PER_IP and
+// RATE_LIMIT_<CLASS>_PER_USER; zero disables that limit. Every request is
+// counted against its client IP, and signed-in requests also against the
+// account, so a shared NAT gets a roomier IP limit than one user does.
+type rateLimitClass struct {
+	name    string
+	perIP   int
+	perUser int
+}
+
+var rateLimitDefaults = []rateLimitClass{
+	{name: "auth", perIP: 20, perUser: 20},
+	{name: "decks", perIP: 60, perUser: 30},
+	{name: "cards", perIP: 600, perUser: 600},
+	{name: "rooms", perIP: 1200, perUser: 0},
+	{name: "default", perIP: 600, perUser: 300},
+}
+
+// rateLimiter is a set of token buckets keyed by class and client. Each
+// bucket holds a minute's worth of requests and refills continuously.
+type rateLimiter struct {
+	classes map[string]rateLimitClass
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimitClassKey struct{}
+
+func loadRateLimiter() *rateLimiter {
+	limiter := &rateLimiter{
+		classes: make(map[string]rateLimitClass),
+		buckets: make(map[string]*rateBucket),
+	}
+	for _, class := range rateLimitDefaults {
+		prefix := "RATE_LIMIT_" + strings.ToUpper(class.name)
+		class.perIP = envInt(prefix+"_PER_IP", class.perIP)
+		class.perUser = envInt(prefix+"_PER_USER", class.perUser)
+		limiter.classes[class.name] = class
+	}
+	return limiter
+}
+
+// classifyRequest picks the limit class for a request, or "" for requests
+// that are never limited.
+func classifyRequest(r *http.Request) string {
+	path := apiRoute(r)
+	switch {
+	case r.Method == http.MethodOptions, path == "/ws", path == "/health", path == "/healthz", path == "/readyz":
+		return ""
+	case path == "/login", path == "/register", path == "/logout", strings.HasPrefix(path, "/auth/"),
+		path == "/me/tokens" && r.Method == http.MethodPost:
+		return "auth"
+	case strings.HasPrefix(path, "/decks") && (r.Method == http.MethodPost || r.Method == http.MethodPut) &&
+		!strings.HasSuffix(path, "/like") && !strings.HasSuffix(path, "/comments"):
+		return "decks"
+	case strings.HasPrefix(path, "/cards/"):
+		return "cards"
+	case strings.HasPrefix(path, "/rooms/"), strings.HasPrefix(path, "/replays/"):
+		return "rooms"
+	default:
+		return "default"
+	}
+}
+
+// allow takes a token from the bucket and returns how long the caller must
+// wait when it is empty.
+func (l *rateLimiter) allow(key string, perMinute int) time.Duration {
+	if perMinute <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	capacity := float64(perMinute)
+	rate := capacity / 60
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &rateBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return 0
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitMiddleware applies the per-IP limit and records the request's
+// class for allowUser, which runs once the account is known.
+func (a *App) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := classifyRequest(r)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		class := a.rateLimits.classes[name]
+		if wait := a.rateLimits.allow(name+":ip:"+clientIP(r), class.perIP); wait > 0 {
+			writeTooManyRequests(w, wait, "Too many requests, slow down")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitClassKey{}, name)))
+	})
+}
+
+// allowUser applies the per-account limit for the request's class. It
+// writes the 429 response itself and reports false when over the limit.
+func (a *App) allowUser(w http.ResponseWriter, r *http.Request, user *User) bool {
+	name, _ := r.Context().Value(rateLimitClassKey{}).(string)
+	if user == nil || name == "" {
+		return true
+	}
+	class := a.rateLimits.classes[name]
+	if wait := a.rateLimits.allow(name+":user:"+strconv.FormatInt(user.ID, 10), class.perUser); wait > 0 {
+		writeTooManyRequests(w, wait, "Too many requests, slow down")
+		return false
+	}
+	return true
+}
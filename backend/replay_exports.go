@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// replayExportTimeFormat starts an export's name, so names sort by age.
+const replayExportTimeFormat = "20060102T150405.000Z"
+
+var replayExportNamePattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}Z-[0-9a-f]{8}\.replay\.json$`)
+
+// replayExports are replay documents stored when a player asks for one, so
+// that a finished game can still be downloaded after its room has been
+// compacted or pruned. They are kept in REPLAY_EXPORT_DIR (default
+// data/replays), or under replays/ in the STORAGE_S3_BUCKET, one folder per
+// room; REPLAY_EXPORT_KEEP caps how many a room keeps (default 10, 0 keeps
+// all). An export is written from the spectators' view of the board, since
+// everyone with access to the room can download it.
+type replayExports struct {
+	store objectStore
+	keep  int
+}
+
+type replayExport struct {
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"sizeBytes,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	URL       string `json:"url"`
+}
+
+func loadReplayExports() (*replayExports, error) {
+	dir := strings.TrimSpace(os.Getenv("REPLAY_EXPORT_DIR"))
+	if dir == "" {
+		dir = filepath.Join(rootDir(), "data", "replays")
+	}
+	store, err := loadObjectStore(dir, "replays/")
+	if err != nil {
+		return nil, err
+	}
+	return &replayExports{store: store, keep: envInt("REPLAY_EXPORT_KEEP", 10)}, nil
+}
+
+// replayExportFolder names a room's folder by a hash of its id, which may
+// hold characters that are not safe in a path or an object key.
+func replayExportFolder(roomID string) string {
+	return sha256Hex([]byte(roomID))[:32] + "/"
+}
+
+func replayExportInfo(roomID string, name string, size int64) replayExport {
+	createdAt := ""
+	if at, err := time.Parse(replayExportTimeFormat, strings.SplitN(name, "-", 2)[0]); err == nil {
+		createdAt = at.Format(time.RFC3339)
+	}
+	return replayExport{
+		Name:      name,
+		SizeBytes: size,
+		CreatedAt: createdAt,
+		URL:       apiV1Prefix + "/replays/" + url.PathEscape(roomID) + "/exports/" + name,
+	}
+}
+
+func (a *App) handleReplayExports(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	names, err := a.replays.store.list(r.Context(), replayExportFolder(roomID))
+	if err != nil {
+		log.Printf("[replays] failed to list exports of %s: %v", roomID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to list replay exports")
+		return
+	}
+	exports := []replayExport{}
+	for i := len(names) - 1; i >= 0; i-- {
+		exports = append(exports, replayExportInfo(roomID, path.Base(names[i]), 0))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"roomId": roomID, "exports": exports})
+}
+
+// handleCreateReplayExport stores the room's replay document as it is now
+// and drops the room's oldest exports beyond the limit.
+func (a *App) handleCreateReplayExport(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	var recorded bool
+	if err := a.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM room_events WHERE room_id = ?)
+			OR EXISTS (SELECT 1 FROM room_snapshots WHERE room_id = ?)
+	`, roomID, roomID).Scan(&recorded); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load replay")
+		return
+	}
+	if !recorded {
+		writeError(w, http.StatusNotFound, codeNotFound, "The room has nothing to replay")
+		return
+	}
+	exportedAt := time.Now().UTC()
+	document, problem := a.replayDocument(r.Context(), roomID, roomViewer{}, exportedAt)
+	if problem != "" {
+		writeError(w, http.StatusInternalServerError, codeInternal, problem)
+		return
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to encode replay")
+		return
+	}
+	folder := replayExportFolder(roomID)
+	name := exportedAt.Format(replayExportTimeFormat) + "-" + randomID(4) + ".replay.json"
+	if err := a.replays.store.put(r.Context(), folder+name, "application/json", bytes.NewReader(data)); err != nil {
+		log.Printf("[replays] failed to store export of %s: %v", roomID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to store replay export")
+		return
+	}
+	if a.replays.keep > 0 {
+		if names, err := a.replays.store.list(r.Context(), folder); err == nil {
+			for len(names) > a.replays.keep {
+				if err := a.replays.store.remove(r.Context(), names[0]); err != nil {
+					log.Printf("[replays] failed to remove %s: %v", names[0], err)
+					break
+				}
+				names = names[1:]
+			}
+		}
+	}
+	writeJSON(w, http.StatusCreated, replayExportInfo(roomID, name, int64(len(data))))
+}
+
+func (a *App) handleReplayExport(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	name := chi.URLParam(r, "name")
+	if !replayExportNamePattern.MatchString(name) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Replay export not found")
+		return
+	}
+	object, err := a.replays.store.get(r.Context(), replayExportFolder(roomID)+name)
+	if errors.Is(err, errObjectNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Replay export not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[replays] failed to read export %s of %s: %v", name, roomID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to read replay export")
+		return
+	}
+	defer object.Close()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+replayFilename(roomID)+"-"+strings.TrimSuffix(name, ".replay.json")+`.replay.json"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, object)
+}
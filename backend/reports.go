@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	reportDeck    = "deck"
+	reportUser    = "user"
+	reportComment = "comment"
+
+	reportOpen      = "open"
+	reportResolved  = "resolved"
+	reportDismissed = "dismissed"
+
+	reportActionDismiss       = "dismiss"
+	reportActionHideDeck      = "hide_deck"
+	reportActionDeleteComment = "delete_comment"
+	reportActionRenameUser    = "rename_user"
+
+	maxReportReasonLen    = 500
+	maxOpenReportsPerUser = 20
+)
+
+// Users report an offensive deck name, username or deck comment with
+// POST /reports and follow what became of it with GET /reports. Admins work
+// through the open reports with GET /admin/reports and close one with an
+// action; every open report about the same thing is closed with it, and the
+// reporters are notified. Actions other than dismissing go to the audit log.
+
+type reportPayload struct {
+	Kind     string `json:"kind"`
+	TargetID string `json:"targetId"`
+	Reason   string `json:"reason"`
+}
+
+type resolveReportPayload struct {
+	Action   string `json:"action"`
+	Note     string `json:"note"`
+	Username string `json:"username"`
+	Days     int    `json:"days"`
+}
+
+type report struct {
+	ID           int64   `json:"id"`
+	Kind         string  `json:"kind"`
+	TargetID     string  `json:"targetId"`
+	Content      string  `json:"content"`
+	Reason       string  `json:"reason"`
+	Status       string  `json:"status"`
+	Action       *string `json:"action"`
+	CreatedAt    string  `json:"createdAt"`
+	ResolvedAt   *string `json:"resolvedAt"`
+	ReporterID   int64   `json:"reporterId,omitempty"`
+	Reporter     string  `json:"reporter,omitempty"`
+	TargetUserID *int64  `json:"targetUserId,omitempty"`
+	TargetUser   *string `json:"targetUser,omitempty"`
+	ReportCount  int     `json:"reportCount,omitempty"`
+}
+
+const reportColumns = `r.id, r.kind, r.target_id, r.content, r.reason, r.status, r.action, r.created_at, r.resolved_at`
+
+func scanReport(rows *sql.Rows, extra ...interface{}) (report, error) {
+	var item report
+	var action, resolvedAt sql.NullString
+	dest := append([]interface{}{&item.ID, &item.Kind, &item.TargetID, &item.Content, &item.Reason, &item.Status, &action, &item.CreatedAt, &resolvedAt}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return item, err
+	}
+	item.Action = nullStringToPtr(action)
+	item.ResolvedAt = nullStringToPtr(resolvedAt)
+	return item, nil
+}
+
+// reportTarget looks up what is being reported as the reporter can see it,
+// returning its text and the account responsible for it.
+func (a *App) reportTarget(ctx context.Context, user *User, kind string, targetID string) (string, int64, error) {
+	var content string
+	var ownerID int64
+	switch kind {
+	case reportDeck:
+		deck, err := a.loadVisibleDeck(ctx, user, targetID)
+		if err != nil {
+			return "", 0, err
+		}
+		content = deck.Name
+		err = a.db.QueryRowContext(ctx, `SELECT user_id FROM decks WHERE id = ?`, targetID).Scan(&ownerID)
+		return content, ownerID, err
+	case reportUser:
+		id, err := strconv.ParseInt(targetID, 10, 64)
+		if err != nil {
+			return "", 0, sql.ErrNoRows
+		}
+		err = a.db.QueryRowContext(ctx, `SELECT id, username FROM users WHERE id = ?`, id).Scan(&ownerID, &content)
+		return content, ownerID, err
+	default:
+		var deckID string
+		err := a.db.QueryRowContext(ctx, `SELECT deck_id, user_id, body FROM deck_comments WHERE id = ?`, targetID).Scan(&deckID, &ownerID, &content)
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := a.loadVisibleDeck(ctx, user, deckID); err != nil {
+			return "", 0, err
+		}
+		return content, ownerID, nil
+	}
+}
+
+func (a *App) handleCreateReport(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	var payload reportPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	payload.TargetID = strings.TrimSpace(payload.TargetID)
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	switch {
+	case payload.Kind != reportDeck && payload.Kind != reportUser && payload.Kind != reportComment:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "kind must be deck, user or comment")
+		return
+	case payload.TargetID == "":
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "targetId is required")
+		return
+	case payload.Reason == "":
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "reason is required")
+		return
+	case len(payload.Reason) > maxReportReasonLen:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("reason must be at most %d characters", maxReportReasonLen))
+		return
+	}
+	content, ownerID, err := a.reportTarget(r.Context(), user, payload.Kind, payload.TargetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Reported "+payload.Kind+" not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to file report")
+		return
+	}
+	if ownerID == user.ID {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "You cannot report your own content")
+		return
+	}
+	var open int
+	var duplicate bool
+	_ = a.db.QueryRowContext(r.Context(), `
+		SELECT COUNT(*), COALESCE(MAX(kind = ? AND target_id = ?), 0)
+		FROM reports
+		WHERE reporter_id = ? AND status = ?
+	`, payload.Kind, payload.TargetID, user.ID, reportOpen).Scan(&open, &duplicate)
+	if duplicate {
+		writeError(w, http.StatusConflict, codeConflict, "You have already reported this "+payload.Kind)
+		return
+	}
+	if open >= maxOpenReportsPerUser {
+		writeError(w, http.StatusUnprocessableEntity, codeLimitReached, fmt.Sprintf("You can have at most %d open reports", maxOpenReportsPerUser))
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO reports (reporter_id, kind, target_id, target_user_id, content, reason)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, user.ID, payload.Kind, payload.TargetID, nullIfZero(ownerID), content, payload.Reason)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to file report")
+		return
+	}
+	id, _ := result.LastInsertId()
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "status": reportOpen})
+}
+
+// handleReports lists the user's own reports and what became of them.
+func (a *App) handleReports(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 100 || limit <= 0 {
+		limit = 100
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT `+reportColumns+`
+		FROM reports r
+		WHERE r.reporter_id = ?
+		ORDER BY r.id DESC
+		LIMIT ? OFFSET ?
+	`, user.ID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load reports")
+		return
+	}
+	defer rows.Close()
+	reports := make([]report, 0)
+	for rows.Next() {
+		if item, err := scanReport(rows); err == nil {
+			reports = append(reports, item)
+		}
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// handleAdminReports is the moderation queue: open reports oldest first,
+// each with how many open reports there are about the same thing. Closed
+// reports are listed newest first.
+func (a *App) handleAdminReports(w http.ResponseWriter, r *http.Request) {
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 200 || limit <= 0 {
+		limit = 200
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = reportOpen
+	}
+	if status != reportOpen && status != reportResolved && status != reportDismissed {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "status must be open, resolved or dismissed")
+		return
+	}
+	order := "r.id DESC"
+	if status == reportOpen {
+		order = "r.id ASC"
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT `+reportColumns+`, r.reporter_id, reporter.username, r.target_user_id, target.username,
+			(SELECT COUNT(*) FROM reports o WHERE o.kind = r.kind AND o.target_id = r.target_id AND o.status = 'open')
+		FROM reports r
+		JOIN users reporter ON reporter.id = r.reporter_id
+		LEFT JOIN users target ON target.id = r.target_user_id
+		WHERE r.status = ?
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, status, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load reports")
+		return
+	}
+	defer rows.Close()
+	reports := make([]report, 0)
+	for rows.Next() {
+		var targetUserID sql.NullInt64
+		var targetUser sql.NullString
+		var reporterID int64
+		var reporter string
+		var count int
+		item, err := scanReport(rows, &reporterID, &reporter, &targetUserID, &targetUser, &count)
+		if err != nil {
+			continue
+		}
+		item.ReporterID, item.Reporter, item.ReportCount = reporterID, reporter, count
+		if targetUserID.Valid {
+			item.TargetUserID = &targetUserID.Int64
+		}
+		item.TargetUser = nullStringToPtr(targetUser)
+		reports = append(reports, item)
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// handleAdminResolveReport applies an action to what a report is about and
+// closes every open report about it.
+func (a *App) handleAdminResolveReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := strconv.ParseInt(chi.URLParam(r, "reportId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid report id")
+		return
+	}
+	var payload resolveReportPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	payload.Note = strings.TrimSpace(payload.Note)
+	if len(payload.Note) > maxSuspensionReasonLen {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("note must be at most %d characters", maxSuspensionReasonLen))
+		return
+	}
+	var kind, targetID, status string
+	var targetUserID sql.NullInt64
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT kind, target_id, target_user_id, status FROM reports WHERE id = ?
+	`, reportID).Scan(&kind, &targetID, &targetUserID, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Report not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load report")
+		return
+	}
+	if status != reportOpen {
+		writeError(w, http.StatusConflict, codeConflict, "Report is already closed")
+		return
+	}
+	admin := a.currentUser(r)
+	reason := fmt.Sprintf("report #%d", reportID)
+	if payload.Note != "" {
+		reason += ": " + payload.Note
+	}
+
+	if problem := a.applyReportAction(r.Context(), admin, kind, targetID, targetUserID.Int64, reason, payload); problem != nil {
+		problem.write(w)
+		return
+	}
+	closeAs := reportResolved
+	if payload.Action == reportActionDismiss {
+		closeAs = reportDismissed
+	}
+
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, reporter_id FROM reports WHERE kind = ? AND target_id = ? AND status = ?
+	`, kind, targetID, reportOpen)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to close reports")
+		return
+	}
+	reporters := make(map[int64]int64)
+	for rows.Next() {
+		var id, reporterID int64
+		if rows.Scan(&id, &reporterID) == nil {
+			reporters[id] = reporterID
+		}
+	}
+	rows.Close()
+	if _, err := a.db.ExecContext(r.Context(), `
+		UPDATE reports SET status = ?, action = ?, resolved_by = ?, resolved_at = CURRENT_TIMESTAMP
+		WHERE kind = ? AND target_id = ? AND status = ?
+	`, closeAs, payload.Action, admin.ID, kind, targetID, reportOpen); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to close reports")
+		return
+	}
+	for id, reporterID := range reporters {
+		a.notify(reporterID, notifyReportClosed, map[string]interface{}{
+			"reportId": id,
+			"status":   closeAs,
+			"action":   payload.Action,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     reportID,
+		"status": closeAs,
+		"action": payload.Action,
+		"closed": len(reporters),
+	})
+}
+
+// applyReportAction carries out the admin's decision on a report.
+func (a *App) applyReportAction(ctx context.Context, admin *User, kind string, targetID string, targetUserID int64, reason string, payload resolveReportPayload) *apiError {
+	var targetName string
+	if targetUserID != 0 {
+		_ = a.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = ?`, targetUserID).Scan(&targetName)
+	}
+	needsUser := &apiError{http.StatusNotFound, codeNotFound, "The reported account no longer exists"}
+	failed := &apiError{http.StatusInternalServerError, codeInternal, "Failed to apply the action"}
+	switch payload.Action {
+	case reportActionDismiss:
+		return nil
+	case reportActionHideDeck:
+		if kind != reportDeck {
+			return &apiError{http.StatusBadRequest, codeValidationFailed, "hide_deck only applies to deck reports"}
+		}
+		found, err := a.takeDownDeck(ctx, targetID)
+		if err != nil {
+			return failed
+		}
+		if !found {
+			return &apiError{http.StatusNotFound, codeNotFound, "Deck not found"}
+		}
+	case reportActionDeleteComment:
+		if kind != reportComment {
+			return &apiError{http.StatusBadRequest, codeValidationFailed, "delete_comment only applies to comment reports"}
+		}
+		if _, err := a.db.ExecContext(ctx, `DELETE FROM deck_comments WHERE id = ?`, targetID); err != nil {
+			return failed
+		}
+	case reportActionRenameUser:
+		if targetName == "" {
+			return needsUser
+		}
+		username := strings.TrimSpace(payload.Username)
+		if len(username) < 3 {
+			return &apiError{http.StatusBadRequest, codeValidationFailed, "username must be at least 3 characters"}
+		}
+		if a.words.blocked(username) {
+			return &apiError{http.StatusBadRequest, codeBlockedWord, blockedWordMessage("username")}
+		}
+		if _, err := a.db.ExecContext(ctx, `UPDATE users SET username = ? WHERE id = ?`, username, targetUserID); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				return &apiError{http.StatusBadRequest, codeUsernameTaken, "Username already exists"}
+			}
+			return failed
+		}
+		reason = targetName + " renamed to " + username + ", " + reason
+	case auditSuspend, auditBan:
+		if targetName == "" {
+			return needsUser
+		}
+		_, _, problem := a.restrictAccount(ctx, admin, targetUserID, payload.Action, adminSuspendPayload{Reason: reason, Days: payload.Days})
+		return problem
+	default:
+		return &apiError{http.StatusBadRequest, codeValidationFailed, "action must be dismiss, hide_deck, delete_comment, rename_user, suspend or ban"}
+	}
+	if err := recordAudit(ctx, a.db, admin, payload.Action, targetUserID, targetName, reason); err != nil {
+		return failed
+	}
+	return nil
+}
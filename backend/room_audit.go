@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Under the audit house rule every deal stores the seeds the server shuffled
+// the turn order and each library with, the deck lists before the shuffle and
+// the cards dealt (room_audits), and the room's events are never compacted.
+// POST /replays/{roomId}/verify then checks each audited game: that the
+// seeds give the recorded turn order and libraries, and that the event log
+// is consistent with them when replayed against each player's hand and
+// library. Draws take the known top card, server mulligans are redone from
+// their seeds, scries must look at the cards on top, and a card may only be
+// moved, kept or reordered in the hand if the log put it there. A shuffle or
+// a random library placement done by a client has no recorded seed, so the
+// library's order is not checked from then until the player's next mulligan;
+// the report lists those as unchecked rather than as issues.
+type auditDeal struct {
+	OrderSeed int64 `json:"orderSeed"`
+	// Seats are the seat numbers before the turn order was shuffled.
+	Seats     []int         `json:"seats"`
+	TurnOrder []int         `json:"turnOrder"`
+	Players   []auditPlayer `json:"players"`
+	// Board are the cards dealt onto the shared board (commanders).
+	Board []auditCard `json:"board,omitempty"`
+}
+
+type auditPlayer struct {
+	PlayerName string `json:"playerName"`
+	Seed       int64  `json:"seed"`
+	// Deck is the library's card names before the shuffle, Library the
+	// cards dealt from it, the top card last. The last seven went to the
+	// hand.
+	Deck    []string    `json:"deck"`
+	Library []auditCard `json:"library"`
+}
+
+type auditCard struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+}
+
+type auditFinding struct {
+	EventID int64  `json:"eventId,omitempty"`
+	Player  string `json:"player,omitempty"`
+	Message string `json:"message"`
+}
+
+type auditGameReport struct {
+	Game     int    `json:"game"`
+	DealtAt  string `json:"dealtAt"`
+	Events   int    `json:"events"`
+	Finished bool   `json:"finished"`
+	// Issues are where the log contradicts the deal; Unchecked are the
+	// stretches the server could not check.
+	Issues    []auditFinding `json:"issues"`
+	Unchecked []auditFinding `json:"unchecked"`
+}
+
+func newAuditCard(card map[string]interface{}) auditCard {
+	id, _ := card["id"].(string)
+	name, _ := card["name"].(string)
+	owner, _ := card["ownerId"].(string)
+	return auditCard{ID: id, Name: name, Owner: owner}
+}
+
+// newAuditPlayer records a player's deal. shuffled is deck after the shuffle,
+// in the order library was built from it.
+func newAuditPlayer(name string, seed int64, deck []deckEntry, shuffled []deckEntry, library []map[string]interface{}) auditPlayer {
+	player := auditPlayer{PlayerName: name, Seed: seed}
+	for _, entry := range deck {
+		player.Deck = append(player.Deck, entry.Name)
+	}
+	for i, card := range library {
+		id, _ := card["id"].(string)
+		player.Library = append(player.Library, auditCard{ID: id, Name: shuffled[i].Name})
+	}
+	return player
+}
+
+// recordAuditDeal stores a deal. The game's events are the ones logged after
+// it, so it is recorded after the highest event id so far.
+func (a *App) recordAuditDeal(roomID string, game int, deal auditDeal) {
+	if _, err := a.db.Exec(`
+		INSERT INTO room_audits (room_id, game, after_event_id, deal)
+		SELECT ?, ?, COALESCE(MAX(id), 0), ? FROM room_events
+	`, roomID, game, string(marshalPayload(deal))); err != nil {
+		log.Printf("[rooms] failed to record the deal of %s: %v", roomID, err)
+	}
+}
+
+// auditZones are a player's hand and library as the log says they should
+// be. library is top first while ordered; once a client shuffled it, it is
+// only the set of cards, and blind counts the cards drawn from it since,
+// which are in the hand without the log saying which.
+type auditZones struct {
+	library []string
+	hand    map[string]bool
+	ordered bool
+	blind   int
+}
+
+func (z *auditZones) inLibrary(id string) bool {
+	for _, libraryID := range z.library {
+		if libraryID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *auditZones) removeFromLibrary(id string) bool {
+	for i, libraryID := range z.library {
+		if libraryID == id {
+			z.library = append(z.library[:i], z.library[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// mayHold reports whether the card could be in the hand.
+func (z *auditZones) mayHold(id string) bool {
+	return z.hand[id] || z.blind > 0 && z.inLibrary(id)
+}
+
+// take removes a card from the hand or library, reporting whether it was in
+// either. A card taken from an unordered library may have been drawn blind.
+func (z *auditZones) take(id string) bool {
+	if z.hand[id] {
+		delete(z.hand, id)
+		return true
+	}
+	if !z.removeFromLibrary(id) {
+		return false
+	}
+	if z.blind > 0 {
+		z.blind--
+	}
+	return true
+}
+
+// auditGame replays one game's events against its deal.
+type auditGame struct {
+	report    *auditGameReport
+	zones     map[string]*auditZones
+	owners    map[string]string
+	mulligans map[string]int
+	noted     map[string]bool
+}
+
+type auditAction struct {
+	Kind         string           `json:"kind"`
+	ID           string           `json:"id"`
+	CardID       string           `json:"cardId"`
+	PlayerName   string           `json:"playerName"`
+	Zone         string           `json:"zone"`
+	LibraryPlace string           `json:"libraryPlace"`
+	Card         *boardCardOwner  `json:"card"`
+	Cards        []boardCardOwner `json:"cards"`
+	Updates      struct {
+		Zone *string `json:"zone"`
+	} `json:"updates"`
+}
+
+func newAuditGame(report *auditGameReport, deal auditDeal) *auditGame {
+	game := &auditGame{
+		report:    report,
+		zones:     make(map[string]*auditZones),
+		owners:    make(map[string]string),
+		mulligans: make(map[string]int),
+		noted:     make(map[string]bool),
+	}
+	order := append([]int(nil), deal.Seats...)
+	shuffleWithSeed(order, deal.OrderSeed)
+	if !slices.Equal(order, deal.TurnOrder) {
+		game.issue(0, "", "the turn order does not match its seed")
+	}
+	for _, card := range deal.Board {
+		game.owners[card.ID] = card.Owner
+	}
+	for _, player := range deal.Players {
+		names := append([]string(nil), player.Deck...)
+		shuffleWithSeed(names, player.Seed)
+		dealt := make([]string, len(player.Library))
+		for i, card := range player.Library {
+			dealt[i] = card.Name
+		}
+		if !slices.Equal(names, dealt) {
+			game.issue(0, player.PlayerName, "the library dealt does not match its seed")
+		}
+		zones := &auditZones{hand: make(map[string]bool), ordered: true}
+		for i := len(player.Library) - 1; i >= 0; i-- {
+			id := player.Library[i].ID
+			game.owners[id] = player.PlayerName
+			if i >= len(player.Library)-openingHandSize {
+				zones.hand[id] = true
+			} else {
+				zones.library = append(zones.library, id)
+			}
+		}
+		game.zones[player.PlayerName] = zones
+	}
+	return game
+}
+
+func (g *auditGame) issue(eventID int64, player string, format string, args ...interface{}) {
+	g.report.Issues = append(g.report.Issues, auditFinding{EventID: eventID, Player: player, Message: fmt.Sprintf(format, args...)})
+}
+
+// unchecked notes a stretch that cannot be checked, once per player and
+// reason.
+func (g *auditGame) unchecked(eventID int64, player string, message string) {
+	if g.noted[player+"\x00"+message] {
+		return
+	}
+	g.noted[player+"\x00"+message] = true
+	g.report.Unchecked = append(g.report.Unchecked, auditFinding{EventID: eventID, Player: player, Message: message})
+}
+
+func (g *auditGame) playerZones(player string) *auditZones {
+	zones := g.zones[player]
+	if zones == nil {
+		zones = &auditZones{hand: make(map[string]bool), ordered: true}
+		g.zones[player] = zones
+	}
+	return zones
+}
+
+func (g *auditGame) apply(eventID int64, eventType string, player string, data json.RawMessage) {
+	switch eventType {
+	case roomEventGameResult:
+		g.report.Finished = true
+	case roomEventMulligan:
+		var decision mulliganDecision
+		if json.Unmarshal(data, &decision) == nil {
+			g.applyMulligan(eventID, player, decision)
+		}
+	case roomEventScry:
+		var decision scryDecision
+		if json.Unmarshal(data, &decision) == nil {
+			g.applyScry(eventID, player, decision)
+		}
+	case "CARD_ACTION":
+		var action auditAction
+		if json.Unmarshal(data, &action) == nil {
+			g.applyAction(eventID, player, action)
+		}
+	}
+}
+
+func (g *auditGame) applyMulligan(eventID int64, player string, decision mulliganDecision) {
+	zones := g.playerZones(player)
+	if decision.Kind == mulliganKindKeep {
+		if decision.Mulligans != g.mulligans[player] {
+			g.issue(eventID, player, "keeps after %d mulligans but took %d", decision.Mulligans, g.mulligans[player])
+		}
+		if len(decision.Bottom) != decision.bottomCount() {
+			g.issue(eventID, player, "puts %d cards on the bottom instead of %d", len(decision.Bottom), decision.bottomCount())
+		}
+		for _, id := range decision.Bottom {
+			if !zones.mayHold(id) || !zones.take(id) {
+				g.issue(eventID, player, "puts %s on the bottom, which is not in their hand", id)
+				continue
+			}
+			zones.library = append(zones.library, id)
+		}
+		return
+	}
+	g.mulligans[player]++
+	if decision.Mulligans != g.mulligans[player] {
+		g.issue(eventID, player, "records mulligan %d as mulligan %d", g.mulligans[player], decision.Mulligans)
+	}
+	pile := append([]string(nil), zones.library...)
+	for id := range zones.hand {
+		pile = append(pile, id)
+	}
+	if len(pile) < openingHandSize {
+		g.issue(eventID, player, "takes a mulligan with %d cards in hand and library", len(pile))
+		return
+	}
+	// As redealHand: sorted by id, shuffled with the seed, the first seven
+	// dealt.
+	sort.Strings(pile)
+	shuffleWithSeed(pile, decision.Seed)
+	zones.hand = make(map[string]bool)
+	for _, id := range pile[:openingHandSize] {
+		zones.hand[id] = true
+	}
+	zones.library, zones.ordered, zones.blind = pile[openingHandSize:], true, 0
+}
+
+func (g *auditGame) applyScry(eventID int64, player string, decision scryDecision) {
+	zones := g.playerZones(player)
+	looked := append(append(append([]string(nil), decision.Top...), decision.Bottom...), decision.Graveyard...)
+	if len(looked) != decision.Count {
+		g.issue(eventID, player, "places %d cards after looking at %d", len(looked), decision.Count)
+	}
+	if zones.ordered {
+		top := make(map[string]bool)
+		for _, id := range zones.library[:min(decision.Count, len(zones.library))] {
+			top[id] = true
+		}
+		for _, id := range looked {
+			if !top[id] {
+				g.issue(eventID, player, "looks at %s, which is not among the top %d cards of their library", id, decision.Count)
+			}
+		}
+	}
+	for _, id := range looked {
+		if !zones.removeFromLibrary(id) && !zones.ordered {
+			g.issue(eventID, player, "looks at %s, which is not in their library", id)
+		}
+	}
+	if zones.ordered {
+		zones.library = append(append(append([]string(nil), decision.Top...), zones.library...), decision.Bottom...)
+		return
+	}
+	zones.library = append(append(zones.library, decision.Top...), decision.Bottom...)
+}
+
+func (g *auditGame) applyAction(eventID int64, player string, action auditAction) {
+	if action.PlayerName != "" {
+		player = action.PlayerName
+	}
+	cardID := action.ID
+	if cardID == "" {
+		cardID = action.CardID
+	}
+	owner, known := g.owners[cardID]
+	if cardID != "" && !known && action.Kind != "add" && action.Kind != "addToLibrary" {
+		g.issue(eventID, player, "acts on %s, which was never dealt or added", cardID)
+		return
+	}
+	switch action.Kind {
+	case "drawFromLibrary":
+		zones := g.playerZones(player)
+		switch {
+		case len(zones.library)-zones.blind <= 0:
+			g.issue(eventID, player, "draws from an empty library")
+		case zones.ordered:
+			zones.hand[zones.library[0]] = true
+			zones.library = zones.library[1:]
+		default:
+			zones.blind++
+		}
+	case "shuffleLibrary", "mulligan":
+		zones := g.playerZones(player)
+		if action.Kind == "mulligan" {
+			// The client shuffles the hand back and draws seven.
+			for id := range zones.hand {
+				zones.library = append(zones.library, id)
+			}
+			zones.hand = make(map[string]bool)
+			zones.blind = min(openingHandSize, len(zones.library))
+		}
+		zones.ordered = false
+		g.unchecked(eventID, player, "shuffles without a recorded seed; their library is not checked until their next mulligan")
+	case "reorderHand":
+		if zones := g.zones[owner]; zones == nil || !zones.mayHold(cardID) {
+			g.issue(eventID, player, "reorders %s in their hand, which is not in it", cardID)
+		}
+	case "reorderLibrary":
+		zones := g.zones[owner]
+		if zones == nil || !zones.inLibrary(cardID) {
+			g.issue(eventID, player, "reorders %s in their library, which is not in it", cardID)
+			return
+		}
+		zones.ordered = false
+		g.unchecked(eventID, owner, "reorders their library by hand; it is not checked until their next mulligan")
+	case "add", "addToLibrary":
+		if action.Card == nil {
+			return
+		}
+		zone := action.Card.Zone
+		if action.Kind == "addToLibrary" {
+			zone = "library"
+		}
+		g.owners[action.Card.ID] = action.Card.OwnerID
+		g.place(eventID, action.Card.OwnerID, action.Card.ID, zone, "")
+		if privateZones[zone] {
+			g.unchecked(eventID, action.Card.OwnerID, "adds cards to their "+zone)
+		}
+	case "replaceLibrary":
+		zones := g.playerZones(player)
+		g.issue(eventID, player, "replaces their library with %d cards", len(action.Cards))
+		zones.library, zones.ordered, zones.blind = nil, false, 0
+		for _, card := range action.Cards {
+			g.owners[card.ID] = player
+			zones.library = append(zones.library, card.ID)
+		}
+	case "changeZone":
+		g.place(eventID, owner, cardID, action.Zone, action.LibraryPlace)
+	case "updateCard":
+		if action.Updates.Zone != nil {
+			g.place(eventID, owner, cardID, *action.Updates.Zone, "")
+		}
+	case "setCommander":
+		g.place(eventID, owner, cardID, "commander", "")
+	case "remove":
+		g.place(eventID, owner, cardID, "", "")
+		delete(g.owners, cardID)
+	}
+}
+
+// place moves a card to a zone; "" takes it out of the game.
+func (g *auditGame) place(eventID int64, owner string, id string, zone string, libraryPlace string) {
+	if owner == "" {
+		if privateZones[zone] {
+			g.unchecked(eventID, "", "moves a card without an owner into a hand or library")
+		}
+		return
+	}
+	zones := g.playerZones(owner)
+	zones.take(id)
+	switch {
+	case zone == "hand":
+		zones.hand[id] = true
+	case zone == "library" && zones.ordered && libraryPlace == "top":
+		zones.library = append([]string{id}, zones.library...)
+	case zone == "library" && zones.ordered && libraryPlace == "bottom":
+		zones.library = append(zones.library, id)
+	case zone == "library":
+		zones.library = append(zones.library, id)
+		if zones.ordered {
+			zones.ordered = false
+			g.unchecked(eventID, owner, "puts a card into their library at a place the server did not choose; it is not checked until their next mulligan")
+		}
+	}
+}
+
+// verifyRoom checks every audited game of the room, oldest first.
+func (a *App) verifyRoom(ctx context.Context, roomID string) ([]auditGameReport, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT game, after_event_id, deal, created_at FROM room_audits WHERE room_id = ? ORDER BY id
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	type audit struct {
+		report auditGameReport
+		after  int64
+		deal   auditDeal
+	}
+	var audits []audit
+	for rows.Next() {
+		var item audit
+		var deal string
+		if err := rows.Scan(&item.report.Game, &item.after, &deal, &item.report.DealtAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(deal), &item.deal); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		audits = append(audits, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reports := make([]auditGameReport, 0, len(audits))
+	for i, item := range audits {
+		report := item.report
+		report.Issues, report.Unchecked = []auditFinding{}, []auditFinding{}
+		game := newAuditGame(&report, item.deal)
+		query := `
+			SELECT id, event_type, event_data, COALESCE(player_name, '')
+			FROM room_events
+			WHERE room_id = ? AND id > ? AND reverted_at IS NULL`
+		args := []interface{}{roomID, item.after}
+		if i+1 < len(audits) {
+			query += ` AND id <= ?`
+			args = append(args, audits[i+1].after)
+		}
+		events, err := a.db.QueryContext(ctx, query+` ORDER BY id`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for events.Next() {
+			var eventID int64
+			var eventType, data, player string
+			if err := events.Scan(&eventID, &eventType, &data, &player); err != nil {
+				events.Close()
+				return nil, err
+			}
+			report.Events++
+			game.apply(eventID, eventType, player, json.RawMessage(data))
+		}
+		events.Close()
+		if err := events.Err(); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// handleVerifyReplay checks the room's audited games and reports whether
+// their logs are consistent with their deals.
+func (a *App) handleVerifyReplay(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	reports, err := a.verifyRoom(r.Context(), roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to verify the room's games")
+		return
+	}
+	if len(reports) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "The room has no audited games")
+		return
+	}
+	verified := true
+	for _, report := range reports {
+		verified = verified && len(report.Issues) == 0
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId":   roomID,
+		"verified": verified,
+		"games":    reports,
+	})
+}
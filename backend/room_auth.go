@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	roomTokenHeader    = "X-Room-Token"
+	roomPasswordHeader = "X-Room-Password"
+)
+
+// roomTokenSigner issues HMAC-signed tokens that grant access to one room's
+// REST endpoints. Each participant gets their own on room:create/room:join,
+// naming the player it was issued to; it expires after ROOM_TOKEN_TTL_SECONDS
+// and a member renews it with room:token. Without ROOM_TOKEN_SECRET the key
+// is random per process, so tokens stop working after a restart and clients
+// must rejoin to get new ones.
+type roomTokenSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+func loadRoomTokenSigner() *roomTokenSigner {
+	signer := &roomTokenSigner{
+		ttl: time.Duration(envInt("ROOM_TOKEN_TTL_SECONDS", 60*60)) * time.Second,
+	}
+	if secret := strings.TrimSpace(os.Getenv("ROOM_TOKEN_SECRET")); secret != "" {
+		signer.key = []byte(secret)
+		return signer
+	}
+	signer.key = make([]byte, 32)
+	if _, err := rand.Read(signer.key); err != nil {
+		log.Fatalf("failed to generate room token key: %v", err)
+	}
+	return signer
+}
+
+func (s *roomTokenSigner) sign(message string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type roomTokenClaims struct {
+	RoomID     string `json:"r"`
+	PlayerID   string `json:"i"`
+	PlayerName string `json:"p,omitempty"`
+	UserID     int64  `json:"u,omitempty"`
+	Expires    int64  `json:"e"`
+}
+
+func (s *roomTokenSigner) issue(roomID string, member ClientInfo) string {
+	message, _ := json.Marshal(roomTokenClaims{
+		RoomID:     roomID,
+		PlayerID:   member.PlayerID,
+		PlayerName: member.PlayerName,
+		UserID:     member.UserID,
+		Expires:    time.Now().Add(s.ttl).Unix(),
+	})
+	return base64.RawURLEncoding.EncodeToString(message) + "." + s.sign(string(message))
+}
+
+// verify returns the claims of a token that is correctly signed, unexpired
+// and issued for roomID.
+func (s *roomTokenSigner) verify(token string, roomID string) (roomTokenClaims, bool) {
+	var claims roomTokenClaims
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, false
+	}
+	message, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, false
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(string(message)))) {
+		return claims, false
+	}
+	if err := json.Unmarshal(message, &claims); err != nil || claims.RoomID != roomID {
+		return claims, false
+	}
+	return claims, time.Now().Unix() < claims.Expires
+}
+
+// resumeRoomToken checks the token on a room:join and takes the player from
+// it, reporting false when the token is not one this client may resume with.
+func (a *App) resumeRoomToken(client *WSClient, payload *RoomJoinPayload) bool {
+	claims, ok := a.roomTokens.verify(payload.RoomToken, payload.RoomID)
+	if !ok || claims.PlayerID == "" || claims.UserID != client.userID {
+		return false
+	}
+	if payload.PlayerID != "" && payload.PlayerID != claims.PlayerID {
+		return false
+	}
+	payload.PlayerID = claims.PlayerID
+	payload.PlayerName = claims.PlayerName
+	payload.resumed = true
+	return true
+}
+
+// handleRoomTokenRefresh answers room:token with a fresh token for the
+// socket's own membership.
+func (a *App) handleRoomTokenRefresh(client *WSClient, raw json.RawMessage) {
+	var payload struct {
+		RoomID string `json:"roomId"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil || payload.RoomID == "" {
+		a.sendError(client.id, codeInvalidRequest, "roomId is required")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	a.send(client.id, WSMessage{
+		Type: "room:token",
+		Payload: marshalPayload(map[string]string{
+			"roomId":    payload.RoomID,
+			"roomToken": a.roomTokens.issue(payload.RoomID, member),
+		}),
+	})
+}
+
+// hasPlayer reports whether the player is still in the live room.
+func (r *RoomRegistry) hasPlayer(roomID string, playerID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return false
+	}
+	if room.HostPlayerID == playerID {
+		return true
+	}
+	for _, client := range room.Clients {
+		if client.PlayerID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostPlayer reports whether the player is the live room's host.
+func (r *RoomRegistry) isHostPlayer(roomID string, playerID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	return room != nil && playerID != "" && room.HostPlayerID == playerID
+}
+
+// roomSocket returns one of the user's sockets that is in the room, if any.
+func (p *presenceTracker) roomSocket(userID int64, roomID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for socketID, socketRoom := range p.sockets[userID] {
+		if socketRoom == roomID {
+			return socketID
+		}
+	}
+	return ""
+}
+
+// PlayerName returns the name the socket joined the room under.
+func (r *RoomRegistry) PlayerName(roomID string, socketID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return ""
+	}
+	if room.HostSocketID == socketID {
+		return room.HostPlayerName
+	}
+	return room.Clients[socketID].PlayerName
+}
+
+// checkPassword reports whether the room is live and password protected with
+// the given password. Open rooms never match, so they need a member or token.
+func (r *RoomRegistry) checkPassword(roomID string, password string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil || room.Password == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(room.Password), []byte(password)) == 1
+}
+
+// roomViewer is who a room request is made on behalf of. Only that player's
+// private zones are returned, plus any cards other players have revealed to
+// them; admins see every zone.
+type roomViewer struct {
+	PlayerID   string
+	PlayerName string
+	UserID     int64
+	All        bool
+	Revealed   map[string]bool
+}
+
+type roomViewerKey struct{}
+
+func viewerFromRequest(r *http.Request) roomViewer {
+	viewer, _ := r.Context().Value(roomViewerKey{}).(roomViewer)
+	return viewer
+}
+
+// requireRoomAccess guards the /api/rooms/{roomId} endpoints. Callers need a
+// room token issued on room:create/room:join, the password of a live
+// protected room, or a signed-in account with a socket in the room. Admins
+// can always read and write.
+func (a *App) requireRoomAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "roomId")
+		serve := func(viewer roomViewer) {
+			next(w, r.WithContext(context.WithValue(r.Context(), roomViewerKey{}, viewer)))
+		}
+		if token := r.Header.Get(roomTokenHeader); token != "" {
+			if claims, ok := a.roomTokens.verify(token, roomID); ok {
+				serve(claims.viewer())
+				return
+			}
+		}
+		if password := r.Header.Get(roomPasswordHeader); password != "" && a.rooms.checkPassword(roomID, password) {
+			serve(roomViewer{})
+			return
+		}
+		user, err := a.userFromRequest(r)
+		if err == errTokenScope {
+			writeError(w, http.StatusForbidden, codeInsufficientScope, err.Error())
+			return
+		}
+		if user != nil && user.isAdmin() {
+			serve(roomViewer{All: true})
+			return
+		}
+		if user != nil {
+			if socketID := a.presence.roomSocket(user.ID, roomID); socketID != "" {
+				serve(roomViewer{PlayerName: a.rooms.PlayerName(roomID, socketID)})
+				return
+			}
+		}
+		if user == nil {
+			writeError(w, http.StatusUnauthorized, codeRoomAccessRequired, "Room access requires a room token or password")
+			return
+		}
+		writeError(w, http.StatusForbidden, codeForbidden, "You are not a member of this room")
+	}
+}
+
+func (c roomTokenClaims) viewer() roomViewer {
+	return roomViewer{PlayerID: c.PlayerID, PlayerName: c.PlayerName, UserID: c.UserID}
+}
+
+// requireRoomToken guards the endpoints that persist a room's state and
+// events. They are only open to the holder of a room token whose player is
+// still in the live room, so what is saved is attributed to the identity
+// that joined over the socket, and to admins.
+func (a *App) requireRoomToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "roomId")
+		serve := func(viewer roomViewer) {
+			next(w, r.WithContext(context.WithValue(r.Context(), roomViewerKey{}, viewer)))
+		}
+		if token := r.Header.Get(roomTokenHeader); token != "" {
+			claims, ok := a.roomTokens.verify(token, roomID)
+			if ok && claims.PlayerID != "" && a.rooms.hasPlayer(roomID, claims.PlayerID) {
+				serve(claims.viewer())
+				return
+			}
+			if ok {
+				writeError(w, http.StatusForbidden, codeForbidden, "You are no longer in this room")
+				return
+			}
+		}
+		user, err := a.userFromRequest(r)
+		if err == errTokenScope {
+			writeError(w, http.StatusForbidden, codeInsufficientScope, err.Error())
+			return
+		}
+		if user != nil && user.isAdmin() {
+			serve(roomViewer{All: true})
+			return
+		}
+		writeError(w, http.StatusUnauthorized, codeRoomAccessRequired, "Saving to a room requires a current room token")
+	}
+}
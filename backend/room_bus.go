@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	roomBusHeartbeat   = 10 * time.Second
+	roomBusPeerTimeout = 3 * roomBusHeartbeat
+	roomBusDialTimeout = 5 * time.Second
+	roomBusIOTimeout   = 5 * time.Second
+	roomBusRetryDelay  = time.Second
+)
+
+// roomBus lets several backend instances share rooms over Redis pub/sub.
+// Every instance keeps a replica of the room registry: creates, joins and
+// leaves are published so peers can route to sockets they do not hold, and a
+// send to a remote socket is published for the instance that holds it.
+// Instances announce themselves on a heartbeat; when one goes quiet its
+// sockets are dropped as if they had disconnected. Friend presence stays per
+// instance. Enabled by ROOM_BUS_URL (redis:// or rediss://).
+type roomBus struct {
+	app      *App
+	url      *url.URL
+	channel  string
+	instance string
+
+	pubMu     sync.Mutex
+	pubConn   net.Conn
+	pubReader *bufio.Reader
+
+	peersMu sync.Mutex
+	peers   map[string]time.Time
+	remote  map[string]string
+}
+
+type busMessage struct {
+	Origin  string     `json:"origin"`
+	Kind    string     `json:"kind"`
+	Room    *busRoom   `json:"room,omitempty"`
+	Sockets []string   `json:"sockets,omitempty"`
+	Message *WSMessage `json:"message,omitempty"`
+}
+
+// busRoom is a RoomState as it travels between instances.
+type busRoom struct {
+	ID             string                `json:"id"`
+	Password       string                `json:"password,omitempty"`
+	HostSocketID   string                `json:"hostSocketId"`
+	HostPlayerID   string                `json:"hostPlayerId"`
+	HostPlayerName string                `json:"hostPlayerName"`
+	HostUserID     int64                 `json:"hostUserId,omitempty"`
+	HostSeat       int                   `json:"hostSeat,omitempty"`
+	TeamSize       int                   `json:"teamSize,omitempty"`
+	HouseRules     *houseRules           `json:"houseRules,omitempty"`
+	GroupID        string                `json:"groupId,omitempty"`
+	Clients        map[string]ClientInfo `json:"clients"`
+	TurnOrder      []int                 `json:"turnOrder,omitempty"`
+}
+
+func loadRoomBus(app *App) (*roomBus, error) {
+	raw := strings.TrimSpace(os.Getenv("ROOM_BUS_URL"))
+	if raw == "" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("ROOM_BUS_URL: %w", err)
+	}
+	if parsed.Scheme != "redis" && parsed.Scheme != "rediss" {
+		return nil, fmt.Errorf("ROOM_BUS_URL must be a redis:// or rediss:// URL")
+	}
+	channel := strings.TrimSpace(os.Getenv("ROOM_BUS_CHANNEL"))
+	if channel == "" {
+		channel = "mtonline:rooms"
+	}
+	return &roomBus{
+		app:      app,
+		url:      parsed,
+		channel:  channel,
+		instance: randomID(8),
+		peers:    make(map[string]time.Time),
+		remote:   make(map[string]string),
+	}, nil
+}
+
+func (b *roomBus) start() {
+	if b == nil {
+		return
+	}
+	log.Printf("[bus] instance %s using %s channel %s", b.instance, b.url.Host, b.channel)
+	go b.subscribe()
+	go b.heartbeat()
+}
+
+func (b *roomBus) publish(message busMessage) {
+	if b == nil {
+		return
+	}
+	message.Origin = b.instance
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pubConn == nil {
+			if b.pubConn, b.pubReader, err = dialRedis(b.url); err != nil {
+				break
+			}
+		}
+		// A stalled Redis must not hold pubMu, and with it every publisher,
+		// indefinitely: a timed out round-trip drops the connection like
+		// any other error.
+		if err = b.pubConn.SetDeadline(time.Now().Add(roomBusIOTimeout)); err == nil {
+			if err = writeRedisCommand(b.pubConn, "PUBLISH", b.channel, string(data)); err == nil {
+				if _, err = readRedisReply(b.pubReader); err == nil {
+					return
+				}
+			}
+		}
+		b.pubConn.Close()
+		b.pubConn, b.pubReader = nil, nil
+	}
+	log.Printf("[bus] publish %s failed: %v", message.Kind, err)
+}
+
+// publishRoom shares the room with peers, claiming socketIDs as held here.
+func (b *roomBus) publishRoom(roomID string, socketIDs ...string) {
+	if b == nil {
+		return
+	}
+	room, ok := b.app.rooms.exportRoom(roomID)
+	if !ok {
+		return
+	}
+	b.publish(busMessage{Kind: "room", Room: &room, Sockets: socketIDs})
+}
+
+func (b *roomBus) publishLeave(socketIDs ...string) {
+	if b == nil {
+		return
+	}
+	b.publish(busMessage{Kind: "leave", Sockets: socketIDs})
+}
+
+// publishBroadcast hands a message meant for every client to the peers.
+func (b *roomBus) publishBroadcast(message WSMessage) {
+	if b == nil {
+		return
+	}
+	b.publish(busMessage{Kind: "broadcast", Message: &message})
+}
+
+// publishMaintenance hands a changed maintenance window to the peers.
+func (b *roomBus) publishMaintenance(message WSMessage) {
+	if b == nil {
+		return
+	}
+	b.publish(busMessage{Kind: "maintenance", Message: &message})
+}
+
+// forward hands a message to whichever peer holds the socket. It reports
+// false when the socket is unknown to every instance.
+func (b *roomBus) forward(socketID string, message WSMessage) bool {
+	if b == nil {
+		return false
+	}
+	b.peersMu.Lock()
+	_, known := b.remote[socketID]
+	b.peersMu.Unlock()
+	if !known {
+		return false
+	}
+	b.publish(busMessage{Kind: "send", Sockets: []string{socketID}, Message: &message})
+	return true
+}
+
+func (b *roomBus) subscribe() {
+	for {
+		if err := b.listen(); err != nil {
+			log.Printf("[bus] subscription lost: %v", err)
+		}
+		time.Sleep(roomBusRetryDelay)
+	}
+}
+
+func (b *roomBus) listen() error {
+	conn, reader, err := dialRedis(b.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := writeRedisCommand(conn, "SUBSCRIBE", b.channel); err != nil {
+		return err
+	}
+	if _, err := readRedisReply(reader); err != nil {
+		return err
+	}
+	// Ask peers for their rooms, which also covers anything missed while
+	// this subscription was down.
+	b.publish(busMessage{Kind: "sync"})
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 || parts[0] != "message" {
+			continue
+		}
+		payload, _ := parts[2].(string)
+		var message busMessage
+		if json.Unmarshal([]byte(payload), &message) != nil || message.Origin == b.instance {
+			continue
+		}
+		b.handle(message)
+	}
+}
+
+func (b *roomBus) handle(message busMessage) {
+	b.peersMu.Lock()
+	b.peers[message.Origin] = time.Now()
+	b.peersMu.Unlock()
+
+	switch message.Kind {
+	case "room":
+		if message.Room == nil {
+			return
+		}
+		b.peersMu.Lock()
+		for _, socketID := range message.Sockets {
+			b.remote[socketID] = message.Origin
+		}
+		b.peersMu.Unlock()
+		b.app.rooms.mergeRoom(*message.Room)
+	case "leave":
+		for _, socketID := range message.Sockets {
+			b.peersMu.Lock()
+			delete(b.remote, socketID)
+			b.peersMu.Unlock()
+			// The host leaving closes the room on its instance, which
+			// reports it; here it only has to be forgotten.
+			if roomID, _, _, wasHost := b.app.rooms.RemoveSocket(socketID); wasHost {
+				b.app.forgetRoom(roomID)
+			}
+		}
+	case "send":
+		if message.Message == nil {
+			return
+		}
+		for _, socketID := range message.Sockets {
+			b.app.sendLocal(socketID, *message.Message)
+		}
+	case "broadcast":
+		if message.Message != nil {
+			b.app.broadcastLocal(*message.Message)
+		}
+	case "maintenance":
+		if message.Message != nil {
+			b.app.applyPeerMaintenance(*message.Message)
+		}
+	case "sync":
+		for _, roomID := range b.app.rooms.roomIDs() {
+			if local := b.app.localSockets(b.app.rooms.socketIDs(roomID)); len(local) > 0 {
+				b.publishRoom(roomID, local...)
+			}
+		}
+	}
+}
+
+// heartbeat announces this instance and drops the sockets of peers that
+// stopped announcing themselves, telling local room members as a disconnect
+// would.
+func (b *roomBus) heartbeat() {
+	ticker := time.NewTicker(roomBusHeartbeat)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.publish(busMessage{Kind: "alive"})
+
+		var gone []string
+		b.peersMu.Lock()
+		for peer, seen := range b.peers {
+			if time.Since(seen) < roomBusPeerTimeout {
+				continue
+			}
+			delete(b.peers, peer)
+			for socketID, owner := range b.remote {
+				if owner == peer {
+					gone = append(gone, socketID)
+					delete(b.remote, socketID)
+				}
+			}
+			log.Printf("[bus] peer %s timed out", peer)
+		}
+		b.peersMu.Unlock()
+		for _, socketID := range gone {
+			if closed := b.app.leaveRoom(socketID, func(id string, message WSMessage) { b.app.sendLocal(id, message) }); closed != "" {
+				b.app.forgetRoom(closed)
+			}
+		}
+	}
+}
+
+func (r *RoomRegistry) exportRoom(roomID string) (busRoom, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return busRoom{}, false
+	}
+	clients := make(map[string]ClientInfo, len(room.Clients))
+	for socketID, info := range room.Clients {
+		clients[socketID] = info
+	}
+	return busRoom{
+		ID:             room.ID,
+		Password:       room.Password,
+		HostSocketID:   room.HostSocketID,
+		HostPlayerID:   room.HostPlayerID,
+		HostPlayerName: room.HostPlayerName,
+		HostUserID:     room.HostUserID,
+		HostSeat:       room.HostSeat,
+		TeamSize:       room.TeamSize,
+		HouseRules:     room.HouseRules,
+		GroupID:        room.GroupID,
+		Clients:        clients,
+		TurnOrder:      append([]int(nil), room.TurnOrder...),
+	}, true
+}
+
+// mergeRoom applies a room published by a peer. Clients are only added here;
+// they are removed by the leave that follows a disconnect, so concurrent joins
+// on different instances do not overwrite each other.
+func (r *RoomRegistry) mergeRoom(incoming busRoom) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room := r.rooms[incoming.ID]
+	if room == nil {
+		room = &RoomState{
+			ID:             incoming.ID,
+			Password:       incoming.Password,
+			HostSocketID:   incoming.HostSocketID,
+			HostPlayerID:   incoming.HostPlayerID,
+			HostPlayerName: incoming.HostPlayerName,
+			HostUserID:     incoming.HostUserID,
+			TeamSize:       incoming.TeamSize,
+			GroupID:        incoming.GroupID,
+			Clients:        make(map[string]ClientInfo),
+		}
+		r.rooms[incoming.ID] = room
+		r.socketToRoom[incoming.HostSocketID] = incoming.ID
+		r.socketRole[incoming.HostSocketID] = "host"
+	}
+	// Seats, the turn order and the house rules change in place, so the
+	// latest publish wins.
+	if incoming.HostSeat != 0 {
+		room.HostSeat = incoming.HostSeat
+	}
+	room.TurnOrder = incoming.TurnOrder
+	room.HouseRules = incoming.HouseRules
+	for socketID, info := range incoming.Clients {
+		if socketID == room.HostSocketID {
+			continue
+		}
+		room.Clients[socketID] = info
+		r.socketToRoom[socketID] = incoming.ID
+		r.socketRole[socketID] = "client"
+	}
+}
+
+func (r *RoomRegistry) roomOf(socketID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.socketToRoom[socketID]
+}
+
+func (r *RoomRegistry) roomIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.rooms))
+	for id := range r.rooms {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// socketIDs returns every socket in the room, host first.
+func (r *RoomRegistry) socketIDs(roomID string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return nil
+	}
+	ids := []string{room.HostSocketID}
+	for id := range room.Clients {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (a *App) localSockets(socketIDs []string) []string {
+	a.clientsMu.RLock()
+	defer a.clientsMu.RUnlock()
+	var local []string
+	for _, id := range socketIDs {
+		if a.clients[id] != nil {
+			local = append(local, id)
+		}
+	}
+	return local
+}
+
+func dialRedis(u *url.URL) (net.Conn, *bufio.Reader, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	dialer := &net.Dialer{Timeout: roomBusDialTimeout}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if password, ok := u.User.Password(); ok {
+		conn.SetDeadline(time.Now().Add(roomBusIOTimeout))
+		args := []string{"AUTH", password}
+		if username := u.User.Username(); username != "" {
+			args = []string{"AUTH", username, password}
+		}
+		if err := writeRedisCommand(conn, args...); err == nil {
+			_, err = readRedisReply(reader)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, reader, nil
+}
+
+func writeRedisCommand(w io.Writer, args ...string) error {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// readRedisReply reads one RESP2 reply. Bulk strings and simple strings come
+// back as string, integers as int64, arrays as []interface{} and error
+// replies as an error.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+)
+
+const (
+	maxCardCounterNameLen = 32
+	maxCardCounterValue   = 999
+)
+
+// cardCounters are the named counters on cards, stored with the board under
+// cardCounters: card instance id, then counter name (+1/+1, loyalty, charge),
+// then how many. They are changed with room:card_counter and sent to the room
+// with the other server-kept counters as room:counters. A card only keeps its
+// counters while it is on the shared board.
+type cardCounters map[string]map[string]int
+
+var errCardNotOnBoard = errors.New("card is not on the board")
+
+type RoomCardCounterPayload struct {
+	RoomID  string   `json:"roomId"`
+	CardID  string   `json:"cardId"`
+	Counter string   `json:"counter"`
+	Value   *float64 `json:"value"`
+	Delta   *float64 `json:"delta"`
+}
+
+// prune drops the counters of cards that are no longer on the board.
+func (c cardCounters) prune(board map[string]bool) {
+	for id, counters := range c {
+		if !board[id] {
+			delete(c, id)
+			continue
+		}
+		for name, count := range counters {
+			if count == 0 {
+				delete(counters, name)
+			}
+		}
+		if len(counters) == 0 {
+			delete(c, id)
+		}
+	}
+}
+
+func (a *App) handleCardCounter(client *WSClient, raw json.RawMessage) {
+	var payload RoomCardCounterPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	payload.Counter = strings.TrimSpace(payload.Counter)
+	switch {
+	case payload.CardID == "" || payload.Counter == "":
+		a.sendError(client.id, codeValidationFailed, "cardId and counter are required")
+		return
+	case len(payload.Counter) > maxCardCounterNameLen:
+		a.sendError(client.id, codeValidationFailed, "counter name is too long")
+		return
+	case (payload.Value == nil) == (payload.Delta == nil):
+		a.sendError(client.id, codeValidationFailed, "send either value or delta")
+		return
+	case payload.Value != nil && (*payload.Value < 0 || *payload.Value > maxCardCounterValue || *payload.Value != math.Trunc(*payload.Value)):
+		a.sendError(client.id, codeValidationFailed, "value must be a whole number between 0 and 999")
+		return
+	case payload.Delta != nil && *payload.Delta != math.Trunc(*payload.Delta):
+		a.sendError(client.id, codeValidationFailed, "delta must be a whole number")
+		return
+	}
+
+	message, err := a.updateRoomCounters(context.Background(), payload.RoomID, func(state map[string]json.RawMessage) error {
+		var board []boardCardOwner
+		_ = json.Unmarshal(state["board"], &board)
+		ids := make(map[string]bool, len(board))
+		for _, card := range board {
+			ids[card.ID] = true
+		}
+		if !ids[payload.CardID] {
+			return errCardNotOnBoard
+		}
+		counters := cardCounters{}
+		_ = json.Unmarshal(state["cardCounters"], &counters)
+		if counters[payload.CardID] == nil {
+			counters[payload.CardID] = make(map[string]int)
+		}
+		count := counters[payload.CardID][payload.Counter]
+		if payload.Value != nil {
+			count = int(*payload.Value)
+		} else {
+			count += int(*payload.Delta)
+		}
+		counters[payload.CardID][payload.Counter] = min(max(count, 0), maxCardCounterValue)
+		counters.prune(ids)
+		state["cardCounters"] = marshalPayload(counters)
+		return nil
+	})
+	if errors.Is(err, errCardNotOnBoard) {
+		a.sendError(client.id, codeNotFound, "card is not on the board")
+		return
+	}
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save card counters")
+		return
+	}
+	event := map[string]interface{}{
+		"kind":    "setCardCounter",
+		"id":      payload.CardID,
+		"counter": payload.Counter,
+		"value":   message.CardCounters[payload.CardID][payload.Counter],
+	}
+	if payload.Delta != nil {
+		event = map[string]interface{}{
+			"kind":    "adjustCardCounter",
+			"id":      payload.CardID,
+			"counter": payload.Counter,
+			"delta":   *payload.Delta,
+		}
+	}
+	_, _ = a.recordRoomEvent(RoomEventPayload{
+		RoomID:     payload.RoomID,
+		EventType:  "CARD_ACTION",
+		EventData:  marshalPayload(event),
+		PlayerID:   member.PlayerID,
+		PlayerName: member.PlayerName,
+		UserID:     member.UserID,
+	})
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:counters",
+		Payload: marshalPayload(message),
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+const (
+	// commanderDamageLethal is the commander damage from a single commander
+	// that loses a player the game.
+	commanderDamageLethal = 21
+	maxCommanderDamage    = 999
+	roomStateWriteRetries = 5
+)
+
+// commanderDamage is the room's commander damage matrix, stored with the
+// board under commanderDamage: target player id, then attacking player id,
+// then the damage dealt.
+type commanderDamage map[string]map[string]int
+
+type RoomCommanderDamagePayload struct {
+	RoomID           string   `json:"roomId"`
+	TargetPlayerID   string   `json:"targetPlayerId"`
+	AttackerPlayerID string   `json:"attackerPlayerId"`
+	Damage           *float64 `json:"damage"`
+	Delta            *float64 `json:"delta"`
+}
+
+// lethalCommanderDamage is a commander that has dealt lethal damage: to one
+// player, or, in a team game, to a team across its players.
+type lethalCommanderDamage struct {
+	TargetPlayerID   string `json:"targetPlayerId,omitempty"`
+	TargetTeam       int    `json:"targetTeam,omitempty"`
+	AttackerPlayerID string `json:"attackerPlayerId"`
+	Damage           int    `json:"damage"`
+}
+
+// roomCountersMessage is sent to the whole room whenever the server changes
+// the stored counters, commander damage, team life totals or card counters.
+type roomCountersMessage struct {
+	RoomID          string                  `json:"roomId"`
+	Version         int64                   `json:"version"`
+	Counters        json.RawMessage         `json:"counters"`
+	CommanderDamage commanderDamage         `json:"commanderDamage"`
+	TeamLife        map[string]int          `json:"teamLife,omitempty"`
+	CardCounters    cardCounters            `json:"cardCounters"`
+	Lethal          []lethalCommanderDamage `json:"lethal"`
+}
+
+// serverKeptState holds the parts of the stored state the server writes
+// itself, so a client saving the whole state without them does not wipe
+// them.
+type serverKeptState struct {
+	CommanderDamage json.RawMessage `json:"commanderDamage"`
+	TeamLife        json.RawMessage `json:"teamLife"`
+	Supplemental    json.RawMessage `json:"supplemental"`
+	CustomCards     json.RawMessage `json:"customCards"`
+	CardCounters    json.RawMessage `json:"cardCounters"`
+}
+
+func (a *App) storedServerState(roomID string) serverKeptState {
+	var kept serverKeptState
+	var stateJSON string
+	if err := a.db.QueryRow(`SELECT board_state FROM rooms WHERE room_id = ?`, roomID).Scan(&stateJSON); err != nil {
+		return kept
+	}
+	_ = json.Unmarshal([]byte(stateJSON), &kept)
+	return kept
+}
+
+// lethal lists the commanders at or over the lethal total. With teams,
+// damage to teammates adds up against the team.
+func (m commanderDamage) lethal(teams map[string]int) []lethalCommanderDamage {
+	lethal := []lethalCommanderDamage{}
+	teamDamage := make(map[int]map[string]int)
+	for target, attackers := range m {
+		team := teams[target]
+		for attacker, damage := range attackers {
+			if team == 0 {
+				if damage >= commanderDamageLethal {
+					lethal = append(lethal, lethalCommanderDamage{TargetPlayerID: target, AttackerPlayerID: attacker, Damage: damage})
+				}
+				continue
+			}
+			if teamDamage[team] == nil {
+				teamDamage[team] = make(map[string]int)
+			}
+			teamDamage[team][attacker] += damage
+		}
+	}
+	for team, attackers := range teamDamage {
+		for attacker, damage := range attackers {
+			if damage >= commanderDamageLethal {
+				lethal = append(lethal, lethalCommanderDamage{TargetTeam: team, AttackerPlayerID: attacker, Damage: damage})
+			}
+		}
+	}
+	sort.Slice(lethal, func(i, j int) bool {
+		if lethal[i].TargetTeam != lethal[j].TargetTeam {
+			return lethal[i].TargetTeam < lethal[j].TargetTeam
+		}
+		if lethal[i].TargetPlayerID != lethal[j].TargetPlayerID {
+			return lethal[i].TargetPlayerID < lethal[j].TargetPlayerID
+		}
+		return lethal[i].AttackerPlayerID < lethal[j].AttackerPlayerID
+	})
+	return lethal
+}
+
+func (a *App) handleCommanderDamage(client *WSClient, raw json.RawMessage) {
+	var payload RoomCommanderDamagePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	switch {
+	case payload.TargetPlayerID == "" || payload.AttackerPlayerID == "":
+		a.sendError(client.id, codeValidationFailed, "targetPlayerId and attackerPlayerId are required")
+		return
+	case (payload.Damage == nil) == (payload.Delta == nil):
+		a.sendError(client.id, codeValidationFailed, "send either damage or delta")
+		return
+	case payload.Damage != nil && (*payload.Damage < 0 || *payload.Damage > maxCommanderDamage || *payload.Damage != math.Trunc(*payload.Damage)):
+		a.sendError(client.id, codeValidationFailed, "damage must be a whole number between 0 and 999")
+		return
+	case payload.Delta != nil && *payload.Delta != math.Trunc(*payload.Delta):
+		a.sendError(client.id, codeValidationFailed, "delta must be a whole number")
+		return
+	}
+
+	message, err := a.updateRoomCounters(context.Background(), payload.RoomID, func(state map[string]json.RawMessage) error {
+		matrix := commanderDamage{}
+		_ = json.Unmarshal(state["commanderDamage"], &matrix)
+		if matrix[payload.TargetPlayerID] == nil {
+			matrix[payload.TargetPlayerID] = make(map[string]int)
+		}
+		damage := matrix[payload.TargetPlayerID][payload.AttackerPlayerID]
+		if payload.Damage != nil {
+			damage = int(*payload.Damage)
+		} else {
+			damage += int(*payload.Delta)
+		}
+		matrix[payload.TargetPlayerID][payload.AttackerPlayerID] = min(max(damage, 0), maxCommanderDamage)
+		state["commanderDamage"] = marshalPayload(matrix)
+		return nil
+	})
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save commander damage")
+		return
+	}
+	event := map[string]interface{}{
+		"kind":             "setCommanderDamage",
+		"targetPlayerId":   payload.TargetPlayerID,
+		"attackerPlayerId": payload.AttackerPlayerID,
+		"damage":           message.CommanderDamage[payload.TargetPlayerID][payload.AttackerPlayerID],
+	}
+	if payload.Delta != nil {
+		event = map[string]interface{}{
+			"kind":             "adjustCommanderDamage",
+			"targetPlayerId":   payload.TargetPlayerID,
+			"attackerPlayerId": payload.AttackerPlayerID,
+			"delta":            *payload.Delta,
+		}
+	}
+	_, _ = a.recordRoomEvent(RoomEventPayload{
+		RoomID:     payload.RoomID,
+		EventType:  "CARD_ACTION",
+		EventData:  marshalPayload(event),
+		PlayerID:   member.PlayerID,
+		PlayerName: member.PlayerName,
+		UserID:     member.UserID,
+	})
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:counters",
+		Payload: marshalPayload(message),
+	})
+}
+
+// updateRoomState applies a change to the stored state, retrying if another
+// write bumps the room version in between. It returns the new version and the
+// state as written.
+func (a *App) updateRoomState(ctx context.Context, roomID string, apply func(state map[string]json.RawMessage) error) (int64, map[string]json.RawMessage, error) {
+	for attempt := 0; attempt < roomStateWriteRetries; attempt++ {
+		var stateJSON string
+		var version int64
+		err := a.db.QueryRowContext(ctx, `SELECT board_state, version FROM rooms WHERE room_id = ?`, roomID).Scan(&stateJSON, &version)
+		exists := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, nil, err
+		}
+		if !exists || stateJSON == "{}" {
+			defaultJSON, _ := json.Marshal(defaultRoomState())
+			stateJSON = string(defaultJSON)
+		}
+		var state map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(stateJSON), &state); err != nil {
+			return 0, nil, err
+		}
+		if err := apply(state); err != nil {
+			return 0, nil, err
+		}
+		updated, _ := json.Marshal(state)
+
+		newVersion, err := a.writeRoomState(ctx, roomID, updated, version, exists)
+		if errors.Is(err, errRoomVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		return newVersion, state, nil
+	}
+	return 0, nil, errRoomVersionConflict
+}
+
+// updateRoomCounters applies a change to the stored counters and returns the
+// message to send the room.
+func (a *App) updateRoomCounters(ctx context.Context, roomID string, apply func(state map[string]json.RawMessage) error) (roomCountersMessage, error) {
+	version, state, err := a.updateRoomState(ctx, roomID, apply)
+	if err != nil {
+		return roomCountersMessage{}, err
+	}
+	message := roomCountersMessage{
+		RoomID:          roomID,
+		Version:         version,
+		Counters:        ensureJSONDefault(state["counters"], []byte("[]")),
+		CommanderDamage: commanderDamage{},
+		CardCounters:    cardCounters{},
+	}
+	_ = json.Unmarshal(state["commanderDamage"], &message.CommanderDamage)
+	_ = json.Unmarshal(state["teamLife"], &message.TeamLife)
+	_ = json.Unmarshal(state["cardCounters"], &message.CardCounters)
+	message.Lethal = message.CommanderDamage.lethal(a.rooms.playerTeams(roomID))
+	return message, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	maxCustomCardsPerRoom = 100
+	maxCustomNameLen      = 100
+	maxCustomTypeLineLen  = 100
+	maxCustomManaCostLen  = 50
+	maxCustomOracleLen    = 1000
+	maxCustomStatLen      = 8
+	maxCustomImageURLLen  = 2048
+)
+
+// Players can bring homebrew cards, tokens and proxies into a game with
+// room:create_custom_card. The server gives each card an id that starts with
+// customCardPrefix, keeps it in the stored state under customCards and tells
+// the room with room:custom_card. POST /cards/batch resolves a room's custom
+// cards, by customId or by name, when the request carries the roomId.
+
+const customCardPrefix = "custom-"
+
+type customCard struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	TypeLine   string `json:"typeLine,omitempty"`
+	ManaCost   string `json:"manaCost,omitempty"`
+	OracleText string `json:"oracleText,omitempty"`
+	Power      string `json:"power,omitempty"`
+	Toughness  string `json:"toughness,omitempty"`
+	ImageURL   string `json:"imageUrl,omitempty"`
+	CreatedBy  string `json:"createdBy"`
+	Custom     bool   `json:"custom"`
+}
+
+type RoomCustomCardPayload struct {
+	RoomID     string `json:"roomId"`
+	Name       string `json:"name"`
+	TypeLine   string `json:"typeLine"`
+	ManaCost   string `json:"manaCost"`
+	OracleText string `json:"oracleText"`
+	Power      string `json:"power"`
+	Toughness  string `json:"toughness"`
+	ImageURL   string `json:"imageUrl"`
+}
+
+// checkCustomCard trims the fields and lists what is wrong with them.
+func checkCustomCard(payload *RoomCustomCardPayload) []string {
+	var details []string
+	for _, field := range []struct {
+		name  string
+		value *string
+		max   int
+	}{
+		{"name", &payload.Name, maxCustomNameLen},
+		{"typeLine", &payload.TypeLine, maxCustomTypeLineLen},
+		{"manaCost", &payload.ManaCost, maxCustomManaCostLen},
+		{"oracleText", &payload.OracleText, maxCustomOracleLen},
+		{"power", &payload.Power, maxCustomStatLen},
+		{"toughness", &payload.Toughness, maxCustomStatLen},
+		{"imageUrl", &payload.ImageURL, maxCustomImageURLLen},
+	} {
+		*field.value = strings.TrimSpace(*field.value)
+		if len(*field.value) > field.max {
+			details = append(details, fmt.Sprintf("%s: must be at most %d characters", field.name, field.max))
+		}
+	}
+	if payload.Name == "" {
+		details = append(details, "name: is required")
+	}
+	if (payload.Power == "") != (payload.Toughness == "") {
+		details = append(details, "power: power and toughness go together")
+	}
+	if payload.ImageURL != "" {
+		parsed, err := url.Parse(payload.ImageURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			details = append(details, "imageUrl: must be an absolute http or https URL")
+		}
+	}
+	return details
+}
+
+func (a *App) handleCreateCustomCard(client *WSClient, raw json.RawMessage) {
+	var payload RoomCustomCardPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if details := checkCustomCard(&payload); len(details) > 0 {
+		a.sendErrorDetails(client.id, codeValidationFailed, "invalid custom card", details)
+		return
+	}
+	card := customCard{
+		ID:         customCardPrefix + randomID(8),
+		Name:       payload.Name,
+		TypeLine:   payload.TypeLine,
+		ManaCost:   payload.ManaCost,
+		OracleText: payload.OracleText,
+		Power:      payload.Power,
+		Toughness:  payload.Toughness,
+		ImageURL:   payload.ImageURL,
+		CreatedBy:  member.PlayerName,
+		Custom:     true,
+	}
+	errTooMany := errors.New("too many custom cards")
+	version, _, err := a.updateRoomState(context.Background(), payload.RoomID, func(state map[string]json.RawMessage) error {
+		cards := map[string]customCard{}
+		_ = json.Unmarshal(state["customCards"], &cards)
+		if len(cards) >= maxCustomCardsPerRoom {
+			return errTooMany
+		}
+		cards[card.ID] = card
+		state["customCards"] = marshalPayload(cards)
+		return nil
+	})
+	if errors.Is(err, errTooMany) {
+		a.sendError(client.id, codeLimitReached, fmt.Sprintf("a room can have at most %d custom cards", maxCustomCardsPerRoom))
+		return
+	}
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save the custom card")
+		return
+	}
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type: "room:custom_card",
+		Payload: marshalPayload(map[string]interface{}{
+			"roomId":  payload.RoomID,
+			"version": version,
+			"card":    card,
+		}),
+	})
+	a.logRoomLine(payload.RoomID, member.PlayerName, member.PlayerName+" creates the custom card "+card.Name)
+}
+
+// roomCustomCards returns a room's custom cards from the stored state.
+func (a *App) roomCustomCards(ctx context.Context, roomID string) map[string]customCard {
+	var stored struct {
+		CustomCards map[string]customCard `json:"customCards"`
+	}
+	var stateJSON string
+	if err := a.db.QueryRowContext(ctx, `SELECT board_state FROM rooms WHERE room_id = ?`, roomID).Scan(&stateJSON); err != nil {
+		return nil
+	}
+	_ = json.Unmarshal([]byte(stateJSON), &stored)
+	return stored.CustomCards
+}
+
+// findCustomCard matches a batch request against a room's custom cards, by id
+// or else by name.
+func findCustomCard(cards map[string]customCard, request batchCardRequest) (customCard, bool) {
+	if request.CustomID != "" {
+		card, ok := cards[request.CustomID]
+		return card, ok
+	}
+	name := normalizeCardName(request.Name)
+	if name == "" {
+		return customCard{}, false
+	}
+	var found customCard
+	for _, card := range cards {
+		// Several cards can share a name; the lowest id wins so lookups are
+		// stable.
+		if normalizeCardName(card.Name) == name && (found.ID == "" || card.ID < found.ID) {
+			found = card
+		}
+	}
+	return found, found.ID != ""
+}
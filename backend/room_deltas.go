@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hosts broadcast the whole board with every room:host_message of type
+// ROOM_STATE or BOARD_STATE. When ROOM_STATE_DELTAS is on (the default) the
+// server remembers the last one of each type per room and sends clients that
+// joined with "acceptDeltas": true an RFC 6902 JSON Patch against it instead,
+// as room:host_message_delta with the message type and the patch. A client
+// is only sent a patch when it received the previous message of that type;
+// anyone else, clients on other instances, and everyone when the patch would
+// not be smaller, gets the full message as before. A client that fails to
+// apply a patch can ask for the board again with room:request_state.
+type deltaTracker struct {
+	enabled bool
+
+	mu    sync.Mutex
+	rooms map[string]map[string]*deltaBase
+}
+
+// deltaBase is the last full message of a type broadcast in a room and the
+// sockets that hold it.
+type deltaBase struct {
+	value   interface{}
+	holders map[string]bool
+}
+
+type hostMessageDelta struct {
+	Type  string         `json:"type"`
+	Patch []deltaPatchOp `json:"patch"`
+}
+
+type deltaPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// deltaMessageTypes are the host messages that carry the full board.
+var deltaMessageTypes = map[string]bool{
+	"ROOM_STATE":  true,
+	"BOARD_STATE": true,
+}
+
+func loadDeltaTracker() *deltaTracker {
+	setting := strings.TrimSpace(os.Getenv("ROOM_STATE_DELTAS"))
+	return &deltaTracker{
+		enabled: setting == "" || envBool("ROOM_STATE_DELTAS"),
+		rooms:   make(map[string]map[string]*deltaBase),
+	}
+}
+
+// forget drops a closed room's baselines.
+func (t *deltaTracker) forget(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.rooms, roomID)
+}
+
+// diverge records that a socket was sent a message of the type outside the
+// room broadcast, so it no longer holds the room's baseline.
+func (t *deltaTracker) diverge(roomID string, messageType string, socketID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if base := t.rooms[roomID][messageType]; base != nil {
+		delete(base.holders, socketID)
+	}
+}
+
+// hostMessageType returns the type of a host message whose deltas are
+// tracked, or "".
+func hostMessageType(message interface{}) string {
+	object, ok := message.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	messageType, _ := object["type"].(string)
+	if !deltaMessageTypes[messageType] {
+		return ""
+	}
+	return messageType
+}
+
+// broadcastHostMessage sends a host's message to the room's clients, as a
+// patch to those that can take one.
+func (a *App) broadcastHostMessage(roomID string, socketIDs []string, message interface{}) {
+	full := WSMessage{Type: "room:host_message", Payload: marshalPayload(message)}
+	messageType := hostMessageType(message)
+	if !a.deltas.enabled || messageType == "" {
+		a.broadcastToRoom(roomID, socketIDs, full)
+		return
+	}
+	value, err := decodeJSONValue(full.Payload)
+	if err != nil {
+		a.broadcastToRoom(roomID, socketIDs, full)
+		return
+	}
+
+	a.deltas.mu.Lock()
+	bases := a.deltas.rooms[roomID]
+	if bases == nil {
+		bases = make(map[string]*deltaBase)
+		a.deltas.rooms[roomID] = bases
+	}
+	previous := bases[messageType]
+	current := &deltaBase{value: value, holders: make(map[string]bool, len(socketIDs))}
+	for _, socketID := range socketIDs {
+		current.holders[socketID] = true
+	}
+	bases[messageType] = current
+	a.deltas.mu.Unlock()
+
+	var delta *WSMessage
+	if previous != nil {
+		patch := diffJSON("", previous.value, value, nil)
+		payload := marshalPayload(hostMessageDelta{Type: messageType, Patch: patch})
+		if len(payload) < len(full.Payload) {
+			delta = &WSMessage{Type: "room:host_message_delta", Payload: payload}
+		}
+	}
+	for _, socketID := range socketIDs {
+		if delta != nil && previous.holders[socketID] && a.acceptsDeltas(socketID) {
+			a.send(socketID, *delta)
+			continue
+		}
+		a.send(socketID, full)
+	}
+}
+
+// acceptsDeltas reports whether a socket on this instance asked for deltas.
+func (a *App) acceptsDeltas(socketID string) bool {
+	a.clientsMu.RLock()
+	client := a.clients[socketID]
+	a.clientsMu.RUnlock()
+	return client != nil && client.acceptDeltas.Load()
+}
+
+// diffJSON appends to ops the RFC 6902 operations that turn before into
+// after. Objects are compared key by key and arrays index by index, with
+// elements added or removed at the end; anything else that differs is
+// replaced.
+func diffJSON(path string, before interface{}, after interface{}, ops []deltaPatchOp) []deltaPatchOp {
+	switch previous := before.(type) {
+	case map[string]interface{}:
+		if next, ok := after.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(previous)+len(next))
+			for key := range previous {
+				keys = append(keys, key)
+			}
+			for key := range next {
+				if _, ok := previous[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				keyPath := path + "/" + escapeJSONPointer(key)
+				oldValue, had := previous[key]
+				newValue, has := next[key]
+				switch {
+				case !has:
+					ops = append(ops, deltaPatchOp{Op: "remove", Path: keyPath})
+				case !had:
+					ops = append(ops, deltaPatchOp{Op: "add", Path: keyPath, Value: marshalPayload(newValue)})
+				default:
+					ops = diffJSON(keyPath, oldValue, newValue, ops)
+				}
+			}
+			return ops
+		}
+	case []interface{}:
+		if next, ok := after.([]interface{}); ok {
+			common := len(previous)
+			if len(next) < common {
+				common = len(next)
+			}
+			for i := 0; i < common; i++ {
+				ops = diffJSON(path+"/"+strconv.Itoa(i), previous[i], next[i], ops)
+			}
+			for i := len(previous) - 1; i >= len(next); i-- {
+				ops = append(ops, deltaPatchOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+			}
+			for i := len(previous); i < len(next); i++ {
+				ops = append(ops, deltaPatchOp{Op: "add", Path: path + "/-", Value: marshalPayload(next[i])})
+			}
+			return ops
+		}
+	}
+	if !jsonEqual(before, after) {
+		ops = append(ops, deltaPatchOp{Op: "replace", Path: path, Value: marshalPayload(after)})
+	}
+	return ops
+}
+
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const maxEmoteTargetLen = 64
+
+// defaultEmotes are the emotes players can send when ROOM_EMOTES is not set.
+var defaultEmotes = []string{"hello", "gg", "thanks", "sorry", "thinking", "nice", "wow", "oops", "laugh", "thumbs_up"}
+
+// Emotes are quick reactions a player sends to the table with room:emote:
+// one of the instance's emotes, aimed at the whole table, a card or another
+// player. The server only checks the emote and who it is aimed at and
+// passes it on to everyone in the room as room:emote; emotes are not stored
+// and are not part of the game log. ROOM_EMOTES (comma separated) sets the
+// emotes, and ROOM_EMOTES_PER_MINUTE how many one socket may send, counted
+// with the same token buckets as the HTTP rate limits.
+type emoteSettings struct {
+	list      []string
+	known     map[string]bool
+	perMinute int
+}
+
+type RoomEmotePayload struct {
+	RoomID         string `json:"roomId"`
+	Emote          string `json:"emote"`
+	TargetCardID   string `json:"targetCardId,omitempty"`
+	TargetPlayerID string `json:"targetPlayerId,omitempty"`
+}
+
+type roomEmoteMessage struct {
+	RoomEmotePayload
+	PlayerID   string `json:"playerId"`
+	PlayerName string `json:"playerName"`
+}
+
+func loadEmoteSettings() emoteSettings {
+	settings := emoteSettings{
+		known:     make(map[string]bool),
+		perMinute: envInt("ROOM_EMOTES_PER_MINUTE", 20),
+	}
+	list := defaultEmotes
+	if raw := strings.TrimSpace(os.Getenv("ROOM_EMOTES")); raw != "" {
+		list = strings.Split(raw, ",")
+	}
+	for _, emote := range list {
+		emote = strings.TrimSpace(emote)
+		if emote == "" || settings.known[emote] {
+			continue
+		}
+		settings.known[emote] = true
+		settings.list = append(settings.list, emote)
+	}
+	return settings
+}
+
+func (a *App) handleEmotes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"emotes":    a.emotes.list,
+		"perMinute": a.emotes.perMinute,
+	})
+}
+
+func (a *App) handleRoomEmote(client *WSClient, raw json.RawMessage) {
+	var payload RoomEmotePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	switch {
+	case !a.emotes.known[payload.Emote]:
+		a.sendErrorDetails(client.id, codeValidationFailed, "unknown emote", map[string]interface{}{"emotes": a.emotes.list})
+		return
+	case payload.TargetCardID != "" && payload.TargetPlayerID != "":
+		a.sendError(client.id, codeValidationFailed, "aim an emote at a card or a player, not both")
+		return
+	case len(payload.TargetCardID) > maxEmoteTargetLen:
+		a.sendError(client.id, codeValidationFailed, "targetCardId is too long")
+		return
+	}
+	if payload.TargetPlayerID != "" && !a.seatedPlayer(payload.RoomID, payload.TargetPlayerID) {
+		a.sendError(client.id, codeNotFound, "player is not in the room")
+		return
+	}
+	if wait := a.rateLimits.allow("emote:"+client.id, a.emotes.perMinute); wait > 0 {
+		seconds := max(int(math.Ceil(wait.Seconds())), 1)
+		a.sendErrorDetails(client.id, codeRateLimited, "Too many emotes, slow down", map[string]int{"retryAfter": seconds})
+		return
+	}
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type: "room:emote",
+		Payload: marshalPayload(roomEmoteMessage{
+			RoomEmotePayload: payload,
+			PlayerID:         member.PlayerID,
+			PlayerName:       member.PlayerName,
+		}),
+	})
+}
+
+// seatedPlayer reports whether playerID is one of the room's players.
+func (a *App) seatedPlayer(roomID string, playerID string) bool {
+	for _, seat := range a.rooms.Seating(roomID) {
+		if seat.PlayerID == playerID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// valueSchema is a small subset of JSON Schema: a type, optional enum,
+// object properties with a required list, and array items. Properties not
+// listed are allowed so that clients can add fields without a server change.
+type valueSchema struct {
+	Type       string
+	Enum       []string
+	Nullable   bool
+	Properties map[string]valueSchema
+	Required   []string
+	Items      *valueSchema
+}
+
+// eventSchema validates one event type. Events that carry a discriminator
+// field pick their object schema from Variants by that field's value.
+type eventSchema struct {
+	Discriminator string
+	Variants      map[string]valueSchema
+	Schema        valueSchema
+}
+
+type eventValidationError struct {
+	Details []string
+}
+
+func (e *eventValidationError) Error() string {
+	return "Invalid event: " + strings.Join(e.Details, "; ")
+}
+
+var (
+	schemaString  = valueSchema{Type: "string"}
+	schemaNumber  = valueSchema{Type: "number"}
+	schemaInteger = valueSchema{Type: "integer"}
+	schemaBoolean = valueSchema{Type: "boolean"}
+	schemaPoint   = valueSchema{
+		Type:       "object",
+		Properties: map[string]valueSchema{"x": schemaNumber, "y": schemaNumber},
+		Required:   []string{"x", "y"},
+	}
+	schemaZone = valueSchema{Type: "string", Enum: []string{"battlefield", "library", "hand", "cemetery", "exile", "commander", "tokens"}}
+	schemaCard = valueSchema{
+		Type: "object",
+		Properties: map[string]valueSchema{
+			"id":       schemaString,
+			"name":     schemaString,
+			"ownerId":  schemaString,
+			"position": schemaPoint,
+			"tapped":   schemaBoolean,
+			"zone":     schemaZone,
+		},
+		Required: []string{"id", "name", "ownerId", "zone"},
+	}
+)
+
+func objectSchema(properties map[string]valueSchema, required ...string) valueSchema {
+	return valueSchema{Type: "object", Properties: properties, Required: required}
+}
+
+// roomEventGameResult records how a game ended: the winning player, or null
+// for a draw. Storing one sends the game.finished webhook.
+const roomEventGameResult = "GAME_RESULT"
+
+// roomEventScry records how a player resolved a scry or surveil: the card ids
+// put back on top (first is the new top), on the bottom, and, for surveil,
+// into the cemetery. Only the server stores it, from room:scry_resolve.
+const roomEventScry = "SCRY"
+
+// roomEventMulligan records a London mulligan: the seed the hand was
+// reshuffled with, or, when the hand is kept, the card ids put on the bottom.
+// Only the server stores it, from room:mulligan and room:mulligan_keep.
+const roomEventMulligan = "MULLIGAN"
+
+// serverEvents are the event types clients cannot store themselves, with the
+// message that records each.
+var serverEvents = map[string]string{
+	roomEventScry:     "room:scry_resolve",
+	roomEventMulligan: "room:mulligan",
+}
+
+var schemaStringList = valueSchema{Type: "array", Items: &schemaString}
+
+// roomEventSchemas lists the event types rooms may record. CARD_ACTION
+// mirrors the CardAction union in the client's game store.
+var roomEventSchemas = map[string]eventSchema{
+	roomEventGameResult: {
+		Schema: objectSchema(map[string]valueSchema{
+			"winnerPlayerId": {Type: "string", Nullable: true},
+			"reason":         {Type: "string", Enum: []string{"concede", "life", "poison", "commander_damage", "decked", "draw", "other"}},
+		}, "winnerPlayerId"),
+	},
+	roomEventScry: {
+		Schema: objectSchema(map[string]valueSchema{
+			"kind":      {Type: "string", Enum: []string{"scry", "surveil"}},
+			"count":     schemaInteger,
+			"top":       schemaStringList,
+			"bottom":    schemaStringList,
+			"graveyard": schemaStringList,
+		}, "kind", "count", "top", "bottom"),
+	},
+	roomEventMulligan: {
+		Schema: objectSchema(map[string]valueSchema{
+			"kind":      {Type: "string", Enum: []string{"mulligan", "keep"}},
+			"mulligans": schemaInteger,
+			"free":      schemaInteger,
+			"seed":      schemaInteger,
+			"bottom":    schemaStringList,
+		}, "kind", "mulligans"),
+	},
+	"CARD_ACTION": {
+		Discriminator: "kind",
+		Variants: map[string]valueSchema{
+			"add":           objectSchema(map[string]valueSchema{"card": schemaCard}, "card"),
+			"updateCard":    objectSchema(map[string]valueSchema{"id": schemaString, "updates": {Type: "object"}}, "id", "updates"),
+			"move":          objectSchema(map[string]valueSchema{"id": schemaString, "position": schemaPoint}, "id", "position"),
+			"moveLibrary":   objectSchema(map[string]valueSchema{"playerName": schemaString, "position": schemaPoint}, "playerName", "position"),
+			"moveCemetery":  objectSchema(map[string]valueSchema{"playerName": schemaString, "position": schemaPoint}, "playerName", "position"),
+			"moveExile":     objectSchema(map[string]valueSchema{"playerName": schemaString, "position": schemaPoint}, "playerName", "position"),
+			"moveCommander": objectSchema(map[string]valueSchema{"playerName": schemaString, "position": schemaPoint}, "playerName", "position"),
+			"moveTokens":    objectSchema(map[string]valueSchema{"playerName": schemaString, "position": schemaPoint}, "playerName", "position"),
+			"toggleTap":     objectSchema(map[string]valueSchema{"id": schemaString}, "id"),
+			"remove":        objectSchema(map[string]valueSchema{"id": schemaString}, "id"),
+			"flipCard":      objectSchema(map[string]valueSchema{"id": schemaString}, "id"),
+			"addToLibrary":  objectSchema(map[string]valueSchema{"card": schemaCard}, "card"),
+			"replaceLibrary": objectSchema(map[string]valueSchema{
+				"cards":      {Type: "array", Items: &schemaCard},
+				"playerName": schemaString,
+			}, "cards", "playerName"),
+			"drawFromLibrary": objectSchema(map[string]valueSchema{"playerName": schemaString}, "playerName"),
+			"changeZone": objectSchema(map[string]valueSchema{
+				"id":           schemaString,
+				"zone":         schemaZone,
+				"position":     schemaPoint,
+				"libraryPlace": {Type: "string", Enum: []string{"top", "bottom", "random"}},
+			}, "id", "zone", "position"),
+			"setCommander":   objectSchema(map[string]valueSchema{"id": schemaString, "position": schemaPoint}, "id", "position"),
+			"reorderHand":    objectSchema(map[string]valueSchema{"cardId": schemaString, "newIndex": schemaInteger, "playerName": schemaString}, "cardId", "newIndex", "playerName"),
+			"reorderLibrary": objectSchema(map[string]valueSchema{"cardId": schemaString, "newIndex": schemaInteger, "playerName": schemaString}, "cardId", "newIndex", "playerName"),
+			"shuffleLibrary": objectSchema(map[string]valueSchema{"playerName": schemaString}, "playerName"),
+			"mulligan":       objectSchema(map[string]valueSchema{"playerName": schemaString}, "playerName"),
+			"createCounter": objectSchema(map[string]valueSchema{
+				"ownerId":  schemaString,
+				"type":     {Type: "string", Enum: []string{"numeral", "plus"}},
+				"position": schemaPoint,
+			}, "ownerId", "type", "position"),
+			"moveCounter": objectSchema(map[string]valueSchema{"counterId": schemaString, "position": schemaPoint}, "counterId", "position"),
+			"modifyCounter": objectSchema(map[string]valueSchema{
+				"counterId": schemaString,
+				"delta":     schemaNumber,
+				"deltaX":    schemaNumber,
+				"deltaY":    schemaNumber,
+				"setValue":  schemaNumber,
+				"setX":      schemaNumber,
+				"setY":      schemaNumber,
+			}, "counterId"),
+			"removeCounterToken":  objectSchema(map[string]valueSchema{"counterId": schemaString}, "counterId"),
+			"setPlayerLife":       objectSchema(map[string]valueSchema{"playerId": schemaString, "life": schemaNumber}, "playerId", "life"),
+			"setSimulatedPlayers": objectSchema(map[string]valueSchema{"count": schemaInteger}, "count"),
+			"setZoomedCard":       objectSchema(map[string]valueSchema{"cardId": {Type: "string", Nullable: true}}, "cardId"),
+			"setCommanderDamage": objectSchema(map[string]valueSchema{
+				"targetPlayerId":   schemaString,
+				"attackerPlayerId": schemaString,
+				"damage":           schemaNumber,
+			}, "targetPlayerId", "attackerPlayerId", "damage"),
+			"setCardCounter": objectSchema(map[string]valueSchema{
+				"id":      schemaString,
+				"counter": schemaString,
+				"value":   schemaNumber,
+			}, "id", "counter", "value"),
+			"adjustCardCounter": objectSchema(map[string]valueSchema{
+				"id":      schemaString,
+				"counter": schemaString,
+				"delta":   schemaNumber,
+			}, "id", "counter", "delta"),
+			"adjustCommanderDamage": objectSchema(map[string]valueSchema{
+				"targetPlayerId":   schemaString,
+				"attackerPlayerId": schemaString,
+				"delta":            schemaNumber,
+			}, "targetPlayerId", "attackerPlayerId", "delta"),
+		},
+	},
+}
+
+// validateRoomEvent checks an event against the registry and returns an
+// *eventValidationError listing every problem found.
+func validateRoomEvent(eventType string, data json.RawMessage) error {
+	schema, ok := roomEventSchemas[eventType]
+	if !ok {
+		known := make([]string, 0, len(roomEventSchemas))
+		for name := range roomEventSchemas {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return &eventValidationError{Details: []string{
+			fmt.Sprintf("eventType: unknown type %q (expected one of %s)", eventType, strings.Join(known, ", ")),
+		}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return &eventValidationError{Details: []string{"eventData: not valid JSON"}}
+	}
+	var details []string
+	if schema.Discriminator == "" {
+		details = validateValue(value, schema.Schema, "eventData")
+	} else {
+		details = validateVariant(value, schema, "eventData")
+	}
+	if len(details) > 0 {
+		return &eventValidationError{Details: details}
+	}
+	return nil
+}
+
+func validateVariant(value interface{}, schema eventSchema, path string) []string {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return []string{path + ": must be an object"}
+	}
+	field := path + "." + schema.Discriminator
+	kind, ok := object[schema.Discriminator].(string)
+	if !ok {
+		return []string{field + ": is required and must be a string"}
+	}
+	variant, ok := schema.Variants[kind]
+	if !ok {
+		return []string{fmt.Sprintf("%s: unknown value %q", field, kind)}
+	}
+	return validateValue(value, variant, path)
+}
+
+func validateValue(value interface{}, schema valueSchema, path string) []string {
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return []string{path + ": must not be null"}
+	}
+	switch schema.Type {
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{path + ": must be a string"}
+		}
+		if len(schema.Enum) > 0 && !containsString(schema.Enum, text) {
+			return []string{fmt.Sprintf("%s: must be one of %s", path, strings.Join(schema.Enum, ", "))}
+		}
+	case "number", "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return []string{path + ": must be a number"}
+		}
+		if schema.Type == "integer" {
+			if _, err := number.Int64(); err != nil {
+				return []string{path + ": must be an integer"}
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{path + ": must be a boolean"}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{path + ": must be an array"}
+		}
+		if schema.Items == nil {
+			return nil
+		}
+		var details []string
+		for i, item := range items {
+			details = append(details, validateValue(item, *schema.Items, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return details
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{path + ": must be an object"}
+		}
+		var details []string
+		for _, name := range schema.Required {
+			if _, present := object[name]; !present {
+				details = append(details, path+"."+name+": is required")
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if field, present := object[name]; present {
+				details = append(details, validateValue(field, schema.Properties[name], path+"."+name)...)
+			}
+		}
+		return details
+	}
+	return nil
+}
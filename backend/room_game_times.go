@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Rooms whose turns are tracked by the server (the stack's active player,
+// see room_stack.go) keep each player's time as the active player: their
+// turns, the time those took and the longest one. When the game's
+// GAME_RESULT is stored the times are saved with it and start over, and
+// match history lists them for every game in the room, so a pod can see who
+// takes the long turns. Like the stack, the times live on the instance that
+// received the turn messages; a result stored on another instance saves
+// none.
+type playerTime struct {
+	Turns   int
+	Total   time.Duration
+	Longest time.Duration
+}
+
+// gamePlayerTime is a player's time in a finished game, as match history
+// lists it.
+type gamePlayerTime struct {
+	PlayerName         string `json:"playerName"`
+	Turns              int    `json:"turns"`
+	TotalSeconds       int64  `json:"totalSeconds"`
+	LongestTurnSeconds int64  `json:"longestTurnSeconds"`
+	AverageTurnSeconds int64  `json:"averageTurnSeconds"`
+}
+
+type gameTimes struct {
+	EventID    int64            `json:"eventId"`
+	FinishedAt string           `json:"finishedAt"`
+	Players    []gamePlayerTime `json:"players"`
+}
+
+// endTurn adds the active player's turn so far to their time.
+func (s *roomStack) endTurn(now time.Time) {
+	if s.Active == "" || s.TurnStarted.IsZero() {
+		return
+	}
+	if s.Times == nil {
+		s.Times = make(map[string]*playerTime)
+	}
+	spent := s.Times[s.Active]
+	if spent == nil {
+		spent = &playerTime{}
+		s.Times[s.Active] = spent
+	}
+	turn := now.Sub(s.TurnStarted)
+	spent.Turns++
+	spent.Total += turn
+	spent.Longest = max(spent.Longest, turn)
+}
+
+// recordGameTimes saves the players' times in the game that just ended with
+// the GAME_RESULT eventID, and starts the times over. The active player's
+// turn is cut at the result.
+func (a *App) recordGameTimes(roomID string, eventID int64) {
+	now := time.Now()
+	a.stacks.mu.Lock()
+	stack := a.stacks.stacks[roomID]
+	var times map[string]*playerTime
+	if stack != nil {
+		stack.endTurn(now)
+		stack.TurnStarted = now
+		times, stack.Times = stack.Times, nil
+	}
+	a.stacks.mu.Unlock()
+	if len(times) == 0 {
+		return
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		log.Printf("[rooms] failed to save the game times of %s: %v", roomID, err)
+		return
+	}
+	defer tx.Rollback()
+	for name, spent := range times {
+		if _, err := tx.Exec(`
+			INSERT INTO game_times (event_id, room_id, player_name, turns, total_ms, longest_ms)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, eventID, roomID, name, spent.Turns, spent.Total.Milliseconds(), spent.Longest.Milliseconds()); err != nil {
+			log.Printf("[rooms] failed to save the game times of %s: %v", roomID, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("[rooms] failed to save the game times of %s: %v", roomID, err)
+	}
+}
+
+// loadGameTimes returns the times of the rooms' finished games, oldest game
+// first. Games whose result was undone are left out.
+func (a *App) loadGameTimes(ctx context.Context, roomIDs []string) (map[string][]gameTimes, error) {
+	games := make(map[string][]gameTimes)
+	if len(roomIDs) == 0 {
+		return games, nil
+	}
+	args := make([]interface{}, len(roomIDs))
+	for i, roomID := range roomIDs {
+		args[i] = roomID
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT t.room_id, t.event_id, t.created_at, t.player_name, t.turns, t.total_ms, t.longest_ms
+		FROM game_times t
+		WHERE t.room_id IN (?`+strings.Repeat(", ?", len(roomIDs)-1)+`)
+			AND NOT EXISTS (SELECT 1 FROM room_events e WHERE e.id = t.event_id AND e.reverted_at IS NOT NULL)
+		ORDER BY t.event_id, t.total_ms DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var roomID, finishedAt string
+		var eventID, totalMs, longestMs int64
+		var player gamePlayerTime
+		if err := rows.Scan(&roomID, &eventID, &finishedAt, &player.PlayerName, &player.Turns, &totalMs, &longestMs); err != nil {
+			return nil, err
+		}
+		player.TotalSeconds = totalMs / 1000
+		player.LongestTurnSeconds = longestMs / 1000
+		if player.Turns > 0 {
+			player.AverageTurnSeconds = totalMs / int64(player.Turns) / 1000
+		}
+		room := games[roomID]
+		if len(room) == 0 || room[len(room)-1].EventID != eventID {
+			room = append(room, gameTimes{EventID: eventID, FinishedAt: finishedAt})
+		}
+		room[len(room)-1].Players = append(room[len(room)-1].Players, player)
+		games[roomID] = room
+	}
+	return games, rows.Err()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+const (
+	handLimitOff   = "off"
+	handLimitWarn  = "warn"
+	handLimitBlock = "block"
+
+	defaultMaxHandSize = 7
+)
+
+// Under a handLimit house rule the server checks the hand of the active
+// player when their turn ends (room:priority_set naming someone else, or an
+// idle pass) and sends the room room:hand_limit_warning when it holds more
+// than maxHandSize cards. Under "warn" the turn moves on; under "block" the
+// player has to discard first, though the host can still move the turn on
+// for them. The hand is counted in the stored board's private zones, since a
+// draw event does not say which card it took; the warning also says how many
+// cards the player drew since their turn started, counted from their
+// drawFromLibrary events. Like the stack, the draw counts live on the
+// instance that received the events.
+type handLimitWarning struct {
+	RoomID      string `json:"roomId"`
+	Player      string `json:"player"`
+	HandSize    int    `json:"handSize"`
+	MaxHandSize int    `json:"maxHandSize"`
+	Draws       int    `json:"draws"`
+	Blocked     bool   `json:"blocked"`
+}
+
+// countDraw counts a drawFromLibrary event towards the player's draws, in
+// rooms whose turns the server tracks.
+func (a *App) countDraw(payload RoomEventPayload) {
+	var action struct {
+		Kind       string `json:"kind"`
+		PlayerName string `json:"playerName"`
+	}
+	if err := json.Unmarshal(payload.EventData, &action); err != nil || action.Kind != "drawFromLibrary" {
+		return
+	}
+	a.stacks.mu.Lock()
+	defer a.stacks.mu.Unlock()
+	stack := a.stacks.stacks[payload.RoomID]
+	if stack == nil {
+		return
+	}
+	if stack.Draws == nil {
+		stack.Draws = make(map[string]int)
+	}
+	stack.Draws[action.PlayerName]++
+}
+
+// handSize counts the cards in the player's hand on the stored board.
+func (a *App) handSize(ctx context.Context, roomID string, player string) (int, error) {
+	var stateJSON string
+	err := a.db.QueryRowContext(ctx, `SELECT board_state FROM rooms WHERE room_id = ?`, roomID).Scan(&stateJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var state struct {
+		Private map[string]privateZone `json:"private"`
+	}
+	if err := json.Unmarshal([]byte(stateJSON), &state); err != nil {
+		return 0, err
+	}
+	size := 0
+	for _, raw := range state.Private[player].Board {
+		var card boardCardOwner
+		if json.Unmarshal(raw, &card) == nil && card.Zone == "hand" {
+			size++
+		}
+	}
+	return size, nil
+}
+
+// checkHandLimit checks the hand of the player whose turn is ending and warns
+// the room when it is over the limit. It reports whether the turn may not
+// end yet; override lets it end anyway.
+func (a *App) checkHandLimit(roomID string, player string, override bool) (handLimitWarning, bool) {
+	rules := a.rooms.HouseRules(roomID)
+	if rules == nil || rules.HandLimit == "" || player == "" {
+		return handLimitWarning{}, false
+	}
+	size, err := a.handSize(context.Background(), roomID, player)
+	if err != nil || size <= rules.MaxHandSize {
+		return handLimitWarning{}, false
+	}
+	a.stacks.mu.Lock()
+	draws := 0
+	if stack := a.stacks.stacks[roomID]; stack != nil {
+		draws = stack.Draws[player]
+	}
+	a.stacks.mu.Unlock()
+	warning := handLimitWarning{
+		RoomID:      roomID,
+		Player:      player,
+		HandSize:    size,
+		MaxHandSize: rules.MaxHandSize,
+		Draws:       draws,
+		Blocked:     rules.HandLimit == handLimitBlock && !override,
+	}
+	a.broadcastToRoom(roomID, a.rooms.socketIDs(roomID), WSMessage{
+		Type:    "room:hand_limit_warning",
+		Payload: marshalPayload(warning),
+	})
+	line := player + " ends their turn with " + strconv.Itoa(size) + " cards in hand"
+	if warning.Blocked {
+		line = player + " has " + strconv.Itoa(size) + " cards in hand and must discard down to " + strconv.Itoa(rules.MaxHandSize)
+	}
+	a.logRoomLine(roomID, player, line)
+	return warning, warning.Blocked
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	proxyPolicyAllowed = "allowed"
+	proxyPolicyNone    = "none"
+	// proxyFlag marks a deck entry as a proxy, as "[Proxies{proxy}]" in a
+	// text decklist.
+	proxyFlag = "proxy"
+
+	maxHouseStartingLife  = 999
+	maxHouseBannedCards   = 100
+	maxHouseBannedNameLen = 200
+	maxHouseHandSize      = 99
+)
+
+// A room's creator can set house rules with room:create's houseRules, and
+// the host can change them with room:house_rules while no game is being
+// played. Players are shown them in room:created and room:joined, and the
+// room is sent room:house_rules when they change. The server applies what it
+// can: startingLife replaces everyone's starting life total, the first
+// freeMulligans mulligans put no card on the bottom, and a deck holding a
+// house-banned card or, under the "none" proxy policy, an entry flagged
+// {proxy} is refused when it is picked and again when the game starts. A
+// handLimit of warn or block checks the hand of the player whose turn ends
+// against maxHandSize; see room_hand_limit.go. Under audit every deal's
+// seeds are kept so the game can be verified later; see room_audit.go.
+type houseRules struct {
+	StartingLife  int      `json:"startingLife,omitempty"`
+	FreeMulligans int      `json:"freeMulligans,omitempty"`
+	BannedCards   []string `json:"bannedCards,omitempty"`
+	ProxyPolicy   string   `json:"proxyPolicy,omitempty"`
+	HandLimit     string   `json:"handLimit,omitempty"`
+	MaxHandSize   int      `json:"maxHandSize,omitempty"`
+	Audit         bool     `json:"audit,omitempty"`
+}
+
+type RoomHouseRulesPayload struct {
+	RoomID     string      `json:"roomId"`
+	HouseRules *houseRules `json:"houseRules"`
+}
+
+// normalize checks the rules and tidies the banned card names, returning nil
+// when no rule is set.
+func (rules *houseRules) normalize() (*houseRules, string) {
+	if rules == nil {
+		return nil, ""
+	}
+	switch {
+	case rules.StartingLife < 0 || rules.StartingLife > maxHouseStartingLife:
+		return nil, "startingLife must be between 1 and " + strconv.Itoa(maxHouseStartingLife)
+	case rules.FreeMulligans < 0 || rules.FreeMulligans > openingHandSize:
+		return nil, "freeMulligans must be between 0 and " + strconv.Itoa(openingHandSize)
+	case len(rules.BannedCards) > maxHouseBannedCards:
+		return nil, "at most " + strconv.Itoa(maxHouseBannedCards) + " cards can be banned"
+	case rules.MaxHandSize < 0 || rules.MaxHandSize > maxHouseHandSize:
+		return nil, "maxHandSize must be between 0 and " + strconv.Itoa(maxHouseHandSize)
+	}
+	normalized := houseRules{StartingLife: rules.StartingLife, FreeMulligans: rules.FreeMulligans, Audit: rules.Audit}
+	switch policy := strings.ToLower(strings.TrimSpace(rules.ProxyPolicy)); policy {
+	case "", proxyPolicyAllowed:
+	case proxyPolicyNone:
+		normalized.ProxyPolicy = policy
+	default:
+		return nil, "proxyPolicy must be allowed or none"
+	}
+	switch limit := strings.ToLower(strings.TrimSpace(rules.HandLimit)); limit {
+	case "", handLimitOff:
+	case handLimitWarn, handLimitBlock:
+		normalized.HandLimit = limit
+		normalized.MaxHandSize = rules.MaxHandSize
+		if normalized.MaxHandSize == 0 {
+			normalized.MaxHandSize = defaultMaxHandSize
+		}
+	default:
+		return nil, "handLimit must be off, warn or block"
+	}
+	seen := make(map[string]bool)
+	for _, name := range rules.BannedCards {
+		name = strings.Join(strings.Fields(name), " ")
+		if len(name) > maxHouseBannedNameLen {
+			return nil, "banned card names must be at most " + strconv.Itoa(maxHouseBannedNameLen) + " characters"
+		}
+		if name == "" || seen[normalizeCardName(name)] {
+			continue
+		}
+		seen[normalizeCardName(name)] = true
+		normalized.BannedCards = append(normalized.BannedCards, name)
+	}
+	if normalized.StartingLife == 0 && normalized.FreeMulligans == 0 && normalized.BannedCards == nil &&
+		normalized.ProxyPolicy == "" && normalized.HandLimit == "" && !normalized.Audit {
+		return nil, ""
+	}
+	return &normalized, ""
+}
+
+// deckProblem says why the house rules refuse a deck, or "".
+func (rules *houseRules) deckProblem(entries []deckEntry) string {
+	if rules == nil {
+		return ""
+	}
+	banned := make(map[string]string)
+	for _, name := range rules.BannedCards {
+		normalized := normalizeCardName(name)
+		banned[normalized] = name
+		if front, _, ok := strings.Cut(normalized, " // "); ok {
+			banned[front] = name
+		}
+	}
+	for _, entry := range entries {
+		if name, ok := banned[normalizeCardName(entry.Name)]; ok {
+			return name + " is banned by the house rules"
+		}
+		if rules.ProxyPolicy == proxyPolicyNone {
+			for _, flag := range entry.Flags {
+				if strings.EqualFold(flag, proxyFlag) {
+					return "the house rules do not allow proxies (" + entry.Name + ")"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (rules *houseRules) startingLife() int {
+	if rules == nil {
+		return 0
+	}
+	return rules.StartingLife
+}
+
+func (rules *houseRules) freeMulligans() int {
+	if rules == nil {
+		return 0
+	}
+	return rules.FreeMulligans
+}
+
+func (rules *houseRules) audited() bool {
+	return rules != nil && rules.Audit
+}
+
+func (r *RoomRegistry) HouseRules(roomID string) *houseRules {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if room := r.rooms[roomID]; room != nil {
+		return room.HouseRules
+	}
+	return nil
+}
+
+func (r *RoomRegistry) setHouseRules(roomID string, rules *houseRules) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return false
+	}
+	room.HouseRules = rules
+	return true
+}
+
+func (a *App) handleHouseRules(client *WSClient, raw json.RawMessage) {
+	var payload RoomHouseRulesPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	if _, ok := a.rooms.Member(payload.RoomID, client.id); !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if a.rooms.HostSocket(payload.RoomID) != client.id {
+		a.sendError(client.id, codeForbidden, "only the host can change the house rules")
+		return
+	}
+	rules, problem := payload.HouseRules.normalize()
+	if problem != "" {
+		a.sendError(client.id, codeValidationFailed, problem)
+		return
+	}
+	a.lobbies.mu.Lock()
+	lobby := a.lobbies.lobby(payload.RoomID)
+	playing := lobby.Started && lobby.Result == nil
+	a.lobbies.mu.Unlock()
+	if playing {
+		a.sendError(client.id, codeConflict, "the house rules cannot change during a game")
+		return
+	}
+	if !a.rooms.setHouseRules(payload.RoomID, rules) {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	a.bus.publishRoom(payload.RoomID)
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:house_rules",
+		Payload: marshalPayload(RoomHouseRulesPayload{RoomID: payload.RoomID, HouseRules: rules}),
+	})
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	idleActionNone    = "none"
+	idleActionPass    = "pass"
+	idleActionConcede = "concede"
+
+	idleCheckInterval = 5 * time.Second
+	minIdleTimeout    = 30
+	maxIdleTimeout    = 60 * 60
+)
+
+// Rooms whose turns are tracked by the server (the stack's active player,
+// see room_stack.go) are watched for players who stop playing during their
+// turn, so a four-player game does not stall on someone who walked away.
+// Any message from one of the active player's sockets counts as activity.
+// Once the active player has been quiet for the room's timeout the room is
+// sent room:player_idle, and depending on the room's action the server then
+// passes their turn on or treats them as having conceded: a conceded player
+// is skipped from then on, and when a single player or team is left their
+// win is recorded as the game's GAME_RESULT. ROOM_IDLE_TIMEOUT_SECONDS and
+// ROOM_IDLE_ACTION set the defaults; the host changes them for the room with
+// room:idle_settings. Like the stack, this lives on the instance that
+// received the messages.
+type idleTracker struct {
+	mu       sync.Mutex
+	defaults idleSettings
+	rooms    map[string]*roomIdle
+}
+
+type idleSettings struct {
+	// TimeoutSeconds is how long the active player may be idle; 0 turns
+	// idle detection off for the room.
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+	Action         string `json:"action"`
+}
+
+type roomIdle struct {
+	Settings idleSettings
+	// Notified is when the active player's current idle stretch was
+	// reported.
+	Notified time.Time
+	// Conceded are the players an idle concede took out of this game.
+	Conceded map[string]bool
+}
+
+type RoomIdleSettingsPayload struct {
+	RoomID string `json:"roomId"`
+	idleSettings
+}
+
+type roomPlayerIdleMessage struct {
+	RoomID      string `json:"roomId"`
+	PlayerName  string `json:"playerName"`
+	IdleSeconds int    `json:"idleSeconds"`
+	Action      string `json:"action"`
+}
+
+func loadIdleTracker() *idleTracker {
+	defaults := idleSettings{
+		TimeoutSeconds: envInt("ROOM_IDLE_TIMEOUT_SECONDS", 300),
+		Action:         strings.ToLower(strings.TrimSpace(os.Getenv("ROOM_IDLE_ACTION"))),
+	}
+	if defaults.Action != idleActionPass && defaults.Action != idleActionConcede {
+		defaults.Action = idleActionNone
+	}
+	if defaults.TimeoutSeconds < 0 {
+		defaults.TimeoutSeconds = 0
+	}
+	return &idleTracker{defaults: defaults, rooms: make(map[string]*roomIdle)}
+}
+
+// room returns the room's idle state, creating it on first use. Called with
+// t.mu held.
+func (t *idleTracker) room(roomID string) *roomIdle {
+	room := t.rooms[roomID]
+	if room == nil {
+		room = &roomIdle{Settings: t.defaults, Conceded: make(map[string]bool)}
+		t.rooms[roomID] = room
+	}
+	return room
+}
+
+// newGame brings back the players who conceded in the previous game.
+func (t *idleTracker) newGame(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if room := t.rooms[roomID]; room != nil {
+		room.Conceded = make(map[string]bool)
+	}
+}
+
+// forget drops a closed room's idle state.
+func (t *idleTracker) forget(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.rooms, roomID)
+}
+
+func (s idleSettings) validate() string {
+	switch {
+	case s.TimeoutSeconds != 0 && (s.TimeoutSeconds < minIdleTimeout || s.TimeoutSeconds > maxIdleTimeout):
+		return "timeoutSeconds must be 0 or between " + strconv.Itoa(minIdleTimeout) + " and " + strconv.Itoa(maxIdleTimeout)
+	case s.Action != idleActionNone && s.Action != idleActionPass && s.Action != idleActionConcede:
+		return "action must be none, pass or concede"
+	}
+	return ""
+}
+
+// handleIdleSettings changes the room's idle timeout and action. Only the
+// host may; the room is sent the new settings as room:idle_settings.
+func (a *App) handleIdleSettings(client *WSClient, raw json.RawMessage) {
+	var payload RoomIdleSettingsPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	if _, ok := a.rooms.Member(payload.RoomID, client.id); !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if a.rooms.HostSocket(payload.RoomID) != client.id {
+		a.sendError(client.id, codeForbidden, "only the host can change the idle settings")
+		return
+	}
+	if payload.Action == "" {
+		payload.Action = idleActionNone
+	}
+	if problem := payload.idleSettings.validate(); problem != "" {
+		a.sendError(client.id, codeValidationFailed, problem)
+		return
+	}
+	a.idle.mu.Lock()
+	a.idle.room(payload.RoomID).Settings = payload.idleSettings
+	a.idle.mu.Unlock()
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:idle_settings",
+		Payload: marshalPayload(payload),
+	})
+}
+
+func (a *App) runIdleChecks() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		a.checkIdlePlayers(now)
+	}
+}
+
+type idleTurn struct {
+	active  string
+	started time.Time
+}
+
+// checkIdlePlayers looks at the active player of every room with a turn.
+func (a *App) checkIdlePlayers(now time.Time) {
+	turns := make(map[string]idleTurn)
+	a.stacks.mu.Lock()
+	for roomID, stack := range a.stacks.stacks {
+		if stack.Active != "" {
+			turns[roomID] = idleTurn{stack.Active, stack.TurnStarted}
+		}
+	}
+	a.stacks.mu.Unlock()
+	for roomID, turn := range turns {
+		a.checkIdleTurn(roomID, turn, now)
+	}
+}
+
+func (a *App) checkIdleTurn(roomID string, turn idleTurn, now time.Time) {
+	active := turn.active
+	var lastActive time.Time
+	seated := false
+	for _, seat := range a.rooms.Seating(roomID) {
+		if seat.PlayerName != active {
+			continue
+		}
+		seated = true
+		if at := a.socketActivity(seat.SocketID); at.After(lastActive) {
+			lastActive = at
+		}
+	}
+	if !seated || a.lobbies.finished(roomID) {
+		return
+	}
+
+	a.idle.mu.Lock()
+	room := a.idle.room(roomID)
+	settings := room.Settings
+	since := turn.started
+	if lastActive.After(since) {
+		since = lastActive
+	}
+	conceded := room.Conceded[active]
+	idle := settings.TimeoutSeconds > 0 && !conceded && room.Notified.Before(since) &&
+		now.Sub(since) >= time.Duration(settings.TimeoutSeconds)*time.Second
+	if idle {
+		room.Notified = now
+		if settings.Action == idleActionConcede {
+			room.Conceded[active] = true
+		}
+	}
+	a.idle.mu.Unlock()
+
+	switch {
+	case conceded:
+		// A player who conceded has no turns left to take.
+		a.passIdleTurn(roomID, active)
+	case idle:
+		idleSeconds := int(now.Sub(since).Seconds())
+		a.broadcastToRoom(roomID, a.rooms.socketIDs(roomID), WSMessage{
+			Type: "room:player_idle",
+			Payload: marshalPayload(roomPlayerIdleMessage{
+				RoomID:      roomID,
+				PlayerName:  active,
+				IdleSeconds: idleSeconds,
+				Action:      settings.Action,
+			}),
+		})
+		log.Printf("[rooms] %s idle in %s for %ds", active, roomID, idleSeconds)
+		switch settings.Action {
+		case idleActionPass:
+			a.logRoomLine(roomID, active, active+" is idle; their turn is passed")
+			a.passIdleTurn(roomID, active)
+		case idleActionConcede:
+			a.logRoomLine(roomID, active, active+" is idle and concedes")
+			if !a.recordIdleWinner(roomID) {
+				a.passIdleTurn(roomID, active)
+			}
+		default:
+			a.logRoomLine(roomID, active, active+" is idle")
+		}
+	}
+}
+
+// passIdleTurn makes the next player who has not conceded active, if the
+// idle player still is.
+func (a *App) passIdleTurn(roomID string, idle string) {
+	a.idle.mu.Lock()
+	conceded := make(map[string]bool)
+	for name := range a.idle.room(roomID).Conceded {
+		conceded[name] = true
+	}
+	a.idle.mu.Unlock()
+
+	a.stacks.mu.Lock()
+	stack := a.roomStack(roomID)
+	if stack.Active != idle {
+		a.stacks.mu.Unlock()
+		return
+	}
+	next := stack.nextActive(func(name string) bool { return conceded[name] })
+	if next != idle {
+		stack.startTurn(next)
+	}
+	view := stack.view(roomID)
+	a.stacks.mu.Unlock()
+
+	if next == idle {
+		return
+	}
+	a.checkHandLimit(roomID, idle, true)
+	a.broadcastToRoom(roomID, a.rooms.socketIDs(roomID), WSMessage{
+		Type:    "room:stack",
+		Payload: marshalPayload(view),
+	})
+	a.logRoomLine(roomID, next, turnLogLine(next))
+}
+
+// recordIdleWinner stores the game's result once idle concedes leave a
+// single player or team, reporting whether it did.
+func (a *App) recordIdleWinner(roomID string) bool {
+	a.idle.mu.Lock()
+	conceded := a.idle.room(roomID).Conceded
+	sides := make(map[string]seatInfo)
+	for _, seat := range a.rooms.Seating(roomID) {
+		if conceded[seat.PlayerName] {
+			continue
+		}
+		side := "player:" + seat.PlayerName
+		if seat.Team != 0 {
+			side = "team:" + strconv.Itoa(seat.Team)
+		}
+		if _, ok := sides[side]; !ok {
+			sides[side] = seat
+		}
+	}
+	a.idle.mu.Unlock()
+	if len(sides) != 1 {
+		return false
+	}
+	for _, winner := range sides {
+		_, err := a.recordRoomEvent(RoomEventPayload{
+			RoomID:    roomID,
+			EventType: roomEventGameResult,
+			EventData: marshalPayload(map[string]string{"winnerPlayerId": winner.PlayerID, "reason": "concede"}),
+		})
+		if err != nil {
+			log.Printf("[rooms] failed to record the result of %s: %v", roomID, err)
+			return false
+		}
+	}
+	return true
+}
+
+// socketActivity returns when a socket on this instance last sent a message.
+func (a *App) socketActivity(socketID string) time.Time {
+	a.clientsMu.RLock()
+	client := a.clients[socketID]
+	a.clientsMu.RUnlock()
+	if client == nil {
+		return time.Time{}
+	}
+	if at := client.lastActive.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
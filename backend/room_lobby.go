@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	startingLife          = 20
+	commanderStartingLife = 40
+)
+
+// A room starts in its lobby. Each player picks a deck with
+// room:deck_select; the server loads and checks it, and the room sees who is
+// ready as room:lobby. When the host sends room:game_start the server shuffles
+// the turn order, which also picks the first player, shuffles every library,
+// deals opening hands and writes the new board, then sends the room a single
+// room:game_start. Clients load their hands from the stored state, so no one
+// has to relay setup messages through the host. Players then mulligan with
+// room:mulligan (room_mulligan.go). After a game, room:rematch snapshots the
+// finished board, adds its GAME_RESULT to the session's running score and
+// deals a new game from the same decks, with everyone still in the room.
+// Like the stack, the lobby lives on the instance that received the messages.
+type lobbyTracker struct {
+	mu      sync.Mutex
+	lobbies map[string]*roomLobby
+}
+
+type roomLobby struct {
+	// Decks are the selected decks by player name.
+	Decks   map[string]lobbyDeck
+	Started bool
+	// Mulligans are the players' mulligans in the current game.
+	Mulligans map[string]mulliganState
+	// Game counts the games dealt this session. Score holds each player's
+	// wins in the games finished by a rematch, Draws the drawn ones.
+	Game  int
+	Score map[string]int
+	Draws int
+	// Result is the current game's reported winner, "" for a draw, or nil
+	// while no GAME_RESULT has been stored.
+	Result *string
+}
+
+type lobbyDeck struct {
+	DeckID      string   `json:"deckId"`
+	DeckName    string   `json:"deckName"`
+	LibrarySize int      `json:"librarySize"`
+	Commanders  []string `json:"commanders,omitempty"`
+	library     []deckEntry
+	commanders  []deckEntry
+}
+
+// played returns the deck's library and commander entries.
+func (d lobbyDeck) played() []deckEntry {
+	return append(append([]deckEntry(nil), d.library...), d.commanders...)
+}
+
+type lobbyPlayer struct {
+	Seat       int    `json:"seat"`
+	PlayerName string `json:"playerName"`
+	Ready      bool   `json:"ready"`
+	*lobbyDeck
+}
+
+type lobbyView struct {
+	RoomID  string        `json:"roomId"`
+	Players []lobbyPlayer `json:"players"`
+	Ready   bool          `json:"ready"`
+	Started bool          `json:"started"`
+	sessionScore
+}
+
+// sessionScore is the running score of the games played in a room.
+type sessionScore struct {
+	Game  int            `json:"game"`
+	Score map[string]int `json:"score"`
+	Draws int            `json:"draws"`
+}
+
+type gameStartPlayer struct {
+	Seat        int    `json:"seat"`
+	PlayerName  string `json:"playerName"`
+	DeckName    string `json:"deckName"`
+	Life        int    `json:"life"`
+	LibrarySize int    `json:"librarySize"`
+	HandSize    int    `json:"handSize"`
+}
+
+type gameStartMessage struct {
+	RoomID      string            `json:"roomId"`
+	Version     int64             `json:"version"`
+	FirstPlayer string            `json:"firstPlayer"`
+	TurnOrder   []int             `json:"turnOrder"`
+	Players     []gameStartPlayer `json:"players"`
+	Rematch     bool              `json:"rematch"`
+	sessionScore
+}
+
+type RoomLobbyPayload struct {
+	RoomID string `json:"roomId"`
+	DeckID string `json:"deckId"`
+}
+
+func newLobbyTracker() *lobbyTracker {
+	return &lobbyTracker{lobbies: make(map[string]*roomLobby)}
+}
+
+// forget drops a closed room's lobby.
+func (t *lobbyTracker) forget(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.lobbies, roomID)
+}
+
+// lobby returns the room's lobby, creating it on first use. Called with
+// t.mu held.
+func (t *lobbyTracker) lobby(roomID string) *roomLobby {
+	lobby := t.lobbies[roomID]
+	if lobby == nil {
+		lobby = &roomLobby{Decks: make(map[string]lobbyDeck), Mulligans: make(map[string]mulliganState), Score: make(map[string]int)}
+		t.lobbies[roomID] = lobby
+	}
+	return lobby
+}
+
+func (l *roomLobby) score() sessionScore {
+	score := sessionScore{Game: l.Game, Score: make(map[string]int, len(l.Score)), Draws: l.Draws}
+	for name, wins := range l.Score {
+		score.Score[name] = wins
+	}
+	return score
+}
+
+// setResult records the current game's result as it is reported.
+func (t *lobbyTracker) setResult(roomID string, winner string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lobby(roomID).Result = &winner
+}
+
+// finished reports whether the current game's result has been stored.
+func (t *lobbyTracker) finished(roomID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lobby := t.lobbies[roomID]
+	return lobby != nil && lobby.Result != nil
+}
+
+// view lists the seated players and their decks. Called with a.lobbies.mu
+// held.
+func (a *App) lobbyView(roomID string, lobby *roomLobby) lobbyView {
+	view := lobbyView{RoomID: roomID, Players: []lobbyPlayer{}, Started: lobby.Started, sessionScore: lobby.score()}
+	view.Ready = true
+	for _, seat := range a.rooms.Seating(roomID) {
+		player := lobbyPlayer{Seat: seat.Seat, PlayerName: seat.PlayerName}
+		if deck, ok := lobby.Decks[seat.PlayerName]; ok {
+			player.Ready, player.lobbyDeck = true, &deck
+		}
+		view.Ready = view.Ready && player.Ready
+		view.Players = append(view.Players, player)
+	}
+	return view
+}
+
+// loadLobbyDeck loads a deck the player may use and checks it can start a
+// game.
+func (a *App) loadLobbyDeck(ctx context.Context, userID int64, deckID string) (lobbyDeck, string) {
+	var user *User
+	if userID != 0 {
+		user = &User{ID: userID}
+	}
+	row, err := a.loadVisibleDeck(ctx, user, deckID)
+	if err != nil {
+		return lobbyDeck{}, "deck not found"
+	}
+	var entries []deckEntry
+	if err := json.Unmarshal([]byte(row.Entries), &entries); err != nil {
+		return lobbyDeck{}, "deck entries are not in a known format"
+	}
+	deck := lobbyDeck{DeckID: row.ID, DeckName: row.Name, library: libraryCards(entries)}
+	deck.LibrarySize = len(deck.library)
+	if deck.LibrarySize < openingHandSize || deck.LibrarySize > maxLibraryCards {
+		return lobbyDeck{}, "deck needs between " + strconv.Itoa(openingHandSize) + " and " + strconv.Itoa(maxLibraryCards) + " library cards"
+	}
+	// The ban list may have changed since the deck was saved.
+	if violations, err := a.deckBanViolations(ctx, row.Format.String, row.Entries); err == nil && len(violations) > 0 {
+		return lobbyDeck{}, banViolationMessage(row.Format.String, violations)
+	}
+	for _, entry := range entries {
+		if entrySection(entry) != "commander" {
+			continue
+		}
+		for i := 0; i < entry.Quantity; i++ {
+			card := entry
+			card.Quantity = 1
+			deck.commanders = append(deck.commanders, card)
+			deck.Commanders = append(deck.Commanders, entry.Name)
+		}
+	}
+	return deck, ""
+}
+
+func (a *App) handleLobbyMessage(client *WSClient, messageType string, raw json.RawMessage) {
+	var payload RoomLobbyPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	switch messageType {
+	case "room:deck_select":
+		deck, problem := a.loadLobbyDeck(context.Background(), member.UserID, strings.TrimSpace(payload.DeckID))
+		if problem == "" {
+			problem = a.rooms.HouseRules(payload.RoomID).deckProblem(deck.played())
+		}
+		if problem != "" {
+			a.sendError(client.id, codeInvalidDeck, problem)
+			return
+		}
+		a.lobbies.mu.Lock()
+		lobby := a.lobbies.lobby(payload.RoomID)
+		lobby.Decks[member.PlayerName] = deck
+		view := a.lobbyView(payload.RoomID, lobby)
+		a.lobbies.mu.Unlock()
+		a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+			Type:    "room:lobby",
+			Payload: marshalPayload(view),
+		})
+	case "room:game_start", "room:rematch":
+		if a.rooms.HostSocket(payload.RoomID) != client.id {
+			a.sendError(client.id, codeForbidden, "only the host can start the game")
+			return
+		}
+		rematch := messageType == "room:rematch"
+		a.lobbies.mu.Lock()
+		started := a.lobbies.lobby(payload.RoomID).Started
+		a.lobbies.mu.Unlock()
+		if rematch && !started {
+			a.sendError(client.id, codeValidationFailed, "no game has been played yet")
+			return
+		}
+		a.startGame(client, payload.RoomID, member, rematch)
+	}
+}
+
+// startGame seats the players in a random turn order, deals from freshly
+// shuffled libraries and replaces the stored board with the new game. A
+// rematch first snapshots the finished game and scores its result.
+func (a *App) startGame(client *WSClient, roomID string, host ClientInfo, rematch bool) {
+	a.lobbies.mu.Lock()
+	lobby := a.lobbies.lobby(roomID)
+	decks := make(map[string]lobbyDeck, len(lobby.Decks))
+	for name, deck := range lobby.Decks {
+		decks[name] = deck
+	}
+	a.lobbies.mu.Unlock()
+
+	seats := a.rooms.Seating(roomID)
+	rules := a.rooms.HouseRules(roomID)
+	var missing []string
+	order := make([]int, len(seats))
+	for i, seat := range seats {
+		deck, ok := decks[seat.PlayerName]
+		if !ok {
+			missing = append(missing, seat.PlayerName+" has not selected a deck")
+		} else if problem := rules.deckProblem(deck.played()); problem != "" {
+			// The house rules may have changed since the deck was picked.
+			missing = append(missing, seat.PlayerName+"'s deck: "+problem)
+		}
+		order[i] = seat.Seat
+	}
+	if len(missing) > 0 {
+		a.sendErrorDetails(client.id, codeValidationFailed, "every player must select a deck", missing)
+		return
+	}
+	deal := auditDeal{OrderSeed: randomSeed(), Seats: append([]int(nil), order...)}
+	shuffleWithSeed(order, deal.OrderSeed)
+	deal.TurnOrder = order
+	if !a.rooms.SetTurnOrder(roomID, order) {
+		a.sendError(client.id, codeConflict, "the seating changed, try again")
+		return
+	}
+	seats = a.rooms.Seating(roomID)
+
+	ctx := context.Background()
+	board := []map[string]interface{}{}
+	private := make(map[string]privateZone)
+	var players []map[string]interface{}
+	message := gameStartMessage{RoomID: roomID, FirstPlayer: seats[0].PlayerName, Rematch: rematch}
+	for _, seat := range seats {
+		deck := decks[seat.PlayerName]
+		seed := randomSeed()
+		shuffled := append([]deckEntry(nil), deck.library...)
+		library := a.deckLibrary(ctx, shuffled, seat.PlayerName, seed)
+		if rules.audited() {
+			deal.Players = append(deal.Players, newAuditPlayer(seat.PlayerName, seed, deck.library, shuffled, library))
+		}
+		// The top of the library is its last card.
+		hand := library[len(library)-openingHandSize:]
+		library = library[:len(library)-openingHandSize]
+		zone := privateZone{}
+		for i, card := range hand {
+			card["zone"] = "hand"
+			card["handIndex"] = i
+			delete(card, "stackIndex")
+			zone.Board = append(zone.Board, marshalPayload(card))
+		}
+		for _, card := range library {
+			zone.Board = append(zone.Board, marshalPayload(card))
+		}
+		private[seat.PlayerName] = zone
+		for _, card := range a.deckBoardCards(ctx, deck.commanders, seat.PlayerName, "commander") {
+			card["isCommander"] = true
+			card["deckSection"] = "commander"
+			board = append(board, card)
+			if rules.audited() {
+				deal.Board = append(deal.Board, newAuditCard(card))
+			}
+		}
+
+		life := startingLife
+		if len(deck.commanders) > 0 {
+			life = commanderStartingLife
+		}
+		if rules.startingLife() > 0 {
+			life = rules.startingLife()
+		}
+		players = append(players, map[string]interface{}{"id": seat.PlayerID, "name": seat.PlayerName, "life": life})
+		message.TurnOrder = append(message.TurnOrder, seat.Seat)
+		message.Players = append(message.Players, gameStartPlayer{
+			Seat:        seat.Seat,
+			PlayerName:  seat.PlayerName,
+			DeckName:    deck.DeckName,
+			Life:        life,
+			LibrarySize: len(library),
+			HandSize:    len(hand),
+		})
+	}
+
+	if rematch {
+		if _, err := a.snapshotRoom(roomID); err != nil {
+			a.sendError(client.id, codeInternal, "failed to snapshot the finished game")
+			return
+		}
+	}
+	version, _, err := a.updateRoomState(ctx, roomID, func(state map[string]json.RawMessage) error {
+		state["board"] = marshalPayload(board)
+		state["private"] = marshalPayload(private)
+		state["players"] = marshalPayload(players)
+		state["counters"] = []byte("[]")
+		state["commanderDamage"] = []byte("{}")
+		delete(state, "teamLife")
+		delete(state, "cardCounters")
+		return nil
+	})
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save the new game")
+		return
+	}
+	message.Version = version
+
+	// Reveals, scries and the stack refer to the previous game's cards.
+	a.reveals.forget(roomID)
+	a.scries.forget(roomID)
+	a.stacks.mu.Lock()
+	stack := a.roomStack(roomID)
+	stack.Items = nil
+	stack.startTurn(message.FirstPlayer)
+	stack.Times = nil
+	a.stacks.mu.Unlock()
+	a.idle.newGame(roomID)
+
+	a.lobbies.mu.Lock()
+	lobby = a.lobbies.lobby(roomID)
+	if rematch && lobby.Result != nil {
+		if *lobby.Result == "" {
+			lobby.Draws++
+		} else {
+			lobby.Score[*lobby.Result]++
+		}
+	}
+	lobby.Started = true
+	lobby.Mulligans = make(map[string]mulliganState)
+	lobby.Game++
+	lobby.Result = nil
+	message.sessionScore = lobby.score()
+	a.lobbies.mu.Unlock()
+
+	if rules.audited() {
+		a.recordAuditDeal(roomID, message.Game, deal)
+	}
+
+	a.bus.publishRoom(roomID)
+	a.broadcastSeats(roomID, a.send)
+	a.broadcastToRoom(roomID, a.rooms.socketIDs(roomID), WSMessage{
+		Type:    "room:game_start",
+		Payload: marshalPayload(message),
+	})
+	a.logRoomLine(roomID, host.PlayerName, "Game "+strconv.Itoa(message.Game)+" starts; "+message.FirstPlayer+" goes first")
+}
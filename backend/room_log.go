@@ -0,0 +1,549 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxRoomLogPage = 500
+	// roomLogMergeWindow is how close together lines sharing a merge key
+	// must be to read as one, as in "Alice draws 7 cards".
+	roomLogMergeWindow = 5 * time.Second
+)
+
+// roomLog turns the room events the server understands into human-readable
+// lines for the table's sidebar, stored in room_log and sent to the room as
+// room:log messages. Lines survive event compaction. The server only sees
+// actions, not the board, so it keeps an index of each open room's cards
+// from the events themselves, seeded from the stored state, to name the
+// cards involved. Cards in a hand or library stay "a card" unless the action
+// moves them into view.
+type roomLog struct {
+	mu    sync.Mutex
+	rooms map[string]*roomLogState
+}
+
+type roomLogState struct {
+	cards map[string]*logCard
+	last  *roomLogEntry
+}
+
+type logCard struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	OwnerID string `json:"ownerId"`
+	Zone    string `json:"zone"`
+	Tapped  bool   `json:"tapped"`
+}
+
+type roomLogEntry struct {
+	ID        int64     `json:"id"`
+	RoomID    string    `json:"roomId"`
+	EventID   int64     `json:"eventId"`
+	Actor     string    `json:"actor"`
+	Message   string    `json:"message"`
+	CreatedAt string    `json:"createdAt"`
+	merge     string    // lines with the same key may be merged
+	count     int       // actions merged into the line
+	at        time.Time // when the line was last written
+}
+
+// logAction is the union of the CARD_ACTION fields the log reads.
+type logAction struct {
+	Kind             string    `json:"kind"`
+	ID               string    `json:"id"`
+	Card             *logCard  `json:"card"`
+	Cards            []logCard `json:"cards"`
+	PlayerName       string    `json:"playerName"`
+	PlayerID         string    `json:"playerId"`
+	Zone             string    `json:"zone"`
+	LibraryPlace     string    `json:"libraryPlace"`
+	Life             *float64  `json:"life"`
+	TargetPlayerID   string    `json:"targetPlayerId"`
+	AttackerPlayerID string    `json:"attackerPlayerId"`
+	Damage           *float64  `json:"damage"`
+	Delta            *float64  `json:"delta"`
+	Counter          string    `json:"counter"`
+	Value            *float64  `json:"value"`
+	Updates          struct {
+		Name   *string `json:"name"`
+		Zone   *string `json:"zone"`
+		Tapped *bool   `json:"tapped"`
+	} `json:"updates"`
+}
+
+var zoneLabels = map[string]string{
+	"battlefield": "the battlefield",
+	"hand":        "their hand",
+	"library":     "their library",
+	"cemetery":    "the cemetery",
+	"exile":       "exile",
+	"commander":   "the command zone",
+	"tokens":      "the tokens zone",
+}
+
+func newRoomLog() *roomLog {
+	return &roomLog{rooms: make(map[string]*roomLogState)}
+}
+
+// forget drops a closed room's card index.
+func (l *roomLog) forget(roomID string) {
+	l.mu.Lock()
+	delete(l.rooms, roomID)
+	l.mu.Unlock()
+}
+
+// roomLogState returns the room's index, seeding it from the stored board the
+// first time the room is seen. Called with l.mu held.
+func (a *App) roomLogState(roomID string) *roomLogState {
+	state := a.gameLog.rooms[roomID]
+	if state != nil {
+		return state
+	}
+	state = &roomLogState{cards: make(map[string]*logCard)}
+	a.gameLog.rooms[roomID] = state
+	var boardState string
+	if err := a.db.QueryRow(`SELECT board_state FROM rooms WHERE room_id = ?`, roomID).Scan(&boardState); err != nil {
+		return state
+	}
+	var stored struct {
+		Board   []logCard `json:"board"`
+		Private map[string]struct {
+			Board []logCard `json:"board"`
+		} `json:"private"`
+	}
+	_ = json.Unmarshal([]byte(boardState), &stored)
+	cards := stored.Board
+	for _, zone := range stored.Private {
+		cards = append(cards, zone.Board...)
+	}
+	for i := range cards {
+		if cards[i].ID != "" {
+			state.cards[cards[i].ID] = &cards[i]
+		}
+	}
+	return state
+}
+
+// appendRoomLog records the line for a stored event, if it has one, and
+// sends it to the room. A line merged into the previous one keeps that
+// line's id, so clients replace it.
+func (a *App) appendRoomLog(payload RoomEventPayload, eventID int64) {
+	a.gameLog.mu.Lock()
+	defer a.gameLog.mu.Unlock()
+	state := a.roomLogState(payload.RoomID)
+	entry, ok := a.describeRoomEvent(state, payload)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if last := state.last; last != nil && entry.merge != "" && last.merge == entry.merge && now.Sub(last.at) < roomLogMergeWindow {
+		entry.ID, entry.CreatedAt, entry.count = last.ID, last.CreatedAt, last.count+entry.count
+		if strings.HasPrefix(entry.merge, "draw:") {
+			entry.Message = drawMessage(entry.Actor, entry.count)
+		}
+		if _, err := a.db.Exec(`UPDATE room_log SET message = ?, event_id = ? WHERE id = ?`, entry.Message, eventID, entry.ID); err != nil {
+			return
+		}
+	} else if !a.insertRoomLog(payload.RoomID, eventID, &entry, now) {
+		return
+	}
+	entry.RoomID = payload.RoomID
+	entry.EventID = eventID
+	entry.at = now
+	state.last = &entry
+	a.broadcastRoomLog(entry)
+}
+
+// logRoomLine records a line that has no stored event behind it, such as a
+// reveal. It never merges with the previous line.
+func (a *App) logRoomLine(roomID string, actor string, message string) {
+	a.gameLog.mu.Lock()
+	defer a.gameLog.mu.Unlock()
+	state := a.roomLogState(roomID)
+	entry := roomLogEntry{RoomID: roomID, Actor: actor, Message: message}
+	_, _ = a.db.Exec(`
+		INSERT INTO rooms (room_id, board_state, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(room_id) DO NOTHING
+	`, roomID, "{}")
+	now := time.Now()
+	if !a.insertRoomLog(roomID, 0, &entry, now) {
+		return
+	}
+	entry.at = now
+	state.last = &entry
+	a.broadcastRoomLog(entry)
+}
+
+// turnLogLine is the line that starts a player's turn; exports begin a new
+// turn at it.
+func turnLogLine(player string) string {
+	return "It is " + player + "'s turn"
+}
+
+// turnLogPlayer returns whose turn a turnLogLine starts.
+func turnLogPlayer(message string) (string, bool) {
+	player, ok := strings.CutPrefix(message, "It is ")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(player, "'s turn")
+}
+
+func (a *App) insertRoomLog(roomID string, eventID int64, entry *roomLogEntry, now time.Time) bool {
+	result, err := a.db.Exec(`
+		INSERT INTO room_log (room_id, event_id, actor, message)
+		VALUES (?, ?, ?, ?)
+	`, roomID, nullIfZero(eventID), entry.Actor, entry.Message)
+	if err != nil {
+		return false
+	}
+	entry.ID, _ = result.LastInsertId()
+	entry.CreatedAt = now.UTC().Format("2006-01-02 15:04:05")
+	return true
+}
+
+func (a *App) broadcastRoomLog(entry roomLogEntry) {
+	a.broadcastToRoom(entry.RoomID, a.rooms.socketIDs(entry.RoomID), WSMessage{
+		Type:    "room:log",
+		Payload: marshalPayload(entry),
+	})
+}
+
+// describeRoomEvent writes the line for an event and applies it to the card
+// index. Actions that only rearrange the table (moving a card or a pile,
+// reordering a hand, zooming) have no line.
+func (a *App) describeRoomEvent(state *roomLogState, payload RoomEventPayload) (roomLogEntry, bool) {
+	if payload.EventType == roomEventGameResult {
+		var result struct {
+			WinnerPlayerID *string `json:"winnerPlayerId"`
+			Reason         string  `json:"reason"`
+		}
+		_ = json.Unmarshal(payload.EventData, &result)
+		if result.WinnerPlayerID == nil {
+			return roomLogEntry{Actor: payload.PlayerName, Message: "The game ends in a draw", count: 1}, true
+		}
+		winner := a.rooms.playerNameByID(payload.RoomID, *result.WinnerPlayerID)
+		return roomLogEntry{Actor: winner, Message: winner + " wins the game", count: 1}, true
+	}
+	if payload.EventType == roomEventScry {
+		return describeScry(state, payload.PlayerName, payload.EventData), true
+	}
+	if payload.EventType == roomEventMulligan {
+		return describeMulligan(payload.PlayerName, payload.EventData), true
+	}
+	if payload.EventType != "CARD_ACTION" {
+		return roomLogEntry{}, false
+	}
+	var action logAction
+	if err := json.Unmarshal(payload.EventData, &action); err != nil {
+		return roomLogEntry{}, false
+	}
+	card := state.cards[action.ID]
+	actor := payload.PlayerName
+	if action.PlayerName != "" {
+		actor = action.PlayerName
+	} else if card != nil && card.OwnerID != "" {
+		actor = card.OwnerID
+	}
+	line := func(format string, args ...interface{}) (roomLogEntry, bool) {
+		return roomLogEntry{Actor: actor, Message: actor + " " + fmt.Sprintf(format, args...), count: 1}, true
+	}
+
+	switch action.Kind {
+	case "add", "addToLibrary":
+		if action.Card == nil {
+			return roomLogEntry{}, false
+		}
+		added := *action.Card
+		if action.Kind == "addToLibrary" {
+			added.Zone = "library"
+		}
+		state.cards[added.ID] = &added
+		if added.OwnerID != "" {
+			actor = added.OwnerID
+		}
+		if added.Zone == "battlefield" {
+			return line("puts %s onto the battlefield", visibleCardName(&added, added.Zone))
+		}
+		return line("puts %s into %s", visibleCardName(&added, added.Zone), zoneLabel(added.Zone))
+	case "replaceLibrary":
+		for id, known := range state.cards {
+			if known.Zone == "library" && known.OwnerID == action.PlayerName {
+				delete(state.cards, id)
+			}
+		}
+		for i := range action.Cards {
+			state.cards[action.Cards[i].ID] = &action.Cards[i]
+		}
+		return line("loads a library of %d cards", len(action.Cards))
+	case "drawFromLibrary":
+		return roomLogEntry{Actor: actor, Message: drawMessage(actor, 1), merge: "draw:" + actor, count: 1}, true
+	case "shuffleLibrary":
+		return line("shuffles their library")
+	case "mulligan":
+		return line("takes a mulligan")
+	case "toggleTap":
+		if card == nil {
+			return line("taps or untaps a card")
+		}
+		card.Tapped = !card.Tapped
+		if card.Tapped {
+			return line("taps %s", visibleCardName(card, card.Zone))
+		}
+		return line("untaps %s", visibleCardName(card, card.Zone))
+	case "flipCard":
+		return line("transforms %s", visibleCardName(card, cardZone(card)))
+	case "remove":
+		delete(state.cards, action.ID)
+		return line("removes %s", visibleCardName(card, cardZone(card)))
+	case "setCommander":
+		if card != nil {
+			card.Zone = "commander"
+		}
+		return line("sets %s as their commander", visibleCardName(card, "commander"))
+	case "changeZone":
+		from := cardZone(card)
+		if card != nil {
+			card.Zone = action.Zone
+		}
+		name := visibleCardName(card, action.Zone)
+		if privateZones[action.Zone] && !privateZones[from] && from != "" {
+			name = visibleCardName(card, from)
+		}
+		switch {
+		case action.Zone == "library" && action.LibraryPlace == "top":
+			return line("puts %s on top of their library", name)
+		case action.Zone == "library" && action.LibraryPlace == "bottom":
+			return line("puts %s on the bottom of their library", name)
+		case action.Zone == "library" && action.LibraryPlace == "random":
+			return line("shuffles %s into their library", name)
+		// A draw does not say which card it took, so the index can be wrong
+		// about which hidden zone a card is in; those are never named.
+		case privateZones[from] && action.Zone == "battlefield":
+			return line("plays %s", name)
+		case from != "" && from != action.Zone && !privateZones[from]:
+			return line("moves %s from %s to %s", name, zoneLabel(from), zoneLabel(action.Zone))
+		}
+		return line("moves %s to %s", name, zoneLabel(action.Zone))
+	case "updateCard":
+		if card != nil {
+			if action.Updates.Name != nil {
+				card.Name = *action.Updates.Name
+			}
+			if action.Updates.Zone != nil {
+				card.Zone = *action.Updates.Zone
+			}
+			if action.Updates.Tapped != nil {
+				card.Tapped = *action.Updates.Tapped
+			}
+		}
+		return roomLogEntry{}, false
+	case "createCounter":
+		return line("places a counter")
+	case "setPlayerLife":
+		if action.Life == nil {
+			return roomLogEntry{}, false
+		}
+		player := a.rooms.playerNameByID(payload.RoomID, action.PlayerID)
+		return roomLogEntry{
+			Actor:   player,
+			Message: fmt.Sprintf("%s is at %s life", player, formatLogNumber(*action.Life)),
+			merge:   "life:" + action.PlayerID,
+			count:   1,
+		}, true
+	case "setCardCounter", "adjustCardCounter":
+		actor = payload.PlayerName
+		name := visibleCardName(card, cardZone(card))
+		switch {
+		case action.Kind == "setCardCounter" && action.Value != nil:
+			entry, ok := line("sets the %s counters on %s to %s", action.Counter, name, formatLogNumber(*action.Value))
+			entry.merge = "cardCounter:" + action.ID + ":" + action.Counter
+			return entry, ok
+		case action.Kind == "adjustCardCounter" && action.Delta != nil && *action.Delta >= 0:
+			return line("puts %s on %s", counterCount(*action.Delta, action.Counter), name)
+		case action.Kind == "adjustCardCounter" && action.Delta != nil:
+			return line("removes %s from %s", counterCount(-*action.Delta, action.Counter), name)
+		}
+		return roomLogEntry{}, false
+	case "setCommanderDamage", "adjustCommanderDamage":
+		target := a.rooms.playerNameByID(payload.RoomID, action.TargetPlayerID)
+		attacker := a.rooms.playerNameByID(payload.RoomID, action.AttackerPlayerID)
+		entry := roomLogEntry{Actor: attacker, count: 1}
+		switch {
+		case action.Kind == "setCommanderDamage" && action.Damage != nil:
+			// Totals replace each other; adjustments below do not merge, as
+			// the merged line would only show the last one.
+			entry.merge = "commander:" + action.TargetPlayerID + ":" + action.AttackerPlayerID
+			entry.Message = fmt.Sprintf("%s has taken %s commander damage from %s", target, formatLogNumber(*action.Damage), attacker)
+		case action.Kind == "adjustCommanderDamage" && action.Delta != nil && *action.Delta >= 0:
+			entry.Message = fmt.Sprintf("%s deals %s commander damage to %s", attacker, formatLogNumber(*action.Delta), target)
+		case action.Kind == "adjustCommanderDamage" && action.Delta != nil:
+			entry.Message = fmt.Sprintf("%s's commander damage from %s is reduced by %s", target, attacker, formatLogNumber(-*action.Delta))
+		default:
+			return roomLogEntry{}, false
+		}
+		return entry, true
+	}
+	return roomLogEntry{}, false
+}
+
+func counterCount(count float64, counter string) string {
+	if count == 1 {
+		return "a " + counter + " counter"
+	}
+	return formatLogNumber(count) + " " + counter + " counters"
+}
+
+func drawMessage(actor string, count int) string {
+	if count == 1 {
+		return actor + " draws a card"
+	}
+	return fmt.Sprintf("%s draws %d cards", actor, count)
+}
+
+// describeScry writes the line for a resolved scry or surveil. Cards kept in
+// the library stay counts; cards put into the cemetery are named.
+func describeScry(state *roomLogState, actor string, data json.RawMessage) roomLogEntry {
+	var scry scryDecision
+	_ = json.Unmarshal(data, &scry)
+	verb := "scries"
+	if scry.Kind == "surveil" {
+		verb = "surveils"
+	}
+	var parts []string
+	if len(scry.Top) > 0 {
+		parts = append(parts, fmt.Sprintf("%d on top", len(scry.Top)))
+	}
+	if len(scry.Bottom) > 0 {
+		parts = append(parts, fmt.Sprintf("%d on the bottom", len(scry.Bottom)))
+	}
+	if len(scry.Graveyard) > 0 {
+		names := make([]string, 0, len(scry.Graveyard))
+		for _, id := range scry.Graveyard {
+			card := state.cards[id]
+			if card != nil {
+				card.Zone = "cemetery"
+			}
+			names = append(names, visibleCardName(card, "cemetery"))
+		}
+		parts = append(parts, strings.Join(names, ", ")+" into the cemetery")
+	}
+	message := fmt.Sprintf("%s %s %d", actor, verb, scry.Count)
+	if len(parts) > 0 {
+		message += ": " + strings.Join(parts, ", ")
+	}
+	return roomLogEntry{Actor: actor, Message: message, count: 1}
+}
+
+func describeMulligan(actor string, data json.RawMessage) roomLogEntry {
+	var mulligan mulliganDecision
+	_ = json.Unmarshal(data, &mulligan)
+	message := fmt.Sprintf("%s mulligans (%s to the bottom)", actor, pluralCards(mulligan.bottomCount()))
+	if mulligan.Kind == mulliganKindKeep {
+		message = fmt.Sprintf("%s keeps %s", actor, pluralCards(openingHandSize-mulligan.bottomCount()))
+	}
+	return roomLogEntry{Actor: actor, Message: message, count: 1}
+}
+
+func pluralCards(count int) string {
+	if count == 1 {
+		return "1 card"
+	}
+	return fmt.Sprintf("%d cards", count)
+}
+
+func cardZone(card *logCard) string {
+	if card == nil {
+		return ""
+	}
+	return card.Zone
+}
+
+// visibleCardName names a card for everyone at the table: by name when it
+// is in, or going to, a zone everyone can see.
+func visibleCardName(card *logCard, zone string) string {
+	if card == nil || card.Name == "" || privateZones[zone] {
+		return "a card"
+	}
+	return card.Name
+}
+
+func zoneLabel(zone string) string {
+	if label, ok := zoneLabels[zone]; ok {
+		return label
+	}
+	return zone
+}
+
+func formatLogNumber(value float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", value), "0"), ".")
+}
+
+// playerNameByID finds a seated player's name, falling back to "A player"
+// for ids the room does not know.
+func (r *RoomRegistry) playerNameByID(roomID string, playerID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if room := r.rooms[roomID]; room != nil && playerID != "" {
+		if room.HostPlayerID == playerID {
+			return room.HostPlayerName
+		}
+		for _, info := range room.Clients {
+			if info.PlayerID == playerID {
+				return info.PlayerName
+			}
+		}
+	}
+	return "A player"
+}
+
+// handleRoomLog returns a room's log lines oldest first, paged like the
+// event log with sinceId and limit.
+func (a *App) handleRoomLog(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	query := r.URL.Query()
+	sinceID := parseIntDefault(query.Get("sinceId"), 0)
+	limit := parseIntDefault(query.Get("limit"), maxRoomLogPage)
+	if limit <= 0 || limit > maxRoomLogPage {
+		limit = maxRoomLogPage
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, event_id, actor, message, created_at
+		FROM room_log
+		WHERE room_id = ? AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, roomID, sinceID, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load log")
+		return
+	}
+	defer rows.Close()
+	entries := make([]roomLogEntry, 0)
+	for rows.Next() {
+		entry := roomLogEntry{RoomID: roomID}
+		var eventID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &eventID, &entry.Actor, &entry.Message, &entry.CreatedAt); err != nil {
+			continue
+		}
+		entry.EventID = eventID.Int64
+		entries = append(entries, entry)
+	}
+	response := map[string]interface{}{"entries": entries, "nextCursor": nil}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		response["entries"] = entries
+		response["nextCursor"] = entries[limit-1].ID
+	}
+	writeJSON(w, http.StatusOK, response)
+}
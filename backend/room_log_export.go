@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// gameLogLine matches the line startGame writes when a game is dealt, which
+// also starts the first player's turn.
+var gameLogLine = regexp.MustCompile(`^Game (\d+) starts; (.+) goes first$`)
+
+var markdownSpecial = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+)
+
+// logExportSection is a stretch of the log under one heading: a game, or a
+// turn within it.
+type logExportSection struct {
+	game  string
+	turn  int
+	actor string
+	lines []roomLogEntry
+}
+
+// splitLogExport groups the log into games and turns. Lines before the first
+// game or the first turn get a section of their own.
+func splitLogExport(entries []roomLogEntry) []logExportSection {
+	var sections []logExportSection
+	game, turn := "", 0
+	for _, entry := range entries {
+		player, turnStarts := turnLogPlayer(entry.Message)
+		match := gameLogLine.FindStringSubmatch(entry.Message)
+		switch {
+		case match != nil:
+			game, turn = match[1], 1
+			sections = append(sections,
+				logExportSection{game: game, lines: []roomLogEntry{entry}},
+				logExportSection{game: game, turn: turn, actor: match[2]})
+			continue
+		case turnStarts && game != "":
+			turn++
+			sections = append(sections, logExportSection{game: game, turn: turn, actor: player})
+			continue
+		case len(sections) == 0:
+			sections = append(sections, logExportSection{})
+		}
+		last := &sections[len(sections)-1]
+		last.lines = append(last.lines, entry)
+	}
+	return sections
+}
+
+func logExportTime(createdAt string) string {
+	if at, err := time.Parse(time.RFC3339, createdAt); err == nil {
+		return at.UTC().Format("15:04:05")
+	}
+	return createdAt
+}
+
+func formatLogText(roomID string, exportedAt time.Time, entries []roomLogEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Game log for %s\nExported %s\n", roomID, exportedAt.Format("2006-01-02 15:04 MST"))
+	for _, section := range splitLogExport(entries) {
+		switch {
+		case section.turn > 0:
+			fmt.Fprintf(&b, "\n-- Turn %d: %s --\n", section.turn, section.actor)
+		case section.game != "":
+			fmt.Fprintf(&b, "\n== Game %s ==\n", section.game)
+		}
+		for _, line := range section.lines {
+			fmt.Fprintf(&b, "[%s] %s\n", logExportTime(line.CreatedAt), line.Message)
+		}
+	}
+	return b.String()
+}
+
+func formatLogMarkdown(roomID string, exportedAt time.Time, entries []roomLogEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Game log for %s\n\n_Exported %s_\n", markdownSpecial.Replace(roomID), exportedAt.Format("2006-01-02 15:04 MST"))
+	for _, section := range splitLogExport(entries) {
+		switch {
+		case section.turn > 0:
+			fmt.Fprintf(&b, "\n### Turn %d: %s\n\n", section.turn, markdownSpecial.Replace(section.actor))
+		case section.game != "":
+			fmt.Fprintf(&b, "\n## Game %s\n\n", section.game)
+		default:
+			b.WriteString("\n")
+		}
+		for _, line := range section.lines {
+			fmt.Fprintf(&b, "- `%s` %s\n", logExportTime(line.CreatedAt), markdownSpecial.Replace(line.Message))
+		}
+	}
+	return b.String()
+}
+
+// handleExportRoomLog renders the room's whole log as a plain text or
+// Markdown document, with a heading for each game and each turn.
+func (a *App) handleExportRoomLog(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	contentType := "text/plain; charset=utf-8"
+	switch format {
+	case "", "txt":
+		format = "txt"
+	case "md":
+		contentType = "text/markdown; charset=utf-8"
+	default:
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "format must be txt or md")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, event_id, actor, message, created_at
+		FROM room_log
+		WHERE room_id = ?
+		ORDER BY id ASC
+	`, roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load log")
+		return
+	}
+	defer rows.Close()
+	var entries []roomLogEntry
+	for rows.Next() {
+		entry := roomLogEntry{RoomID: roomID}
+		var eventID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &eventID, &entry.Actor, &entry.Message, &entry.CreatedAt); err != nil {
+			continue
+		}
+		entry.EventID = eventID.Int64
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "The room has no log")
+		return
+	}
+
+	exportedAt := time.Now().UTC()
+	text := formatLogText(roomID, exportedAt, entries)
+	if format == "md" {
+		text = formatLogMarkdown(roomID, exportedAt, entries)
+	}
+	w.Header().Set("Content-Type", contentType)
+	if r.URL.Query().Get("download") != "" {
+		filename := strings.Trim(deckExportFilenameUnsafe.ReplaceAllString(roomID, "-"), "-")
+		if filename == "" {
+			filename = "room"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-log.%s"`, filename, format))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(text))
+}
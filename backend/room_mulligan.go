@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	mulliganKindMulligan = "mulligan"
+	mulliganKindKeep     = "keep"
+)
+
+// Once the game has started, a player unhappy with their opening hand sends
+// room:mulligan: the server shuffles their hand back into the library, deals
+// seven new cards and counts the mulligan. room:mulligan_keep ends the
+// player's mulligans, putting one card from the hand on the bottom of the
+// library per mulligan taken (the London mulligan). Both are stored as
+// MULLIGAN events with the shuffle seed, and the room is sent room:mulligan;
+// only the player sees their new hand. Under house rules with free
+// mulligans, that many mulligans are not paid for with a card.
+
+// mulliganDecision is the stored MULLIGAN event data.
+type mulliganDecision struct {
+	Kind      string   `json:"kind"`
+	Mulligans int      `json:"mulligans"`
+	Free      int      `json:"free,omitempty"`
+	Seed      int64    `json:"seed,omitempty"`
+	Bottom    []string `json:"bottom,omitempty"`
+}
+
+// bottomCount is the number of cards the kept hand puts on the bottom.
+func (d mulliganDecision) bottomCount() int {
+	return max(0, d.Mulligans-d.Free)
+}
+
+type mulliganState struct {
+	Mulligans int
+	Kept      bool
+}
+
+type RoomMulliganPayload struct {
+	RoomID string   `json:"roomId"`
+	Bottom []string `json:"bottom"`
+}
+
+type mulliganNotice struct {
+	RoomID      string            `json:"roomId"`
+	Version     int64             `json:"version"`
+	EventID     int64             `json:"eventId"`
+	Player      string            `json:"player"`
+	Mulligans   int               `json:"mulligans"`
+	BottomCount int               `json:"bottomCount"`
+	Kept        bool              `json:"kept"`
+	Seed        int64             `json:"seed,omitempty"`
+	Hand        []json.RawMessage `json:"hand,omitempty"`
+}
+
+// mulliganCard is a card in a private zone, decoded so its zone and order can
+// be changed without losing the fields the client keeps.
+type mulliganCard struct {
+	fields map[string]interface{}
+	id     string
+	zone   string
+	index  float64
+}
+
+func (a *App) handleMulligan(client *WSClient, messageType string, raw json.RawMessage) {
+	var payload RoomMulliganPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+
+	a.lobbies.mu.Lock()
+	lobby := a.lobbies.lobby(payload.RoomID)
+	current, started := lobby.Mulligans[member.PlayerName], lobby.Started
+	a.lobbies.mu.Unlock()
+	free := a.rooms.HouseRules(payload.RoomID).freeMulligans()
+	switch {
+	case !started:
+		a.sendError(client.id, codeValidationFailed, "the game has not started")
+		return
+	case current.Kept:
+		a.sendError(client.id, codeValidationFailed, "you have already kept your hand")
+		return
+	case messageType == "room:mulligan" && current.Mulligans-free >= openingHandSize-1:
+		a.sendError(client.id, codeValidationFailed, "no more mulligans can be taken")
+		return
+	}
+
+	decision := mulliganDecision{Kind: mulliganKindMulligan, Mulligans: current.Mulligans + 1, Free: free}
+	if messageType == "room:mulligan_keep" {
+		decision = mulliganDecision{Kind: mulliganKindKeep, Mulligans: current.Mulligans, Free: free, Bottom: payload.Bottom}
+		if decision.Bottom == nil {
+			decision.Bottom = []string{}
+		}
+	} else {
+		decision.Seed = randomSeed()
+	}
+	var problem error
+	var hand []json.RawMessage
+	version, _, err := a.updateRoomState(context.Background(), payload.RoomID, func(state map[string]json.RawMessage) error {
+		var private map[string]privateZone
+		_ = json.Unmarshal(state["private"], &private)
+		var cards []mulliganCard
+		for _, raw := range private[member.PlayerName].Board {
+			card := mulliganCard{}
+			if err := json.Unmarshal(raw, &card.fields); err != nil {
+				return err
+			}
+			card.id, _ = card.fields["id"].(string)
+			card.zone, _ = card.fields["zone"].(string)
+			card.index, _ = card.fields["stackIndex"].(float64)
+			cards = append(cards, card)
+		}
+		if decision.Kind == mulliganKindKeep {
+			problem = keepHand(cards, decision)
+		} else {
+			problem = redealHand(cards, decision.Seed)
+		}
+		if problem != nil {
+			return problem
+		}
+		zone := privateZone{}
+		hand = nil
+		for _, card := range cards {
+			encoded := marshalPayload(card.fields)
+			zone.Board = append(zone.Board, encoded)
+			if card.fields["zone"] == "hand" {
+				hand = append(hand, encoded)
+			}
+		}
+		if private == nil {
+			private = make(map[string]privateZone)
+		}
+		private[member.PlayerName] = zone
+		state["private"] = marshalPayload(private)
+		return nil
+	})
+	if problem != nil {
+		a.sendError(client.id, codeValidationFailed, problem.Error())
+		return
+	}
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save the mulligan")
+		return
+	}
+
+	a.lobbies.mu.Lock()
+	lobby.Mulligans[member.PlayerName] = mulliganState{Mulligans: decision.Mulligans, Kept: decision.Kind == mulliganKindKeep}
+	a.lobbies.mu.Unlock()
+
+	eventID, _ := a.recordRoomEvent(RoomEventPayload{
+		RoomID:     payload.RoomID,
+		EventType:  roomEventMulligan,
+		EventData:  marshalPayload(decision),
+		PlayerID:   member.PlayerID,
+		PlayerName: member.PlayerName,
+		UserID:     member.UserID,
+	})
+	notice := mulliganNotice{
+		RoomID:      payload.RoomID,
+		Version:     version,
+		EventID:     eventID,
+		Player:      member.PlayerName,
+		Mulligans:   decision.Mulligans,
+		BottomCount: decision.bottomCount(),
+		Kept:        decision.Kind == mulliganKindKeep,
+		Seed:        decision.Seed,
+	}
+	for _, socketID := range a.rooms.socketIDs(payload.RoomID) {
+		if socketID == client.id {
+			continue
+		}
+		a.send(socketID, WSMessage{Type: "room:mulligan", Payload: marshalPayload(notice)})
+	}
+	notice.Hand = hand
+	a.send(client.id, WSMessage{Type: "room:mulligan", Payload: marshalPayload(notice)})
+}
+
+// redealHand shuffles the player's hand and library together and deals a new
+// opening hand from the top.
+func redealHand(cards []mulliganCard, seed int64) error {
+	var pile []*mulliganCard
+	for i := range cards {
+		if cards[i].zone == "hand" || cards[i].zone == "library" {
+			pile = append(pile, &cards[i])
+		}
+	}
+	if len(pile) < openingHandSize {
+		return errors.New("your hand and library hold fewer than 7 cards")
+	}
+	// Sort first so the same seed always gives the same order.
+	sort.Slice(pile, func(i, j int) bool { return pile[i].id < pile[j].id })
+	shuffleWithSeed(pile, seed)
+	for i, card := range pile {
+		if i < openingHandSize {
+			card.fields["zone"] = "hand"
+			card.fields["handIndex"] = i
+			delete(card.fields, "stackIndex")
+			continue
+		}
+		card.fields["zone"] = "library"
+		card.fields["stackIndex"] = len(pile) - 1 - i
+		delete(card.fields, "handIndex")
+	}
+	return nil
+}
+
+// keepHand puts the chosen cards from the hand on the bottom of the library,
+// each below the last.
+func keepHand(cards []mulliganCard, decision mulliganDecision) error {
+	if len(decision.Bottom) != decision.bottomCount() {
+		return fmt.Errorf("put exactly %d cards on the bottom", decision.bottomCount())
+	}
+	bottom := 0.0
+	inHand := make(map[string]*mulliganCard)
+	for i := range cards {
+		switch cards[i].zone {
+		case "library":
+			bottom = min(bottom, cards[i].index)
+		case "hand":
+			inHand[cards[i].id] = &cards[i]
+		}
+	}
+	for _, id := range decision.Bottom {
+		card := inHand[id]
+		if card == nil {
+			return fmt.Errorf("%q is not in your hand", id)
+		}
+		delete(inHand, id)
+		bottom--
+		card.fields["zone"] = "library"
+		card.fields["stackIndex"] = bottom
+		delete(card.fields, "handIndex")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// recordParticipant links a signed-in socket's seat in a room to its account
+// for match history. Anonymous sockets are not recorded.
+func (a *App) recordParticipant(client *WSClient, roomID string, playerID string, playerName string, role string) {
+	if client.userID == 0 {
+		return
+	}
+	_, _ = a.db.Exec(`
+		INSERT INTO rooms (room_id, board_state, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(room_id) DO NOTHING
+	`, roomID, "{}")
+	if _, err := a.db.Exec(`
+		INSERT INTO room_participants (room_id, user_id, player_id, player_name, role)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(room_id, user_id) DO UPDATE SET
+			player_id = excluded.player_id,
+			player_name = excluded.player_name,
+			role = excluded.role
+	`, roomID, client.userID, playerID, playerName, role); err != nil {
+		log.Printf("[rooms] failed to record participant %d in %s: %v", client.userID, roomID, err)
+	}
+}
+
+// handleMatchHistory lists the rooms the current account has sat in, newest
+// first, with the players' times in each finished game.
+func (a *App) handleMatchHistory(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	limit := parseIntDefault(r.URL.Query().Get("limit"), 50)
+	if limit > 100 || limit <= 0 {
+		limit = 100
+	}
+	offset := parseIntDefault(r.URL.Query().Get("offset"), 0)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT p.room_id, p.player_id, p.player_name, p.role, p.joined_at, r.updated_at,
+			(SELECT COUNT(*) FROM room_events e
+				WHERE e.room_id = p.room_id AND e.user_id = p.user_id AND e.reverted_at IS NULL)
+		FROM room_participants p
+		JOIN rooms r ON r.room_id = p.room_id
+		WHERE p.user_id = ?
+		ORDER BY p.joined_at DESC
+		LIMIT ? OFFSET ?
+	`, user.ID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load match history")
+		return
+	}
+	defer rows.Close()
+	matches := make([]map[string]interface{}, 0)
+	var roomIDs []string
+	for rows.Next() {
+		var roomID, playerID, playerName, role, joinedAt, updatedAt string
+		var events int
+		if err := rows.Scan(&roomID, &playerID, &playerName, &role, &joinedAt, &updatedAt, &events); err != nil {
+			continue
+		}
+		_, live := a.rooms.Summary(roomID)
+		matches = append(matches, map[string]interface{}{
+			"roomId":     roomID,
+			"playerId":   playerID,
+			"playerName": playerName,
+			"role":       role,
+			"joinedAt":   joinedAt,
+			"updatedAt":  updatedAt,
+			"events":     events,
+			"live":       live,
+		})
+		roomIDs = append(roomIDs, roomID)
+	}
+	games, err := a.loadGameTimes(r.Context(), roomIDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load match history")
+		return
+	}
+	for _, match := range matches {
+		match["games"] = append([]gameTimes{}, games[match["roomId"].(string)]...)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"matches": matches})
+}
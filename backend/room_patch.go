@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	jsonPatchContentType  = "application/json-patch+json"
+	mergePatchContentType = "application/merge-patch+json"
+	// maxRoomPatchOps bounds the operations in one JSON Patch.
+	maxRoomPatchOps = 1000
+)
+
+var (
+	errRoomVersionConflict = errors.New("room state was modified concurrently")
+	errOthersPrivateZone   = errors.New("a patch may not read or change another player's private cards")
+	errRoomStateTooLarge   = errors.New("patched room state is too large")
+)
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func defaultRoomState() roomStatePayload {
+	return roomStatePayload{
+		Board:             []byte("[]"),
+		Counters:          []byte("[]"),
+		Players:           []byte("[]"),
+		CemeteryPositions: []byte("{}"),
+		LibraryPositions:  []byte("{}"),
+		CommanderDamage:   []byte("{}"),
+	}
+}
+
+// roomVersionETag renders a room version as a strong ETag so clients can send
+// it back in If-Match.
+func roomVersionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func parseIfMatchVersion(r *http.Request) (int64, bool, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil {
+		return 0, false, errors.New("If-Match must be a room version")
+	}
+	return version, true, nil
+}
+
+// handlePatchRoomState applies a JSON Patch (RFC 6902) or a JSON Merge Patch
+// (RFC 7396) to the stored room state, chosen by Content-Type. An If-Match
+// header holding the expected version turns the write into a conditional one.
+// Paths address the stored document, where private cards live under
+// /private/{player}/board rather than /board; a player may only address
+// their own, and a patch that changes anyone else's is refused.
+func (a *App) handlePatchRoomState(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	if roomID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "roomId is required")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != jsonPatchContentType && contentType != mergePatchContentType {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+			"Content-Type must be "+jsonPatchContentType+" or "+mergePatchContentType)
+		return
+	}
+	expected, conditional, err := parseIfMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	// bodyLimitMiddleware holds the patch to MAX_ROOM_STATE_BYTES, and the
+	// state it produces is held to the same limit below.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+
+	var stateJSON string
+	var version int64
+	err = a.db.QueryRowContext(r.Context(), `SELECT board_state, version FROM rooms WHERE room_id = ?`, roomID).Scan(&stateJSON, &version)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load room state")
+		return
+	}
+	if !exists || stateJSON == "{}" {
+		defaultJSON, _ := json.Marshal(defaultRoomState())
+		stateJSON = string(defaultJSON)
+	}
+	if conditional && expected != version {
+		w.Header().Set("ETag", roomVersionETag(version))
+		body := errorBody(codeVersionMismatch, "Room version mismatch", map[string]int64{"version": version})
+		body["version"] = version
+		writeJSON(w, http.StatusPreconditionFailed, body)
+		return
+	}
+
+	viewer := viewerFromRequest(r)
+	var patched []byte
+	if contentType == jsonPatchContentType {
+		err = checkJSONPatchPrivacy(body, viewer)
+		if err == nil {
+			patched, err = applyJSONPatch([]byte(stateJSON), body, a.bodyLimits.roomStateBytes)
+		}
+	} else {
+		err = checkMergePatchPrivacy(body, viewer)
+		if err == nil {
+			patched, err = applyMergePatch([]byte(stateJSON), body)
+		}
+	}
+	if errors.Is(err, errOthersPrivateZone) {
+		writeError(w, http.StatusForbidden, codeForbidden, "A patch may only touch your own private cards")
+		return
+	}
+	if errors.Is(err, errRoomStateTooLarge) {
+		writeRoomStateTooLarge(w, a.bodyLimits.roomStateBytes)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidPatch, err.Error())
+		return
+	}
+	if limit := a.bodyLimits.roomStateBytes; limit > 0 && int64(len(patched)) > limit {
+		writeRoomStateTooLarge(w, limit)
+		return
+	}
+	patched, err = splitPrivateZones(patched)
+	var state roomStatePayload
+	if err != nil || json.Unmarshal(patched, &state) != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidRoomState, "Patched state is not a valid room state")
+		return
+	}
+	if !othersPrivateZonesKept([]byte(stateJSON), patched, viewer) {
+		writeError(w, http.StatusForbidden, codeForbidden, "A patch may only touch your own private cards")
+		return
+	}
+
+	newVersion, err := a.writeRoomState(r.Context(), roomID, patched, version, exists)
+	if errors.Is(err, errRoomVersionConflict) {
+		writeError(w, http.StatusConflict, codeVersionMismatch, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save room state")
+		return
+	}
+	w.Header().Set("ETag", roomVersionETag(newVersion))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "version": newVersion})
+}
+
+func writeRoomStateTooLarge(w http.ResponseWriter, limit int64) {
+	writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+		fmt.Sprintf("Patched room state is too large (limit %d bytes)", limit), map[string]int64{"limitBytes": limit})
+}
+
+// checkJSONPatchPrivacy refuses operations that address the private zones of
+// anyone but the viewer, whether to change them or, with test, copy or move,
+// to learn what they hold. The document root counts as addressing them all.
+func checkJSONPatchPrivacy(patch []byte, viewer roomViewer) error {
+	if viewer.All {
+		return nil
+	}
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return errors.New("Invalid JSON patch")
+	}
+	for _, op := range ops {
+		pointers := []string{op.Path}
+		if op.Op == "move" || op.Op == "copy" {
+			pointers = append(pointers, op.From)
+		}
+		for _, pointer := range pointers {
+			path, err := parseJSONPointer(pointer)
+			if err != nil {
+				return err
+			}
+			if len(path) == 0 || (path[0] == "private" && (len(path) == 1 || path[1] != viewer.PlayerName)) {
+				return errOthersPrivateZone
+			}
+		}
+	}
+	return nil
+}
+
+// checkMergePatchPrivacy refuses a merge patch that sets private to anything
+// but an object holding the viewer's own zone.
+func checkMergePatchPrivacy(patch []byte, viewer roomViewer) error {
+	if viewer.All {
+		return nil
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &document); err != nil {
+		if bytes.Equal(bytes.TrimSpace(patch), []byte("null")) {
+			return errOthersPrivateZone
+		}
+		return nil
+	}
+	raw, ok := document["private"]
+	if !ok {
+		return nil
+	}
+	var private map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &private); err != nil || private == nil {
+		return errOthersPrivateZone
+	}
+	for owner := range private {
+		if owner != viewer.PlayerName {
+			return errOthersPrivateZone
+		}
+	}
+	return nil
+}
+
+// othersPrivateZonesKept reports whether every private zone but the viewer's
+// is the same after a patch, which also stops a patch from putting cards in
+// another player's hand or library by way of the shared board.
+func othersPrivateZonesKept(before []byte, after []byte, viewer roomViewer) bool {
+	if viewer.All {
+		return true
+	}
+	others := func(stateJSON []byte) (map[string]privateZone, bool) {
+		var state struct {
+			Private map[string]privateZone `json:"private"`
+		}
+		if err := json.Unmarshal(stateJSON, &state); err != nil {
+			return nil, false
+		}
+		delete(state.Private, viewer.PlayerName)
+		for owner, zone := range state.Private {
+			if len(zone.Board) == 0 {
+				delete(state.Private, owner)
+			}
+		}
+		return state.Private, true
+	}
+	kept, ok := others(before)
+	if !ok {
+		return false
+	}
+	patched, ok := others(after)
+	return ok && jsonEqual(kept, patched)
+}
+
+// writeRoomState stores a patched state only if nobody else bumped the version
+// since it was read.
+func (a *App) writeRoomState(ctx context.Context, roomID string, state []byte, version int64, exists bool) (int64, error) {
+	var result sql.Result
+	var err error
+	if exists {
+		result, err = a.db.ExecContext(ctx, `
+			UPDATE rooms SET board_state = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE room_id = ? AND version = ?
+		`, string(state), roomID, version)
+	} else {
+		result, err = a.db.ExecContext(ctx, `
+			INSERT INTO rooms (room_id, board_state, version, updated_at)
+			VALUES (?, ?, 1, CURRENT_TIMESTAMP)
+			ON CONFLICT(room_id) DO NOTHING
+		`, roomID, string(state))
+	}
+	if err != nil {
+		return 0, err
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		return 0, errRoomVersionConflict
+	}
+	return version + 1, nil
+}
+
+func decodeJSONValue(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// applyMergePatch implements RFC 7396: objects merge recursively, null
+// deletes a key and any other value replaces the target outright.
+func applyMergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	merge, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, errors.New("Invalid merge patch")
+	}
+	return json.Marshal(mergeValue(target, merge))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// applyJSONPatch implements the RFC 6902 operations. The patch is applied
+// atomically: any failing operation leaves the document untouched. Since
+// copy can double the document with every operation, the size it may grow
+// to is tracked as the patch goes and it stops with errRoomStateTooLarge
+// once that passes maxBytes (0 for no limit). The estimate only counts what
+// operations add, so it never falls short of the real size.
+func applyJSONPatch(doc, patch []byte, maxBytes int64) ([]byte, error) {
+	target, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, errors.New("Invalid JSON patch")
+	}
+	if len(ops) > maxRoomPatchOps {
+		return nil, fmt.Errorf("a patch may hold at most %d operations", maxRoomPatchOps)
+	}
+	size := int64(len(doc))
+	for i, op := range ops {
+		size += patchOpGrowth(target, op)
+		if maxBytes > 0 && size > maxBytes {
+			return nil, errRoomStateTooLarge
+		}
+		if target, err = applyPatchOp(target, op); err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+// patchOpGrowth is at most how many bytes op adds to the document.
+func patchOpGrowth(doc interface{}, op jsonPatchOp) int64 {
+	switch op.Op {
+	case "add", "replace":
+		return int64(len(op.Value))
+	case "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return 0
+		}
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return 0
+		}
+		data, _ := json.Marshal(value)
+		return int64(len(data))
+	default:
+		return 0
+	}
+}
+
+func applyPatchOp(doc interface{}, op jsonPatchOp) (interface{}, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("value is required")
+		}
+		value, err := decodeJSONValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return pointerAdd(doc, path, value)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, path); err != nil {
+				return nil, err
+			}
+			return pointerAdd(doc, path, value)
+		default:
+			current, err := pointerGet(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !jsonEqual(current, value) {
+				return nil, errors.New("test failed")
+			}
+			return doc, nil
+		}
+	case "remove":
+		doc, _, err = pointerRemove(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			doc, value, err = pointerRemove(doc, from)
+		} else {
+			value, err = pointerGet(doc, from)
+			if err == nil {
+				value, err = cloneJSONValue(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	default:
+		return nil, errors.New("unsupported op")
+	}
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path segment %q not found", token)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path segment %q not found", token)
+		}
+	}
+	return current, nil
+}
+
+// pointerAdd and pointerRemove rebuild the containers along the path, since
+// array inserts and deletes change the slice header held by the parent.
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("path segment %q not found", token)
+		}
+		updated, err := pointerAdd(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = updated
+		return node, nil
+	case []interface{}:
+		if len(path) == 1 {
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		index, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := pointerAdd(node[index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = updated
+		return node, nil
+	default:
+		return nil, fmt.Errorf("path segment %q not found", token)
+	}
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the document root")
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path segment %q not found", token)
+		}
+		if len(path) == 1 {
+			delete(node, token)
+			return node, child, nil
+		}
+		updated, removed, err := pointerRemove(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = updated
+		return node, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := node[index]
+			return append(node[:index], node[index+1:]...), removed, nil
+		}
+		updated, removed, err := pointerRemove(node[index], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[index] = updated
+		return node, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("path segment %q not found", token)
+	}
+}
+
+func cloneJSONValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONValue(data)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	left, errLeft := json.Marshal(a)
+	right, errRight := json.Marshal(b)
+	return errLeft == nil && errRight == nil && bytes.Equal(left, right)
+}
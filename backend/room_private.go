@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// privateZones are the zones whose cards only their owner may see.
+var privateZones = map[string]bool{
+	"hand":    true,
+	"library": true,
+}
+
+type privateZone struct {
+	Board []json.RawMessage `json:"board"`
+}
+
+type boardCardOwner struct {
+	ID      string `json:"id"`
+	OwnerID string `json:"ownerId"`
+	Zone    string `json:"zone"`
+}
+
+// splitPrivateZones rewrites a stored room state so that cards in a private
+// zone live under private[ownerId] instead of the shared board. Cards are
+// gathered from both places first, so a patch that moves a card between
+// zones lands it in the right section.
+func splitPrivateZones(stateJSON []byte) ([]byte, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, err
+	}
+	var cards []json.RawMessage
+	if raw, ok := state["board"]; ok {
+		if err := json.Unmarshal(raw, &cards); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := state["private"]; ok {
+		var private map[string]privateZone
+		if err := json.Unmarshal(raw, &private); err != nil {
+			return nil, err
+		}
+		owners := make([]string, 0, len(private))
+		for owner := range private {
+			owners = append(owners, owner)
+		}
+		sort.Strings(owners)
+		for _, owner := range owners {
+			cards = append(cards, private[owner].Board...)
+		}
+	}
+	shared := make([]json.RawMessage, 0, len(cards))
+	private := make(map[string]*privateZone)
+	for _, card := range cards {
+		var info boardCardOwner
+		_ = json.Unmarshal(card, &info)
+		if !privateZones[info.Zone] || info.OwnerID == "" {
+			shared = append(shared, card)
+			continue
+		}
+		zone := private[info.OwnerID]
+		if zone == nil {
+			zone = &privateZone{}
+			private[info.OwnerID] = zone
+		}
+		zone.Board = append(zone.Board, card)
+	}
+	state["board"] = marshalPayload(shared)
+	if len(private) > 0 {
+		state["private"] = marshalPayload(private)
+	} else {
+		delete(state, "private")
+	}
+	return json.Marshal(state)
+}
+
+// emptyRoomView stands in for a stored state that cannot be decoded, so that
+// no private card is shown for want of knowing whose it is.
+var emptyRoomView = []byte(`{"board":[],"hiddenCounts":{}}`)
+
+// viewRoomState returns the stored state as the viewer may see it: the shared
+// board plus the viewer's own private cards, with only card counts for the
+// other players' private zones. Cards revealed to the viewer are shown and
+// left out of the counts. The order of undrawn supplemental cards is only
+// shown to admins.
+func viewRoomState(stateJSON []byte, viewer roomViewer) []byte {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		if viewer.All {
+			return stateJSON
+		}
+		return emptyRoomView
+	}
+	hid := !viewer.All && hideSupplementalDecks(state)
+	raw, ok := state["private"]
+	if !ok && hid {
+		return marshalPayload(state)
+	}
+	if !ok {
+		return stateJSON
+	}
+	delete(state, "private")
+	// Undecodable private zones are left out, leaving only the shared board.
+	var private map[string]privateZone
+	if err := json.Unmarshal(raw, &private); err != nil && viewer.All {
+		return stateJSON
+	}
+	var board []json.RawMessage
+	_ = json.Unmarshal(state["board"], &board)
+	owners := make([]string, 0, len(private))
+	for owner := range private {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	hidden := make(map[string]map[string]int)
+	for _, owner := range owners {
+		zone := private[owner]
+		if viewer.All || owner == viewer.PlayerName {
+			board = append(board, zone.Board...)
+			continue
+		}
+		counts := make(map[string]int)
+		for _, card := range zone.Board {
+			var info boardCardOwner
+			_ = json.Unmarshal(card, &info)
+			if viewer.Revealed[info.ID] {
+				board = append(board, card)
+				continue
+			}
+			counts[info.Zone]++
+		}
+		hidden[owner] = counts
+	}
+	if board == nil {
+		board = []json.RawMessage{}
+	}
+	state["board"] = marshalPayload(board)
+	state["hiddenCounts"] = marshalPayload(hidden)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return emptyRoomView
+	}
+	return data
+}
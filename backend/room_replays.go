@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	replayFormat        = "mtonline-replay"
+	replayFormatVersion = 1
+	sqliteTimeLayout    = "2006-01-02 15:04:05"
+)
+
+// roomEventsBetween returns a room's events after sinceID in log order,
+// optionally stopping at the SQLite timestamp until.
+func (a *App) roomEventsBetween(ctx context.Context, roomID string, sinceID int64, until string) ([]map[string]interface{}, error) {
+	query := `
+		SELECT id, event_type, event_data, player_id, player_name, user_id, created_at
+		FROM room_events
+		WHERE room_id = ? AND id > ? AND reverted_at IS NULL`
+	args := []interface{}{roomID, sinceID}
+	if until != "" {
+		query += ` AND created_at <= ?`
+		args = append(args, until)
+	}
+	rows, err := a.db.QueryContext(ctx, query+` ORDER BY id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		if event, err := scanRoomEvent(rows); err == nil {
+			events = append(events, event)
+		}
+	}
+	return events, rows.Err()
+}
+
+// roomSnapshotTimeline lists every snapshot of a room without its state, so
+// a replay viewer can offer the keyframes it can seek to.
+func (a *App) roomSnapshotTimeline(ctx context.Context, roomID string) ([]map[string]interface{}, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, version, last_event_id, created_at
+		FROM room_snapshots
+		WHERE room_id = ?
+		ORDER BY id ASC
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	timeline := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, version, lastEventID int64
+		var createdAt string
+		if err := rows.Scan(&id, &version, &lastEventID, &createdAt); err != nil {
+			continue
+		}
+		timeline = append(timeline, map[string]interface{}{
+			"id":          id,
+			"version":     version,
+			"lastEventId": lastEventID,
+			"createdAt":   createdAt,
+		})
+	}
+	return timeline, rows.Err()
+}
+
+// replayDocument is the latest snapshot, as the viewer sees it, and the
+// events logged after it, which together rebuild the room without reading
+// its whole history. On failure it returns the message to send.
+func (a *App) replayDocument(ctx context.Context, roomID string, viewer roomViewer, exportedAt time.Time) (map[string]interface{}, string) {
+	snapshot, err := a.roomSnapshotAt(ctx, roomID, "")
+	if err != nil {
+		return nil, "Failed to load snapshot"
+	}
+	var sinceID int64
+	if snapshot != nil {
+		sinceID = snapshot.LastEventID
+		snapshot.State = viewRoomState(snapshot.State, viewer)
+	}
+	events, err := a.roomEventsBetween(ctx, roomID, sinceID, "")
+	if err != nil {
+		return nil, "Failed to load events"
+	}
+	timeline, err := a.roomSnapshotTimeline(ctx, roomID)
+	if err != nil {
+		return nil, "Failed to load snapshots"
+	}
+	return map[string]interface{}{
+		"format":      replayFormat,
+		"version":     replayFormatVersion,
+		"roomId":      roomID,
+		"exportedAt":  exportedAt.Format(time.RFC3339),
+		"snapshots":   timeline,
+		"snapshot":    snapshot,
+		"eventsSince": events,
+	}, ""
+}
+
+// handleRoomReplay returns the room's replay document. With ?download=1 it
+// is served as a replay file.
+func (a *App) handleRoomReplay(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	exportedAt := time.Now().UTC()
+	document, problem := a.replayDocument(r.Context(), roomID, viewerFromRequest(r), exportedAt)
+	if problem != "" {
+		writeError(w, http.StatusInternalServerError, codeInternal, problem)
+		return
+	}
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.replay.json"`, replayFilename(roomID), exportedAt.Format("20060102-150405")))
+	}
+	writeJSON(w, http.StatusOK, document)
+}
+
+func replayFilename(roomID string) string {
+	filename := strings.Trim(deckExportFilenameUnsafe.ReplaceAllString(roomID, "-"), "-")
+	if filename == "" {
+		filename = "room"
+	}
+	return filename
+}
+
+func parseReplayTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("t is required")
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("t must be unix seconds or an RFC 3339 timestamp")
+	}
+	return parsed.UTC(), nil
+}
+
+// handleReplayAt returns what a client needs to rebuild the room as it was at
+// time t: the newest snapshot taken by then and the events that followed it
+// up to t. Events are opaque to the server, so applying them is left to the
+// client. Once a later snapshot has compacted the events in between, the
+// result is only accurate to the earlier snapshot and approximate is set.
+func (a *App) handleReplayAt(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	at, err := parseReplayTime(r.URL.Query().Get("t"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	until := at.Format(sqliteTimeLayout)
+	snapshot, err := a.roomSnapshotAt(r.Context(), roomID, until)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load snapshot")
+		return
+	}
+	var sinceID int64
+	if snapshot != nil {
+		sinceID = snapshot.LastEventID
+		snapshot.State = viewRoomState(snapshot.State, viewerFromRequest(r))
+	}
+	events, err := a.roomEventsBetween(r.Context(), roomID, sinceID, until)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load events")
+		return
+	}
+	var later int
+	_ = a.db.QueryRowContext(r.Context(), `
+		SELECT COUNT(*) FROM room_snapshots WHERE room_id = ? AND created_at > ?
+	`, roomID, until).Scan(&later)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId":      roomID,
+		"at":          at.Format(time.RFC3339),
+		"snapshot":    snapshot,
+		"eventsSince": events,
+		"approximate": later > 0,
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	resyncMinInterval = 5 * time.Second
+	resyncHostTimeout = 3 * time.Second
+	resyncFromServer  = "server"
+)
+
+// A client that has lost track of the board asks for it again with
+// room:request_state. While the host is connected the request is passed on
+// to it as room:state_requested, and the host answers that socket with a
+// room:host_message carrying targetSocketId, as it does for a player who just
+// joined. When the host is the one asking, when the client asks for the
+// "server" source, or when a host on this instance has not answered within
+// resyncHostTimeout, the server sends its stored state instead as room:state,
+// without the private zones the player may not see. A socket may ask once
+// every resyncMinInterval.
+type resyncTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	// pending maps sockets waiting for the host's answer to their room.
+	pending map[string]string
+}
+
+type RoomRequestStatePayload struct {
+	RoomID string `json:"roomId"`
+	Source string `json:"source"`
+}
+
+type roomStateRequestedMessage struct {
+	RoomID     string `json:"roomId"`
+	SocketID   string `json:"socketId"`
+	PlayerID   string `json:"playerId"`
+	PlayerName string `json:"playerName"`
+}
+
+type roomStateMessage struct {
+	RoomID  string          `json:"roomId"`
+	Version int64           `json:"version"`
+	State   json.RawMessage `json:"state"`
+}
+
+func newResyncTracker() *resyncTracker {
+	return &resyncTracker{last: make(map[string]time.Time), pending: make(map[string]string)}
+}
+
+// allow records a request from the socket, returning how long it must wait
+// first when it asked too recently.
+func (t *resyncTracker) allow(socketID string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if wait := t.last[socketID].Add(resyncMinInterval).Sub(now); wait > 0 {
+		return wait
+	}
+	t.last[socketID] = now
+	return 0
+}
+
+func (t *resyncTracker) await(socketID string, roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[socketID] = roomID
+}
+
+// settle clears the socket's pending request, reporting whether it was still
+// waiting for the room's host.
+func (t *resyncTracker) settle(socketID string, roomID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending[socketID] != roomID {
+		return false
+	}
+	delete(t.pending, socketID)
+	return true
+}
+
+// forget drops a departed socket.
+func (t *resyncTracker) forget(socketID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, socketID)
+	delete(t.pending, socketID)
+}
+
+func (a *App) handleRequestState(client *WSClient, raw json.RawMessage) {
+	var payload RoomRequestStatePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if wait := a.resyncs.allow(client.id, time.Now()); wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		a.sendErrorDetails(client.id, codeRateLimited, "state was requested too recently", map[string]int{"retryAfter": seconds})
+		return
+	}
+
+	hostID := a.rooms.HostSocket(payload.RoomID)
+	if hostID == client.id || payload.Source == resyncFromServer {
+		a.sendStoredState(client.id, payload.RoomID, member.PlayerName)
+		return
+	}
+	a.send(hostID, WSMessage{
+		Type: "room:state_requested",
+		Payload: marshalPayload(roomStateRequestedMessage{
+			RoomID:     payload.RoomID,
+			SocketID:   client.id,
+			PlayerID:   member.PlayerID,
+			PlayerName: member.PlayerName,
+		}),
+	})
+	// A host on another instance answers there, out of sight.
+	if len(a.localSockets([]string{hostID})) == 0 {
+		return
+	}
+	a.resyncs.await(client.id, payload.RoomID)
+	time.AfterFunc(resyncHostTimeout, func() {
+		if a.resyncs.settle(client.id, payload.RoomID) {
+			a.sendStoredState(client.id, payload.RoomID, member.PlayerName)
+		}
+	})
+}
+
+// sendStoredState sends the socket the room's stored state as the player
+// sees it.
+func (a *App) sendStoredState(socketID string, roomID string, playerName string) {
+	state, version := a.roomStateView(context.Background(), roomID, roomViewer{PlayerName: playerName})
+	a.send(socketID, WSMessage{
+		Type:    "room:state",
+		Payload: marshalPayload(roomStateMessage{RoomID: roomID, Version: version, State: state}),
+	})
+}
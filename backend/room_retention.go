@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const roomPruneInterval = time.Hour
+
+type roomPruneResult struct {
+	Rooms     int64 `json:"rooms"`
+	Events    int64 `json:"events"`
+	Snapshots int64 `json:"snapshots"`
+}
+
+// loadRoomRetentionDays reads ROOM_RETENTION_DAYS; zero or less disables
+// scheduled pruning.
+func loadRoomRetentionDays() int {
+	return envInt("ROOM_RETENTION_DAYS", 30)
+}
+
+func (a *App) runRoomRetention(days int) {
+	if days <= 0 {
+		log.Printf("[rooms] retention pruning disabled")
+		return
+	}
+	ticker := time.NewTicker(roomPruneInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		result, err := a.pruneStaleRooms(days)
+		if err != nil {
+			log.Printf("[rooms] retention pruning failed: %v", err)
+			continue
+		}
+		if result.Rooms > 0 {
+			log.Printf("[rooms] pruned %d rooms, %d events, %d snapshots older than %d days", result.Rooms, result.Events, result.Snapshots, days)
+		}
+	}
+}
+
+// pruneStaleRooms deletes rooms whose state and events have not changed in
+// the given number of days. Their events and snapshots go with them through
+// ON DELETE CASCADE. Rooms that are still open are kept.
+func (a *App) pruneStaleRooms(days int) (roomPruneResult, error) {
+	var result roomPruneResult
+	cutoff := "-" + strconv.Itoa(days) + " days"
+	rows, err := a.db.Query(`
+		SELECT r.room_id FROM rooms r
+		WHERE r.updated_at < datetime('now', ?)
+			AND NOT EXISTS (
+				SELECT 1 FROM room_events e
+				WHERE e.room_id = r.room_id AND e.created_at >= datetime('now', ?)
+			)
+	`, cutoff, cutoff)
+	if err != nil {
+		return result, err
+	}
+	var stale []interface{}
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err != nil {
+			continue
+		}
+		if _, live := a.rooms.Summary(roomID); !live {
+			stale = append(stale, roomID)
+		}
+	}
+	rows.Close()
+	if len(stale) == 0 {
+		return result, nil
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(stale)), ",") + ")"
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM room_events WHERE room_id IN `+placeholders, stale...).Scan(&result.Events); err != nil {
+		return result, err
+	}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM room_snapshots WHERE room_id IN `+placeholders, stale...).Scan(&result.Snapshots); err != nil {
+		return result, err
+	}
+	deleted, err := tx.Exec(`DELETE FROM rooms WHERE room_id IN `+placeholders, stale...)
+	if err != nil {
+		return result, err
+	}
+	result.Rooms, _ = deleted.RowsAffected()
+	return result, tx.Commit()
+}
+
+// handleAdminPruneRooms runs retention pruning now. ?days= overrides the
+// configured window for this run.
+func (a *App) handleAdminPruneRooms(w http.ResponseWriter, r *http.Request) {
+	days := parseIntDefault(r.URL.Query().Get("days"), loadRoomRetentionDays())
+	if days <= 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "days must be positive")
+		return
+	}
+	result, err := a.pruneStaleRooms(days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to prune rooms")
+		return
+	}
+	log.Printf("[admin] %s pruned %d rooms older than %d days", a.currentUser(r).Username, result.Rooms, days)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"days":    days,
+		"deleted": result,
+		"success": true,
+	})
+}
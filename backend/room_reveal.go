@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	revealTTL      = 2 * time.Minute
+	peekRequestTTL = time.Minute
+	maxRevealCards = 100
+)
+
+// revealTracker records who may see which hidden cards. A player reveals
+// cards from their own hand or library with room:reveal, to everyone or to
+// named players, or in answer to another player's room:peek_request. The
+// server checks the cards are the sender's, sends their identities only to
+// the sockets allowed to see them (everyone else gets a notice with the
+// count), and keeps a grant so GET /rooms/{roomId}/state shows those cards to
+// the same players until the owner ends the reveal or it expires. Like
+// presence, grants are kept by the instance that received the reveal.
+type revealTracker struct {
+	mu       sync.Mutex
+	grants   map[string][]*revealGrant
+	requests map[string]*peekRequest
+}
+
+type revealGrant struct {
+	ID      string
+	RoomID  string
+	Owner   string
+	Zone    string
+	Viewers []string // player names; empty means everyone
+	CardIDs []string
+	Expires time.Time
+}
+
+type peekRequest struct {
+	ID              string
+	RoomID          string
+	Requester       string
+	RequesterSocket string
+	Owner           string
+	Zone            string
+	Expires         time.Time
+}
+
+type RoomRevealPayload struct {
+	RoomID string            `json:"roomId"`
+	Zone   string            `json:"zone"`
+	Cards  []json.RawMessage `json:"cards"`
+	// To names the players who may see the cards; empty reveals them to
+	// everyone. It is ignored when answering a peek request.
+	To        []string `json:"to"`
+	RequestID string   `json:"requestId"`
+}
+
+type RoomPeekPayload struct {
+	RoomID    string `json:"roomId"`
+	Target    string `json:"target"`
+	Zone      string `json:"zone"`
+	RequestID string `json:"requestId"`
+	RevealID  string `json:"revealId"`
+}
+
+type revealedPayload struct {
+	RoomID    string            `json:"roomId"`
+	RevealID  string            `json:"revealId"`
+	From      string            `json:"from"`
+	Zone      string            `json:"zone"`
+	To        []string          `json:"to"`
+	Count     int               `json:"count"`
+	Cards     []json.RawMessage `json:"cards,omitempty"`
+	ExpiresAt string            `json:"expiresAt"`
+}
+
+func newRevealTracker() *revealTracker {
+	return &revealTracker{
+		grants:   make(map[string][]*revealGrant),
+		requests: make(map[string]*peekRequest),
+	}
+}
+
+// prune drops expired grants and requests. Called with t.mu held.
+func (t *revealTracker) prune(now time.Time) {
+	for roomID, grants := range t.grants {
+		kept := grants[:0]
+		for _, grant := range grants {
+			if now.Before(grant.Expires) {
+				kept = append(kept, grant)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.grants, roomID)
+		} else {
+			t.grants[roomID] = kept
+		}
+	}
+	for id, request := range t.requests {
+		if !now.Before(request.Expires) {
+			delete(t.requests, id)
+		}
+	}
+}
+
+// visibleTo returns the ids of the hidden cards the player has been shown.
+func (t *revealTracker) visibleTo(roomID string, playerName string) map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(time.Now())
+	visible := make(map[string]bool)
+	for _, grant := range t.grants[roomID] {
+		if len(grant.Viewers) == 0 || containsString(grant.Viewers, playerName) {
+			for _, id := range grant.CardIDs {
+				visible[id] = true
+			}
+		}
+	}
+	return visible
+}
+
+// forget drops a closed room's grants and requests.
+func (t *revealTracker) forget(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.grants, roomID)
+	for id, request := range t.requests {
+		if request.RoomID == roomID {
+			delete(t.requests, id)
+		}
+	}
+}
+
+// roomPlayers maps every player name in the room to its socket.
+func (a *App) roomPlayers(roomID string) map[string]string {
+	players := make(map[string]string)
+	for _, socketID := range a.rooms.socketIDs(roomID) {
+		if info, ok := a.rooms.Member(roomID, socketID); ok {
+			players[info.PlayerName] = socketID
+		}
+	}
+	return players
+}
+
+func (a *App) handleRoomReveal(client *WSClient, raw json.RawMessage) {
+	var payload RoomRevealPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if !privateZones[payload.Zone] {
+		a.sendError(client.id, codeValidationFailed, "zone must be hand or library")
+		return
+	}
+	if len(payload.Cards) == 0 || len(payload.Cards) > maxRevealCards {
+		a.sendError(client.id, codeValidationFailed, fmt.Sprintf("reveal between 1 and %d cards", maxRevealCards))
+		return
+	}
+	cardIDs, details := revealedCardIDs(payload.Cards, member.PlayerName, payload.Zone)
+	if len(details) > 0 {
+		a.sendErrorDetails(client.id, codeValidationFailed, "invalid cards", details)
+		return
+	}
+	players := a.roomPlayers(payload.RoomID)
+	viewers := payload.To
+	if payload.RequestID != "" {
+		a.reveals.mu.Lock()
+		request := a.reveals.requests[payload.RequestID]
+		if request != nil && request.RoomID == payload.RoomID && request.Owner == member.PlayerName && request.Zone == payload.Zone {
+			delete(a.reveals.requests, payload.RequestID)
+		} else {
+			request = nil
+		}
+		a.reveals.mu.Unlock()
+		if request == nil {
+			a.sendError(client.id, codeNotFound, "peek request not found or expired")
+			return
+		}
+		viewers = []string{request.Requester}
+	}
+	for _, name := range viewers {
+		if _, seated := players[name]; !seated || name == member.PlayerName {
+			a.sendError(client.id, codeValidationFailed, fmt.Sprintf("%q is not another player in the room", name))
+			return
+		}
+	}
+
+	grant := &revealGrant{
+		ID:      randomID(8),
+		RoomID:  payload.RoomID,
+		Owner:   member.PlayerName,
+		Zone:    payload.Zone,
+		Viewers: viewers,
+		CardIDs: cardIDs,
+		Expires: time.Now().Add(revealTTL),
+	}
+	a.reveals.mu.Lock()
+	a.reveals.prune(time.Now())
+	a.reveals.grants[payload.RoomID] = append(a.reveals.grants[payload.RoomID], grant)
+	a.reveals.mu.Unlock()
+
+	notice := revealedPayload{
+		RoomID:    payload.RoomID,
+		RevealID:  grant.ID,
+		From:      member.PlayerName,
+		Zone:      payload.Zone,
+		To:        viewers,
+		Count:     len(cardIDs),
+		ExpiresAt: grant.Expires.UTC().Format(time.RFC3339),
+	}
+	if notice.To == nil {
+		notice.To = []string{}
+	}
+	revealed := notice
+	revealed.Cards = payload.Cards
+	for name, socketID := range players {
+		if name == member.PlayerName || len(viewers) == 0 || containsString(viewers, name) {
+			a.send(socketID, WSMessage{Type: "room:revealed", Payload: marshalPayload(revealed)})
+		} else {
+			a.send(socketID, WSMessage{Type: "room:reveal_notice", Payload: marshalPayload(notice)})
+		}
+	}
+	a.logRoomLine(payload.RoomID, member.PlayerName, revealMessage(member.PlayerName, payload.Zone, len(cardIDs), viewers))
+}
+
+// revealedCardIDs checks that every card belongs to the owner and sits in
+// the zone being revealed, returning their ids.
+func revealedCardIDs(cards []json.RawMessage, owner string, zone string) ([]string, []string) {
+	ids := make([]string, 0, len(cards))
+	var details []string
+	for i, raw := range cards {
+		path := fmt.Sprintf("cards[%d]", i)
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			details = append(details, path+": not valid JSON")
+			continue
+		}
+		if problems := validateValue(value, schemaCard, path); len(problems) > 0 {
+			details = append(details, problems...)
+			continue
+		}
+		card := value.(map[string]interface{})
+		if card["ownerId"] != owner {
+			details = append(details, path+": only your own cards can be revealed")
+			continue
+		}
+		if card["zone"] != zone {
+			details = append(details, fmt.Sprintf("%s: is not in the %s", path, zone))
+			continue
+		}
+		ids = append(ids, card["id"].(string))
+	}
+	return ids, details
+}
+
+func revealMessage(owner string, zone string, count int, viewers []string) string {
+	what := "their hand"
+	if zone == "library" {
+		what = fmt.Sprintf("%d cards from their library", count)
+		if count == 1 {
+			what = "a card from their library"
+		}
+	}
+	to := "everyone"
+	if len(viewers) > 0 {
+		to = strings.Join(viewers, ", ")
+	}
+	return fmt.Sprintf("%s reveals %s to %s", owner, what, to)
+}
+
+// handleRoomRevealEnd lets the owner hide revealed cards again before the
+// reveal expires.
+func (a *App) handleRoomRevealEnd(client *WSClient, raw json.RawMessage) {
+	var payload RoomPeekPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	a.reveals.mu.Lock()
+	found := false
+	grants := a.reveals.grants[payload.RoomID]
+	for i, grant := range grants {
+		if grant.ID == payload.RevealID && grant.Owner == member.PlayerName {
+			a.reveals.grants[payload.RoomID] = append(grants[:i:i], grants[i+1:]...)
+			found = true
+			break
+		}
+	}
+	a.reveals.mu.Unlock()
+	if !found {
+		a.sendError(client.id, codeNotFound, "reveal not found or expired")
+		return
+	}
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:reveal_ended",
+		Payload: marshalPayload(map[string]string{"roomId": payload.RoomID, "revealId": payload.RevealID}),
+	})
+}
+
+// handlePeekRequest asks another player to show a hidden zone. Only the
+// target hears of it; they answer with room:reveal carrying the requestId,
+// or with room:peek_decline.
+func (a *App) handlePeekRequest(client *WSClient, raw json.RawMessage) {
+	var payload RoomPeekPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if payload.Zone == "" {
+		payload.Zone = "hand"
+	}
+	if !privateZones[payload.Zone] {
+		a.sendError(client.id, codeValidationFailed, "zone must be hand or library")
+		return
+	}
+	targetSocket, seated := a.roomPlayers(payload.RoomID)[payload.Target]
+	if !seated || payload.Target == member.PlayerName {
+		a.sendError(client.id, codeValidationFailed, "target must be another player in the room")
+		return
+	}
+	request := &peekRequest{
+		ID:              randomID(8),
+		RoomID:          payload.RoomID,
+		Requester:       member.PlayerName,
+		RequesterSocket: client.id,
+		Owner:           payload.Target,
+		Zone:            payload.Zone,
+		Expires:         time.Now().Add(peekRequestTTL),
+	}
+	a.reveals.mu.Lock()
+	a.reveals.prune(time.Now())
+	a.reveals.requests[request.ID] = request
+	a.reveals.mu.Unlock()
+	message := map[string]string{
+		"roomId":    payload.RoomID,
+		"requestId": request.ID,
+		"from":      request.Requester,
+		"target":    request.Owner,
+		"zone":      request.Zone,
+		"expiresAt": request.Expires.UTC().Format(time.RFC3339),
+	}
+	a.send(targetSocket, WSMessage{Type: "room:peek_requested", Payload: marshalPayload(message)})
+	a.send(client.id, WSMessage{Type: "room:peek_pending", Payload: marshalPayload(message)})
+}
+
+func (a *App) handlePeekDecline(client *WSClient, raw json.RawMessage) {
+	var payload RoomPeekPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	a.reveals.mu.Lock()
+	request := a.reveals.requests[payload.RequestID]
+	if request != nil && request.RoomID == payload.RoomID && request.Owner == member.PlayerName {
+		delete(a.reveals.requests, payload.RequestID)
+	} else {
+		request = nil
+	}
+	a.reveals.mu.Unlock()
+	if request == nil {
+		a.sendError(client.id, codeNotFound, "peek request not found or expired")
+		return
+	}
+	a.send(request.RequesterSocket, WSMessage{
+		Type:    "room:peek_declined",
+		Payload: marshalPayload(map[string]string{"roomId": payload.RoomID, "requestId": request.ID, "target": request.Owner}),
+	})
+}
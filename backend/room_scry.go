@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	scryTTL         = 5 * time.Minute
+	maxScryCards    = 20
+	scryKindScry    = "scry"
+	scryKindSurveil = "surveil"
+)
+
+// scryTracker holds the scries and surveils players have started but not yet
+// resolved. room:scry reads the top cards of the player's stored library and
+// sends them to that player alone; the rest of the room only hears how many
+// cards are being looked at. room:scry_resolve must place exactly those
+// cards, and the decision is stored as a SCRY event. Like reveals, sessions
+// are kept by the instance that started them.
+type scryTracker struct {
+	mu       sync.Mutex
+	sessions map[string]*scrySession
+}
+
+type scrySession struct {
+	ID      string
+	RoomID  string
+	Owner   string
+	Kind    string
+	CardIDs []string
+	Cards   map[string]json.RawMessage
+	Expires time.Time
+}
+
+type RoomScryPayload struct {
+	RoomID string `json:"roomId"`
+	Kind   string `json:"kind"`
+	Count  int    `json:"count"`
+}
+
+type RoomScryResolvePayload struct {
+	RoomID string `json:"roomId"`
+	ScryID string `json:"scryId"`
+	scryDecision
+}
+
+// scryDecision is the stored SCRY event data.
+type scryDecision struct {
+	Kind      string   `json:"kind"`
+	Count     int      `json:"count"`
+	Top       []string `json:"top"`
+	Bottom    []string `json:"bottom"`
+	Graveyard []string `json:"graveyard,omitempty"`
+}
+
+type scryNotice struct {
+	RoomID    string            `json:"roomId"`
+	ScryID    string            `json:"scryId"`
+	Player    string            `json:"player"`
+	Kind      string            `json:"kind"`
+	Count     int               `json:"count"`
+	Cards     []json.RawMessage `json:"cards,omitempty"`
+	ExpiresAt string            `json:"expiresAt,omitempty"`
+	EventID   int64             `json:"eventId,omitempty"`
+	Top       *int              `json:"top,omitempty"`
+	Bottom    *int              `json:"bottom,omitempty"`
+	Decision  *scryDecision     `json:"decision,omitempty"`
+}
+
+func newScryTracker() *scryTracker {
+	return &scryTracker{sessions: make(map[string]*scrySession)}
+}
+
+// start records a session, replacing any the player left open in the room.
+func (t *scryTracker) start(session *scrySession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for id, open := range t.sessions {
+		if !now.Before(open.Expires) || (open.RoomID == session.RoomID && open.Owner == session.Owner) {
+			delete(t.sessions, id)
+		}
+	}
+	t.sessions[session.ID] = session
+}
+
+// find returns the player's open session with the given id.
+func (t *scryTracker) find(roomID string, owner string, id string) *scrySession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session := t.sessions[id]
+	if session == nil || session.RoomID != roomID || session.Owner != owner || !time.Now().Before(session.Expires) {
+		return nil
+	}
+	return session
+}
+
+// take closes a session, reporting false if it was already resolved.
+func (t *scryTracker) take(session *scrySession) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions[session.ID] != session {
+		return false
+	}
+	delete(t.sessions, session.ID)
+	return true
+}
+
+// forget drops a closed room's open sessions.
+func (t *scryTracker) forget(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, session := range t.sessions {
+		if session.RoomID == roomID {
+			delete(t.sessions, id)
+		}
+	}
+}
+
+// libraryTop returns up to count cards from the top of the owner's stored
+// library. The client keeps the highest stackIndex on top.
+func (a *App) libraryTop(roomID string, owner string, count int) ([]json.RawMessage, []string, error) {
+	var boardState string
+	if err := a.db.QueryRow(`SELECT board_state FROM rooms WHERE room_id = ?`, roomID).Scan(&boardState); err != nil {
+		return nil, nil, err
+	}
+	var stored struct {
+		Board   []json.RawMessage      `json:"board"`
+		Private map[string]privateZone `json:"private"`
+	}
+	if err := json.Unmarshal([]byte(boardState), &stored); err != nil {
+		return nil, nil, err
+	}
+	type libraryCard struct {
+		raw   json.RawMessage
+		id    string
+		index *float64
+	}
+	var library []libraryCard
+	for _, raw := range append(stored.Board, stored.Private[owner].Board...) {
+		var card struct {
+			boardCardOwner
+			StackIndex *float64 `json:"stackIndex"`
+		}
+		if json.Unmarshal(raw, &card) != nil || card.Zone != "library" || card.OwnerID != owner || card.ID == "" {
+			continue
+		}
+		library = append(library, libraryCard{raw: raw, id: card.ID, index: card.StackIndex})
+	}
+	sort.SliceStable(library, func(i, j int) bool {
+		x, y := library[i], library[j]
+		switch {
+		case x.index != nil && y.index != nil:
+			return *x.index > *y.index
+		case x.index != nil || y.index != nil:
+			return x.index != nil
+		}
+		return x.id < y.id
+	})
+	if len(library) > count {
+		library = library[:count]
+	}
+	cards := make([]json.RawMessage, len(library))
+	ids := make([]string, len(library))
+	for i, card := range library {
+		cards[i], ids[i] = card.raw, card.id
+	}
+	return cards, ids, nil
+}
+
+func (a *App) handleRoomScry(client *WSClient, raw json.RawMessage) {
+	var payload RoomScryPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	if payload.Kind == "" {
+		payload.Kind = scryKindScry
+	}
+	if payload.Kind != scryKindScry && payload.Kind != scryKindSurveil {
+		a.sendError(client.id, codeValidationFailed, "kind must be scry or surveil")
+		return
+	}
+	if payload.Count < 1 || payload.Count > maxScryCards {
+		a.sendError(client.id, codeValidationFailed, fmt.Sprintf("count must be between 1 and %d", maxScryCards))
+		return
+	}
+	cards, ids, err := a.libraryTop(payload.RoomID, member.PlayerName, payload.Count)
+	if err != nil || len(cards) == 0 {
+		a.sendError(client.id, codeValidationFailed, "your library is empty")
+		return
+	}
+	session := &scrySession{
+		ID:      randomID(8),
+		RoomID:  payload.RoomID,
+		Owner:   member.PlayerName,
+		Kind:    payload.Kind,
+		CardIDs: ids,
+		Cards:   make(map[string]json.RawMessage, len(ids)),
+		Expires: time.Now().Add(scryTTL),
+	}
+	for i, id := range ids {
+		session.Cards[id] = cards[i]
+	}
+	a.scries.start(session)
+
+	notice := scryNotice{
+		RoomID:    payload.RoomID,
+		ScryID:    session.ID,
+		Player:    member.PlayerName,
+		Kind:      session.Kind,
+		Count:     len(ids),
+		ExpiresAt: session.Expires.UTC().Format(time.RFC3339),
+	}
+	for _, socketID := range a.rooms.socketIDs(payload.RoomID) {
+		if socketID == client.id {
+			continue
+		}
+		a.send(socketID, WSMessage{Type: "room:scry_started", Payload: marshalPayload(notice)})
+	}
+	notice.Cards = cards
+	a.send(client.id, WSMessage{Type: "room:scry_cards", Payload: marshalPayload(notice)})
+}
+
+func (a *App) handleRoomScryResolve(client *WSClient, raw json.RawMessage) {
+	var payload RoomScryResolvePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	session := a.scries.find(payload.RoomID, member.PlayerName, payload.ScryID)
+	if session == nil {
+		a.sendError(client.id, codeNotFound, "scry not found or expired")
+		return
+	}
+	decision := scryDecision{
+		Kind:      session.Kind,
+		Count:     len(session.CardIDs),
+		Top:       payload.Top,
+		Bottom:    payload.Bottom,
+		Graveyard: payload.Graveyard,
+	}
+	if details := checkScryDecision(session, decision); len(details) > 0 {
+		a.sendErrorDetails(client.id, codeValidationFailed, "invalid scry decision", details)
+		return
+	}
+	if !a.scries.take(session) {
+		a.sendError(client.id, codeNotFound, "scry not found or expired")
+		return
+	}
+	if decision.Top == nil {
+		decision.Top = []string{}
+	}
+	if decision.Bottom == nil {
+		decision.Bottom = []string{}
+	}
+	event := RoomEventPayload{
+		RoomID:     payload.RoomID,
+		EventType:  roomEventScry,
+		EventData:  marshalPayload(decision),
+		PlayerID:   member.PlayerID,
+		PlayerName: member.PlayerName,
+		UserID:     member.UserID,
+	}
+	eventID, err := a.recordRoomEvent(event)
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save event")
+		return
+	}
+
+	// Everyone learns how many cards went where; cards put into the cemetery
+	// are public, so their identities go to the whole room.
+	top, bottom := len(decision.Top), len(decision.Bottom)
+	notice := scryNotice{
+		RoomID:  payload.RoomID,
+		ScryID:  session.ID,
+		Player:  member.PlayerName,
+		Kind:    session.Kind,
+		Count:   decision.Count,
+		EventID: eventID,
+		Top:     &top,
+		Bottom:  &bottom,
+	}
+	for _, id := range decision.Graveyard {
+		notice.Cards = append(notice.Cards, session.Cards[id])
+	}
+	for _, socketID := range a.rooms.socketIDs(payload.RoomID) {
+		if socketID == client.id {
+			continue
+		}
+		a.send(socketID, WSMessage{Type: "room:scry_resolved", Payload: marshalPayload(notice)})
+	}
+	notice.Decision = &decision
+	a.send(client.id, WSMessage{Type: "room:scry_resolved", Payload: marshalPayload(notice)})
+}
+
+// checkScryDecision makes sure every card looked at is placed exactly once
+// and that only a surveil puts cards into the cemetery.
+func checkScryDecision(session *scrySession, decision scryDecision) []string {
+	var details []string
+	if decision.Kind != scryKindSurveil && len(decision.Graveyard) > 0 {
+		details = append(details, "graveyard: only a surveil can put cards into the cemetery")
+	}
+	placed := make(map[string]bool)
+	for _, group := range []struct {
+		name string
+		ids  []string
+	}{{"top", decision.Top}, {"bottom", decision.Bottom}, {"graveyard", decision.Graveyard}} {
+		for i, id := range group.ids {
+			switch {
+			case session.Cards[id] == nil:
+				details = append(details, fmt.Sprintf("%s[%d]: %q was not looked at", group.name, i, id))
+			case placed[id]:
+				details = append(details, fmt.Sprintf("%s[%d]: %q is placed twice", group.name, i, id))
+			}
+			placed[id] = true
+		}
+	}
+	for _, id := range session.CardIDs {
+		if !placed[id] {
+			details = append(details, fmt.Sprintf("%q is not placed", id))
+		}
+	}
+	return details
+}
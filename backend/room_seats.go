@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// maxRoomSeats caps a room's seats, enough for the largest free-for-all
+// Commander pods.
+const maxRoomSeats = 8
+
+// Every player, host included, sits in a numbered seat from 1 to
+// maxRoomSeats. Seats give the table a turn order and let a player address
+// others by position (room:seat_message) instead of relaying everything
+// through the host. The seating is sent to the whole room as room:seats
+// whenever it changes.
+
+type seatInfo struct {
+	Seat       int    `json:"seat"`
+	PlayerID   string `json:"playerId"`
+	PlayerName string `json:"playerName"`
+	SocketID   string `json:"socketId"`
+	Host       bool   `json:"host,omitempty"`
+	Team       int    `json:"team,omitempty"`
+}
+
+type roomSeatsPayload struct {
+	RoomID    string     `json:"roomId"`
+	Seats     []seatInfo `json:"seats"`
+	TurnOrder []int      `json:"turnOrder"`
+	TeamSize  int        `json:"teamSize,omitempty"`
+}
+
+// seatTarget picks the seats a room:seat_message goes to: the listed seats,
+// every seat but OpponentsOf, or everyone else when both are empty.
+type seatTarget struct {
+	Seats       []int `json:"seats"`
+	OpponentsOf int   `json:"opponentsOf"`
+}
+
+type RoomSeatPayload struct {
+	RoomID  string      `json:"roomId"`
+	Seat    int         `json:"seat"`
+	Order   []int       `json:"order"`
+	To      seatTarget  `json:"to"`
+	Message interface{} `json:"message"`
+}
+
+func (room *RoomState) host() ClientInfo {
+	return ClientInfo{PlayerID: room.HostPlayerID, PlayerName: room.HostPlayerName, UserID: room.HostUserID, Seat: room.HostSeat, Team: room.team(room.HostSeat)}
+}
+
+func (room *RoomState) seatTaken(seat int) bool {
+	if room.HostSeat == seat {
+		return true
+	}
+	for _, info := range room.Clients {
+		if info.Seat == seat {
+			return true
+		}
+	}
+	return false
+}
+
+// claimSeat checks a requested seat, or finds the lowest free one when none
+// is asked for. Called with the registry lock held.
+func (room *RoomState) claimSeat(seat int) (int, error) {
+	if seat != 0 {
+		if seat < 1 || seat > maxRoomSeats || room.seatTaken(seat) {
+			return 0, errSeatTaken
+		}
+		return seat, nil
+	}
+	for seat = 1; seat <= maxRoomSeats; seat++ {
+		if !room.seatTaken(seat) {
+			return seat, nil
+		}
+	}
+	return 0, errRoomFull
+}
+
+// seating lists the occupied seats in turn order. Called with the registry
+// lock held.
+func (room *RoomState) seating() []seatInfo {
+	seats := []seatInfo{{
+		Seat:       room.HostSeat,
+		PlayerID:   room.HostPlayerID,
+		PlayerName: room.HostPlayerName,
+		SocketID:   room.HostSocketID,
+		Host:       true,
+		Team:       room.team(room.HostSeat),
+	}}
+	for socketID, info := range room.Clients {
+		seats = append(seats, seatInfo{Seat: info.Seat, PlayerID: info.PlayerID, PlayerName: info.PlayerName, SocketID: socketID, Team: room.team(info.Seat)})
+	}
+	// A seat's place is its place in TurnOrder; seats left out follow in
+	// seat order. Teams take their turns together, so in a team game the
+	// team's earliest place counts for all its seats.
+	unit := func(seat int) int {
+		if room.TeamSize > 0 {
+			return room.team(seat)
+		}
+		return seat
+	}
+	place := make(map[int]int, len(room.TurnOrder))
+	for i, seat := range room.TurnOrder {
+		if _, seen := place[unit(seat)]; !seen {
+			place[unit(seat)] = i
+		}
+	}
+	placeOf := func(seat int) int {
+		if p, ok := place[unit(seat)]; ok {
+			return p
+		}
+		return len(room.TurnOrder) + unit(seat)
+	}
+	sort.Slice(seats, func(i, j int) bool {
+		if pi, pj := placeOf(seats[i].Seat), placeOf(seats[j].Seat); pi != pj {
+			return pi < pj
+		}
+		return seats[i].Seat < seats[j].Seat
+	})
+	return seats
+}
+
+// Seating returns the room's players in turn order.
+func (r *RoomRegistry) Seating(roomID string) []seatInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return nil
+	}
+	return room.seating()
+}
+
+// ChangeSeat moves a player to a free seat.
+func (r *RoomRegistry) ChangeSeat(roomID string, socketID string, seat int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return errRoomNotFound
+	}
+	if seat < 1 || seat > maxRoomSeats || room.seatTaken(seat) {
+		return errSeatTaken
+	}
+	var previous int
+	if room.HostSocketID == socketID {
+		previous, room.HostSeat = room.HostSeat, seat
+	} else {
+		info, ok := room.Clients[socketID]
+		if !ok {
+			return errRoomNotFound
+		}
+		previous, info.Seat = info.Seat, seat
+		room.Clients[socketID] = info
+	}
+	// The player keeps their place in the turn order.
+	for i, turn := range room.TurnOrder {
+		if turn == previous {
+			room.TurnOrder[i] = seat
+		}
+	}
+	return nil
+}
+
+// SetTurnOrder replaces the turn order. It must name every occupied seat
+// exactly once.
+func (r *RoomRegistry) SetTurnOrder(roomID string, order []int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room := r.rooms[roomID]
+	if room == nil {
+		return false
+	}
+	occupied := room.seating()
+	if len(order) != len(occupied) {
+		return false
+	}
+	seen := make(map[int]bool, len(order))
+	for _, seat := range order {
+		if seen[seat] || !room.seatTaken(seat) {
+			return false
+		}
+		seen[seat] = true
+	}
+	room.TurnOrder = append([]int(nil), order...)
+	return true
+}
+
+// broadcastSeats sends the seating to everyone in the room through send, so
+// leaveRoom can use it with its own sender.
+func (a *App) broadcastSeats(roomID string, send func(string, WSMessage)) {
+	seats := a.rooms.Seating(roomID)
+	if seats == nil {
+		return
+	}
+	order := make([]int, len(seats))
+	for i, seat := range seats {
+		order[i] = seat.Seat
+	}
+	message := WSMessage{
+		Type:    "room:seats",
+		Payload: marshalPayload(roomSeatsPayload{RoomID: roomID, Seats: seats, TurnOrder: order, TeamSize: a.rooms.TeamSize(roomID)}),
+	}
+	for _, seat := range seats {
+		send(seat.SocketID, message)
+	}
+}
+
+func (a *App) handleSeatMessage(client *WSClient, messageType string, raw json.RawMessage) {
+	var payload RoomSeatPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	switch messageType {
+	case "room:seat_change":
+		if err := a.rooms.ChangeSeat(payload.RoomID, client.id, payload.Seat); err != nil {
+			a.sendError(client.id, roomErrorCode(err), err.Error())
+			return
+		}
+		a.bus.publishRoom(payload.RoomID)
+		a.broadcastSeats(payload.RoomID, a.send)
+	case "room:turn_order":
+		if a.rooms.HostSocket(payload.RoomID) != client.id {
+			a.sendError(client.id, codeForbidden, "only the host can set the turn order")
+			return
+		}
+		if !a.rooms.SetTurnOrder(payload.RoomID, payload.Order) {
+			a.sendError(client.id, codeValidationFailed, "order must list every occupied seat once")
+			return
+		}
+		a.bus.publishRoom(payload.RoomID)
+		a.broadcastSeats(payload.RoomID, a.send)
+	case "room:seat_message":
+		var targets []string
+		for _, seat := range a.rooms.Seating(payload.RoomID) {
+			if seat.SocketID == client.id {
+				continue
+			}
+			switch {
+			case len(payload.To.Seats) > 0:
+				if !containsInt(payload.To.Seats, seat.Seat) {
+					continue
+				}
+			case payload.To.OpponentsOf != 0:
+				if seat.Seat == payload.To.OpponentsOf {
+					continue
+				}
+			}
+			targets = append(targets, seat.SocketID)
+		}
+		a.broadcastToRoom(payload.RoomID, targets, WSMessage{
+			Type: "room:seat_message",
+			Payload: marshalPayload(map[string]interface{}{
+				"roomId":     payload.RoomID,
+				"fromSeat":   member.Seat,
+				"playerId":   member.PlayerID,
+				"playerName": member.PlayerName,
+				"message":    payload.Message,
+			}),
+		})
+	}
+}
+
+func containsInt(values []int, target int) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// roomCompactionConfig controls the background job that snapshots busy rooms
+// and prunes the events the snapshot covers.
+type roomCompactionConfig struct {
+	interval  time.Duration
+	threshold int
+}
+
+func loadRoomCompactionConfig() roomCompactionConfig {
+	return roomCompactionConfig{
+		interval:  time.Duration(envInt("ROOM_SNAPSHOT_INTERVAL_SECONDS", 300)) * time.Second,
+		threshold: envInt("ROOM_SNAPSHOT_EVENT_THRESHOLD", 500),
+	}
+}
+
+type roomSnapshot struct {
+	ID          int64           `json:"id"`
+	State       json.RawMessage `json:"state"`
+	Version     int64           `json:"version"`
+	LastEventID int64           `json:"lastEventId"`
+	CreatedAt   string          `json:"createdAt"`
+}
+
+func (a *App) runRoomCompaction(config roomCompactionConfig) {
+	if config.interval <= 0 || config.threshold <= 0 {
+		log.Printf("[rooms] snapshot compaction disabled")
+		return
+	}
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := a.compactRooms(config.threshold); err != nil {
+			log.Printf("[rooms] compaction failed: %v", err)
+		}
+	}
+}
+
+// compactRooms snapshots every room holding at least threshold events. Each
+// snapshot prunes the events it covers, so the count left in room_events is
+// the number recorded since the previous snapshot. Rooms with audited games
+// keep their whole log to be verified and are left alone.
+func (a *App) compactRooms(threshold int) error {
+	rows, err := a.db.Query(`
+		SELECT room_id FROM room_events
+		WHERE room_id NOT IN (SELECT room_id FROM room_audits)
+		GROUP BY room_id
+		HAVING COUNT(*) >= ?
+	`, threshold)
+	if err != nil {
+		return err
+	}
+	var roomIDs []string
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err == nil {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	rows.Close()
+	for _, roomID := range roomIDs {
+		if _, err := a.snapshotRoom(roomID); err != nil {
+			log.Printf("[rooms] snapshot of %s failed: %v", roomID, err)
+		}
+	}
+	return nil
+}
+
+// snapshotRoom records the stored room state as covering every event logged
+// so far, then deletes those events unless the room has audited games. It
+// reports false when there was nothing new to snapshot.
+func (a *App) snapshotRoom(roomID string) (bool, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var state string
+	var version int64
+	if err := tx.QueryRow(`SELECT board_state, version FROM rooms WHERE room_id = ?`, roomID).Scan(&state, &version); err != nil {
+		return false, err
+	}
+	var lastEventID sql.NullInt64
+	if err := tx.QueryRow(`SELECT MAX(id) FROM room_events WHERE room_id = ?`, roomID).Scan(&lastEventID); err != nil {
+		return false, err
+	}
+	if !lastEventID.Valid {
+		return false, nil
+	}
+	if state == "{}" {
+		defaultState, _ := json.Marshal(defaultRoomState())
+		state = string(defaultState)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO room_snapshots (room_id, state, version, last_event_id)
+		VALUES (?, ?, ?, ?)
+	`, roomID, state, version, lastEventID.Int64); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM room_events
+		WHERE room_id = ? AND id <= ? AND NOT EXISTS (SELECT 1 FROM room_audits WHERE room_id = ?)
+	`, roomID, lastEventID.Int64, roomID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// roomSnapshotAt returns the newest snapshot taken at or before until, a
+// SQLite timestamp. An empty until means the newest snapshot overall.
+func (a *App) roomSnapshotAt(ctx context.Context, roomID string, until string) (*roomSnapshot, error) {
+	query := `
+		SELECT id, state, version, last_event_id, created_at
+		FROM room_snapshots
+		WHERE room_id = ?`
+	args := []interface{}{roomID}
+	if until != "" {
+		query += ` AND created_at <= ?`
+		args = append(args, until)
+	}
+	var snapshot roomSnapshot
+	var state string
+	err := a.db.QueryRowContext(ctx, query+`
+		ORDER BY id DESC
+		LIMIT 1
+	`, args...).Scan(&snapshot.ID, &state, &snapshot.Version, &snapshot.LastEventID, &snapshot.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot.State = json.RawMessage(state)
+	return &snapshot, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxStackItems          = 100
+	maxStackDescriptionLen = 200
+)
+
+// stackTracker is the optional server-managed stack. Groups that want it
+// push spells and abilities with room:stack_push and pass priority with
+// room:priority_pass; the server tracks whose priority it is and who has
+// passed in succession, and every change is sent to the whole room as
+// room:stack. Rooms that never push or pass have no entry. Like reveals,
+// the stack lives on the instance that received the messages.
+type stackTracker struct {
+	mu     sync.Mutex
+	stacks map[string]*roomStack
+	nextID int64
+}
+
+type roomStack struct {
+	Items []stackItem
+	// Order is the room's turn order by seat, as player names.
+	Order []string
+	// Teams maps player names to their team in a team game; teammates
+	// hold and pass priority together.
+	Teams    map[string]int
+	Active   string
+	Priority string
+	Passed   []string
+	// TurnStarted is when Active became the active player.
+	TurnStarted time.Time
+	// Times are the players' times as the active player this game. See
+	// room_game_times.go.
+	Times map[string]*playerTime
+	// Draws counts each player's draws since their turn started. See
+	// room_hand_limit.go.
+	Draws map[string]int
+}
+
+type stackItem struct {
+	ID          int64           `json:"id"`
+	Controller  string          `json:"controller"`
+	Description string          `json:"description"`
+	Card        json.RawMessage `json:"card,omitempty"`
+}
+
+type stackView struct {
+	RoomID    string      `json:"roomId"`
+	Items     []stackItem `json:"items"`
+	Order     []string    `json:"order"`
+	Active    string      `json:"active"`
+	Priority  string      `json:"priority"`
+	Passed    []string    `json:"passed"`
+	AllPassed bool        `json:"allPassed"`
+}
+
+type RoomStackPayload struct {
+	RoomID      string          `json:"roomId"`
+	Description string          `json:"description"`
+	Card        json.RawMessage `json:"card"`
+	Player      string          `json:"player"`
+}
+
+func newStackTracker() *stackTracker {
+	return &stackTracker{stacks: make(map[string]*roomStack)}
+}
+
+// forget drops a closed room's stack.
+func (t *stackTracker) forget(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.stacks, roomID)
+}
+
+// roomStack returns the room's stack, creating it on first use. Called with
+// a.stacks.mu held.
+func (a *App) roomStack(roomID string) *roomStack {
+	stack := a.stacks.stacks[roomID]
+	if stack == nil {
+		stack = &roomStack{}
+		a.stacks.stacks[roomID] = stack
+	}
+	a.seatStack(roomID, stack)
+	return stack
+}
+
+// seatStack brings the turn order up to date with the room's seating.
+func (a *App) seatStack(roomID string, stack *roomStack) {
+	seated := make(map[string]bool)
+	stack.Order = stack.Order[:0]
+	stack.Teams = make(map[string]int)
+	for _, seat := range a.rooms.Seating(roomID) {
+		if !seated[seat.PlayerName] {
+			seated[seat.PlayerName] = true
+			stack.Order = append(stack.Order, seat.PlayerName)
+		}
+		if seat.Team != 0 {
+			stack.Teams[seat.PlayerName] = seat.Team
+		}
+	}
+	passed := stack.Passed[:0]
+	for _, name := range stack.Passed {
+		if seated[name] {
+			passed = append(passed, name)
+		}
+	}
+	stack.Passed = passed
+	if !seated[stack.Active] && len(stack.Order) > 0 {
+		now := time.Now()
+		stack.endTurn(now)
+		stack.Active, stack.TurnStarted = stack.Order[0], now
+	}
+	if !seated[stack.Priority] {
+		stack.Priority = stack.Active
+	}
+}
+
+func (s *roomStack) view(roomID string) stackView {
+	return stackView{
+		RoomID:    roomID,
+		Items:     append([]stackItem{}, s.Items...),
+		Order:     append([]string{}, s.Order...),
+		Active:    s.Active,
+		Priority:  s.Priority,
+		Passed:    append([]string{}, s.Passed...),
+		AllPassed: s.allPassed(),
+	}
+}
+
+func (s *roomStack) allPassed() bool {
+	return len(s.Order) > 0 && len(s.Passed) >= len(s.Order)
+}
+
+// startTurn makes a player active and gives them priority.
+func (s *roomStack) startTurn(player string) {
+	now := time.Now()
+	s.endTurn(now)
+	s.Active, s.TurnStarted = player, now
+	delete(s.Draws, player)
+	s.givePriority(player)
+}
+
+// givePriority hands priority to a player and starts a new round of passes.
+func (s *roomStack) givePriority(player string) {
+	s.Priority = player
+	s.Passed = nil
+}
+
+func (a *App) handleStackMessage(client *WSClient, messageType string, raw json.RawMessage) {
+	var payload RoomStackPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	isHost := a.rooms.HostSocket(payload.RoomID) == client.id
+	logActor, logLine := member.PlayerName, ""
+
+	if messageType == "room:priority_set" {
+		// The hand limit is checked before the turn moves on, so a blocked
+		// player keeps the turn.
+		a.stacks.mu.Lock()
+		stack := a.roomStack(payload.RoomID)
+		ending := stack.Active
+		mayEnd := member.PlayerName == ending || stack.teammates(member.PlayerName, ending) || isHost
+		a.stacks.mu.Unlock()
+		if mayEnd && ending != payload.Player {
+			if warning, blocked := a.checkHandLimit(payload.RoomID, ending, isHost && member.PlayerName != ending); blocked {
+				a.sendErrorDetails(client.id, codeValidationFailed, ending+" must discard down to "+strconv.Itoa(warning.MaxHandSize)+" cards first", warning)
+				return
+			}
+		}
+	}
+
+	a.stacks.mu.Lock()
+	stack := a.roomStack(payload.RoomID)
+	var problem string
+	switch messageType {
+	case "room:stack_push":
+		description := strings.TrimSpace(payload.Description)
+		var card struct {
+			Name string `json:"name"`
+		}
+		if len(payload.Card) > 0 && string(payload.Card) != "null" {
+			if err := json.Unmarshal(payload.Card, &card); err != nil {
+				problem = "card must be an object"
+				break
+			}
+		} else {
+			payload.Card = nil
+		}
+		if description == "" {
+			description = card.Name
+		}
+		switch {
+		case description == "":
+			problem = "description or card is required"
+		case len(description) > maxStackDescriptionLen:
+			problem = "description is too long"
+		case len(stack.Items) >= maxStackItems:
+			problem = "the stack is full"
+		}
+		if problem != "" {
+			break
+		}
+		a.stacks.nextID++
+		stack.Items = append(stack.Items, stackItem{
+			ID:          a.stacks.nextID,
+			Controller:  member.PlayerName,
+			Description: description,
+			Card:        payload.Card,
+		})
+		// The player who put something on the stack receives priority.
+		stack.givePriority(member.PlayerName)
+		logLine = member.PlayerName + " puts " + description + " on the stack"
+	case "room:priority_pass":
+		if !stack.holdsPriority(member.PlayerName) {
+			problem = "you do not have priority"
+			break
+		}
+		for _, name := range stack.Order {
+			if (name == stack.Priority || stack.teammates(name, stack.Priority)) && !containsString(stack.Passed, name) {
+				stack.Passed = append(stack.Passed, name)
+			}
+		}
+		if !stack.allPassed() {
+			stack.Priority = stack.nextPriority()
+		}
+	case "room:stack_resolve":
+		// Once everyone has passed the top item resolves; the host can
+		// resolve it at any time to settle disputes.
+		switch {
+		case len(stack.Items) == 0:
+			problem = "the stack is empty"
+		case !stack.allPassed() && !isHost:
+			problem = "every player must pass priority first"
+		}
+		if problem != "" {
+			break
+		}
+		top := stack.Items[len(stack.Items)-1]
+		stack.Items = stack.Items[:len(stack.Items)-1]
+		stack.givePriority(stack.Active)
+		logActor, logLine = top.Controller, top.Description+" resolves"
+	case "room:priority_set":
+		// Starting a turn: the active player, or the host, names the next
+		// active player, who receives priority.
+		switch {
+		case member.PlayerName != stack.Active && !stack.teammates(member.PlayerName, stack.Active) && !isHost:
+			problem = "only the active player or the host can change the active player"
+		case !containsString(stack.Order, payload.Player):
+			problem = "player is not in the room"
+		}
+		if problem != "" {
+			break
+		}
+		if payload.Player != stack.Active {
+			logActor, logLine = payload.Player, turnLogLine(payload.Player)
+		}
+		stack.startTurn(payload.Player)
+	}
+	view := stack.view(payload.RoomID)
+	a.stacks.mu.Unlock()
+
+	if problem != "" {
+		a.sendError(client.id, codeValidationFailed, problem)
+		return
+	}
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:stack",
+		Payload: marshalPayload(view),
+	})
+	if logLine != "" {
+		a.logRoomLine(payload.RoomID, logActor, logLine)
+	}
+}
+
+func (s *roomStack) teammates(a string, b string) bool {
+	return s.Teams[a] != 0 && s.Teams[a] == s.Teams[b]
+}
+
+// holdsPriority reports whether the player has priority, directly or through
+// a teammate.
+func (s *roomStack) holdsPriority(player string) bool {
+	return player == s.Priority || s.teammates(player, s.Priority)
+}
+
+// nextPriority returns the first player after the current holder, skipping
+// the holder's teammates.
+func (s *roomStack) nextPriority() string {
+	start := 0
+	for i, name := range s.Order {
+		if name == s.Priority {
+			start = i
+			break
+		}
+	}
+	for step := 1; step <= len(s.Order); step++ {
+		name := s.Order[(start+step)%len(s.Order)]
+		if name != s.Priority && !s.teammates(name, s.Priority) {
+			return name
+		}
+	}
+	return s.Priority
+}
+
+// nextActive returns the first player after the active one who is not the
+// active player's teammate, with whom the turn is shared, and not skipped.
+// It returns the active player when there is no one else.
+func (s *roomStack) nextActive(skip func(string) bool) string {
+	start := 0
+	for i, name := range s.Order {
+		if name == s.Active {
+			start = i
+			break
+		}
+	}
+	for step := 1; step <= len(s.Order); step++ {
+		name := s.Order[(start+step)%len(s.Order)]
+		if name != s.Active && !s.teammates(name, s.Active) && !skip(name) {
+			return name
+		}
+	}
+	return s.Active
+}
+
+// handleRoomStack returns the room's stack and priority, so players who join
+// mid-game can catch up.
+func (a *App) handleRoomStack(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	a.stacks.mu.Lock()
+	stack := a.stacks.stacks[roomID]
+	if stack == nil {
+		stack = &roomStack{}
+	}
+	a.seatStack(roomID, stack)
+	view := stack.view(roomID)
+	a.stacks.mu.Unlock()
+	writeJSON(w, http.StatusOK, view)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newRoomStateTestApp opens a fresh database and a room hosted by Alice that
+// Bob has joined, and returns the room's state routes with both players'
+// room tokens.
+func newRoomStateTestApp(t *testing.T) (*App, http.Handler, map[string]string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := runMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		db:         db,
+		rooms:      NewRoomRegistry(),
+		roomTokens: loadRoomTokenSigner(),
+		bodyLimits: loadBodyLimits(),
+	}
+	if err := a.rooms.Create("r1", RoomCreatePayload{RoomID: "r1", PlayerID: "pa", PlayerName: "Alice"}, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.rooms.Join("r1", RoomJoinPayload{RoomID: "r1", PlayerID: "pb", PlayerName: "Bob"}, "s2"); err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	for _, socketID := range []string{"s1", "s2"} {
+		member, _ := a.rooms.Member("r1", socketID)
+		tokens[member.PlayerName] = a.roomTokens.issue("r1", member)
+	}
+	router := chi.NewRouter()
+	router.Post("/rooms/{roomId}/state", a.requireRoomToken(a.handleSaveRoomState))
+	router.Patch("/rooms/{roomId}/state", a.requireRoomToken(a.handlePatchRoomState))
+	return a, router, tokens
+}
+
+func roomStateRequest(t *testing.T, router http.Handler, method string, token string, contentType string, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, "/rooms/r1/state", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(roomTokenHeader, token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func storedPrivateZones(t *testing.T, a *App) map[string]privateZone {
+	t.Helper()
+	var stateJSON string
+	if err := a.db.QueryRow(`SELECT board_state FROM rooms WHERE room_id = 'r1'`).Scan(&stateJSON); err != nil {
+		t.Fatal(err)
+	}
+	var state struct {
+		Private map[string]privateZone `json:"private"`
+	}
+	if err := json.Unmarshal([]byte(stateJSON), &state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Private
+}
+
+func TestSaveRoomStateOnlyFromHost(t *testing.T) {
+	a, router, tokens := newRoomStateTestApp(t)
+	hand := `{"board":[{"id":"a1","ownerId":"Alice","zone":"hand"},{"id":"b1","ownerId":"Bob","zone":"hand"}]}`
+	if code := roomStateRequest(t, router, http.MethodPost, tokens["Alice"], "application/json", hand); code != http.StatusOK {
+		t.Fatalf("host save: got %d, want 200", code)
+	}
+
+	wipe := `{"board":[{"id":"b1","ownerId":"Bob","zone":"hand"}],"private":{"Alice":{"board":[]}}}`
+	if code := roomStateRequest(t, router, http.MethodPost, tokens["Bob"], "application/json", wipe); code != http.StatusForbidden {
+		t.Fatalf("player save: got %d, want 403", code)
+	}
+	if zone := storedPrivateZones(t, a)["Alice"]; len(zone.Board) != 1 {
+		t.Fatalf("Alice's hand was changed: %d cards", len(zone.Board))
+	}
+}
+
+func TestPatchRoomStateKeepsOthersPrivateZones(t *testing.T) {
+	a, router, tokens := newRoomStateTestApp(t)
+	hand := `{"board":[{"id":"a1","ownerId":"Alice","zone":"hand"},{"id":"b1","ownerId":"Bob","zone":"hand"}]}`
+	if code := roomStateRequest(t, router, http.MethodPost, tokens["Alice"], "application/json", hand); code != http.StatusOK {
+		t.Fatalf("host save: got %d, want 200", code)
+	}
+
+	for _, patch := range []string{
+		`[{"op":"remove","path":"/private/Alice/board/0"}]`,
+		`[{"op":"test","path":"/private/Alice/board/0/id","value":"a1"}]`,
+		`[{"op":"add","path":"/board/-","value":{"id":"x","ownerId":"Alice","zone":"hand"}}]`,
+	} {
+		if code := roomStateRequest(t, router, http.MethodPatch, tokens["Bob"], jsonPatchContentType, patch); code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", patch, code)
+		}
+	}
+	if code := roomStateRequest(t, router, http.MethodPatch, tokens["Bob"], jsonPatchContentType, `[{"op":"remove","path":"/private/Bob/board/0"}]`); code != http.StatusOK {
+		t.Fatalf("own patch: got %d, want 200", code)
+	}
+	zones := storedPrivateZones(t, a)
+	if len(zones["Alice"].Board) != 1 || len(zones["Bob"].Board) != 0 {
+		t.Fatalf("unexpected private zones: %+v", zones)
+	}
+}
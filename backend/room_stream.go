@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	streamTokenHeader   = "X-Stream-Token"
+	streamBuffer        = 256
+	streamKeepAlive     = 20 * time.Second
+	streamOpenEventType = "stream:open"
+)
+
+// roomStream is the Server-Sent Events fallback for networks that block
+// WebSockets. GET /rooms/{roomId}/stream registers an ordinary client whose
+// messages are written as SSE events instead of socket frames, so rooms,
+// presence and the room bus treat it like any other socket. The first event
+// carries its socketId and a streamToken; the client sends what it would
+// have written to the socket as POST /rooms/{roomId}/stream with the token
+// in X-Stream-Token. Sends must reach the instance holding the stream, so
+// multi-instance deployments need sticky sessions for this path.
+type roomStream struct {
+	roomID string
+	secret string
+	events chan []byte
+	// overflow is closed when the client falls too far behind; the stream
+	// is then closed rather than silently skipping room messages.
+	overflow chan struct{}
+	closed   bool
+	// handling serializes sends, which a socket's read loop does for free.
+	handling sync.Mutex
+}
+
+// push queues an event, called with the client's mu held.
+func (s *roomStream) push(data []byte) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- data:
+	default:
+		s.closed = true
+		close(s.overflow)
+	}
+}
+
+func (a *App) handleRoomStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Streaming is not supported")
+		return
+	}
+	user, err := a.socketUser(w, r)
+	if err != nil {
+		return
+	}
+	// The server's read and write timeouts are meant for ordinary responses.
+	controller := http.NewResponseController(w)
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	stream := &roomStream{
+		roomID:   chi.URLParam(r, "roomId"),
+		secret:   randomID(16),
+		events:   make(chan []byte, streamBuffer),
+		overflow: make(chan struct{}),
+	}
+	client := &WSClient{id: randomID(8), stream: stream}
+	if user != nil {
+		client.userID = user.ID
+		client.username = user.Username
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	a.registerClient(client)
+	defer a.unregisterClient(client)
+	a.send(client.id, WSMessage{
+		Type: streamOpenEventType,
+		Payload: marshalPayload(map[string]string{
+			"socketId":    client.id,
+			"streamToken": client.id + "." + stream.secret,
+		}),
+	})
+	a.greetDuringMaintenance(client)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.overflow:
+			log.Printf("[stream] closing %s: client fell behind", client.id)
+			return
+		case data := <-stream.events:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// handleRoomStreamSend accepts one message from a stream client, exactly as
+// if it had arrived on a WebSocket; replies and errors arrive on the stream.
+func (a *App) handleRoomStreamSend(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+	client := a.streamClient(r.Header.Get(streamTokenHeader), roomID)
+	if client == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Stream not found; open a new one")
+		return
+	}
+	var message WSMessage
+	if err := decodeJSON(r, &message); err != nil {
+		writeBodyError(w, err, "invalid message")
+		return
+	}
+	if message.Type == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidMessage, "type is required")
+		return
+	}
+	// A stream is opened for one room, so its messages may not name another.
+	var target struct {
+		RoomID string `json:"roomId"`
+	}
+	_ = json.Unmarshal(message.Payload, &target)
+	if target.RoomID != "" && target.RoomID != roomID {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "roomId does not match the stream's room")
+		return
+	}
+	client.stream.handling.Lock()
+	span := a.traceWSMessage(client, message)
+	a.handleWSMessage(client, message)
+	span.End()
+	client.stream.handling.Unlock()
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// streamClient finds the stream a token was issued for. Tokens are
+// "<socketId>.<secret>": the socket id is shared with the rest of the room,
+// the secret only with the stream's own client.
+func (a *App) streamClient(token string, roomID string) *WSClient {
+	socketID, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil
+	}
+	a.clientsMu.RLock()
+	client := a.clients[socketID]
+	a.clientsMu.RUnlock()
+	if client == nil || client.stream == nil || client.stream.roomID != roomID {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(client.stream.secret)) != 1 {
+		return nil
+	}
+	return client
+}
+
+// isRoomStream reports whether a request opens a room stream, which like the
+// WebSocket is long-lived and must not be buffered or given a deadline.
+func isRoomStream(r *http.Request) bool {
+	path := apiRoute(r)
+	return r.Method == http.MethodGet && strings.HasPrefix(path, "/rooms/") && strings.HasSuffix(path, "/stream")
+}
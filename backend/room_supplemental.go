@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	supplementalPlanechase = "planechase"
+	supplementalArchenemy  = "archenemy"
+	maxSupplementalDeck    = 200
+)
+
+// Planechase and Archenemy are played with a shared supplemental deck of
+// plane or scheme cards, imported from Scryfall like any other card with
+// layout planar or scheme. The host builds a deck with
+// room:supplemental_deck; room:planeswalk then moves to the next plane and
+// room:scheme sets the next scheme in motion. The decks, the current plane and
+// the schemes in play are kept by the server under supplemental in the stored
+// state, and every change is sent to the whole room as room:supplemental.
+// Only admins see the order of the cards left in a deck.
+
+// supplementalLayouts maps a supplemental deck kind to its Scryfall layout.
+var supplementalLayouts = map[string]string{
+	supplementalPlanechase: "planar",
+	supplementalArchenemy:  "scheme",
+}
+
+type supplementalCard struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	TypeLine   *string `json:"typeLine"`
+	OracleText *string `json:"oracleText"`
+	ImageURL   *string `json:"imageUrl,omitempty"`
+}
+
+// ongoing reports whether a scheme stays in play after it is set in motion.
+func (c supplementalCard) ongoing() bool {
+	return c.TypeLine != nil && strings.Contains(strings.ToLower(*c.TypeLine), "ongoing")
+}
+
+type supplementalDeck struct {
+	Deck      []supplementalCard `json:"deck,omitempty"`
+	DeckCount int                `json:"deckCount"`
+	Current   *supplementalCard  `json:"current"`
+	// Ongoing holds the ongoing schemes in play until they are abandoned.
+	Ongoing   []supplementalCard `json:"ongoing,omitempty"`
+	Archenemy string             `json:"archenemy,omitempty"`
+}
+
+// supplementalState is stored under supplemental, one deck per kind.
+type supplementalState struct {
+	Planechase *supplementalDeck `json:"planechase,omitempty"`
+	Archenemy  *supplementalDeck `json:"archenemy,omitempty"`
+}
+
+type roomSupplementalMessage struct {
+	RoomID  string `json:"roomId"`
+	Version int64  `json:"version"`
+	supplementalState
+}
+
+type RoomSupplementalPayload struct {
+	RoomID    string   `json:"roomId"`
+	Kind      string   `json:"kind"`
+	Cards     []string `json:"cards"`
+	Archenemy string   `json:"archenemy"`
+	Abandon   string   `json:"abandon"`
+}
+
+func (s *supplementalState) deck(kind string) **supplementalDeck {
+	if kind == supplementalArchenemy {
+		return &s.Archenemy
+	}
+	return &s.Planechase
+}
+
+// hidden returns the state with the undrawn cards left out.
+func (s supplementalState) hidden() supplementalState {
+	for _, deck := range []**supplementalDeck{&s.Planechase, &s.Archenemy} {
+		if *deck != nil {
+			copied := **deck
+			copied.Deck = nil
+			*deck = &copied
+		}
+	}
+	return s
+}
+
+// hideSupplementalDecks removes the order of the undrawn supplemental cards
+// from a stored state map, reporting whether there were any.
+func hideSupplementalDecks(state map[string]json.RawMessage) bool {
+	raw, ok := state["supplemental"]
+	if !ok {
+		return false
+	}
+	var supplemental supplementalState
+	if err := json.Unmarshal(raw, &supplemental); err != nil {
+		return false
+	}
+	state["supplemental"] = marshalPayload(supplemental.hidden())
+	return true
+}
+
+// supplementalCards loads the plane or scheme cards for a deck, one printing
+// per name. With no names it loads every card of the kind.
+func (a *App) supplementalCards(ctx context.Context, kind string, names []string) ([]supplementalCard, error) {
+	query := `
+		SELECT id, name, type_line, oracle_text, image_url
+		FROM cards
+		WHERE layout = ?`
+	args := []interface{}{supplementalLayouts[kind]}
+	if len(names) > 0 {
+		placeholders := make([]string, len(names))
+		for i, name := range names {
+			placeholders[i] = "?"
+			args = append(args, normalizeCardName(name))
+		}
+		query += ` AND name_normalized IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	query += ` GROUP BY name_normalized ORDER BY name_normalized`
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cards := []supplementalCard{}
+	for rows.Next() {
+		var card supplementalCard
+		var typeLine, oracleText, imageURL sql.NullString
+		if err := rows.Scan(&card.ID, &card.Name, &typeLine, &oracleText, &imageURL); err != nil {
+			return nil, err
+		}
+		card.TypeLine = nullStringToPtr(typeLine)
+		card.OracleText = nullStringToPtr(oracleText)
+		card.ImageURL = nullStringToPtr(imageURL)
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// handleSupplementalCards lists the plane or scheme cards a deck can be built
+// from.
+func (a *App) handleSupplementalCards(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if _, ok := supplementalLayouts[kind]; !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "kind must be planechase or archenemy")
+		return
+	}
+	if !a.ensureCardsAvailable() {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Cards data not loaded. Ensure cards.json is available and restart the Go backend.")
+		return
+	}
+	cards, err := a.supplementalCards(r.Context(), kind, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch cards")
+		return
+	}
+	writeJSON(w, http.StatusOK, cards)
+}
+
+func (a *App) handleSupplementalMessage(client *WSClient, messageType string, raw json.RawMessage) {
+	var payload RoomSupplementalPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	isHost := a.rooms.HostSocket(payload.RoomID) == client.id
+	ctx := context.Background()
+
+	var cards []supplementalCard
+	switch messageType {
+	case "room:supplemental_deck":
+		if _, ok := supplementalLayouts[payload.Kind]; !ok {
+			a.sendError(client.id, codeValidationFailed, "kind must be planechase or archenemy")
+			return
+		}
+		if !isHost {
+			a.sendError(client.id, codeForbidden, "only the host can set up a supplemental deck")
+			return
+		}
+		if len(payload.Cards) > maxSupplementalDeck {
+			a.sendError(client.id, codeValidationFailed, "a supplemental deck holds at most "+strconv.Itoa(maxSupplementalDeck)+" cards")
+			return
+		}
+		if payload.Kind == supplementalArchenemy {
+			if payload.Archenemy == "" {
+				payload.Archenemy = member.PlayerName
+			}
+			if _, ok := a.roomPlayers(payload.RoomID)[payload.Archenemy]; !ok {
+				a.sendError(client.id, codeValidationFailed, "archenemy is not in the room")
+				return
+			}
+		}
+		var err error
+		if cards, err = a.supplementalCards(ctx, payload.Kind, payload.Cards); err != nil {
+			a.sendError(client.id, codeInternal, "failed to load cards")
+			return
+		}
+		if details := missingSupplementalCards(payload.Cards, cards); len(details) > 0 {
+			a.sendErrorDetails(client.id, codeValidationFailed, "unknown "+supplementalLayouts[payload.Kind]+" cards", details)
+			return
+		}
+		if len(cards) == 0 {
+			a.sendError(client.id, codeValidationFailed, "no "+supplementalLayouts[payload.Kind]+" cards are loaded")
+			return
+		}
+		shuffleWithSeed(cards, randomSeed())
+	case "room:planeswalk":
+		payload.Kind = supplementalPlanechase
+	case "room:scheme":
+		payload.Kind = supplementalArchenemy
+	}
+
+	var logLine string
+	var problem error
+	version, state, err := a.updateRoomState(ctx, payload.RoomID, func(state map[string]json.RawMessage) error {
+		var supplemental supplementalState
+		_ = json.Unmarshal(state["supplemental"], &supplemental)
+		slot := supplemental.deck(payload.Kind)
+		if messageType == "room:supplemental_deck" {
+			*slot = &supplementalDeck{Deck: cards, Archenemy: payload.Archenemy}
+			logLine = member.PlayerName + " shuffles a " + supplementalLayouts[payload.Kind] + " deck of " + strconv.Itoa(len(cards)) + " cards"
+			if payload.Kind == supplementalArchenemy {
+				logLine += " for " + payload.Archenemy
+			}
+		} else {
+			deck := *slot
+			if deck == nil {
+				problem = errors.New("set up a " + payload.Kind + " deck first")
+				return problem
+			}
+			if logLine, problem = deck.advance(messageType, member.PlayerName, isHost, payload.Abandon); problem != nil {
+				return problem
+			}
+		}
+		(*slot).DeckCount = len((*slot).Deck)
+		state["supplemental"] = marshalPayload(supplemental)
+		return nil
+	})
+	if problem != nil {
+		a.sendError(client.id, codeValidationFailed, problem.Error())
+		return
+	}
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save supplemental deck")
+		return
+	}
+	message := roomSupplementalMessage{RoomID: payload.RoomID, Version: version}
+	_ = json.Unmarshal(state["supplemental"], &message.supplementalState)
+	message.supplementalState = message.hidden()
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:supplemental",
+		Payload: marshalPayload(message),
+	})
+	a.logRoomLine(payload.RoomID, member.PlayerName, logLine)
+}
+
+// advance plays the next card of the deck: a planeswalk puts the current
+// plane on the bottom and turns over the top one; a scheme set in motion
+// sends the previous non-ongoing scheme to the bottom. Abandoning an ongoing
+// scheme puts it on the bottom too. It returns the log line.
+func (d *supplementalDeck) advance(messageType string, player string, isHost bool, abandon string) (string, error) {
+	if messageType == "room:scheme" {
+		if player != d.Archenemy && !isHost {
+			return "", errors.New("only the archenemy can set schemes in motion")
+		}
+		if abandon != "" {
+			for i, scheme := range d.Ongoing {
+				if scheme.ID == abandon {
+					d.Ongoing = append(d.Ongoing[:i], d.Ongoing[i+1:]...)
+					d.Deck = append(d.Deck, scheme)
+					return player + " abandons " + scheme.Name, nil
+				}
+			}
+			return "", errors.New("that scheme is not in play")
+		}
+	}
+	if len(d.Deck) == 0 && d.Current == nil {
+		return "", errors.New("the deck is empty")
+	}
+	if d.Current != nil {
+		d.Deck = append(d.Deck, *d.Current)
+		d.Current = nil
+	}
+	next := d.Deck[0]
+	d.Deck = d.Deck[1:]
+	if messageType == "room:planeswalk" {
+		d.Current = &next
+		return player + " planeswalks to " + next.Name, nil
+	}
+	if next.ongoing() {
+		d.Ongoing = append(d.Ongoing, next)
+	} else {
+		d.Current = &next
+	}
+	return player + " sets " + next.Name + " in motion", nil
+}
+
+// missingSupplementalCards lists the requested names no card was found for.
+func missingSupplementalCards(names []string, cards []supplementalCard) []string {
+	found := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		found[normalizeCardName(card.Name)] = true
+	}
+	var details []string
+	for i, name := range names {
+		if !found[normalizeCardName(name)] {
+			details = append(details, "cards["+strconv.Itoa(i)+"]: "+strconv.Quote(name)+" not found")
+		}
+	}
+	return details
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+const (
+	maxTeamSize = 4
+	// teamStartingLife is a team's shared life total when it is first
+	// changed, the Two-Headed Giant starting total.
+	teamStartingLife = 30
+	maxTeamLife      = 9999
+)
+
+// A room created with teamSize plays as teams, for Two-Headed Giant and
+// similar formats: seats 1 to teamSize are team 1, the next teamSize seats
+// team 2, and so on, so players pick a team by picking a seat. Teammates
+// take their turns together and pass priority as one, share a life total
+// kept by the server under teamLife in the stored state, and commander
+// damage to either of them counts against the team.
+
+type RoomTeamLifePayload struct {
+	RoomID string   `json:"roomId"`
+	Team   int      `json:"team"`
+	Life   *float64 `json:"life"`
+	Delta  *float64 `json:"delta"`
+}
+
+// team returns the team a seat belongs to, or 0 when the room has no teams.
+func (room *RoomState) team(seat int) int {
+	if room.TeamSize <= 0 || seat <= 0 {
+		return 0
+	}
+	return (seat-1)/room.TeamSize + 1
+}
+
+func (r *RoomRegistry) TeamSize(roomID string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if room := r.rooms[roomID]; room != nil {
+		return room.TeamSize
+	}
+	return 0
+}
+
+// playerTeams maps the room's player ids to their teams. It is empty when the
+// room has no teams.
+func (r *RoomRegistry) playerTeams(roomID string) map[string]int {
+	teams := make(map[string]int)
+	for _, seat := range r.Seating(roomID) {
+		if seat.Team != 0 {
+			teams[seat.PlayerID] = seat.Team
+		}
+	}
+	return teams
+}
+
+func (a *App) handleTeamLife(client *WSClient, raw json.RawMessage) {
+	var payload RoomTeamLifePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	member, ok := a.rooms.Member(payload.RoomID, client.id)
+	if !ok {
+		a.sendError(client.id, codeNotInRoom, "not in room")
+		return
+	}
+	teamSize := a.rooms.TeamSize(payload.RoomID)
+	if payload.Team == 0 {
+		payload.Team = member.Team
+	}
+	switch {
+	case teamSize == 0:
+		a.sendError(client.id, codeValidationFailed, "the room has no teams")
+		return
+	case payload.Team < 1 || payload.Team > maxRoomSeats/teamSize:
+		a.sendError(client.id, codeValidationFailed, "team does not exist")
+		return
+	case (payload.Life == nil) == (payload.Delta == nil):
+		a.sendError(client.id, codeValidationFailed, "send either life or delta")
+		return
+	case payload.Life != nil && *payload.Life != math.Trunc(*payload.Life),
+		payload.Delta != nil && *payload.Delta != math.Trunc(*payload.Delta):
+		a.sendError(client.id, codeValidationFailed, "life must be a whole number")
+		return
+	}
+
+	key := strconv.Itoa(payload.Team)
+	var life int
+	message, err := a.updateRoomCounters(context.Background(), payload.RoomID, func(state map[string]json.RawMessage) error {
+		totals := map[string]int{}
+		_ = json.Unmarshal(state["teamLife"], &totals)
+		current, ok := totals[key]
+		if !ok {
+			current = teamStartingLife
+		}
+		if payload.Life != nil {
+			current = int(*payload.Life)
+		} else {
+			current += int(*payload.Delta)
+		}
+		life = min(max(current, -maxTeamLife), maxTeamLife)
+		totals[key] = life
+		state["teamLife"] = marshalPayload(totals)
+		return nil
+	})
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to save team life")
+		return
+	}
+	a.broadcastToRoom(payload.RoomID, a.rooms.socketIDs(payload.RoomID), WSMessage{
+		Type:    "room:counters",
+		Payload: marshalPayload(message),
+	})
+	a.logRoomLine(payload.RoomID, member.PlayerName, "Team "+key+" is at "+strconv.Itoa(life)+" life")
+}
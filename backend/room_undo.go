@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const maxUndoEvents = 50
+
+// RoomUndoPayload is sent with room:undo and room:redo. The server cannot
+// interpret events, so the host sends the corrected state, either whole or as
+// a JSON Patch against the stored state. Without either, only the event log
+// changes and the next state save brings the stored board in line.
+type RoomUndoPayload struct {
+	RoomID string            `json:"roomId"`
+	Count  int               `json:"count"`
+	State  *roomStatePayload `json:"state,omitempty"`
+	Patch  json.RawMessage   `json:"patch,omitempty"`
+}
+
+type RoomRolledBackPayload struct {
+	RoomID   string          `json:"roomId"`
+	Action   string          `json:"action"`
+	EventIDs []int64         `json:"eventIds"`
+	Version  int64           `json:"version,omitempty"`
+	State    json.RawMessage `json:"state,omitempty"`
+}
+
+// handleRoomUndo marks the newest count events as reverted (or, for redo,
+// restores the oldest reverted events after the last active one) and tells
+// everyone in the room with room:state_rolled_back. Only the host may do it,
+// which is also what lets an undo carry a whole state, private zones and all,
+// as only the host's POST of the state may.
+func (a *App) handleRoomUndo(client *WSClient, raw json.RawMessage, redo bool) {
+	var payload RoomUndoPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		a.sendError(client.id, codeInvalidRequest, "invalid payload")
+		return
+	}
+	if payload.RoomID == "" {
+		a.sendError(client.id, codeInvalidRequest, "roomId is required")
+		return
+	}
+	if a.rooms.HostSocket(payload.RoomID) != client.id {
+		a.sendError(client.id, codeForbidden, "only the host can undo")
+		return
+	}
+	if payload.Count <= 0 {
+		payload.Count = 1
+	}
+	if payload.Count > maxUndoEvents {
+		payload.Count = maxUndoEvents
+	}
+	action := "undo"
+	if redo {
+		action = "redo"
+	}
+	eventIDs, err := a.revertRoomEvents(payload.RoomID, payload.Count, redo)
+	if err != nil {
+		a.sendError(client.id, codeInternal, "failed to "+action)
+		return
+	}
+	if len(eventIDs) == 0 {
+		a.sendError(client.id, codeNothingToUndo, "nothing to "+action)
+		return
+	}
+	rolledBack := RoomRolledBackPayload{RoomID: payload.RoomID, Action: action, EventIDs: eventIDs}
+	switch {
+	case payload.State != nil:
+		rolledBack.Version, err = a.saveRoomState(payload.RoomID, *payload.State)
+	case len(payload.Patch) > 0:
+		rolledBack.Version, err = a.patchRoomState(payload.RoomID, payload.Patch,
+			roomViewer{PlayerName: a.rooms.PlayerName(payload.RoomID, client.id)})
+	}
+	if errors.Is(err, errRoomStateTooLarge) {
+		a.sendError(client.id, codePayloadTooLarge, "events reverted but state was not saved: "+err.Error())
+	} else if err != nil {
+		a.sendError(client.id, codeInternal, "events reverted but state was not saved: "+err.Error())
+	}
+	var state string
+	if rolledBack.Version > 0 {
+		_ = a.db.QueryRow(`SELECT board_state FROM rooms WHERE room_id = ?`, payload.RoomID).Scan(&state)
+	}
+	// Each socket gets the state with only its own player's private zones.
+	for _, socketID := range append([]string{client.id}, a.rooms.ClientSocketIDs(payload.RoomID)...) {
+		message := rolledBack
+		if state != "" {
+			message.State = viewRoomState([]byte(state), roomViewer{PlayerName: a.rooms.PlayerName(payload.RoomID, socketID)})
+		}
+		a.send(socketID, WSMessage{Type: "room:state_rolled_back", Payload: marshalPayload(message)})
+	}
+}
+
+func (a *App) revertRoomEvents(roomID string, count int, redo bool) ([]int64, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `
+		SELECT id FROM room_events
+		WHERE room_id = ? AND reverted_at IS NULL
+		ORDER BY id DESC
+		LIMIT ?`
+	queryArgs := []interface{}{roomID, count}
+	update := `UPDATE room_events SET reverted_at = CURRENT_TIMESTAMP WHERE id IN (`
+	if redo {
+		// Redo only reaches events reverted after the last live one, so a new
+		// action after an undo discards the redo history as editors do.
+		query = `
+			SELECT id FROM room_events
+			WHERE room_id = ? AND reverted_at IS NOT NULL
+				AND id > (SELECT COALESCE(MAX(id), 0) FROM room_events WHERE room_id = ? AND reverted_at IS NULL)
+			ORDER BY id ASC
+			LIMIT ?`
+		queryArgs = []interface{}{roomID, roomID, count}
+		update = `UPDATE room_events SET reverted_at = NULL WHERE id IN (`
+	}
+	rows, err := tx.Query(query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	args := make([]interface{}, 0)
+	placeholders := make([]string, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		args = append(args, id)
+		placeholders = append(placeholders, "?")
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if _, err := tx.Exec(update+strings.Join(placeholders, ",")+`)`, args...); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// patchRoomState applies a JSON Patch to the stored state unconditionally,
+// holding the viewer to their own private zones and the result to
+// MAX_ROOM_STATE_BYTES as a PATCH request is.
+func (a *App) patchRoomState(roomID string, patch json.RawMessage, viewer roomViewer) (int64, error) {
+	var stateJSON string
+	var version int64
+	if err := a.db.QueryRow(`SELECT board_state, version FROM rooms WHERE room_id = ?`, roomID).Scan(&stateJSON, &version); err != nil {
+		return 0, errors.New("room has no saved state")
+	}
+	if err := checkJSONPatchPrivacy(patch, viewer); err != nil {
+		return 0, err
+	}
+	limit := a.bodyLimits.roomStateBytes
+	patched, err := applyJSONPatch([]byte(stateJSON), patch, limit)
+	if err == nil && limit > 0 && int64(len(patched)) > limit {
+		err = errRoomStateTooLarge
+	}
+	if err == nil {
+		patched, err = splitPrivateZones(patched)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !othersPrivateZonesKept([]byte(stateJSON), patched, viewer) {
+		return 0, errOthersPrivateZone
+	}
+	return a.writeRoomState(context.Background(), roomID, patched, version, true)
+}
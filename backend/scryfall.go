@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	scryfallAPIBase         = "https://api.scryfall.com"
+	scryfallMinRequestDelay = 100 * time.Millisecond
+	scryfallMissTTL         = 10 * time.Minute
+	// scryfallMaxMisses bounds the negative cache; once full, expired entries
+	// are swept and, failing that, an arbitrary one makes room.
+	scryfallMaxMisses = 10000
+	// scryfallBatchLookups is how many cards of one batch may fall back to
+	// Scryfall, so that a decklist full of typos cannot hold a request for
+	// minutes at the request pace. The rest are reported as not found.
+	scryfallBatchLookups = 10
+)
+
+// scryfallClient looks up cards missing from the local database and caches
+// them into the cards table. Requests are spaced out to respect Scryfall's
+// rate limit guidance; a caller whose context ends while waiting its turn
+// gives it up.
+type scryfallClient struct {
+	db         *sql.DB
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	lastRequest time.Time
+
+	missesMu sync.Mutex
+	misses   map[string]time.Time
+}
+
+func newScryfallClient(db *sql.DB) *scryfallClient {
+	if !envBool("SCRYFALL_FALLBACK") {
+		return nil
+	}
+	log.Printf("[cards] scryfall fallback enabled")
+	return &scryfallClient{
+		db:         db,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		misses:     make(map[string]time.Time),
+	}
+}
+
+func (c *scryfallClient) FetchByName(ctx context.Context, name string, setCode string) (*cardRow, error) {
+	query := url.Values{}
+	query.Set("fuzzy", name)
+	if setCode != "" {
+		query.Set("set", setCode)
+	}
+	return c.fetch(ctx, "/cards/named?"+query.Encode())
+}
+
+func (c *scryfallClient) FetchBySetCollector(ctx context.Context, setCode string, collectorNumber string) (*cardRow, error) {
+	return c.fetch(ctx, fmt.Sprintf("/cards/%s/%s", url.PathEscape(setCode), url.PathEscape(collectorNumber)))
+}
+
+func (c *scryfallClient) fetch(ctx context.Context, path string) (*cardRow, error) {
+	if c.recentlyMissed(path) {
+		return nil, errors.New("not found")
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scryfallAPIBase+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "MTOnline/1.0")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		c.recordMiss(path)
+		return nil, errors.New("not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scryfall returned %d", resp.StatusCode)
+	}
+
+	var card scryfallCard
+	if err := json.NewDecoder(resp.Body).Decode(&card); err != nil {
+		return nil, err
+	}
+	if card.ID == "" || strings.TrimSpace(card.Name) == "" {
+		return nil, errors.New("not found")
+	}
+	if _, err := c.db.Exec(upsertCardSQL, cardInsertArgs(card)...); err != nil {
+		return nil, err
+	}
+	log.Printf("[cards] cached %q (%s) from scryfall", card.Name, card.Set)
+
+	return scanCardRow(c.db.QueryRow(`SELECT `+cardColumns+` FROM cards WHERE id = ?`, card.ID))
+}
+
+// wait sleeps until a request slot is free and takes it. A slot is only
+// taken once it comes up, so a caller that gives up while waiting holds up
+// nobody behind it.
+func (c *scryfallClient) wait(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		next := c.lastRequest.Add(scryfallMinRequestDelay)
+		if !next.After(now) {
+			c.lastRequest = now
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *scryfallClient) recentlyMissed(path string) bool {
+	c.missesMu.Lock()
+	defer c.missesMu.Unlock()
+	missedAt, ok := c.misses[path]
+	if !ok {
+		return false
+	}
+	if time.Since(missedAt) > scryfallMissTTL {
+		delete(c.misses, path)
+		return false
+	}
+	return true
+}
+
+func (c *scryfallClient) recordMiss(path string) {
+	c.missesMu.Lock()
+	defer c.missesMu.Unlock()
+	if len(c.misses) >= scryfallMaxMisses {
+		for missed, missedAt := range c.misses {
+			if time.Since(missedAt) > scryfallMissTTL {
+				delete(c.misses, missed)
+			}
+		}
+	}
+	if len(c.misses) >= scryfallMaxMisses {
+		for missed := range c.misses {
+			delete(c.misses, missed)
+			break
+		}
+	}
+	c.misses[path] = time.Now()
+}
+
+type scryfallLookupsKey struct{}
+
+// withScryfallLookupLimit lets at most limit of the lookups made with the
+// returned context fall back to Scryfall.
+func withScryfallLookupLimit(ctx context.Context, limit int32) context.Context {
+	remaining := &atomic.Int32{}
+	remaining.Store(limit)
+	return context.WithValue(ctx, scryfallLookupsKey{}, remaining)
+}
+
+// scryfallLookup is used by the card handlers once the local lookup missed.
+func (a *App) scryfallLookup(ctx context.Context, name string, setCode string, collectorNumber string) (*cardRow, error) {
+	if a.scryfall == nil {
+		return nil, errors.New("not found")
+	}
+	if remaining, ok := ctx.Value(scryfallLookupsKey{}).(*atomic.Int32); ok && remaining.Add(-1) < 0 {
+		return nil, errors.New("not found")
+	}
+	if setCode != "" && collectorNumber != "" {
+		if card, err := a.scryfall.FetchBySetCollector(ctx, setCode, collectorNumber); err == nil {
+			return card, nil
+		}
+	}
+	if strings.TrimSpace(name) == "" {
+		return nil, errors.New("not found")
+	}
+	card, err := a.scryfall.FetchByName(ctx, name, setCode)
+	if err != nil && setCode != "" && ctx.Err() == nil {
+		card, err = a.scryfall.FetchByName(ctx, name, "")
+	}
+	return card, err
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScryfallCancelledWaiterDoesNotDelayOthers(t *testing.T) {
+	c := &scryfallClient{lastRequest: time.Now()}
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), scryfallMinRequestDelay/10)
+		if err := c.wait(ctx); err == nil {
+			t.Fatal("cancelled waiter got a slot")
+		}
+		cancel()
+	}
+	start := time.Now()
+	if err := c.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > scryfallMinRequestDelay {
+		t.Fatalf("next caller waited %v behind abandoned slots", waited)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	sessionTTLSeconds   = 30 * 24 * 60 * 60
+	maxSessionUserAgent = 255
+)
+
+// Session cookies and API tokens carry a random value; only its SHA-256 is
+// stored so a leaked database cannot be replayed as live credentials.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// migrateLegacySessions moves tokens from the old users.session_id column
+// into the sessions table so existing logins survive the upgrade.
+func migrateLegacySessions(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, session_id FROM users WHERE session_id IS NOT NULL AND session_id != ''`)
+	if err != nil {
+		return err
+	}
+	type legacy struct {
+		userID int64
+		token  string
+	}
+	var pending []legacy
+	for rows.Next() {
+		var item legacy
+		if err := rows.Scan(&item.userID, &item.token); err == nil {
+			pending = append(pending, item)
+		}
+	}
+	rows.Close()
+	for _, item := range pending {
+		if _, err := db.Exec(`
+			INSERT INTO sessions (id, token_hash, user_id, expires_at)
+			VALUES (?, ?, ?, datetime('now', ?))
+			ON CONFLICT(token_hash) DO NOTHING
+		`, randomID(12), hashToken(item.token), item.userID, fmt.Sprintf("+%d seconds", sessionTTLSeconds)); err != nil {
+			return err
+		}
+		if _, err := db.Exec(`UPDATE users SET session_id = NULL WHERE id = ?`, item.userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSession records a new session for the user and sets its cookie.
+// Other sessions of the same user stay valid.
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, userID int64) error {
+	token := randomID(32)
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxSessionUserAgent {
+		userAgent = userAgent[:maxSessionUserAgent]
+	}
+	_, _ = a.db.ExecContext(r.Context(), `DELETE FROM sessions WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO sessions (id, token_hash, user_id, user_agent, expires_at)
+		VALUES (?, ?, ?, ?, datetime('now', ?))
+	`, randomID(12), hashToken(token), userID, nullIfEmpty(userAgent), fmt.Sprintf("+%d seconds", sessionTTLSeconds)); err != nil {
+		return err
+	}
+	_, _ = a.db.ExecContext(r.Context(), `UPDATE users SET last_login_at = CURRENT_TIMESTAMP WHERE id = ?`, userID)
+	a.setSessionCookie(w, r, token)
+	a.setCSRFCookie(w, r)
+	return nil
+}
+
+func (a *App) handleSessions(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, user_agent, created_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY created_at DESC
+	`, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load sessions")
+		return
+	}
+	defer rows.Close()
+	sessions := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, createdAt, expiresAt string
+		var userAgent sql.NullString
+		if err := rows.Scan(&id, &userAgent, &createdAt, &expiresAt); err != nil {
+			continue
+		}
+		sessions = append(sessions, map[string]interface{}{
+			"id":        id,
+			"userAgent": nullStringToPtr(userAgent),
+			"createdAt": createdAt,
+			"expiresAt": expiresAt,
+			"current":   id == user.SessionID,
+		})
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (a *App) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM sessions WHERE id = ? AND user_id = ?`, chi.URLParam(r, "sessionId"), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to revoke session")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Session not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleRevokeOtherSessions signs out every device except the current one.
+func (a *App) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := a.currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Not authenticated")
+		return
+	}
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM sessions WHERE user_id = ? AND id != ?`, user.ID, user.SessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to revoke sessions")
+		return
+	}
+	revoked, _ := result.RowsAffected()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "revoked": revoked})
+}
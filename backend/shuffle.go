@@ -0,0 +1,25 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"time"
+)
+
+// randomSeed returns a cryptographically random seed so shuffles can be
+// reproduced later from the recorded value.
+func randomSeed() int64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]) >> 1)
+}
+
+func shuffleWithSeed[T any](items []T, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(items), func(i, j int) {
+		items[i], items[j] = items[j], items[i]
+	})
+}
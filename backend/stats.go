@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsTopN = 20
+
+// statsCache holds the aggregates behind the public stats endpoints. They
+// scan every stored room, so they are recomputed on a timer rather than per
+// request.
+type statsCache struct {
+	mu          sync.RWMutex
+	rooms       map[string]interface{}
+	cards       map[string]interface{}
+	refreshedAt time.Time
+}
+
+type cardCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type statsCard struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Zone        string `json:"zone"`
+	IsCommander bool   `json:"isCommander"`
+	DeckSection string `json:"deckSection"`
+}
+
+type statsCardAction struct {
+	Kind  string      `json:"kind"`
+	ID    string      `json:"id"`
+	Zone  string      `json:"zone"`
+	Card  *statsCard  `json:"card"`
+	Cards []statsCard `json:"cards"`
+}
+
+func (a *App) runStatsRefresh() {
+	interval := time.Duration(envInt("STATS_REFRESH_SECONDS", 600)) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		if err := a.refreshStats(); err != nil {
+			log.Printf("[stats] refresh failed: %v", err)
+		}
+	}
+}
+
+func (a *App) refreshStats() error {
+	rooms, err := a.computeRoomStats()
+	if err != nil {
+		return err
+	}
+	cards, err := a.computeCardStats()
+	if err != nil {
+		return err
+	}
+	a.stats.mu.Lock()
+	a.stats.rooms = rooms
+	a.stats.cards = cards
+	a.stats.refreshedAt = time.Now().UTC()
+	a.stats.mu.Unlock()
+	return nil
+}
+
+func (a *App) computeRoomStats() (map[string]interface{}, error) {
+	var totalRooms, activeRooms, totalEvents int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM rooms`).Scan(&totalRooms); err != nil {
+		return nil, err
+	}
+	if err := a.db.QueryRow(`
+		SELECT COUNT(*) FROM rooms r
+		WHERE r.updated_at >= datetime('now', '-1 day')
+			OR EXISTS (SELECT 1 FROM room_events e WHERE e.room_id = r.room_id AND e.created_at >= datetime('now', '-1 day'))
+	`).Scan(&activeRooms); err != nil {
+		return nil, err
+	}
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM room_events WHERE reverted_at IS NULL`).Scan(&totalEvents); err != nil {
+		return nil, err
+	}
+	// A game's length is the span between the first and last thing recorded
+	// for its room, counting snapshots since compaction drops early events.
+	var averageLength, longest float64
+	if err := a.db.QueryRow(`
+		SELECT COALESCE(AVG(span), 0), COALESCE(MAX(span), 0) FROM (
+			SELECT (julianday(MAX(at)) - julianday(MIN(at))) * 86400 AS span
+			FROM (
+				SELECT room_id, created_at AS at FROM room_events
+				UNION ALL SELECT room_id, created_at FROM room_snapshots
+				UNION ALL SELECT room_id, updated_at FROM rooms
+			)
+			GROUP BY room_id
+			HAVING COUNT(*) > 1
+		)
+	`).Scan(&averageLength, &longest); err != nil {
+		return nil, err
+	}
+	averageEvents := 0.0
+	if totalRooms > 0 {
+		averageEvents = float64(totalEvents) / float64(totalRooms)
+	}
+	return map[string]interface{}{
+		"totalRooms":               totalRooms,
+		"liveRooms":                len(a.rooms.Summaries()),
+		"activeRooms24h":           activeRooms,
+		"totalEvents":              totalEvents,
+		"averageEventsPerRoom":     averageEvents,
+		"averageGameLengthSeconds": int64(averageLength),
+		"longestGameSeconds":       int64(longest),
+	}, nil
+}
+
+// computeCardStats counts commanders from the stored boards and, as "drawn",
+// cards moved into a hand by the remaining events. Card names come from the
+// boards and from the events that add cards, since moves only carry ids.
+func (a *App) computeCardStats() (map[string]interface{}, error) {
+	commanders := make(map[string]int)
+	drawn := make(map[string]int)
+	names := make(map[string]map[string]string)
+
+	rows, err := a.db.Query(`SELECT room_id, board_state FROM rooms`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var roomID, stateJSON string
+		if err := rows.Scan(&roomID, &stateJSON); err != nil {
+			continue
+		}
+		var state struct {
+			Board   []statsCard `json:"board"`
+			Private map[string]struct {
+				Board []statsCard `json:"board"`
+			} `json:"private"`
+		}
+		if json.Unmarshal([]byte(stateJSON), &state) != nil {
+			continue
+		}
+		cards := state.Board
+		for _, zone := range state.Private {
+			cards = append(cards, zone.Board...)
+		}
+		roomNames := make(map[string]string, len(cards))
+		seen := make(map[string]bool)
+		for _, card := range cards {
+			roomNames[card.ID] = card.Name
+			if (card.IsCommander || card.DeckSection == "commander") && card.Name != "" && !seen[card.Name] {
+				seen[card.Name] = true
+				commanders[card.Name]++
+			}
+		}
+		names[roomID] = roomNames
+	}
+	rows.Close()
+
+	rows, err = a.db.Query(`
+		SELECT room_id, event_data FROM room_events
+		WHERE event_type = 'CARD_ACTION' AND reverted_at IS NULL
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	drawnIDs := make(map[string]map[string]int)
+	for rows.Next() {
+		var roomID, data string
+		if err := rows.Scan(&roomID, &data); err != nil {
+			continue
+		}
+		var action statsCardAction
+		if json.Unmarshal([]byte(data), &action) != nil {
+			continue
+		}
+		roomNames := names[roomID]
+		if roomNames == nil {
+			roomNames = make(map[string]string)
+			names[roomID] = roomNames
+		}
+		switch action.Kind {
+		case "add", "addToLibrary":
+			if action.Card != nil {
+				roomNames[action.Card.ID] = action.Card.Name
+			}
+		case "replaceLibrary":
+			for _, card := range action.Cards {
+				roomNames[card.ID] = card.Name
+			}
+		case "changeZone":
+			if action.Zone == "hand" {
+				if drawnIDs[roomID] == nil {
+					drawnIDs[roomID] = make(map[string]int)
+				}
+				drawnIDs[roomID][action.ID]++
+			}
+		}
+	}
+	for roomID, counts := range drawnIDs {
+		for id, count := range counts {
+			if name := names[roomID][id]; name != "" {
+				drawn[name] += count
+			}
+		}
+	}
+	return map[string]interface{}{
+		"mostPlayedCommanders": topCardCounts(commanders),
+		"mostDrawnCards":       topCardCounts(drawn),
+	}, nil
+}
+
+func topCardCounts(counts map[string]int) []cardCount {
+	result := make([]cardCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, cardCount{Name: name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > statsTopN {
+		result = result[:statsTopN]
+	}
+	return result
+}
+
+func (a *App) writeStats(w http.ResponseWriter, pick func() map[string]interface{}) {
+	a.stats.mu.RLock()
+	data := pick()
+	refreshedAt := a.stats.refreshedAt
+	a.stats.mu.RUnlock()
+	if data == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Stats are still being computed")
+		return
+	}
+	response := make(map[string]interface{}, len(data)+1)
+	for key, value := range data {
+		response[key] = value
+	}
+	response["refreshedAt"] = refreshedAt.Format(time.RFC3339)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *App) handleRoomStats(w http.ResponseWriter, r *http.Request) {
+	a.writeStats(w, func() map[string]interface{} { return a.stats.rooms })
+}
+
+func (a *App) handleCardStats(w http.ResponseWriter, r *http.Request) {
+	a.writeStats(w, func() map[string]interface{} { return a.stats.cards })
+}
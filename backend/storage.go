@@ -3,104 +3,82 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
 )
 
-func openDatabase() (*sql.DB, error) {
-	dbPath := filepath.Join(rootDir(), "data", "mtonline.db")
-	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+func openDatabase(tracer *tracer) (*sql.DB, error) {
+	dsn, err := databaseDSN()
+	if err != nil {
 		return nil, err
 	}
-	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on", dbPath))
-	if err != nil {
+	var db *sql.DB
+	if tracer != nil {
+		db = sql.OpenDB(&tracedConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{}, tracer: tracer})
+	} else if db, err = sql.Open("sqlite3", dsn); err != nil {
 		return nil, err
 	}
-	if _, err := db.Exec(`PRAGMA journal_mode = WAL;`); err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
 }
 
-func ensureSchema(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL,
-		session_id TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS decks (
-		id TEXT PRIMARY KEY,
-		user_id INTEGER NOT NULL,
-		name TEXT NOT NULL,
-		raw_text TEXT NOT NULL,
-		entries TEXT NOT NULL,
-		is_public INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS rooms (
-		room_id TEXT PRIMARY KEY,
-		board_state TEXT NOT NULL,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS room_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		room_id TEXT NOT NULL,
-		event_type TEXT NOT NULL,
-		event_data TEXT NOT NULL,
-		player_id TEXT,
-		player_name TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_decks_user_id ON decks(user_id);
-	CREATE INDEX IF NOT EXISTS idx_decks_is_public ON decks(is_public);
-	CREATE INDEX IF NOT EXISTS idx_rooms_updated_at ON rooms(updated_at);
-	CREATE INDEX IF NOT EXISTS idx_room_events_room_id ON room_events(room_id);
-	CREATE INDEX IF NOT EXISTS idx_room_events_created_at ON room_events(created_at);
-
-	CREATE TABLE IF NOT EXISTS cards (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		name_normalized TEXT NOT NULL,
-		set_code TEXT,
-		collector_number TEXT,
-		type_line TEXT,
-		mana_cost TEXT,
-		oracle_text TEXT,
-		image_url TEXT,
-		back_image_url TEXT,
-		set_name TEXT,
-		layout TEXT,
-		prints_search_uri TEXT
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_cards_name_normalized ON cards(name_normalized);
-	CREATE INDEX IF NOT EXISTS idx_cards_set_collector ON cards(set_code, collector_number);
-
-	CREATE TABLE IF NOT EXISTS ui_configs (
-		name TEXT PRIMARY KEY,
-		payload TEXT NOT NULL,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-	if _, err := db.Exec(schema); err != nil {
-		return err
+// databaseDSN builds the SQLite DSN. DATABASE_URL is used as given, minus an
+// optional sqlite:// or sqlite3:// scheme; otherwise the file is DATABASE_PATH,
+// defaulting to data/mtonline.db under the working directory. DB_BUSY_TIMEOUT_MS,
+// DB_CACHE_SIZE and DB_SYNCHRONOUS become DSN options rather than one-off
+// PRAGMAs so every pooled connection gets them. Options already present in
+// DATABASE_URL win.
+func databaseDSN() (string, error) {
+	base := strings.TrimSpace(os.Getenv("DATABASE_URL"))
+	for _, scheme := range []string{"sqlite3://", "sqlite://"} {
+		base = strings.TrimPrefix(base, scheme)
+	}
+	if base == "" {
+		dbPath := strings.TrimSpace(os.Getenv("DATABASE_PATH"))
+		if dbPath == "" {
+			dbPath = filepath.Join(rootDir(), "data", "mtonline.db")
+		}
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+			return "", err
+		}
+		base = "file:" + dbPath
+	}
+	path, rawQuery, _ := strings.Cut(base, "?")
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("DATABASE_URL: %w", err)
+	}
+	setDefault := func(key string, value string) {
+		if value != "" && params.Get(key) == "" {
+			params.Set(key, value)
+		}
 	}
-	if _, err := db.Exec(`ALTER TABLE decks ADD COLUMN is_public INTEGER DEFAULT 0`); err != nil {
-		// Column already exists, ignore.
+	setDefault("_foreign_keys", "on")
+	setDefault("_journal_mode", "WAL")
+	setDefault("_busy_timeout", strconv.Itoa(envInt("DB_BUSY_TIMEOUT_MS", 5000)))
+	if cacheSize := strings.TrimSpace(os.Getenv("DB_CACHE_SIZE")); cacheSize != "" {
+		if _, err := strconv.Atoi(cacheSize); err != nil {
+			return "", fmt.Errorf("DB_CACHE_SIZE must be an integer (pages, or negative KiB): %q", cacheSize)
+		}
+		setDefault("_cache_size", cacheSize)
 	}
-	if _, err := db.Exec(`ALTER TABLE cards ADD COLUMN prints_search_uri TEXT`); err != nil {
-		// Column already exists, ignore.
+	if synchronous := strings.ToUpper(strings.TrimSpace(os.Getenv("DB_SYNCHRONOUS"))); synchronous != "" {
+		switch synchronous {
+		case "OFF", "NORMAL", "FULL", "EXTRA":
+			setDefault("_synchronous", synchronous)
+		default:
+			return "", fmt.Errorf("DB_SYNCHRONOUS must be OFF, NORMAL, FULL or EXTRA: %q", synchronous)
+		}
 	}
-	return nil
+	return path + "?" + params.Encode(), nil
 }
 
 func rootDir() string {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tlsReloadCheckInterval = time.Minute
+
+// certReloader serves the TLS_CERT/TLS_KEY pair and picks up renewed files
+// without a restart, so certificates kept fresh by certbot, lego or similar
+// keep working on long-running servers.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// loadTLSConfig returns nil when TLS_CERT and TLS_KEY are unset, in which case
+// the server speaks plain HTTP as before.
+func loadTLSConfig() (*tls.Config, error) {
+	certPath := strings.TrimSpace(os.Getenv("TLS_CERT"))
+	keyPath := strings.TrimSpace(os.Getenv("TLS_KEY"))
+	if certPath == "" && keyPath == "" {
+		return nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("TLS_CERT and TLS_KEY must be set together")
+	}
+	reloader := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}, nil
+}
+
+func (c *certReloader) latestModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, path := range []string{c.certPath, c.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.checkedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	cert, modTime := c.cert, c.modTime
+	due := time.Since(c.checkedAt) >= tlsReloadCheckInterval
+	if due {
+		c.checkedAt = time.Now()
+	}
+	c.mu.Unlock()
+	if !due {
+		return cert, nil
+	}
+	if latest, err := c.latestModTime(); err == nil && latest.After(modTime) {
+		// A half-written renewal fails to parse; keep serving the old pair
+		// and retry on the next check.
+		if err := c.reload(); err != nil {
+			log.Printf("[tls] reload failed, keeping current certificate: %v", err)
+		} else {
+			log.Printf("[tls] reloaded certificate from %s", c.certPath)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
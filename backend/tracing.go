@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Tracing is a small OTLP/HTTP (JSON) exporter so traces can be sent to any
+// OpenTelemetry collector without pulling in the SDK. It is off unless
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set;
+// a nil *tracer and nil *span are valid and do nothing. Trace context is
+// propagated with the W3C traceparent header.
+
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+
+	traceExportInterval = 5 * time.Second
+	traceExportBatch    = 256
+	traceQueueSize      = 4096
+	traceStatementLimit = 1000
+)
+
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	ratio    float64
+	client   *http.Client
+	queue    chan *span
+}
+
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+	name     string
+	kind     int
+	start    time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  map[string]interface{}
+	failed string
+}
+
+type spanKey struct{}
+
+func loadTracer() *tracer {
+	endpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	if endpoint == "" {
+		if base := strings.TrimRight(strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")), "/"); base != "" {
+			endpoint = base + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil
+	}
+	t := &tracer{
+		endpoint: endpoint,
+		headers:  make(map[string]string),
+		service:  strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")),
+		ratio:    1,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *span, traceQueueSize),
+	}
+	if t.service == "" {
+		t.service = "mtonline-backend"
+	}
+	if value := strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER_ARG")); value != "" {
+		if ratio, err := strconv.ParseFloat(value, 64); err == nil && ratio >= 0 && ratio <= 1 {
+			t.ratio = ratio
+		}
+	}
+	for _, entry := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if key, value, ok := strings.Cut(entry, "="); ok && strings.TrimSpace(key) != "" {
+			t.headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	go t.run()
+	log.Printf("[trace] exporting spans to %s (sample ratio %.2f)", endpoint, t.ratio)
+	return t
+}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// start begins a span under the one carried by ctx, or a new trace if there
+// is none. The returned context carries the new span.
+func (t *tracer) start(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+		s.sampled = parent.sampled
+	} else {
+		_, _ = rand.Read(s.traceID[:])
+		s.sampled = sampleTrace(s.traceID, t.ratio)
+	}
+	_, _ = rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func sampleTrace(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/float64(1<<53) < ratio
+}
+
+// withRemoteParent returns ctx carrying the caller's span from a traceparent
+// header, so spans started from it join the caller's trace.
+func withRemoteParent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	parent := &span{}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil || parent.traceID == ([16]byte{}) {
+		return ctx
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil || parent.spanID == ([8]byte{}) {
+		return ctx
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return ctx
+	}
+	parent.sampled = flags&1 == 1
+	return context.WithValue(ctx, spanKey{}, parent)
+}
+
+func (s *span) traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-" + flags
+}
+
+func (s *span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+func (s *span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+func (s *span) Fail(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed = message
+	s.mu.Unlock()
+}
+
+func (s *span) End() {
+	if s == nil || s.tracer == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	select {
+	case s.tracer.queue <- s:
+	default:
+		// Exporter is behind; drop rather than block the request.
+	}
+}
+
+func (t *tracer) run() {
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	batch := make([]*span, 0, traceExportBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			log.Printf("[trace] export failed: %v", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= traceExportBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	result := make([]otlpKeyValue, 0, len(attrs))
+	for key, value := range attrs {
+		var encoded map[string]interface{}
+		switch v := value.(type) {
+		case int:
+			encoded = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			encoded = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			encoded = map[string]interface{}{"boolValue": v}
+		case float64:
+			encoded = map[string]interface{}{"doubleValue": v}
+		default:
+			encoded = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		result = append(result, otlpKeyValue{Key: key, Value: encoded})
+	}
+	return result
+}
+
+func (t *tracer) export(batch []*span) error {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		encoded := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != ([8]byte{}) {
+			encoded["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.failed != "" {
+			encoded["status"] = map[string]interface{}{"code": 2, "message": s.failed}
+		}
+		s.mu.Unlock()
+		spans = append(spans, encoded)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": t.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "mtonline"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// traceMiddleware opens a server span per HTTP request, joining the caller's
+// trace when it sends traceparent, and echoes the span's traceparent back so
+// a client can look the request up.
+func (a *App) traceMiddleware(next http.Handler) http.Handler {
+	if a.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withRemoteParent(r.Context(), r.Header.Get("traceparent"))
+		ctx, s := a.tracer.start(ctx, r.Method, spanKindServer)
+		defer s.End()
+		w.Header().Set("traceparent", s.traceparent())
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		switch {
+		case status != 0:
+		case strings.EqualFold(r.Header.Get("Upgrade"), "websocket"):
+			status = http.StatusSwitchingProtocols
+		default:
+			status = http.StatusOK
+		}
+		s.SetName(r.Method + " " + route)
+		s.SetAttr("http.request.method", r.Method)
+		s.SetAttr("http.route", route)
+		s.SetAttr("url.path", r.URL.Path)
+		s.SetAttr("http.response.status_code", status)
+		s.SetAttr("client.address", r.RemoteAddr)
+		if status >= 500 {
+			s.Fail(http.StatusText(status))
+		}
+	})
+}
+
+// traceWSMessage opens a span covering one incoming WS message, including the
+// broadcasts it triggers.
+func (a *App) traceWSMessage(client *WSClient, message WSMessage) *span {
+	if a.tracer == nil {
+		return nil
+	}
+	_, s := a.tracer.start(context.Background(), "ws "+message.Type, spanKindServer)
+	s.SetAttr("ws.message.type", message.Type)
+	s.SetAttr("ws.socket_id", client.id)
+	if client.userID != 0 {
+		s.SetAttr("enduser.id", client.userID)
+	}
+	var target struct {
+		RoomID string `json:"roomId"`
+	}
+	if json.Unmarshal(message.Payload, &target) == nil && target.RoomID != "" {
+		s.SetAttr("room.id", target.RoomID)
+	}
+	return s
+}
+
+// tracedConnector wraps the SQLite driver so queries run with a traced
+// context get a client span. Queries without one, such as background jobs
+// and the card import, are not recorded.
+type tracedConnector struct {
+	dsn    string
+	driver driver.Driver
+	tracer *tracer
+}
+
+func (c *tracedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, tracer: c.tracer}, nil
+}
+
+func (c *tracedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type tracedConn struct {
+	driver.Conn
+	tracer *tracer
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s := c.startQuery(ctx, query)
+	result, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	endQuery(s, err)
+	return result, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s := c.startQuery(ctx, query)
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	endQuery(s, err)
+	return rows, err
+}
+
+func (c *tracedConn) startQuery(ctx context.Context, query string) *span {
+	if spanFromContext(ctx) == nil {
+		return nil
+	}
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	if len(statement) > traceStatementLimit {
+		statement = statement[:traceStatementLimit]
+	}
+	_, s := c.tracer.start(ctx, "sqlite "+strings.ToUpper(operation), spanKindClient)
+	s.SetAttr("db.system", "sqlite")
+	s.SetAttr("db.statement", statement)
+	return s
+}
+
+func endQuery(s *span, err error) {
+	if err != nil && err != driver.ErrSkip {
+		s.Fail(err.Error())
+	}
+	s.End()
+}
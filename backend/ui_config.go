@@ -1,6 +1,13 @@
 package main
 
-import "database/sql"
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 const defaultUIConfig = `
 {
@@ -82,6 +89,13 @@ const defaultUIConfig = `
     "moveZone": "moveZone",
     "libraryPlace": "libraryPlace"
   },
+  "keybindings": {
+    "t": "tap",
+    "f": "flip",
+    "d": "draw",
+    "s": "shuffle",
+    "m": "mulligan"
+  },
   "entities": {
     "battlefield": {
       "selectable": true,
@@ -160,11 +174,133 @@ func ensureUIConfig(db *sql.DB) error {
 	return err
 }
 
+// uiConfigETag renders a UI config version as a strong ETag for
+// If-None-Match.
+func uiConfigETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
 
+// keybindingModifiers maps the modifier names accepted in a keybinding to
+// the name they are normalized to.
+var keybindingModifiers = map[string]string{
+	"ctrl":    "ctrl",
+	"control": "ctrl",
+	"alt":     "alt",
+	"option":  "alt",
+	"shift":   "shift",
+	"meta":    "meta",
+	"cmd":     "meta",
+}
 
+var keybindingModifierOrder = []string{"ctrl", "alt", "shift", "meta"}
 
+// normalizeKeybinding turns a combo such as "Shift+D" into its canonical
+// form ("shift+d") so that two spellings of the same keys are recognized as
+// one binding.
+func normalizeKeybinding(combo string) (string, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(combo)), "+")
+	key := strings.TrimSpace(parts[len(parts)-1])
+	if key == "" {
+		// "+" itself, alone or after modifiers ("shift++").
+		if len(parts) < 2 || strings.TrimSpace(parts[len(parts)-2]) != "" {
+			return "", fmt.Errorf("missing key")
+		}
+		key = "+"
+		parts = parts[:len(parts)-1]
+	}
+	if _, isModifier := keybindingModifiers[key]; isModifier {
+		return "", fmt.Errorf("missing key after modifiers")
+	}
+	held := map[string]bool{}
+	for _, part := range parts[:len(parts)-1] {
+		modifier, ok := keybindingModifiers[strings.TrimSpace(part)]
+		if !ok {
+			return "", fmt.Errorf("unknown modifier %q", strings.TrimSpace(part))
+		}
+		held[modifier] = true
+	}
+	normalized := make([]string, 0, len(held)+1)
+	for _, modifier := range keybindingModifierOrder {
+		if held[modifier] {
+			normalized = append(normalized, modifier)
+		}
+	}
+	return strings.Join(append(normalized, key), "+"), nil
+}
 
+type keybinding struct {
+	Combo   string
+	Command interface{}
+}
 
+// decodeKeybindings reads the keybindings object in document order. A plain
+// map would silently keep only the last of two entries for the same key.
+func decodeKeybindings(raw json.RawMessage) ([]keybinding, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, false
+	}
+	var bindings []keybinding
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+		var command interface{}
+		if err := decoder.Decode(&command); err != nil {
+			return nil, false
+		}
+		bindings = append(bindings, keybinding{Combo: token.(string), Command: command})
+	}
+	return bindings, true
+}
 
-
-
+// validateUIConfig checks the parts of a UI config the server relies on and
+// returns every problem found. Keybindings map key combos ("t", "shift+d")
+// to commands from the aliases table, optionally with an argument as in the
+// menus ("moveZone:exile"); no two entries may bind the same keys.
+func validateUIConfig(body []byte) []string {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(body, &config); err != nil {
+		return []string{"config: must be a JSON object"}
+	}
+	var aliases map[string]string
+	if raw, ok := config["aliases"]; ok {
+		if err := json.Unmarshal(raw, &aliases); err != nil {
+			return []string{"aliases: must be an object of strings"}
+		}
+	}
+	raw, ok := config["keybindings"]
+	if !ok {
+		return nil
+	}
+	bindings, ok := decodeKeybindings(raw)
+	if !ok {
+		return []string{"keybindings: must be an object"}
+	}
+	var details []string
+	bound := map[string]string{}
+	for _, binding := range bindings {
+		path := fmt.Sprintf("keybindings[%q]", binding.Combo)
+		combo, err := normalizeKeybinding(binding.Combo)
+		if err != nil {
+			details = append(details, path+": "+err.Error())
+			continue
+		}
+		if previous, taken := bound[combo]; taken {
+			details = append(details, fmt.Sprintf("%s: conflicts with %q", path, previous))
+			continue
+		}
+		bound[combo] = binding.Combo
+		command, ok := binding.Command.(string)
+		if !ok {
+			details = append(details, path+": must be a string")
+			continue
+		}
+		name, _, _ := strings.Cut(command, ":")
+		if _, known := aliases[name]; !known {
+			details = append(details, fmt.Sprintf("%s: unknown command %q (not in aliases)", path, name))
+		}
+	}
+	return details
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	webhookRoomCreated    = "room.created"
+	webhookRoomClosed     = "room.closed"
+	webhookGameFinished   = "game.finished"
+	webhookRoundCompleted = "tournament.round_completed"
+	webhookPing           = "ping"
+
+	maxWebhooks        = 20
+	maxWebhookURLLen   = 2048
+	webhookQueueSize   = 256
+	webhookMaxAttempts = 3
+)
+
+// webhookEvents are the events an admin may subscribe a webhook to.
+// tournament.round_completed is reserved for the tournament mode and is not
+// sent by anything yet.
+var webhookEvents = map[string]bool{
+	webhookRoomCreated:    true,
+	webhookRoomClosed:     true,
+	webhookGameFinished:   true,
+	webhookRoundCompleted: true,
+}
+
+// webhookRetryDelays is how long to wait before each retry of a failed
+// delivery.
+var webhookRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second}
+
+// webhookDispatcher POSTs events to the URLs admins have configured, so
+// Discord bots and league trackers can react without polling. Each body is
+// {"id", "event", "createdAt", "data"} and carries an X-MTOnline-Signature
+// header of the form t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+// keyed with the webhook's secret; receivers should check it and reject old
+// timestamps. Deliveries run in the background, are retried on network
+// errors and 5xx responses, and the last outcome is kept on the webhook.
+type webhookDispatcher struct {
+	db     *sql.DB
+	client *http.Client
+	queue  chan webhookEnvelope
+}
+
+type webhookEnvelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt string      `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+type webhookTarget struct {
+	ID     string
+	URL    string
+	Secret string
+}
+
+type webhookPayload struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func newWebhookDispatcher(db *sql.DB) *webhookDispatcher {
+	d := &webhookDispatcher{
+		db:     db,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan webhookEnvelope, webhookQueueSize),
+	}
+	go d.run()
+	return d
+}
+
+// emit queues an event for every webhook subscribed to it. It never blocks
+// the caller: when the queue is full the event is dropped and logged.
+func (d *webhookDispatcher) emit(event string, data interface{}) {
+	if d == nil {
+		return
+	}
+	envelope := webhookEnvelope{
+		ID:        randomID(12),
+		Event:     event,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	}
+	select {
+	case d.queue <- envelope:
+	default:
+		log.Printf("[webhooks] queue full, dropping %s %s", event, envelope.ID)
+	}
+}
+
+func (d *webhookDispatcher) run() {
+	for envelope := range d.queue {
+		targets, err := d.subscribers(envelope.Event)
+		if err != nil {
+			log.Printf("[webhooks] failed to load webhooks for %s: %v", envelope.Event, err)
+			continue
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		body, err := json.Marshal(envelope)
+		if err != nil {
+			continue
+		}
+		for _, target := range targets {
+			go d.deliverWithRetries(target, envelope, body)
+		}
+	}
+}
+
+func (d *webhookDispatcher) subscribers(event string) ([]webhookTarget, error) {
+	rows, err := d.db.Query(`SELECT id, url, secret, events FROM webhooks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var targets []webhookTarget
+	for rows.Next() {
+		var target webhookTarget
+		var events string
+		if err := rows.Scan(&target.ID, &target.URL, &target.Secret, &events); err != nil {
+			return nil, err
+		}
+		if containsString(strings.Split(events, " "), event) {
+			targets = append(targets, target)
+		}
+	}
+	return targets, rows.Err()
+}
+
+func (d *webhookDispatcher) deliverWithRetries(target webhookTarget, envelope webhookEnvelope, body []byte) {
+	for attempt := 1; ; attempt++ {
+		status, err := d.deliver(target, envelope, body)
+		d.recordDelivery(target.ID, status, err)
+		if err == nil || status >= 400 && status < 500 || attempt >= webhookMaxAttempts {
+			if err != nil {
+				log.Printf("[webhooks] %s to %s failed after %d attempt(s): %v", envelope.Event, target.ID, attempt, err)
+			}
+			return
+		}
+		time.Sleep(webhookRetryDelays[attempt-1])
+	}
+}
+
+// deliver sends one signed POST and returns the response status, with an
+// error for anything other than a 2xx.
+func (d *webhookDispatcher) deliver(target webhookTarget, envelope webhookEnvelope, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MTOnline-Webhooks/1")
+	req.Header.Set("X-MTOnline-Event", envelope.Event)
+	req.Header.Set("X-MTOnline-Delivery", envelope.ID)
+	req.Header.Set("X-MTOnline-Signature", signWebhook(target.Secret, time.Now(), body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func signWebhook(secret string, now time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *webhookDispatcher) recordDelivery(id string, status int, deliveryErr error) {
+	var lastError interface{}
+	if deliveryErr != nil {
+		lastError = deliveryErr.Error()
+	}
+	_, _ = d.db.Exec(`
+		UPDATE webhooks SET last_delivery_at = CURRENT_TIMESTAMP, last_status = ?, last_error = ?
+		WHERE id = ?
+	`, nullIfZero(int64(status)), lastError, id)
+}
+
+func validateWebhookURL(raw string) error {
+	if raw == "" || len(raw) > maxWebhookURLLen {
+		return fmt.Errorf("url is required and must be at most %d characters", maxWebhookURLLen)
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func (a *App) handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, url, events, created_at, last_delivery_at, last_status, last_error
+		FROM webhooks
+		ORDER BY created_at
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load webhooks")
+		return
+	}
+	defer rows.Close()
+	webhooks := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, hookURL, events, createdAt string
+		var lastDeliveryAt, lastError sql.NullString
+		var lastStatus sql.NullInt64
+		if err := rows.Scan(&id, &hookURL, &events, &createdAt, &lastDeliveryAt, &lastStatus, &lastError); err != nil {
+			continue
+		}
+		webhook := map[string]interface{}{
+			"id":             id,
+			"url":            hookURL,
+			"events":         strings.Split(events, " "),
+			"createdAt":      createdAt,
+			"lastDeliveryAt": nullStringToPtr(lastDeliveryAt),
+			"lastError":      nullStringToPtr(lastError),
+			"lastStatus":     nil,
+		}
+		if lastStatus.Valid {
+			webhook["lastStatus"] = lastStatus.Int64
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+// handleCreateWebhook returns the signing secret; like API tokens it is not
+// shown again.
+func (a *App) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload webhookPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeBodyError(w, err, "Invalid request")
+		return
+	}
+	hookURL := strings.TrimSpace(payload.URL)
+	if err := validateWebhookURL(hookURL); err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	events := make([]string, 0, len(payload.Events))
+	for _, event := range payload.Events {
+		if !webhookEvents[event] {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("unknown event %q", event))
+			return
+		}
+		if !containsString(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "at least one event is required")
+		return
+	}
+	var count int
+	_ = a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM webhooks`).Scan(&count)
+	if count >= maxWebhooks {
+		writeError(w, http.StatusUnprocessableEntity, codeLimitReached, fmt.Sprintf("Webhook limit reached (%d webhooks)", maxWebhooks))
+		return
+	}
+	id := randomID(12)
+	secret := "whsec_" + randomID(24)
+	user := a.currentUser(r)
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO webhooks (id, url, secret, events, created_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, id, hookURL, secret, strings.Join(events, " "), user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create webhook")
+		return
+	}
+	log.Printf("[admin] %s added webhook %s for %s", user.Username, id, strings.Join(events, ", "))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"url":    hookURL,
+		"events": events,
+		"secret": secret,
+	})
+}
+
+func (a *App) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	result, err := a.db.ExecContext(r.Context(), `DELETE FROM webhooks WHERE id = ?`, chi.URLParam(r, "webhookId"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete webhook")
+		return
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Webhook not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleTestWebhook sends a ping synchronously, without retries, and reports
+// how the receiver answered.
+func (a *App) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	var target webhookTarget
+	err := a.db.QueryRowContext(r.Context(), `SELECT id, url, secret FROM webhooks WHERE id = ?`, chi.URLParam(r, "webhookId")).
+		Scan(&target.ID, &target.URL, &target.Secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load webhook")
+		return
+	}
+	envelope := webhookEnvelope{
+		ID:        randomID(12),
+		Event:     webhookPing,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      map[string]string{"webhookId": target.ID},
+	}
+	body, _ := json.Marshal(envelope)
+	status, err := a.webhooks.deliver(target, envelope, body)
+	a.webhooks.recordDelivery(target.ID, status, err)
+	result := map[string]interface{}{"delivered": err == nil, "status": status}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	wordFilterOff    = "off"
+	wordFilterReject = "reject"
+	wordFilterMask   = "mask"
+)
+
+// wordFilter keeps blocked words out of what other players see: usernames,
+// player names in rooms, deck names and deck comments. The words come from
+// BLOCKED_WORDS (comma separated) and BLOCKED_WORDS_FILE (one per line, #
+// starts a comment) and match whole words regardless of case, where words
+// are split at anything that is not a letter and inside camelCase; an entry
+// starting or ending with * matches anywhere, even inside a longer word.
+// WORD_FILTER_MODE decides whether text with a blocked word is rejected or
+// stored with the word masked. Usernames are always rejected, since a masked
+// name is not one anybody can sign in with.
+type wordFilter struct {
+	mode      string
+	words     map[string]bool
+	fragments [][]rune
+}
+
+func loadWordFilter() (*wordFilter, error) {
+	filter := &wordFilter{
+		mode:  strings.ToLower(strings.TrimSpace(os.Getenv("WORD_FILTER_MODE"))),
+		words: make(map[string]bool),
+	}
+	if filter.mode != wordFilterOff && filter.mode != wordFilterMask {
+		filter.mode = wordFilterReject
+	}
+	words := strings.Split(os.Getenv("BLOCKED_WORDS"), ",")
+	if path := strings.TrimSpace(os.Getenv("BLOCKED_WORDS_FILE")); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			words = append(words, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		trimmed := strings.ToLower(strings.Trim(word, "*"))
+		switch {
+		case trimmed == "":
+		case trimmed != strings.ToLower(word):
+			filter.fragments = append(filter.fragments, []rune(trimmed))
+		default:
+			filter.words[trimmed] = true
+		}
+	}
+	return filter, nil
+}
+
+// find marks the runes of text that belong to a blocked word, returning nil
+// when there are none.
+func (f *wordFilter) find(text string) []bool {
+	if f.mode == wordFilterOff || (len(f.words) == 0 && len(f.fragments) == 0) {
+		return nil
+	}
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	var marked []bool
+	mark := func(from, to int) {
+		if marked == nil {
+			marked = make([]bool, len(runes))
+		}
+		for i := from; i < to; i++ {
+			marked[i] = true
+		}
+	}
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		letter := i < len(runes) && unicode.IsLetter(runes[i])
+		camel := letter && i > 0 && unicode.IsLower(runes[i-1]) && unicode.IsUpper(runes[i])
+		if start >= 0 && (!letter || camel) {
+			if f.words[string(lower[start:i])] {
+				mark(start, i)
+			}
+			start = -1
+		}
+		if letter && start < 0 {
+			start = i
+		}
+	}
+	for _, fragment := range f.fragments {
+		for i := 0; i+len(fragment) <= len(lower); i++ {
+			if string(lower[i:i+len(fragment)]) == string(fragment) {
+				mark(i, i+len(fragment))
+			}
+		}
+	}
+	return marked
+}
+
+// blocked reports whether the text contains a blocked word.
+func (f *wordFilter) blocked(text string) bool {
+	return f.find(text) != nil
+}
+
+// apply returns the text to store and whether it may be stored at all: in
+// mask mode every blocked word is replaced by asterisks, in reject mode text
+// with a blocked word is refused.
+func (f *wordFilter) apply(text string) (string, bool) {
+	marked := f.find(text)
+	if marked == nil {
+		return text, true
+	}
+	if f.mode == wordFilterReject {
+		return text, false
+	}
+	runes := []rune(text)
+	for i := range runes {
+		if marked[i] {
+			runes[i] = '*'
+		}
+	}
+	return string(runes), true
+}
+
+// blockedWordMessage is the error for a field refused by the filter.
+func blockedWordMessage(field string) string {
+	return field + " contains a word that is not allowed"
+}
+
+// filterText applies the filter to a field of a REST request.
+func (a *App) filterText(field string, text string) (string, *apiError) {
+	filtered, ok := a.words.apply(text)
+	if !ok {
+		return "", &apiError{http.StatusBadRequest, codeBlockedWord, blockedWordMessage(field)}
+	}
+	return filtered, nil
+}
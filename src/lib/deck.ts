@@ -8,7 +8,7 @@ export interface DeckEntry {
   collectorNumber?: string;
   printTag?: string;
   finishTags?: string[];
-  section?: 'commander' | 'mainboard' | 'maybeboard' | 'tokens';
+  section?: 'commander' | 'companion' | 'mainboard' | 'sideboard' | 'maybeboard' | 'tokens';
   tags?: string[];
   flags?: string[];
   isCommander?: boolean;
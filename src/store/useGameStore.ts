@@ -188,10 +188,14 @@ type RoomStatus = 'idle' | 'initializing' | 'waiting' | 'connected' | 'error';
 
 const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:3000';
 
+// As rotas REST da sala exigem o token recebido em room:create/room:join/room:token
+const roomHeaders = (roomToken?: string): Record<string, string> =>
+  roomToken ? { 'X-Room-Token': roomToken } : {};
+
 // Funções para event sourcing - salvar e carregar eventos
 
 // Salvar um evento no backend
-const saveEvent = async (roomId: string, eventType: string, eventData: CardAction, playerId?: string, playerName?: string, socket?: WebSocket): Promise<void> => {
+const saveEvent = async (roomId: string, eventType: string, eventData: CardAction, playerId?: string, playerName?: string, socket?: WebSocket, roomToken?: string): Promise<void> => {
   if (!roomId) return;
   
   try {
@@ -210,7 +214,7 @@ const saveEvent = async (roomId: string, eventType: string, eventData: CardActio
     }
     await fetch(`${API_URL}/api/rooms/${roomId}/events`, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
+      headers: { 'Content-Type': 'application/json', ...roomHeaders(roomToken) },
       credentials: 'include',
       body: JSON.stringify({
         eventType,
@@ -224,7 +228,7 @@ const saveEvent = async (roomId: string, eventType: string, eventData: CardActio
 };
 
 // Carregar eventos e fazer replay para reconstruir o estado
-const loadRoomStateFromEvents = async (roomId: string): Promise<{
+const loadRoomStateFromEvents = async (roomId: string, roomToken?: string): Promise<{
   board: CardOnBoard[];
   counters: Counter[];
   players: PlayerSummary[];
@@ -240,6 +244,7 @@ const loadRoomStateFromEvents = async (roomId: string): Promise<{
   try {
     const response = await fetch(`${API_URL}/api/rooms/${roomId}/events`, {
       credentials: 'include',
+      headers: roomHeaders(roomToken),
     });
     if (!response.ok) return null;
     
@@ -248,6 +253,7 @@ const loadRoomStateFromEvents = async (roomId: string): Promise<{
       // Se não houver eventos, tentar carregar estado antigo (backward compatibility)
       const stateResponse = await fetch(`${API_URL}/api/rooms/${roomId}/state`, {
         credentials: 'include',
+        headers: roomHeaders(roomToken),
       });
       if (stateResponse.ok) {
         return await stateResponse.json();
@@ -559,6 +565,7 @@ interface GameStore {
   isHost: boolean;
   roomId: string;
   roomPassword: string;
+  roomToken: string;
   error?: string;
   board: CardOnBoard[];
   counters: Counter[];
@@ -1347,6 +1354,7 @@ export const useGameStore = create<GameStore>((set, get) => {
       return {
         roomId: '',
         roomPassword: '',
+        roomToken: '',
         playerName: '',
     isHost: false,
       };
@@ -1359,6 +1367,7 @@ export const useGameStore = create<GameStore>((set, get) => {
         return {
           roomId: parsed.roomId || '',
           roomPassword: parsed.roomPassword || '',
+          roomToken: parsed.roomToken || '',
           playerName: parsed.playerName || '',
           isHost: parsed.isHost || false,
         };
@@ -1369,6 +1378,7 @@ export const useGameStore = create<GameStore>((set, get) => {
     return {
     roomId: '',
     roomPassword: '',
+      roomToken: '',
       playerName: '',
       isHost: false,
     };
@@ -1382,6 +1392,8 @@ export const useGameStore = create<GameStore>((set, get) => {
       localStorage.setItem('mtonline-room-state', JSON.stringify({
         roomId,
         roomPassword,
+        // O token só vale para a sala em que foi emitido
+        roomToken: get()?.roomId === roomId ? get()?.roomToken || '' : '',
         playerName,
         isHost,
       }));
@@ -1405,6 +1417,7 @@ export const useGameStore = create<GameStore>((set, get) => {
     isHost: persisted.isHost,
     roomId: persisted.roomId,
     roomPassword: persisted.roomPassword,
+    roomToken: persisted.roomToken,
     error: undefined,
     board: [] as CardOnBoard[],
     counters: [] as Counter[],
@@ -1778,7 +1791,8 @@ export const useGameStore = create<GameStore>((set, get) => {
           action,
           stateAfter.playerId,
           stateAfter.playerName,
-          stateAfter.socket
+          stateAfter.socket,
+          stateAfter.roomToken
         ).catch(() => {
         });
       }
@@ -1834,7 +1848,8 @@ export const useGameStore = create<GameStore>((set, get) => {
         action,
         stateAfter.playerId,
         stateAfter.playerName,
-        stateAfter.socket
+        stateAfter.socket,
+        stateAfter.roomToken
       ).catch(() => {
       });
     }
@@ -2169,8 +2184,47 @@ export const useGameStore = create<GameStore>((set, get) => {
     });
   };
 
+  // Renovar o token da sala antes de expirar (ROOM_TOKEN_TTL_SECONDS no backend)
+  let roomTokenRefreshHandle: number | null = null;
+  const clearRoomTokenRefresh = () => {
+    if (roomTokenRefreshHandle !== null) {
+      window.clearTimeout(roomTokenRefreshHandle);
+      roomTokenRefreshHandle = null;
+    }
+  };
+
+  const roomTokenExpiresAt = (roomToken: string): number | null => {
+    try {
+      const encoded = roomToken.split('.')[0].replace(/-/g, '+').replace(/_/g, '/');
+      const claims = JSON.parse(atob(encoded.padEnd(Math.ceil(encoded.length / 4) * 4, '=')));
+      return typeof claims?.e === 'number' ? claims.e * 1000 : null;
+    } catch (error) {
+      return null;
+    }
+  };
+
+  const setRoomToken = (socket: WebSocket, roomToken: unknown) => {
+    if (typeof roomToken !== 'string' || !roomToken) return;
+    set({ roomToken });
+    const state = get();
+    if (state) {
+      savePersistedState(state.roomId, state.roomPassword, state.playerName || '', state.isHost);
+    }
+    clearRoomTokenRefresh();
+    // Pedir um novo token com 80% da validade usada; sem a validade, a cada 10 minutos
+    const expiresAt = roomTokenExpiresAt(roomToken);
+    const delay = expiresAt ? Math.max((expiresAt - Date.now()) * 0.8, 5000) : 10 * 60 * 1000;
+    roomTokenRefreshHandle = window.setTimeout(() => {
+      roomTokenRefreshHandle = null;
+      const current = get();
+      if (!current || current.socket !== socket || !current.roomId) return;
+      sendWs(socket, { type: 'room:token', payload: { roomId: current.roomId } });
+    }, delay);
+  };
+
   const destroyPeer = () => {
     debugLog('destroying socket and connections');
+    clearRoomTokenRefresh();
     const state = get();
     if (!state) return;
     state.hostConnection?.close();
@@ -2204,10 +2258,15 @@ export const useGameStore = create<GameStore>((set, get) => {
               },
             ];
         set({ status: 'connected', players });
+        setRoomToken(socket, (message.payload as any)?.roomToken);
         break;
       }
       case 'room:joined':
         set({ status: 'waiting' });
+        setRoomToken(socket, (message.payload as any)?.roomToken);
+        break;
+      case 'room:token':
+        setRoomToken(socket, (message.payload as any)?.roomToken);
         break;
       case 'room:error': {
         const errorMessage = (message.payload as any)?.message || 'Room error';
@@ -2672,7 +2731,9 @@ export const useGameStore = create<GameStore>((set, get) => {
       destroyPeer();
       const trimmedId = roomId?.trim() || `room-${randomId()}`;
       const currentState = get();
-      const savedState = await loadRoomState(trimmedId);
+      // Ao recarregar a página, o token salvo ainda dá acesso ao estado da sala
+      const storedToken = currentState.roomId === trimmedId ? currentState.roomToken : '';
+      const savedState = await loadRoomState(trimmedId, storedToken);
       const socket = createSocket();
 
       set({
@@ -2682,6 +2743,7 @@ export const useGameStore = create<GameStore>((set, get) => {
         status: 'initializing' as RoomStatus,
         roomId: trimmedId,
         roomPassword: password,
+        roomToken: storedToken,
         playerId: currentState.playerId,
         playerName: currentState.playerName || '',
         board: savedState?.board || [],
@@ -2713,7 +2775,9 @@ export const useGameStore = create<GameStore>((set, get) => {
       destroyPeer();
       const trimmedId = roomId?.trim();
       const currentState = get();
-      const savedState = await loadRoomState(trimmedId);
+      // Ao recarregar a página, o token salvo ainda dá acesso ao estado da sala
+      const storedToken = currentState.roomId === trimmedId ? currentState.roomToken : '';
+      const savedState = await loadRoomState(trimmedId, storedToken);
       const socket = createSocket();
 
       set({
@@ -2722,6 +2786,7 @@ export const useGameStore = create<GameStore>((set, get) => {
         status: 'initializing' as RoomStatus,
         roomId: trimmedId,
         roomPassword: password,
+        roomToken: storedToken,
         isHost: false,
         playerId: currentState.playerId,
         playerName: currentState.playerName || '',
@@ -2764,6 +2829,7 @@ export const useGameStore = create<GameStore>((set, get) => {
         if (!s) return s;
         const newState = {
         ...baseState(),
+          roomToken: '',
           playerId: s.playerId,
           playerName: s.playerName,
           savedDecks: s.savedDecks,